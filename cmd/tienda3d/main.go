@@ -99,6 +99,10 @@ func main() {
 
 	server := &http.Server{Handler: application.HTTPHandler()}
 
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	application.StartBackground(bgCtx)
+
 	go func() {
 		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
 		}
@@ -107,6 +111,7 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
+	stopBackground()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	_ = server.Shutdown(ctx)
@@ -0,0 +1,332 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/boombuler/barcode/code128"
+	"github.com/jung-kurt/gofpdf"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// Generator arma el remito de preparación, el comprobante de compra y la factura de una orden en PDF,
+// sin binarios externos: gofpdf para el documento y el código de barras (Code 128 del id de la orden)
+// dibujado con rectángulos.
+type Generator struct {
+	StoreName    string
+	StoreAddress string
+	StoreContact string
+	// Issuer son los datos fiscales que se imprimen en las facturas.
+	Issuer InvoiceIssuerInfo
+	// Location es la zona horaria en la que se imprimen las fechas.
+	Location *time.Location
+}
+
+func NewGenerator() *Generator {
+	loc, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	if err != nil {
+		loc = time.FixedZone("ART", -3*60*60)
+	}
+	g := &Generator{
+		StoreName:    envOr("STORE_NAME", "NewMobile"),
+		StoreAddress: os.Getenv("STORE_ADDRESS"),
+		StoreContact: envOr("STORE_CONTACT", "ventas@newmobile.com.ar"),
+		Location:     loc,
+	}
+	g.Issuer = invoiceIssuerFromEnv(g.StoreName, g.StoreAddress)
+	return g
+}
+
+func envOr(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// doc envuelve gofpdf con el traductor a cp1252 de las fuentes base (acentos y ñ).
+type doc struct {
+	*gofpdf.Fpdf
+	tr func(string) string
+}
+
+func (g *Generator) newDoc(title string) *doc {
+	f := gofpdf.New("P", "mm", "A4", "")
+	f.SetMargins(12, 12, 12)
+	f.SetAutoPageBreak(true, 15)
+	f.SetTitle(title, true)
+	f.SetCreator(g.StoreName, true)
+	f.AddPage()
+	return &doc{Fpdf: f, tr: f.UnicodeTranslatorFromDescriptor("")}
+}
+
+func (d *doc) text(w, h float64, s, border string, ln int, align string) {
+	d.CellFormat(w, h, d.tr(s), border, ln, align, false, 0, "")
+}
+
+func (d *doc) output() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := d.Output(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// header imprime el encabezado común: tienda a la izquierda, título y número de orden a la derecha.
+func (g *Generator) header(d *doc, title string, o *domain.Order) {
+	d.SetFont("Helvetica", "B", 16)
+	d.text(110, 8, g.StoreName, "", 0, "L")
+	d.SetFont("Helvetica", "B", 14)
+	d.text(0, 8, title, "", 1, "R")
+	d.SetFont("Helvetica", "", 9)
+	sub := strings.TrimSpace(strings.Join([]string{g.StoreAddress, g.StoreContact}, " · "))
+	d.text(110, 5, strings.Trim(sub, " ·"), "", 0, "L")
+	d.text(0, 5, fmt.Sprintf("Orden #%s · %s", shortID(o), o.CreatedAt.In(g.Location).Format("02/01/2006 15:04")), "", 1, "R")
+	d.Ln(2)
+	d.SetDrawColor(160, 160, 160)
+	d.Line(12, d.GetY(), 198, d.GetY())
+	d.Ln(4)
+}
+
+// barcode dibuja el Code 128 del id completo de la orden con el texto debajo.
+func (g *Generator) barcode(d *doc, o *domain.Order) error {
+	bc, err := code128.Encode(o.ID.String())
+	if err != nil {
+		return err
+	}
+	const module, height = 0.3, 14.0
+	modules := bc.Bounds().Dx()
+	x := 12 + (186-float64(modules)*module)/2
+	y := d.GetY()
+	d.SetFillColor(0, 0, 0)
+	for i := 0; i < modules; i++ {
+		if r, _, _, _ := bc.At(i, 0).RGBA(); r == 0 {
+			d.Rect(x+float64(i)*module, y, module, height, "F")
+		}
+	}
+	d.SetY(y + height + 1)
+	d.SetFont("Courier", "", 9)
+	d.text(0, 4, o.ID.String(), "", 1, "C")
+	d.Ln(3)
+	return nil
+}
+
+func (g *Generator) section(d *doc, title string) {
+	d.SetFont("Helvetica", "B", 11)
+	d.SetFillColor(235, 235, 235)
+	d.CellFormat(0, 7, d.tr(title), "", 1, "L", true, 0, "")
+	d.Ln(1)
+}
+
+func (g *Generator) field(d *doc, label, value string) {
+	if strings.TrimSpace(value) == "" {
+		return
+	}
+	d.SetFont("Helvetica", "B", 10)
+	d.text(35, 6, label, "", 0, "L")
+	d.SetFont("Helvetica", "", 10)
+	d.MultiCell(0, 6, d.tr(value), "", "L", false)
+}
+
+// PackingSlip es el remito para preparar y entregar el pedido: destinatario, items sin precios y, si
+// se cobra en la entrega, el monto a cobrar.
+func (g *Generator) PackingSlip(o *domain.Order) ([]byte, error) {
+	if o == nil {
+		return nil, fmt.Errorf("orden nil")
+	}
+	d := g.newDoc("Remito " + shortID(o))
+	g.header(d, "REMITO", o)
+	if err := g.barcode(d, o); err != nil {
+		return nil, err
+	}
+
+	g.section(d, "Destinatario")
+	g.field(d, "Nombre", o.Name)
+	g.field(d, "Teléfono", o.Phone)
+	g.field(d, "DNI", o.DNI)
+	g.field(d, "Email", o.Email)
+	g.field(d, "Entrega", shippingLabel(o.ShippingMethod))
+	if o.ShippingMethod != "retiro" {
+		g.field(d, "Dirección", o.Address)
+		g.field(d, "Localidad", strings.Trim(strings.Join([]string{o.PostalCode, o.Province}, " · "), " ·"))
+	}
+	if notes := strings.TrimSpace(o.DeliveryNotes); notes != "" {
+		d.Ln(1)
+		d.SetFont("Helvetica", "B", 10)
+		d.text(0, 6, "Notas de entrega", "", 1, "L")
+		d.SetFont("Helvetica", "", 10)
+		d.MultiCell(0, 6, d.tr(notes), "1", "L", false)
+	}
+	d.Ln(4)
+
+	g.section(d, "Productos")
+	d.SetFont("Helvetica", "B", 9)
+	d.text(14, 7, "Cant.", "B", 0, "C")
+	d.text(84, 7, "Producto", "B", 0, "L")
+	d.text(28, 7, "Color", "B", 0, "L")
+	d.text(32, 7, "SKU", "B", 0, "L")
+	d.text(0, 7, "EAN", "B", 1, "L")
+	d.SetFont("Helvetica", "", 9)
+	units := 0
+	for _, it := range o.Items {
+		units += it.Qty
+		d.text(14, 7, fmt.Sprintf("%d", it.Qty), "B", 0, "C")
+		d.text(84, 7, truncate(it.Title, 52), "B", 0, "L")
+		d.text(28, 7, truncate(it.Color, 16), "B", 0, "L")
+		d.text(32, 7, truncate(it.SKU, 20), "B", 0, "L")
+		d.text(0, 7, it.EAN, "B", 1, "L")
+	}
+	d.SetFont("Helvetica", "B", 9)
+	d.text(0, 7, fmt.Sprintf("%d unidades", units), "", 1, "L")
+	d.Ln(3)
+
+	if o.PaymentMethod == "efectivo" && o.PaymentStatus != "approved" {
+		d.SetFont("Helvetica", "B", 14)
+		d.text(0, 10, "COBRAR EN EFECTIVO: "+formatARS(o.Total), "1", 1, "C")
+		d.Ln(3)
+	}
+
+	d.Ln(12)
+	d.SetFont("Helvetica", "", 9)
+	d.text(62, 6, "Firma", "T", 0, "C")
+	d.text(5, 6, "", "", 0, "C")
+	d.text(62, 6, "Aclaración", "T", 0, "C")
+	d.text(5, 6, "", "", 0, "C")
+	d.text(0, 6, "DNI", "T", 1, "C")
+	return d.output()
+}
+
+// Receipt es el comprobante de compra para el cliente. No es una factura.
+func (g *Generator) Receipt(o *domain.Order) ([]byte, error) {
+	if o == nil {
+		return nil, fmt.Errorf("orden nil")
+	}
+	d := g.newDoc("Comprobante " + shortID(o))
+	g.header(d, "COMPROBANTE DE COMPRA", o)
+
+	g.section(d, "Cliente")
+	g.field(d, "Nombre", o.Name)
+	g.field(d, "Email", o.Email)
+	g.field(d, "DNI", o.DNI)
+	g.field(d, "Entrega", shippingLabel(o.ShippingMethod))
+	if o.ShippingMethod != "retiro" {
+		g.field(d, "Dirección", strings.Trim(strings.Join([]string{o.Address, o.PostalCode, o.Province}, " · "), " ·"))
+	}
+	d.Ln(4)
+
+	g.section(d, "Detalle")
+	d.SetFont("Helvetica", "B", 9)
+	d.text(100, 7, "Producto", "B", 0, "L")
+	d.text(16, 7, "Cant.", "B", 0, "C")
+	d.text(35, 7, "Precio unit.", "B", 0, "R")
+	d.text(0, 7, "Subtotal", "B", 1, "R")
+	d.SetFont("Helvetica", "", 9)
+	for _, it := range o.Items {
+		title := it.Title
+		if it.Color != "" {
+			title += " (" + it.Color + ")"
+		}
+		d.text(100, 7, truncate(title, 62), "B", 0, "L")
+		d.text(16, 7, fmt.Sprintf("%d", it.Qty), "B", 0, "C")
+		d.text(35, 7, formatARS(it.UnitPrice), "B", 0, "R")
+		d.text(0, 7, formatARS(it.Subtotal()), "B", 1, "R")
+	}
+	d.Ln(2)
+	total := func(label, value string, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		d.SetFont("Helvetica", style, 10)
+		d.text(151, 6, label, "", 0, "R")
+		d.text(0, 6, value, "", 1, "R")
+	}
+	total("Productos", formatARS(o.ItemsSubtotal()), false)
+	if o.ShippingCost > 0 {
+		total("Envío", formatARS(o.ShippingCost), false)
+	}
+	if o.DiscountAmount > 0 {
+		total("Descuento", "-"+formatARS(o.DiscountAmount), false)
+	}
+	total("Total", formatARS(o.Total), true)
+	d.Ln(4)
+
+	g.section(d, "Pago")
+	g.field(d, "Medio", paymentLabel(o.PaymentMethod))
+	status := "Pendiente de pago"
+	if o.PaymentStatus == "approved" {
+		status = "Pagado"
+	} else if o.PaymentStatus == "partial" {
+		status = "Pago parcial (seña)"
+	}
+	g.field(d, "Estado", status)
+	if o.CryptoAmount > 0 {
+		g.field(d, "Monto cripto", fmt.Sprintf("%.2f USDT/USDC", o.CryptoAmount))
+	}
+	d.Ln(6)
+	d.SetFont("Helvetica", "I", 8)
+	d.text(0, 5, "Documento no válido como factura.", "", 1, "C")
+	return d.output()
+}
+
+func shortID(o *domain.Order) string { return strings.ToUpper(o.Number()) }
+
+func shippingLabel(method string) string {
+	switch method {
+	case "envio":
+		return "Envío a domicilio"
+	case "cadete":
+		return "Cadete"
+	case "retiro":
+		return "Retiro en el local"
+	}
+	return method
+}
+
+func paymentLabel(method string) string {
+	switch method {
+	case "mercadopago":
+		return "MercadoPago"
+	case "transferencia":
+		return "Transferencia bancaria"
+	case "cripto":
+		return "Cripto (USDT/USDC)"
+	case "efectivo":
+		return "Efectivo"
+	}
+	return method
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// formatARS formatea pesos con separador de miles: $ 12.345,67.
+func formatARS(v float64) string {
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	s := fmt.Sprintf("%.2f", v)
+	intPart, dec := s[:len(s)-3], s[len(s)-2:]
+	var b strings.Builder
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(c)
+	}
+	out := "$ " + b.String() + "," + dec
+	if neg {
+		out = "-" + out
+	}
+	return out
+}
@@ -0,0 +1,216 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/skip2/go-qrcode"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// InvoiceIssuerInfo son los datos fiscales del emisor que se imprimen en las facturas.
+type InvoiceIssuerInfo struct {
+	LegalName     string
+	Address       string
+	GrossIncomeID string // ingresos brutos
+	ActivityStart string // inicio de actividades (dd/mm/aaaa)
+}
+
+func invoiceIssuerFromEnv(storeName, storeAddress string) InvoiceIssuerInfo {
+	return InvoiceIssuerInfo{
+		LegalName:     envOr("INVOICE_LEGAL_NAME", storeName),
+		Address:       envOr("INVOICE_ADDRESS", storeAddress),
+		GrossIncomeID: os.Getenv("INVOICE_IIBB"),
+		ActivityStart: os.Getenv("INVOICE_ACTIVITY_START"),
+	}
+}
+
+// Invoice es la representación impresa de una factura electrónica: encabezado con la letra y el código
+// de comprobante, receptor, detalle (con IVA discriminado sólo en la A), CAE y el QR de AFIP.
+func (g *Generator) Invoice(inv *domain.Invoice, o *domain.Order) ([]byte, error) {
+	if inv == nil || o == nil {
+		return nil, fmt.Errorf("factura u orden nil")
+	}
+	d := g.newDoc("Factura " + inv.FullNumber())
+	issuer := g.Issuer
+
+	d.SetFont("Helvetica", "B", 11)
+	d.text(0, 7, "ORIGINAL", "1", 1, "C")
+	top := d.GetY()
+
+	// Letra del comprobante en el centro.
+	d.SetXY(97, top)
+	d.SetFont("Helvetica", "B", 22)
+	d.text(16, 12, string(inv.Type), "1", 2, "C")
+	d.SetFont("Helvetica", "", 6)
+	d.text(16, 4, fmt.Sprintf("COD. %03d", inv.Type.AFIPCode()), "1", 0, "C")
+
+	// Emisor a la izquierda.
+	d.SetXY(12, top+2)
+	d.SetFont("Helvetica", "B", 13)
+	d.text(83, 7, truncate(issuer.LegalName, 40), "", 2, "L")
+	d.SetFont("Helvetica", "", 8)
+	d.text(83, 4.5, truncate("Domicilio: "+issuer.Address, 58), "", 2, "L")
+	d.text(83, 4.5, "Condición frente al IVA: "+domain.TaxConditionLabel(inv.IssuerCondition), "", 2, "L")
+
+	// Comprobante a la derecha.
+	d.SetXY(118, top+2)
+	d.SetFont("Helvetica", "B", 13)
+	d.text(80, 7, "FACTURA", "", 2, "L")
+	d.SetFont("Helvetica", "", 8)
+	d.text(80, 4.5, fmt.Sprintf("Punto de venta: %04d   Comp. Nro: %08d", inv.PointOfSale, inv.Number), "", 2, "L")
+	d.text(80, 4.5, "Fecha de emisión: "+inv.IssuedAt.In(g.Location).Format("02/01/2006"), "", 2, "L")
+	d.text(80, 4.5, "CUIT: "+formatCUIT(inv.IssuerCUIT), "", 2, "L")
+	if issuer.GrossIncomeID != "" {
+		d.text(80, 4.5, "Ingresos Brutos: "+issuer.GrossIncomeID, "", 2, "L")
+	}
+	if issuer.ActivityStart != "" {
+		d.text(80, 4.5, "Inicio de actividades: "+issuer.ActivityStart, "", 2, "L")
+	}
+	bottom := d.GetY() + 2
+	if bottom < top+30 {
+		bottom = top + 30
+	}
+	d.Rect(12, top, 186, bottom-top, "D")
+	d.SetXY(12, bottom+3)
+
+	// Receptor.
+	g.section(d, "Receptor")
+	doc := "Consumidor final"
+	switch inv.DocType {
+	case domain.DocTypeCUIT:
+		doc = "CUIT " + formatCUIT(inv.DocNumber)
+	case domain.DocTypeDNI:
+		doc = "DNI " + inv.DocNumber
+	}
+	g.field(d, "Documento", doc)
+	g.field(d, "Nombre", inv.BuyerName)
+	g.field(d, "Cond. IVA", domain.TaxConditionLabel(inv.BuyerCondition))
+	g.field(d, "Domicilio", inv.BuyerAddress)
+	g.field(d, "Venta", "Contado · "+paymentLabel(o.PaymentMethod)+" · Orden #"+shortID(o))
+	d.Ln(3)
+
+	g.invoiceDetail(d, inv, o)
+	g.invoiceFooter(d, inv)
+	return d.output()
+}
+
+// invoiceDetail imprime los items y los totales. En la A los importes van netos de IVA y el IVA se
+// detalla por alícuota; en la B y la C van con IVA incluido.
+func (g *Generator) invoiceDetail(d *doc, inv *domain.Invoice, o *domain.Order) {
+	discriminate := inv.Type.DiscriminatesVAT()
+	g.section(d, "Detalle")
+	d.SetFont("Helvetica", "B", 8)
+	d.text(94, 6, "Producto / Servicio", "B", 0, "L")
+	d.text(14, 6, "Cant.", "B", 0, "C")
+	d.text(30, 6, "Precio unit.", "B", 0, "R")
+	if discriminate {
+		d.text(16, 6, "IVA", "B", 0, "R")
+	} else {
+		d.text(16, 6, "", "B", 0, "R")
+	}
+	d.text(0, 6, "Subtotal", "B", 1, "R")
+	d.SetFont("Helvetica", "", 8)
+
+	shown := 0.0
+	line := func(title string, qty int, unit, rate float64) {
+		if discriminate {
+			unit = unit / (1 + rate/100)
+		}
+		sub := unit * float64(qty)
+		shown += sub
+		d.text(94, 6, truncate(title, 60), "B", 0, "L")
+		d.text(14, 6, fmt.Sprintf("%d", qty), "B", 0, "C")
+		d.text(30, 6, formatARS(unit), "B", 0, "R")
+		if discriminate {
+			d.text(16, 6, fmt.Sprintf("%g%%", rate), "B", 0, "R")
+		} else {
+			d.text(16, 6, "", "B", 0, "R")
+		}
+		d.text(0, 6, formatARS(sub), "B", 1, "R")
+	}
+	for _, it := range o.Items {
+		title := it.Title
+		if it.Color != "" {
+			title += " (" + it.Color + ")"
+		}
+		line(title, it.Qty, it.UnitPrice, it.VATRate)
+	}
+	if o.ShippingCost > 0 {
+		line("Envío: "+shippingLabel(o.ShippingMethod), 1, o.ShippingCost, 21)
+	}
+	// La bonificación por medio de pago cierra la diferencia con lo autorizado.
+	base := inv.Total
+	if discriminate {
+		base = inv.Net
+	}
+	if diff := base - shown; diff < -0.005 {
+		d.text(154, 6, "Bonificación por medio de pago", "B", 0, "L")
+		d.text(0, 6, formatARS(diff), "B", 1, "R")
+	}
+	d.Ln(2)
+
+	total := func(label, value string, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		d.SetFont("Helvetica", style, 9)
+		d.text(151, 5.5, label, "", 0, "R")
+		d.text(0, 5.5, value, "", 1, "R")
+	}
+	if discriminate {
+		total("Importe neto gravado", formatARS(inv.Net), false)
+		for _, l := range inv.VATLines {
+			total(fmt.Sprintf("IVA %g%%", l.Rate), formatARS(l.Amount), false)
+		}
+	}
+	total("Importe total", formatARS(inv.Total), true)
+	if inv.Type == domain.InvoiceTypeB && inv.VAT > 0 {
+		d.Ln(1)
+		d.SetFont("Helvetica", "", 7)
+		d.text(0, 4, "Régimen de Transparencia Fiscal al Consumidor (Ley 27.743) - IVA contenido: "+formatARS(inv.VAT), "", 1, "R")
+	}
+	d.Ln(4)
+}
+
+// invoiceFooter imprime el QR de AFIP junto al CAE y su vencimiento.
+func (g *Generator) invoiceFooter(d *doc, inv *domain.Invoice) {
+	y := d.GetY()
+	if y > 240 {
+		d.AddPage()
+		y = d.GetY()
+	}
+	if inv.QRData != "" {
+		if png, err := qrcode.Encode(inv.QRData, qrcode.Medium, 256); err == nil {
+			opts := gofpdf.ImageOptions{ImageType: "PNG"}
+			d.RegisterImageOptionsReader("afip-qr", opts, bytes.NewReader(png))
+			d.ImageOptions("afip-qr", 12, y, 32, 32, false, opts, 0, "")
+		}
+	}
+	d.SetXY(48, y+4)
+	d.SetFont("Helvetica", "BI", 10)
+	d.text(0, 6, "Comprobante Autorizado", "", 2, "L")
+	d.SetFont("Helvetica", "", 9)
+	d.text(0, 5, "CAE N°: "+inv.CAE, "", 2, "L")
+	d.text(0, 5, "Fecha de Vto. de CAE: "+inv.CAEExpires.In(g.Location).Format("02/01/2006"), "", 2, "L")
+	if inv.Environment != "produccion" {
+		d.SetTextColor(180, 0, 0)
+		d.SetFont("Helvetica", "B", 9)
+		d.text(0, 6, strings.ToUpper("Sin validez fiscal - comprobante de "+inv.Environment), "", 2, "L")
+		d.SetTextColor(0, 0, 0)
+	}
+	d.SetY(y + 36)
+}
+
+// formatCUIT escribe un CUIT con guiones: 20-12345678-9.
+func formatCUIT(s string) string {
+	if len(s) != 11 {
+		return s
+	}
+	return s[:2] + "-" + s[2:10] + "-" + s[10:]
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"gopkg.in/gomail.v2"
@@ -22,6 +24,11 @@ type SMTPService struct {
 	password string
 	from     string
 	enabled  bool
+	// attachments son los PDF que se adjuntan a la confirmación ("receipt", "packing_slip").
+	attachments []string
+
+	// Documents genera los PDF adjuntos; sin él la confirmación sale sin adjuntos.
+	Documents domain.OrderDocuments
 }
 
 func NewSMTPService() *SMTPService {
@@ -65,13 +72,77 @@ func NewSMTPService() *SMTPService {
 			Msg("⚠️ SMTP no configurado - los emails de confirmación no se enviarán")
 	}
 
+	attachments := []string{"receipt"}
+	if raw, ok := os.LookupEnv("ORDER_EMAIL_ATTACHMENTS"); ok {
+		attachments = nil
+		for _, a := range strings.Split(raw, ",") {
+			if a = strings.TrimSpace(strings.ToLower(a)); a != "" && a != "none" {
+				attachments = append(attachments, a)
+			}
+		}
+	}
+
 	return &SMTPService{
-		host:     host,
-		port:     port,
-		user:     user,
-		password: password,
-		from:     from,
-		enabled:  enabled,
+		host:        host,
+		port:        port,
+		user:        user,
+		password:    password,
+		from:        from,
+		enabled:     enabled,
+		attachments: attachments,
+	}
+}
+
+// Enabled indica si hay credenciales SMTP para enviar.
+func (s *SMTPService) Enabled() bool { return s.enabled }
+
+// Channel y Send hacen del servicio el canal de email de los avisos al staff (domain.Notifier): el
+// destino es la dirección y el aviso sale en texto plano. A diferencia de los emails al comprador,
+// sin SMTP configurado devuelve error para que el aviso quede pendiente.
+func (s *SMTPService) Channel() string { return domain.ChannelEmail }
+
+func (s *SMTPService) Send(ctx context.Context, to string, msg domain.NotificationMessage) error {
+	if !s.enabled {
+		return fmt.Errorf("email no configurado (SMTP_HOST, SMTP_USER, SMTP_PASS)")
+	}
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.from)
+	m.SetHeader("To", strings.TrimSpace(to))
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Text)
+	return s.dialAndSend(m)
+}
+
+// attachDocuments adjunta a m los PDF configurados. Si uno falla se loguea y el email sale igual.
+func (s *SMTPService) attachDocuments(m *gomail.Message, order *domain.Order) {
+	if s.Documents == nil {
+		return
+	}
+	short := order.Number()
+	for _, kind := range s.attachments {
+		var (
+			name string
+			data []byte
+			err  error
+		)
+		switch kind {
+		case "receipt":
+			name = "comprobante-" + short + ".pdf"
+			data, err = s.Documents.Receipt(order)
+		case "packing_slip":
+			name = "remito-" + short + ".pdf"
+			data, err = s.Documents.PackingSlip(order)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Str("order_id", order.ID.String()).Str("doc", kind).Msg("no se pudo generar el PDF adjunto")
+			continue
+		}
+		m.Attach(name, gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		}), gomail.SetHeader(map[string][]string{"Content-Type": {"application/pdf"}}))
 	}
 }
 
@@ -98,7 +169,7 @@ func (s *SMTPService) SendOrderConfirmation(ctx context.Context, order *domain.O
 	}
 
 	// Crear mensaje
-	orderNumber := order.ID.String()[:8]
+	orderNumber := order.Number()
 	subject := fmt.Sprintf("✅ Confirmación de tu pedido #%s", orderNumber)
 
 	m := gomail.NewMessage()
@@ -106,17 +177,10 @@ func (s *SMTPService) SendOrderConfirmation(ctx context.Context, order *domain.O
 	m.SetHeader("To", order.Email)
 	m.SetHeader("Subject", subject)
 	m.SetBody("text/html", html)
+	s.attachDocuments(m, order)
 
 	// Enviar
-	d := gomail.NewDialer(s.host, s.port, s.user, s.password)
-	
-	// Para puerto 465 (Gmail SSL), habilitar SSL
-	if s.port == 465 {
-		d.SSL = true
-	}
-	// Para puerto 587, gomail usa STARTTLS automáticamente
-	
-	if err := d.DialAndSend(m); err != nil {
+	if err := s.dialAndSend(m); err != nil {
 		log.Error().
 			Err(err).
 			Str("order_id", order.ID.String()).
@@ -135,6 +199,474 @@ func (s *SMTPService) SendOrderConfirmation(ctx context.Context, order *domain.O
 	return nil
 }
 
+// dialAndSend envía el mensaje usando la configuración SMTP del servicio.
+func (s *SMTPService) dialAndSend(m *gomail.Message) error {
+	d := gomail.NewDialer(s.host, s.port, s.user, s.password)
+
+	// Para puerto 465 (Gmail SSL), habilitar SSL
+	if s.port == 465 {
+		d.SSL = true
+	}
+	// Para puerto 587, gomail usa STARTTLS automáticamente
+
+	return d.DialAndSend(m)
+}
+
+// SendRefundNotice avisa al comprador que se le devolvió dinero de su pedido.
+func (s *SMTPService) SendRefundNotice(ctx context.Context, order *domain.Order, refund *domain.Refund) error {
+	if order == nil || refund == nil {
+		return fmt.Errorf("orden o reembolso nil")
+	}
+	if !s.enabled {
+		log.Warn().Str("order_id", order.ID.String()).Msg("⚠️ SMTP no configurado - no se envió aviso de reembolso")
+		return nil
+	}
+	if order.Email == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := refundTmpl.Execute(&buf, map[string]any{
+		"Name":        order.Name,
+		"OrderNumber": order.Number(),
+		"Amount":      refund.Amount,
+		"Reason":      refund.Reason,
+		"Lines":       refund.Lines,
+		"Manual":      refund.Status == domain.RefundStatusManual,
+	}); err != nil {
+		return fmt.Errorf("error ejecutando template: %w", err)
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.from)
+	m.SetHeader("To", order.Email)
+	m.SetHeader("Subject", fmt.Sprintf("Reembolso de tu pedido #%s", order.Number()))
+	m.SetBody("text/html", buf.String())
+	if err := s.dialAndSend(m); err != nil {
+		log.Error().Err(err).Str("order_id", order.ID.String()).Msg("❌ Error enviando aviso de reembolso")
+		return err
+	}
+	log.Info().Str("order_id", order.ID.String()).Str("email", order.Email).Msg("📧 Aviso de reembolso enviado")
+	return nil
+}
+
+var refundTmpl = template.Must(template.New("refund").Parse(`<!DOCTYPE html>
+<html lang="es">
+<body style="margin:0;padding:20px;font-family:-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif;background-color:#f3f4f6;">
+  <table role="presentation" style="max-width:600px;width:100%;margin:0 auto;background-color:#ffffff;border-radius:8px;padding:30px;">
+    <tr><td>
+      <h1 style="margin:0 0 20px 0;color:#111827;font-size:22px;">Reembolso de tu pedido #{{.OrderNumber}}</h1>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Hola <strong>{{.Name}}</strong>, registramos un reembolso de <strong>${{printf "%.2f" .Amount}}</strong>.</p>
+      {{if .Lines}}
+      <ul style="color:#374151;font-size:15px;line-height:1.6;">
+        {{range .Lines}}<li>{{.Title}}{{if .Qty}} x{{.Qty}}{{end}} — ${{printf "%.2f" .Amount}}</li>{{end}}
+      </ul>
+      {{end}}
+      {{if .Reason}}<p style="color:#6b7280;font-size:14px;">Motivo: {{.Reason}}</p>{{end}}
+      {{if .Manual}}
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Te vamos a contactar para coordinar la devolución del dinero.</p>
+      {{else}}
+      <p style="color:#374151;font-size:15px;line-height:1.6;">El dinero se acredita en el mismo medio de pago que usaste; puede demorar algunos días según tu banco.</p>
+      {{end}}
+    </td></tr>
+  </table>
+</body>
+</html>`))
+
+// SendReceiptRejected avisa al comprador que su comprobante de pago no pudo validarse.
+func (s *SMTPService) SendReceiptRejected(ctx context.Context, order *domain.Order, reason string) error {
+	if order == nil {
+		return fmt.Errorf("orden nil")
+	}
+	if !s.enabled {
+		log.Warn().Str("order_id", order.ID.String()).Msg("⚠️ SMTP no configurado - no se envió aviso de comprobante rechazado")
+		return nil
+	}
+	if order.Email == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := receiptRejectedTmpl.Execute(&buf, map[string]any{
+		"Name":        order.Name,
+		"OrderNumber": order.Number(),
+		"Reason":      reason,
+		"PayURL":      strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/") + "/pay/" + order.ID.String(),
+	}); err != nil {
+		return fmt.Errorf("error ejecutando template: %w", err)
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.from)
+	m.SetHeader("To", order.Email)
+	m.SetHeader("Subject", fmt.Sprintf("No pudimos validar el pago de tu pedido #%s", order.Number()))
+	m.SetBody("text/html", buf.String())
+	if err := s.dialAndSend(m); err != nil {
+		log.Error().Err(err).Str("order_id", order.ID.String()).Msg("❌ Error enviando aviso de comprobante rechazado")
+		return err
+	}
+	log.Info().Str("order_id", order.ID.String()).Str("email", order.Email).Msg("📧 Aviso de comprobante rechazado enviado")
+	return nil
+}
+
+var receiptRejectedTmpl = template.Must(template.New("receipt_rejected").Parse(`<!DOCTYPE html>
+<html lang="es">
+<body style="margin:0;padding:20px;font-family:-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif;background-color:#f3f4f6;">
+  <table role="presentation" style="max-width:600px;width:100%;margin:0 auto;background-color:#ffffff;border-radius:8px;padding:30px;">
+    <tr><td>
+      <h1 style="margin:0 0 20px 0;color:#111827;font-size:22px;">Comprobante de tu pedido #{{.OrderNumber}}</h1>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Hola <strong>{{.Name}}</strong>, revisamos el comprobante que subiste y no pudimos validar el pago.</p>
+      {{if .Reason}}<p style="color:#6b7280;font-size:14px;">Motivo: {{.Reason}}</p>{{end}}
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Podés subir un nuevo comprobante desde <a href="{{.PayURL}}" style="color:#2563eb;">la página de tu pedido</a>.</p>
+    </td></tr>
+  </table>
+</body>
+</html>`))
+
+// SendPaymentReminder avisa al comprador que el plazo para pagar su pedido está por vencer.
+func (s *SMTPService) SendPaymentReminder(ctx context.Context, order *domain.Order, due time.Time) error {
+	if order == nil {
+		return fmt.Errorf("orden nil")
+	}
+	if !s.enabled {
+		log.Warn().Str("order_id", order.ID.String()).Msg("⚠️ SMTP no configurado - no se envió recordatorio de pago")
+		return nil
+	}
+	if order.Email == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := paymentReminderTmpl.Execute(&buf, map[string]any{
+		"Name":          order.Name,
+		"OrderNumber":   order.Number(),
+		"Total":         order.Total,
+		"PaymentMethod": s.mapPaymentMethod(order.PaymentMethod),
+		"Due":           due.In(argentinaLocation()).Format("02/01/2006 15:04"),
+		"PayURL":        strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/") + "/pay/" + order.ID.String(),
+	}); err != nil {
+		return fmt.Errorf("error ejecutando template: %w", err)
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.from)
+	m.SetHeader("To", order.Email)
+	m.SetHeader("Subject", fmt.Sprintf("Tu pedido #%s está por vencer", order.Number()))
+	m.SetBody("text/html", buf.String())
+	if err := s.dialAndSend(m); err != nil {
+		log.Error().Err(err).Str("order_id", order.ID.String()).Msg("❌ Error enviando recordatorio de pago")
+		return err
+	}
+	log.Info().Str("order_id", order.ID.String()).Str("email", order.Email).Msg("📧 Recordatorio de pago enviado")
+	return nil
+}
+
+// argentinaLocation devuelve la zona horaria de los clientes (UTC-3 si el sistema no trae tzdata).
+func argentinaLocation() *time.Location {
+	if loc, err := time.LoadLocation("America/Argentina/Buenos_Aires"); err == nil {
+		return loc
+	}
+	return time.FixedZone("ART", -3*60*60)
+}
+
+var paymentReminderTmpl = template.Must(template.New("payment_reminder").Parse(`<!DOCTYPE html>
+<html lang="es">
+<body style="margin:0;padding:20px;font-family:-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif;background-color:#f3f4f6;">
+  <table role="presentation" style="max-width:600px;width:100%;margin:0 auto;background-color:#ffffff;border-radius:8px;padding:30px;">
+    <tr><td>
+      <h1 style="margin:0 0 20px 0;color:#111827;font-size:22px;">Tu pedido #{{.OrderNumber}} está por vencer</h1>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Hola <strong>{{.Name}}</strong>, todavía no registramos el pago de tu pedido por <strong>${{printf "%.2f" .Total}}</strong> ({{.PaymentMethod}}).</p>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Si no recibimos el pago antes del <strong>{{.Due}}</strong> el pedido se cancela automáticamente.</p>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Podés ver los datos de pago o subir tu comprobante desde <a href="{{.PayURL}}" style="color:#2563eb;">la página de tu pedido</a>.</p>
+    </td></tr>
+  </table>
+</body>
+</html>`))
+
+// SendPreOrderBalance avisa al comprador que llegó el stock de su preventa y le pide el saldo.
+func (s *SMTPService) SendPreOrderBalance(ctx context.Context, order *domain.Order) error {
+	if order == nil {
+		return fmt.Errorf("orden nil")
+	}
+	if !s.enabled {
+		log.Warn().Str("order_id", order.ID.String()).Msg("⚠️ SMTP no configurado - no se envió pedido de saldo de preventa")
+		return nil
+	}
+	if order.Email == "" {
+		return nil
+	}
+
+	titles := make([]string, 0, len(order.Items))
+	for _, it := range order.Items {
+		titles = append(titles, fmt.Sprintf("%d x %s", it.Qty, it.Title))
+	}
+	var buf bytes.Buffer
+	if err := preOrderBalanceTmpl.Execute(&buf, map[string]any{
+		"Name":          order.Name,
+		"OrderNumber":   order.Number(),
+		"Items":         strings.Join(titles, ", "),
+		"Balance":       order.AmountDue(),
+		"PaymentMethod": s.mapPaymentMethod(order.PaymentMethod),
+		"PayURL":        strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/") + "/pay/" + order.ID.String(),
+	}); err != nil {
+		return fmt.Errorf("error ejecutando template: %w", err)
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.from)
+	m.SetHeader("To", order.Email)
+	m.SetHeader("Subject", fmt.Sprintf("Llegó tu preventa #%s: completá el pago", order.Number()))
+	m.SetBody("text/html", buf.String())
+	if err := s.dialAndSend(m); err != nil {
+		log.Error().Err(err).Str("order_id", order.ID.String()).Msg("❌ Error enviando pedido de saldo de preventa")
+		return err
+	}
+	log.Info().Str("order_id", order.ID.String()).Str("email", order.Email).Msg("📧 Pedido de saldo de preventa enviado")
+	return nil
+}
+
+var preOrderBalanceTmpl = template.Must(template.New("preorder_balance").Parse(`<!DOCTYPE html>
+<html lang="es">
+<body style="margin:0;padding:20px;font-family:-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif;background-color:#f3f4f6;">
+  <table role="presentation" style="max-width:600px;width:100%;margin:0 auto;background-color:#ffffff;border-radius:8px;padding:30px;">
+    <tr><td>
+      <h1 style="margin:0 0 20px 0;color:#111827;font-size:22px;">¡Llegó tu preventa #{{.OrderNumber}}!</h1>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Hola <strong>{{.Name}}</strong>, ya tenemos reservado tu pedido: {{.Items}}.</p>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Para completarlo falta abonar el saldo de <strong>${{printf "%.2f" .Balance}}</strong> ({{.PaymentMethod}}).</p>
+      <p style="margin:24px 0;"><a href="{{.PayURL}}" style="display:inline-block;padding:12px 22px;background-color:#2563eb;color:#ffffff;border-radius:6px;text-decoration:none;font-weight:600;">Pagar el saldo</a></p>
+      <p style="color:#6b7280;font-size:13px;line-height:1.6;">Apenas se acredite el pago preparamos tu pedido para el envío o el retiro.</p>
+    </td></tr>
+  </table>
+</body>
+</html>`))
+
+// SendLoginLink manda el link de ingreso a "Mi cuenta". A diferencia de los avisos de órdenes, sin SMTP
+// devuelve error: el cliente no tiene otra forma de recibir el link.
+func (s *SMTPService) SendLoginLink(ctx context.Context, to, link string, validFor time.Duration) error {
+	if !s.enabled {
+		log.Warn().Msg("⚠️ SMTP no configurado - no se envió el link de ingreso")
+		return fmt.Errorf("SMTP no configurado")
+	}
+	if to == "" {
+		return fmt.Errorf("email vacío")
+	}
+
+	var buf bytes.Buffer
+	if err := loginLinkTmpl.Execute(&buf, map[string]any{
+		"Link":    link,
+		"Minutes": int(validFor.Minutes()),
+	}); err != nil {
+		return fmt.Errorf("error ejecutando template: %w", err)
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.from)
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", "Tu link para ingresar a Mi cuenta")
+	m.SetBody("text/html", buf.String())
+	if err := s.dialAndSend(m); err != nil {
+		log.Error().Err(err).Msg("❌ Error enviando link de ingreso")
+		return err
+	}
+	log.Info().Str("email", to).Msg("📧 Link de ingreso enviado")
+	return nil
+}
+
+var loginLinkTmpl = template.Must(template.New("login_link").Parse(`<!DOCTYPE html>
+<html lang="es">
+<body style="margin:0;padding:20px;font-family:-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif;background-color:#f3f4f6;">
+  <table role="presentation" style="max-width:600px;width:100%;margin:0 auto;background-color:#ffffff;border-radius:8px;padding:30px;">
+    <tr><td>
+      <h1 style="margin:0 0 20px 0;color:#111827;font-size:22px;">Ingresá a tu cuenta</h1>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Tocá el botón para entrar a <strong>Mi cuenta</strong> y ver tus compras. El link sirve una sola vez y vence en {{.Minutes}} minutos.</p>
+      <p style="margin:24px 0;"><a href="{{.Link}}" style="display:inline-block;padding:12px 22px;background-color:#2563eb;color:#ffffff;border-radius:6px;text-decoration:none;font-weight:600;">Ingresar</a></p>
+      <p style="color:#6b7280;font-size:13px;line-height:1.6;">Si no lo pediste vos, ignorá este email: nadie puede entrar a tu cuenta sin este link.</p>
+    </td></tr>
+  </table>
+</body>
+</html>`))
+
+// SendOrderCancelled avisa al comprador que su pedido se canceló.
+func (s *SMTPService) SendOrderCancelled(ctx context.Context, order *domain.Order, reason string) error {
+	if order == nil {
+		return fmt.Errorf("orden nil")
+	}
+	if !s.enabled {
+		log.Warn().Str("order_id", order.ID.String()).Msg("⚠️ SMTP no configurado - no se envió aviso de cancelación")
+		return nil
+	}
+	if order.Email == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := orderCancelledTmpl.Execute(&buf, map[string]any{
+		"Name":        order.Name,
+		"OrderNumber": order.Number(),
+		"Reason":      reason,
+		"ShopURL":     strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/") + "/",
+	}); err != nil {
+		return fmt.Errorf("error ejecutando template: %w", err)
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.from)
+	m.SetHeader("To", order.Email)
+	m.SetHeader("Subject", fmt.Sprintf("Tu pedido #%s fue cancelado", order.Number()))
+	m.SetBody("text/html", buf.String())
+	if err := s.dialAndSend(m); err != nil {
+		log.Error().Err(err).Str("order_id", order.ID.String()).Msg("❌ Error enviando aviso de cancelación")
+		return err
+	}
+	log.Info().Str("order_id", order.ID.String()).Str("email", order.Email).Msg("📧 Aviso de cancelación enviado")
+	return nil
+}
+
+var orderCancelledTmpl = template.Must(template.New("order_cancelled").Parse(`<!DOCTYPE html>
+<html lang="es">
+<body style="margin:0;padding:20px;font-family:-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif;background-color:#f3f4f6;">
+  <table role="presentation" style="max-width:600px;width:100%;margin:0 auto;background-color:#ffffff;border-radius:8px;padding:30px;">
+    <tr><td>
+      <h1 style="margin:0 0 20px 0;color:#111827;font-size:22px;">Pedido #{{.OrderNumber}} cancelado</h1>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Hola <strong>{{.Name}}</strong>, cancelamos tu pedido.</p>
+      {{if .Reason}}<p style="color:#6b7280;font-size:14px;">Motivo: {{.Reason}}</p>{{end}}
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Si igual querés los productos podés hacer un nuevo pedido desde <a href="{{.ShopURL}}" style="color:#2563eb;">la tienda</a>. Si ya habías pagado, respondé este correo con el comprobante y lo revisamos.</p>
+    </td></tr>
+  </table>
+</body>
+</html>`))
+
+// SendRMAUpdate avisa al comprador un cambio de estado de su devolución.
+func (s *SMTPService) SendRMAUpdate(ctx context.Context, order *domain.Order, rma *domain.RMA) error {
+	if order == nil || rma == nil {
+		return fmt.Errorf("orden o devolución nil")
+	}
+	if !s.enabled {
+		log.Warn().Str("order_id", order.ID.String()).Msg("⚠️ SMTP no configurado - no se envió aviso de devolución")
+		return nil
+	}
+	if order.Email == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := rmaUpdateTmpl.Execute(&buf, map[string]any{
+		"Name":        order.Name,
+		"OrderNumber": order.Number(),
+		"RMANumber":   rma.Number(),
+		"Status":      rma.Status,
+		"StatusLabel": domain.RMAStatusLabel(rma.Status),
+		"Resolution":  domain.RMAResolutionLabel(rma.Resolution),
+		"Lines":       rma.Lines,
+		"StaffNote":   rma.StaffNote,
+		"PayURL":      strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/") + "/pay/" + order.ID.String(),
+	}); err != nil {
+		return fmt.Errorf("error ejecutando template: %w", err)
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.from)
+	m.SetHeader("To", order.Email)
+	m.SetHeader("Subject", fmt.Sprintf("Devolución %s: %s", rma.Number(), strings.ToLower(domain.RMAStatusLabel(rma.Status))))
+	m.SetBody("text/html", buf.String())
+	if err := s.dialAndSend(m); err != nil {
+		log.Error().Err(err).Str("order_id", order.ID.String()).Msg("❌ Error enviando aviso de devolución")
+		return err
+	}
+	log.Info().Str("order_id", order.ID.String()).Str("rma", rma.Number()).Str("status", rma.Status).Msg("📧 Aviso de devolución enviado")
+	return nil
+}
+
+var rmaUpdateTmpl = template.Must(template.New("rma_update").Parse(`<!DOCTYPE html>
+<html lang="es">
+<body style="margin:0;padding:20px;font-family:-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif;background-color:#f3f4f6;">
+  <table role="presentation" style="max-width:600px;width:100%;margin:0 auto;background-color:#ffffff;border-radius:8px;padding:30px;">
+    <tr><td>
+      <h1 style="margin:0 0 20px 0;color:#111827;font-size:22px;">Devolución {{.RMANumber}}: {{.StatusLabel}}</h1>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Hola <strong>{{.Name}}</strong>,
+      {{if eq .Status "requested"}}recibimos tu pedido de devolución del pedido #{{.OrderNumber}}. Lo revisamos y te avisamos.
+      {{else if eq .Status "approved"}}aprobamos tu devolución. Traé o enviá el producto con su caja y accesorios, indicando el número {{.RMANumber}}.
+      {{else if eq .Status "rejected"}}no pudimos aprobar tu devolución del pedido #{{.OrderNumber}}.
+      {{else if eq .Status "received"}}recibimos el producto. Lo vamos a revisar y te avisamos la resolución.
+      {{else if eq .Status "inspected"}}revisamos el producto y estamos por resolver tu devolución.
+      {{else if eq .Status "resolved"}}resolvimos tu devolución: <strong>{{.Resolution}}</strong>.
+      {{end}}</p>
+      {{if .Lines}}
+      <ul style="color:#374151;font-size:15px;line-height:1.6;">
+        {{range .Lines}}<li>{{.Title}}{{if .Color}} ({{.Color}}){{end}} x{{.Qty}}</li>{{end}}
+      </ul>
+      {{end}}
+      {{if .StaffNote}}<p style="color:#6b7280;font-size:14px;">{{.StaffNote}}</p>{{end}}
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Podés seguir el estado desde <a href="{{.PayURL}}" style="color:#2563eb;">la página de tu pedido</a>.</p>
+    </td></tr>
+  </table>
+</body>
+</html>`))
+
+// SendRepairUpdate avisa al cliente un cambio de estado de su equipo en el servicio técnico.
+func (s *SMTPService) SendRepairUpdate(ctx context.Context, t *domain.RepairTicket) error {
+	if t == nil {
+		return fmt.Errorf("orden de servicio nil")
+	}
+	if !s.enabled {
+		log.Warn().Str("ticket_id", t.ID.String()).Msg("⚠️ SMTP no configurado - no se envió aviso de servicio técnico")
+		return nil
+	}
+	if t.Email == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := repairUpdateTmpl.Execute(&buf, map[string]any{
+		"Name":           t.Name,
+		"Number":         t.Number(),
+		"Device":         t.Device(),
+		"Status":         t.Status,
+		"StatusLabel":    t.StatusLabel(),
+		"Diagnostic":     t.Diagnostic,
+		"Budget":         t.EstimatedBudget,
+		"BudgetRejected": t.BudgetRejected,
+		"TicketURL":      strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/") + "/repair/" + t.ID.String(),
+	}); err != nil {
+		return fmt.Errorf("error ejecutando template: %w", err)
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", s.from)
+	m.SetHeader("To", t.Email)
+	m.SetHeader("Subject", fmt.Sprintf("Servicio técnico %s: %s", t.Number(), strings.ToLower(t.StatusLabel())))
+	m.SetBody("text/html", buf.String())
+	if err := s.dialAndSend(m); err != nil {
+		log.Error().Err(err).Str("ticket_id", t.ID.String()).Msg("❌ Error enviando aviso de servicio técnico")
+		return err
+	}
+	log.Info().Str("ticket_id", t.ID.String()).Str("status", t.Status).Msg("📧 Aviso de servicio técnico enviado")
+	return nil
+}
+
+var repairUpdateTmpl = template.Must(template.New("repair_update").Parse(`<!DOCTYPE html>
+<html lang="es">
+<body style="margin:0;padding:20px;font-family:-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif;background-color:#f3f4f6;">
+  <table role="presentation" style="max-width:600px;width:100%;margin:0 auto;background-color:#ffffff;border-radius:8px;padding:30px;">
+    <tr><td>
+      <h1 style="margin:0 0 20px 0;color:#111827;font-size:22px;">{{.Number}}: {{.StatusLabel}}</h1>
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Hola <strong>{{.Name}}</strong>,
+      {{if eq .Status "received"}}recibimos tu {{.Device}} en el servicio técnico. Guardá el número {{.Number}} para retirarlo.
+      {{else if eq .Status "diagnosing"}}estamos revisando tu {{.Device}}.
+      {{else if eq .Status "awaiting_approval"}}ya tenemos el diagnóstico de tu {{.Device}}. Para seguir necesitamos que apruebes el presupuesto.
+      {{else if eq .Status "repairing"}}estamos reparando tu {{.Device}}.
+      {{else if eq .Status "ready"}}{{if .BudgetRejected}}tu {{.Device}} está listo para retirar sin reparar.{{else}}tu {{.Device}} está listo para retirar.{{end}}
+      {{else if eq .Status "delivered"}}entregamos tu {{.Device}}. ¡Gracias por confiar en nosotros!
+      {{end}}</p>
+      {{if and (eq .Status "awaiting_approval") .Diagnostic}}
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Diagnóstico: {{.Diagnostic}}<br>Presupuesto: <strong>${{printf "%.2f" .Budget}}</strong></p>
+      {{end}}
+      <p style="color:#374151;font-size:15px;line-height:1.6;">Podés seguir el estado{{if eq .Status "awaiting_approval"}} y responder el presupuesto{{end}} desde <a href="{{.TicketURL}}" style="color:#2563eb;">la página de tu orden de servicio</a>.</p>
+    </td></tr>
+  </table>
+</body>
+</html>`))
+
 type ItemData struct {
 	Title    string
 	Color    string
@@ -153,11 +685,15 @@ type EmailData struct {
 	Address        string
 	PostalCode     string
 	Province       string
+	// CashNote indica cómo se abona una orden en efectivo (vacío para los demás métodos o si ya está paga).
+	CashNote string
+	// PreOrderNote explica la seña y el saldo de una preventa (vacío si no es preventa).
+	PreOrderNote string
 }
 
 func (s *SMTPService) generateOrderHTML(order *domain.Order) (string, error) {
 	// Preparar datos
-	orderNumber := order.ID.String()[:8]
+	orderNumber := order.Number()
 	paymentMethod := s.mapPaymentMethod(order.PaymentMethod)
 
 	items := make([]ItemData, 0, len(order.Items))
@@ -182,6 +718,18 @@ func (s *SMTPService) generateOrderHTML(order *domain.Order) (string, error) {
 		PostalCode:     order.PostalCode,
 		Province:       order.Province,
 	}
+	if order.PaymentMethod == "efectivo" && order.PaymentStatus != "approved" {
+		data.CashNote = "Abonás en efectivo al retirar tu pedido en el local."
+		if order.ShippingMethod == "cadete" {
+			data.CashNote = "Abonás en efectivo al cadete cuando recibas tu pedido."
+		}
+	}
+	if order.PreOrder && order.PaymentStatus != "approved" {
+		data.PreOrderNote = fmt.Sprintf("Es una preventa: ahora abonás la seña de $%.2f y el saldo te lo pedimos por email cuando llegue el stock.", order.DepositAmount)
+		if order.PreOrderETA != nil {
+			data.PreOrderNote += " Llegada estimada: " + order.PreOrderETA.Format("02/01/2006") + "."
+		}
+	}
 
 	// Template HTML
 	tmpl := `<!DOCTYPE html>
@@ -222,6 +770,8 @@ func (s *SMTPService) generateOrderHTML(order *domain.Order) (string, error) {
                                         <p style="margin: 0 0 16px 0; color: #111827; font-size: 20px; font-weight: 600;">#{{.OrderNumber}}</p>
                                         <p style="margin: 0; color: #6b7280; font-size: 14px; font-weight: 500; text-transform: uppercase; letter-spacing: 0.5px;">Método de Pago</p>
                                         <p style="margin: 0; color: #111827; font-size: 16px; font-weight: 500;">{{.PaymentMethod}}</p>
+                                        {{if .CashNote}}<p style="margin: 8px 0 0 0; color: #92400e; font-size: 14px;">{{.CashNote}}</p>{{end}}
+                                        {{if .PreOrderNote}}<p style="margin: 8px 0 0 0; color: #92400e; font-size: 14px;">{{.PreOrderNote}}</p>{{end}}
                                     </td>
                                 </tr>
                             </table>
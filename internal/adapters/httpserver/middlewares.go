@@ -8,6 +8,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -181,13 +182,15 @@ func RateLimit(maxPerMin int) func(http.Handler) http.Handler {
 	}
 }
 
+// PublicRateLimit limita por IP los pedidos por minuto a cada ruta. Un segmento "*" en la ruta (p.ej.
+// "/pay/*/receipt") coincide con cualquier valor y todas esas rutas comparten el límite de la IP.
 func PublicRateLimit(perPathLimits map[string]int) func(http.Handler) http.Handler {
 
 	var mu sync.Mutex
 	buckets := map[string]*bucket{}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			limit, ok := perPathLimits[r.URL.Path]
+			pattern, limit, ok := matchPathLimit(perPathLimits, r.URL.Path)
 			if !ok {
 				next.ServeHTTP(w, r)
 				return
@@ -196,7 +199,7 @@ func PublicRateLimit(perPathLimits map[string]int) func(http.Handler) http.Handl
 			if ip == "" {
 				ip = r.RemoteAddr
 			}
-			key := r.URL.Path + "|" + ip
+			key := pattern + "|" + ip
 			mu.Lock()
 			b, ok := buckets[key]
 			if !ok || time.Since(b.ts) > time.Minute {
@@ -219,6 +222,34 @@ func PublicRateLimit(perPathLimits map[string]int) func(http.Handler) http.Handl
 	}
 }
 
+// matchPathLimit busca el límite de la ruta: primero exacto y después entre los patrones con "*".
+func matchPathLimit(limits map[string]int, path string) (string, int, bool) {
+	if limit, ok := limits[path]; ok {
+		return path, limit, true
+	}
+	segs := strings.Split(path, "/")
+	for pattern, limit := range limits {
+		if !strings.Contains(pattern, "*") {
+			continue
+		}
+		psegs := strings.Split(pattern, "/")
+		if len(psegs) != len(segs) {
+			continue
+		}
+		match := true
+		for i := range psegs {
+			if psegs[i] != "*" && psegs[i] != segs[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return pattern, limit, true
+		}
+	}
+	return "", 0, false
+}
+
 func strconvItoa(i int) string { return fmtInt(i) }
 
 func fmtInt(i int) string {
@@ -265,3 +296,14 @@ func fmtHex(b []byte) string {
 	}
 	return string(out)
 }
+
+// blockPrefix responde 404 a las rutas bajo prefix (p.ej. archivos privados dentro de un directorio público).
+func blockPrefix(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(path.Clean(r.URL.Path)+"/", prefix) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -6,14 +6,15 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
-	"net/smtp"
 	"net/url"
 	"os"
 	"regexp"
@@ -28,7 +29,9 @@ import (
 	"github.com/sashabaranov/go-openai"
 	"golang.org/x/oauth2"
 
+	"github.com/phenrril/tienda3d/internal/adapters/payments/fake"
 	"github.com/phenrril/tienda3d/internal/adapters/payments/mercadopago"
+	"github.com/phenrril/tienda3d/internal/adapters/storage/localfs"
 	"github.com/phenrril/tienda3d/internal/adapters/scraper"
 	"github.com/phenrril/tienda3d/internal/domain"
 	"github.com/phenrril/tienda3d/internal/usecase"
@@ -43,6 +46,20 @@ type Server struct {
 	quotes           *usecase.QuoteUC
 	orders           *usecase.OrderUC
 	payments         *usecase.PaymentUC
+	refunds          *usecase.RefundUC
+	receipts         *usecase.ReceiptUC
+	crypto           *usecase.CryptoUC
+	expiry           *usecase.ExpiryUC
+	invoices         *usecase.InvoiceUC
+	rmas             *usecase.RMAUC
+	repairs          *usecase.RepairUC
+	warranties       *usecase.WarrantyUC
+	preorders        *usecase.PreOrderUC
+	notifications    *usecase.NotificationUC
+	whatsapp         *usecase.WhatsAppUC
+	webhooks         *usecase.WebhookUC
+	accounts         *usecase.AccountUC
+	loginLinks       *usecase.LoginLinkUC
 	models           domain.UploadedModelRepo
 	storage          domain.FileStorage
 	customers        domain.CustomerRepo
@@ -52,6 +69,7 @@ type Server struct {
 	scraper          *scraper.SpecsScraper
 	imageScraper     *scraper.ImageScraper
 	emailService     domain.EmailService
+	docs             domain.OrderDocuments
 
 	adminAllowed map[string]struct{}
 	adminSecret  []byte
@@ -65,8 +83,8 @@ type Server struct {
 
 var emailRe = regexp.MustCompile(`^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$`)
 
-func New(t *template.Template, p *usecase.ProductUC, q *usecase.QuoteUC, o *usecase.OrderUC, pay *usecase.PaymentUC, m domain.UploadedModelRepo, fs domain.FileStorage, customers domain.CustomerRepo, featuredProducts domain.FeaturedProductRepo, starProduct domain.StarProductRepo, oauthCfg *oauth2.Config, emailService domain.EmailService) http.Handler {
-	s := &Server{tmpl: t, products: p, quotes: q, orders: o, payments: pay, models: m, storage: fs, customers: customers, featuredProducts: featuredProducts, starProduct: starProduct, oauthCfg: oauthCfg, scraper: scraper.NewSpecsScraper(), imageScraper: scraper.NewImageScraper(), emailService: emailService, mux: http.NewServeMux(), assetVersion: fmt.Sprintf("%d", time.Now().Unix()), bannerImages: loadBannerImages()}
+func New(t *template.Template, p *usecase.ProductUC, q *usecase.QuoteUC, o *usecase.OrderUC, pay *usecase.PaymentUC, refunds *usecase.RefundUC, receipts *usecase.ReceiptUC, crypto *usecase.CryptoUC, expiry *usecase.ExpiryUC, invoices *usecase.InvoiceUC, rmas *usecase.RMAUC, repairs *usecase.RepairUC, warranties *usecase.WarrantyUC, preorders *usecase.PreOrderUC, notifications *usecase.NotificationUC, whatsapp *usecase.WhatsAppUC, webhooks *usecase.WebhookUC, accounts *usecase.AccountUC, loginLinks *usecase.LoginLinkUC, m domain.UploadedModelRepo, fs domain.FileStorage, customers domain.CustomerRepo, featuredProducts domain.FeaturedProductRepo, starProduct domain.StarProductRepo, oauthCfg *oauth2.Config, emailService domain.EmailService, docs domain.OrderDocuments) http.Handler {
+	s := &Server{tmpl: t, products: p, quotes: q, orders: o, payments: pay, refunds: refunds, receipts: receipts, crypto: crypto, expiry: expiry, invoices: invoices, rmas: rmas, repairs: repairs, warranties: warranties, preorders: preorders, notifications: notifications, whatsapp: whatsapp, webhooks: webhooks, accounts: accounts, loginLinks: loginLinks, models: m, storage: fs, customers: customers, featuredProducts: featuredProducts, starProduct: starProduct, oauthCfg: oauthCfg, scraper: scraper.NewSpecsScraper(), imageScraper: scraper.NewImageScraper(), emailService: emailService, docs: docs, mux: http.NewServeMux(), assetVersion: fmt.Sprintf("%d", time.Now().Unix()), bannerImages: loadBannerImages()}
 
 	allowed := map[string]struct{}{}
 	if raw := os.Getenv("ADMIN_ALLOWED_EMAILS"); raw != "" {
@@ -93,6 +111,10 @@ func New(t *template.Template, p *usecase.ProductUC, q *usecase.QuoteUC, o *usec
 			"/api/quote":    15,
 			"/api/checkout": 10,
 			"/webhooks/mp":  30,
+			"/login":        10,
+			"/auth/email":   20,
+			// Subida pública de comprobantes de transferencia/cripto (hasta 10 MB cada uno).
+			"/pay/*/receipt": 5,
 		}),
 		RateLimit(60),
 		SecurityAndStaticCache,
@@ -107,7 +129,7 @@ func (s *Server) routes() {
 
 	s.mux.Handle("/public/", http.StripPrefix("/public/", http.FileServer(http.Dir("public"))))
 
-	s.mux.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("uploads"))))
+	s.mux.Handle("/uploads/", blockPrefix("/uploads/"+localfs.ReceiptsDir+"/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("uploads")))))
 
 	// SEO endpoints
 	s.mux.HandleFunc("/robots.txt", s.handleRobots)
@@ -119,6 +141,8 @@ func (s *Server) routes() {
 	s.mux.HandleFunc("/quote/", s.handleQuoteView)
 	s.mux.HandleFunc("/checkout", s.handleCheckout)
 	s.mux.HandleFunc("/pay/", s.handlePaySimulated)
+	s.mux.HandleFunc("/repair/", s.handleRepair)
+	s.mux.HandleFunc("/warranty", s.handleWarranty)
 
 	s.mux.HandleFunc("/cart", s.handleCart)
 	s.mux.HandleFunc("/cart/update", s.handleCartUpdate)
@@ -150,22 +174,49 @@ func (s *Server) routes() {
 	s.mux.HandleFunc("/api/quote", s.apiQuote)
 	s.mux.HandleFunc("/api/checkout", s.apiCheckout)
 	s.mux.HandleFunc("/webhooks/mp", s.webhookMP)
+	// Gateway simulado (PAYMENT_GATEWAY=fake): página de pago local.
+	if s.payments != nil {
+		if fg, ok := s.payments.Gateway.(interface{ Handler() http.Handler }); ok {
+			s.mux.Handle(fake.PathPrefix, fg.Handler())
+		}
+	}
 	s.mux.HandleFunc("/api/products/delete", s.apiProductsBulkDelete)
 
 	s.mux.HandleFunc("/auth/google/login", s.handleGoogleLogin)
 	s.mux.HandleFunc("/auth/google/callback", s.handleGoogleCallback)
 	s.mux.HandleFunc("/logout", s.handleLogout)
+	s.mux.HandleFunc("/login", s.handleLogin)
+	s.mux.HandleFunc("/auth/email", s.handleEmailLogin)
+	s.mux.HandleFunc("/cuenta", s.handleAccount)
+	s.mux.HandleFunc("/cuenta/", s.handleAccount)
 
 	s.mux.HandleFunc("/admin/login", s.handleAdminLogin)
 	s.mux.HandleFunc("/admin/auth", s.handleAdminAuth)
 	s.mux.HandleFunc("/admin/logout", s.handleAdminLogout)
 
 	s.mux.HandleFunc("/admin/orders", s.handleAdminOrders)
+	s.mux.HandleFunc("/admin/orders/", s.handleAdminOrderDetail)
+	s.mux.HandleFunc("/api/orders", s.apiOrders)
 	s.mux.HandleFunc("/admin/products", s.handleAdminProducts)
 	s.mux.HandleFunc("/admin/featured", s.handleAdminFeatured)
 	s.mux.HandleFunc("/admin/confirm-payment", s.handleAdminConfirmPayment)
 
 	s.mux.HandleFunc("/admin/sales", s.handleAdminSales)
+	s.mux.HandleFunc("/admin/reconcile", s.handleAdminReconcile)
+	s.mux.HandleFunc("/admin/refund", s.handleAdminRefund)
+	s.mux.HandleFunc("/admin/receipts", s.handleAdminReceipts)
+	s.mux.HandleFunc("/admin/receipts/file", s.handleAdminReceiptFile)
+	s.mux.HandleFunc("/admin/rmas", s.handleAdminRMAs)
+	s.mux.HandleFunc("/admin/rmas/", s.handleAdminRMADetail)
+	s.mux.HandleFunc("/admin/repairs", s.handleAdminRepairs)
+	s.mux.HandleFunc("/admin/repairs/", s.handleAdminRepairDetail)
+	s.mux.HandleFunc("/admin/warranties", s.handleAdminWarranties)
+	s.mux.HandleFunc("/admin/warranties/claims/", s.handleAdminWarrantyClaim)
+	s.mux.HandleFunc("/admin/preorders", s.handleAdminPreOrders)
+	s.mux.HandleFunc("/admin/notifications", s.handleAdminNotifications)
+	s.mux.HandleFunc("/admin/notifications/settings", s.handleAdminNotificationSettings)
+	s.mux.HandleFunc("/admin/notifications/whatsapp", s.handleAdminWhatsAppLog)
+	s.mux.HandleFunc("/admin/webhooks", s.handleAdminWebhooks)
 
 	// API endpoints para productos destacados
 	s.mux.HandleFunc("/api/featured", s.apiFeatured)
@@ -286,6 +337,24 @@ func (s *Server) handleProduct(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 	}
+	// Los colores sin stock marcados para preventa se muestran al final, reservables con seña.
+	preorders := map[string]*preOrderInfo{}
+	for _, v := range p.Variants {
+		c := strings.TrimSpace(v.Color)
+		if c == "" || !v.PreOrderable() || len(colors) >= 16 {
+			continue
+		}
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		colors = append(colors, c)
+		info := &preOrderInfo{ETA: v.PreOrderETA, DepositPct: v.DepositPct}
+		if s.preorders != nil {
+			info.DepositPct = s.preorders.DepositPct(v)
+		}
+		preorders[c] = info
+	}
 	defaultColor := "#111827"
 	if len(colors) > 0 {
 		defaultColor = colors[0]
@@ -307,13 +376,19 @@ func (s *Server) handleProduct(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-	data := map[string]any{"Product": p, "Colors": colors, "DefaultColor": defaultColor, "Added": added, "CanonicalURL": base + "/product/" + p.Slug, "OGImage": og}
+	data := map[string]any{"Product": p, "Colors": colors, "DefaultColor": defaultColor, "Added": added, "CanonicalURL": base + "/product/" + p.Slug, "OGImage": og, "PreOrders": preorders}
 	if u := readUserSession(w, r); u != nil {
 		data["User"] = u
 	}
 	s.render(w, "product.html", data)
 }
 
+// preOrderInfo es lo que se muestra de un color en preventa.
+type preOrderInfo struct {
+	ETA        *time.Time
+	DepositPct float64
+}
+
 // canonicalBase arma el esquema y host para URLs absolutas
 func (s *Server) canonicalBase(r *http.Request) string {
 	host := r.Header.Get("X-Forwarded-Host")
@@ -1085,6 +1160,9 @@ func (s *Server) apiProductVariants(w http.ResponseWriter, r *http.Request) {
 			Stock      int               `json:"stock"`
 			ImageURL   string            `json:"image_url"`
 			Color      string            `json:"color"`
+			PreOrder   bool              `json:"pre_order"`
+			ETA        *time.Time        `json:"pre_order_eta"`
+			DepositPct float64           `json:"deposit_pct"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "json", 400)
@@ -1105,7 +1183,10 @@ func (s *Server) apiProductVariants(w http.ResponseWriter, r *http.Request) {
 		v.Stock = req.Stock
 		v.ImageURL = strings.TrimSpace(req.ImageURL)
 		v.Color = strings.TrimSpace(req.Color)
-		if v.Price < 0 || v.Cost < 0 || v.Stock < 0 {
+		v.PreOrder = req.PreOrder
+		v.PreOrderETA = req.ETA
+		v.DepositPct = req.DepositPct
+		if v.Price < 0 || v.Cost < 0 || v.Stock < 0 || v.DepositPct < 0 || v.DepositPct > 100 {
 			http.Error(w, "datos", 400)
 			return
 		}
@@ -1282,12 +1363,24 @@ func (s *Server) webhookMP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		return
 	}
-	status, extRef, err := s.payments.Gateway.PaymentInfo(r.Context(), payID)
+	// Con MP_WEBHOOK_SECRET configurado sólo se aceptan notificaciones firmadas (header x-signature).
+	if secret := os.Getenv("MP_WEBHOOK_SECRET"); secret != "" {
+		dataID := r.URL.Query().Get("data.id")
+		if dataID == "" {
+			dataID = payID
+		}
+		if !mercadopago.VerifyWebhookSignature(secret, r.Header.Get("x-signature"), r.Header.Get("x-request-id"), dataID) {
+			log.Warn().Str("payment_id", payID).Msg("webhook MP: firma inválida")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+	}
+	gp, err := s.payments.Gateway.PaymentInfo(r.Context(), payID)
 	if err != nil {
 		w.WriteHeader(200)
 		return
 	}
-	orderID, ok := mercadopago.VerifyExternalRef(extRef)
+	orderID, ok := mercadopago.VerifyExternalRef(gp.ExternalRef)
 	if !ok {
 		w.WriteHeader(200)
 		return
@@ -1302,32 +1395,8 @@ func (s *Server) webhookMP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		return
 	}
-	approved := false
-	switch status {
-	case "approved":
-		approved = true
-		o.MPStatus = "approved"
-		o.Status = domain.OrderStatusFinished
-	case "pending", "in_process", "in_mediation":
-		o.MPStatus = status
-		if o.Status != domain.OrderStatusFinished {
-			o.Status = domain.OrderStatusAwaitingPay
-		}
-	default:
-		o.MPStatus = status
-		if status == "rejected" {
-			o.Status = domain.OrderStatusCancelled
-		}
-	}
-	notify := false
-	if approved && !o.Notified {
-		o.Notified = true
-		notify = true
-	}
-	if err := s.orders.Orders.Save(r.Context(), o); err != nil {
-	}
-	if notify {
-		go s.sendOrderNotify(o, true)
+	if _, err := s.payments.ApplyGatewayStatus(r.Context(), o, gp); err != nil {
+		log.Error().Err(err).Str("order_id", o.ID.String()).Msg("webhook MP: error guardando orden")
 	}
 	w.WriteHeader(200)
 }
@@ -1594,48 +1663,32 @@ func (s *Server) handleCartRemove(w http.ResponseWriter, r *http.Request) {
 // normalizeColorName transforma códigos hex válidos en nombres simples si hay match
 // y limpia espacios. Para hex que no matchean, deja el valor original.
 func normalizeColorName(c string) string {
-	s := strings.TrimSpace(c)
-	if s == "" {
-		return s
-	}
-	lower := strings.ToLower(s)
-	// Map comunes
-	m := map[string]string{
-		"#111827": "Negro",
-		"#000000": "Negro",
-		"#ffffff": "Blanco",
-		"#ff0000": "Rojo",
-		"#dc2626": "Rojo",
-		"#10b981": "Verde",
-		"#3b82f6": "Azul",
-		"#6366f1": "Violeta",
-		"#f59e0b": "Amarillo",
-		"#ef4444": "Rojo",
-		"#8b5cf6": "Violeta",
-		"#ec4899": "Rosa",
-		"#14b8a6": "Turquesa",
-		"#f472b6": "Rosa",
-		"#fcd34d": "Amarillo",
-		"#a3e635": "Lima",
-		"#334155": "Gris oscuro",
-		"#64748b": "Gris",
-	}
-	if name, ok := m[lower]; ok {
-		return name
-	}
-	return s
+	return domain.ColorName(c)
 }
 
-func formatColorES(c string) string {
-	s := strings.TrimSpace(c)
-	if s == "" {
-		return ""
+// variantForColor busca la variante del producto que corresponde al color elegido en el carrito.
+func variantForColor(p *domain.Product, color string) *domain.Variant {
+	c := strings.TrimSpace(color)
+	if c == "" {
+		if len(p.Variants) == 1 {
+			return &p.Variants[0]
+		}
+		return nil
 	}
-	// Si es hex, devolver nombre si lo conocemos
-	if strings.HasPrefix(s, "#") && (len(s) == 7 || len(s) == 4) {
-		return normalizeColorName(s)
+	// Si el color tiene una variante con stock y otra en preventa, se vende la que tiene stock.
+	var match *domain.Variant
+	for i := range p.Variants {
+		vc := strings.TrimSpace(p.Variants[i].Color)
+		if strings.EqualFold(vc, c) || strings.EqualFold(normalizeColorName(vc), normalizeColorName(c)) {
+			if p.Variants[i].Stock > 0 {
+				return &p.Variants[i]
+			}
+			if match == nil {
+				match = &p.Variants[i]
+			}
+		}
 	}
-	return s
+	return match
 }
 
 func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
@@ -1684,7 +1737,8 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Extraer datos del paso 2 (datos personales)
-	var email, firstName, lastName, dni, areaCode, phoneNumber string
+	var email, firstName, lastName, dni, areaCode, phoneNumber, phoneType string
+	var whatsAppOptIn bool
 	if isJSON {
 		if step2Data != nil {
 			if v, ok := step2Data["email"].(string); ok {
@@ -1705,6 +1759,12 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 			if v, ok := step2Data["phoneNumber"].(string); ok {
 				phoneNumber = v
 			}
+			if v, ok := step2Data["phoneType"].(string); ok {
+				phoneType = v
+			}
+			if v, ok := step2Data["whatsappOptIn"].(bool); ok {
+				whatsAppOptIn = v
+			}
 		}
 	} else {
 		email = r.FormValue("email")
@@ -1811,10 +1871,20 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 		"mercadopago":   true,
 		"transferencia": true,
 		"cripto":        true,
+		"efectivo":      true,
 	}
 	if !validPaymentMethods[paymentMethod] {
 		paymentMethod = "mercadopago"
 	}
+	// El efectivo se cobra en el local o lo cobra el cadete: no aplica a envíos por correo.
+	if paymentMethod == "efectivo" && shippingMethod != "retiro" && shippingMethod != "cadete" {
+		if isJSON {
+			writeJSON(w, 400, map[string]string{"error": "el pago en efectivo sólo está disponible para retiro en el local o cadete"})
+		} else {
+			http.Redirect(w, r, "/cart?err=efectivo", 302)
+		}
+		return
+	}
 
 	// Validaciones
 	if shippingMethod == "envio" {
@@ -1881,6 +1951,7 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 				Email: strings.ToLower(email),
 				Name:  name,
 				Phone: phone,
+				DNI:   dni,
 			}
 			if err := s.customers.Save(r.Context(), newCust); err == nil {
 				customerID = &newCust.ID
@@ -1889,6 +1960,9 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 			// Actualizar cliente existente
 			cust.Name = name
 			cust.Phone = phone
+			if cust.DNI == "" {
+				cust.DNI = dni
+			}
 			if err := s.customers.Save(r.Context(), cust); err == nil {
 				customerID = &cust.ID
 			}
@@ -1912,6 +1986,15 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 		Total:          0.0, // Se calculará después
 		Notified:       false,
 	}
+	// WhatsApp sólo con opt-in y un número que se pueda pasar a E.164; si no, la orden sigue sin avisos.
+	if whatsAppOptIn {
+		if wa, err := domain.NormalizeARPhone(areaCode, phoneNumber, phoneType != "fijo"); err == nil {
+			optInAt := time.Now()
+			o.WhatsAppPhone, o.WhatsAppOptIn, o.WhatsAppOptInAt = wa, true, &optInAt
+		} else {
+			log.Warn().Err(err).Str("area", areaCode).Msg("checkout: teléfono inválido para WhatsApp, se ignora el opt-in")
+		}
+	}
 
 	itemsTotal := 0.0
 	for _, l := range lines {
@@ -1924,14 +2007,23 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 		} else {
 			title = "Producto"
 		}
-		o.Items = append(o.Items, domain.OrderItem{
+		item := domain.OrderItem{
 			ID:        uuid.New(),
 			ProductID: pid,
 			Qty:       l.Qty,
 			UnitPrice: l.UnitPrice,
 			Title:     title,
 			Color:     normalizeColorName(l.Color),
-		})
+		}
+		// Vincular la variante (por color) para poder reponer stock en reembolsos/devoluciones.
+		if p != nil {
+			if v := variantForColor(p, l.Color); v != nil {
+				item.VariantID = &v.ID
+				item.SKU = v.SKU
+				item.EAN = v.EAN
+			}
+		}
+		o.Items = append(o.Items, item)
 		itemsTotal += l.UnitPrice * float64(l.Qty)
 	}
 
@@ -1969,14 +2061,47 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 	subtotal := itemsTotal + shippingCost
 
 	// Aplicar descuento según método de pago.
-	o.DiscountAmount = 0.0
-	if paymentMethod == "transferencia" {
-		o.DiscountAmount = subtotal * 0.05
-	} else if paymentMethod == "cripto" {
-		o.DiscountAmount = subtotal * 0.10
-	}
+	o.DiscountAmount = subtotal * domain.PaymentDiscountRate(paymentMethod)
 	o.Total = subtotal - o.DiscountAmount
 
+	// Preventa: se cobra sólo la seña.
+	if s.preorders != nil {
+		if err := s.preorders.Apply(r.Context(), o); err != nil {
+			msg := "error preparando la preventa"
+			if errors.Is(err, domain.ErrPreOrderMixed) {
+				msg = err.Error()
+			}
+			if isJSON {
+				writeJSON(w, 400, map[string]string{"error": msg})
+			} else {
+				http.Redirect(w, r, "/cart?err=preventa", 302)
+			}
+			return
+		}
+	}
+
+	// Los pagos manuales quedan pendientes desde el alta: el estado y los avisos de orden nueva se guardan
+	// con la misma Save que crea la orden, así no hay orden sin aviso ni aviso sin orden.
+	switch paymentMethod {
+	case "transferencia":
+		o.PaymentStatus = "transferencia_pending"
+		s.queueOrderNotify(o)
+	case "efectivo":
+		// Queda pendiente hasta que caja (o el cadete) registra el cobro en /admin/confirm-payment.
+		o.PaymentStatus = "efectivo_pending"
+		s.queueOrderNotify(o)
+	case "cripto":
+		// Orden con pago cripto pendiente de confirmación manual
+		o.PaymentStatus = "crypto_pending"
+		if s.crypto != nil {
+			// Cotización fija: monto exacto en stablecoins (identifica la transferencia en la cadena) hasta que venza.
+			if err := s.crypto.Quote(r.Context(), o); err != nil {
+				log.Warn().Err(err).Str("order_id", o.ID.String()).Msg("cripto: orden sin cotización")
+			}
+		}
+		s.queueOrderNotify(o)
+	}
+
 	if err := s.orders.Orders.Save(r.Context(), o); err != nil {
 		if isJSON {
 			writeJSON(w, 500, map[string]string{"error": "error creando orden: " + err.Error()})
@@ -1985,34 +2110,24 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	note := "pago: " + paymentMethod
+	if o.PreOrder {
+		note += fmt.Sprintf(" · preventa, seña $%.2f", o.DepositAmount)
+	}
+	s.orders.RecordCreated(r.Context(), o, domain.StatusChange{Actor: o.Email, Source: domain.StatusSourceCheckout, Note: note})
+	// Con la sesión iniciada, la dirección de envío queda guardada en "Mi cuenta" para la próxima compra.
+	if u := readUserSession(w, r); u != nil && customerID != nil && o.ShippingMethod != "retiro" && strings.EqualFold(u.Email, email) {
+		s.accounts.RememberAddress(r.Context(), *customerID, o.Address, o.PostalCode, o.Province, o.DeliveryNotes)
+	}
 
 	// Limpiar datos del checkout
 	writeCheckoutData(w, checkoutDataPayload{})
 
 	// Manejar según método de pago
 	switch paymentMethod {
-	case "transferencia":
-		// Orden con pago pendiente
-		o.Status = domain.OrderStatusAwaitingPay
-		o.MPStatus = "transferencia_pending"
-		_ = s.orders.Orders.Save(r.Context(), o)
-		s.sendOrderNotify(o, false)
-		writeCart(w, cartPayload{})
-		if isJSON {
-			writeJSON(w, 200, map[string]interface{}{
-				"success":      true,
-				"order_id":     o.ID.String(),
-				"redirect_url": "/pay/" + o.ID.String() + "?status=pending",
-			})
-		} else {
-			http.Redirect(w, r, "/pay/"+o.ID.String()+"?status=pending", 302)
-		}
-	case "cripto":
-		// Orden con pago cripto pendiente de confirmación manual
-		o.Status = domain.OrderStatusAwaitingPay
-		o.MPStatus = "crypto_pending"
-		_ = s.orders.Orders.Save(r.Context(), o)
-		s.sendOrderNotify(o, false)
+	case "transferencia", "efectivo", "cripto":
+		// Orden con pago pendiente: ya quedó guardada con sus avisos.
+		s.notifications.Kick()
 		writeCart(w, cartPayload{})
 		if isJSON {
 			writeJSON(w, 200, map[string]interface{}{
@@ -2053,6 +2168,7 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 		}
 		// Guardar la orden con el MPPreferenceID actualizado
 		if err := s.orders.Orders.Save(r.Context(), o); err != nil {
+			log.Error().Err(err).Str("order_id", o.ID.String()).Msg("checkout: no se pudo guardar la preferencia de MP")
 		}
 		writeCart(w, cartPayload{})
 		if isJSON {
@@ -2069,8 +2185,8 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 		redirURL, err := s.payments.CreatePreference(r.Context(), o)
 		if err != nil {
 			redirURL = "/pay/" + o.ID.String()
-		} else {
-			_ = s.orders.Orders.Save(r.Context(), o)
+		} else if err := s.orders.Orders.Save(r.Context(), o); err != nil {
+			log.Error().Err(err).Str("order_id", o.ID.String()).Msg("checkout: no se pudo guardar la preferencia de MP")
 		}
 		writeCart(w, cartPayload{})
 		if isJSON {
@@ -2087,6 +2203,22 @@ func (s *Server) handleCartCheckout(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handlePaySimulated(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/pay/")
+	if strings.HasSuffix(idStr, "/receipt") {
+		s.handlePayReceipt(w, r, strings.TrimSuffix(idStr, "/receipt"))
+		return
+	}
+	if strings.HasSuffix(idStr, "/requote") {
+		s.handlePayRequote(w, r, strings.TrimSuffix(idStr, "/requote"))
+		return
+	}
+	if strings.HasSuffix(idStr, "/return") {
+		s.handlePayReturn(w, r, strings.TrimSuffix(idStr, "/return"))
+		return
+	}
+	if strings.HasSuffix(idStr, "/mp") {
+		s.handlePayMP(w, r, strings.TrimSuffix(idStr, "/mp"))
+		return
+	}
 	uid, err := uuid.Parse(idStr)
 	if err != nil {
 		http.NotFound(w, r)
@@ -2102,26 +2234,27 @@ func (s *Server) handlePaySimulated(w http.ResponseWriter, r *http.Request) {
 	if status == "" {
 		status = strings.ToLower(q.Get("collection_status"))
 	}
-	success := false
-	if status == "approved" {
-		success = true
-	}
-	if status != "" {
-		if success {
-			o.MPStatus = "approved"
-			o.Status = domain.OrderStatusFinished
-			if !o.Notified {
-				o.Notified = true
-				_ = s.orders.Orders.Save(r.Context(), o)
-				go s.sendOrderNotify(o, true)
-			} else {
-				_ = s.orders.Orders.Save(r.Context(), o)
+	// MP vuelve con payment_id: el estado real se consulta al gateway y se registra en el ledger,
+	// nunca se confía en el query string.
+	payID := q.Get("payment_id")
+	if payID == "" {
+		payID = q.Get("collection_id")
+	}
+	if payID != "" && payID != "null" && s.payments != nil {
+		if gp, err := s.payments.Gateway.PaymentInfo(r.Context(), payID); err == nil {
+			if ref, ok := mercadopago.VerifyExternalRef(gp.ExternalRef); ok && ref == o.ID.String() {
+				if _, err := s.payments.ApplyGatewayStatus(r.Context(), o, gp); err != nil {
+					log.Error().Err(err).Str("order_id", o.ID.String()).Msg("pay: error aplicando pago MP")
+				}
+				status = gp.Status
 			}
-		} else {
-			o.MPStatus = status
-			_ = s.orders.Orders.Save(r.Context(), o)
 		}
 	}
+	success := o.PaymentStatus == "approved"
+	if status == "approved" && !success {
+		// Aprobado según el redirect pero todavía sin confirmar por MP: esperar el webhook.
+		status = "pending"
+	}
 	msg := "Pago pendiente / simulado"
 	if status == "rejected" {
 		msg = "El pago fue rechazado."
@@ -2131,18 +2264,83 @@ func (s *Server) handlePaySimulated(w http.ResponseWriter, r *http.Request) {
 	}
 	// Mensajes específicos según método de pago
 	if o.PaymentMethod == "efectivo" && status == "pending" {
-		msg = "Pedido recibido. Te contactaremos para coordinar el pago en efectivo."
+		msg = "Pedido recibido. Abonás en efectivo al retirarlo en el local."
+		if o.ShippingMethod == "cadete" {
+			msg = "Pedido recibido. Abonás en efectivo al cadete cuando lo recibas."
+		}
 	} else if o.PaymentMethod == "transferencia" && status == "pending" {
 		msg = "Pedido recibido. Por favor realiza la transferencia y envía el comprobante."
 	} else if o.PaymentMethod == "cripto" && status == "pending" {
 		msg = "Pedido recibido. Por favor realiza el pago en USDT/USDC (BSC) y envía el comprobante."
 	}
+	// Preventa con la seña paga: no hay nada más que pagar hasta que llegue el stock.
+	awaitingStock := o.AwaitingDeposit() && o.PaymentStatus == "partial"
+	if awaitingStock {
+		msg = "Recibimos tu seña. Te avisamos por email cuando llegue el stock para que pagues el saldo."
+	} else if o.PreOrder && !success && o.PaymentStatus == "partial" {
+		msg = fmt.Sprintf("¡Llegó tu preventa! Falta pagar el saldo de $%.2f.", o.AmountDue())
+	}
+	cancelled := o.Status == domain.OrderStatusCancelled
+	if cancelled && !success {
+		msg = "Este pedido fue cancelado."
+		if o.CancelReason != "" {
+			msg = "Este pedido fue cancelado: " + o.CancelReason + "."
+		}
+	}
 	data := map[string]any{
 		"Order":                  o,
 		"StatusMsg":              msg,
 		"Success":                success,
-		"IsTransferenciaPending": o.PaymentMethod == "transferencia" && (status == "pending" || o.MPStatus == "transferencia_pending"),
-		"IsCryptoPending":        o.PaymentMethod == "cripto" && (status == "pending" || o.MPStatus == "crypto_pending"),
+		"IsTransferenciaPending": !cancelled && !awaitingStock && o.PaymentMethod == "transferencia" && (status == "pending" || o.PaymentStatus == "transferencia_pending" || (o.PreOrder && o.PaymentStatus == "partial")),
+		"IsCryptoPending":        !cancelled && !awaitingStock && o.PaymentMethod == "cripto" && (status == "pending" || o.PaymentStatus == "crypto_pending" || (o.PreOrder && o.PaymentStatus == "partial")),
+		"AwaitingStock":          awaitingStock,
+		"CanPayMP":               !cancelled && !success && !awaitingStock && o.PreOrder && o.PaymentMethod == "mercadopago" && o.Status == domain.OrderStatusAwaitingPay,
+	}
+	if q.Get("error") == "mp" {
+		data["MPErr"] = "No pudimos generar el pago con Mercado Pago. Probá de nuevo en unos minutos."
+	}
+	if due := s.expiry.DueAt(o); due != nil {
+		data["PaymentDue"] = *due
+	}
+	if s.crypto != nil {
+		data["CryptoWallet"] = s.crypto.Wallet
+	}
+	if o.PaymentMethod == "cripto" && o.Status == domain.OrderStatusAwaitingPay {
+		data["CryptoQuoteExpired"] = o.CryptoQuoteExpired(time.Now())
+		data["CanRequote"] = s.crypto != nil && (o.CryptoAmount == 0 || o.CryptoQuoteExpired(time.Now()))
+		if q.Get("requote") == "error" {
+			data["RequoteErr"] = "No pudimos obtener una cotización nueva. Probá de nuevo en unos minutos."
+		}
+	}
+	if s.receipts != nil && (o.PaymentMethod == "transferencia" || o.PaymentMethod == "cripto") {
+		if list, err := s.receipts.Receipts.ListByOrder(r.Context(), o.ID); err == nil {
+			data["Receipts"] = list
+		}
+		data["CanUploadReceipt"] = o.Status == domain.OrderStatusAwaitingPay && !awaitingStock
+		switch q.Get("receipt") {
+		case "ok":
+			data["ReceiptMsg"] = "Recibimos tu comprobante. Te avisamos por email cuando confirmemos el pago."
+		case "error":
+			data["ReceiptErr"] = q.Get("msg")
+		}
+	}
+	if s.rmas != nil {
+		if list, err := s.rmas.ListByOrder(r.Context(), o.ID); err == nil {
+			data["RMAs"] = list
+		}
+		if s.rmas.CanRequest(o) == nil {
+			if left, err := s.rmas.Returnable(r.Context(), o); err == nil {
+				for _, n := range left {
+					if n > 0 {
+						data["CanReturn"] = true
+						break
+					}
+				}
+			}
+		}
+		if q.Get("rma") == "ok" {
+			data["RMAMsg"] = "Recibimos tu pedido de devolución. Te avisamos por email cuando lo revisemos."
+		}
 	}
 	if u := readUserSession(w, r); u != nil {
 		data["User"] = u
@@ -2204,8 +2402,12 @@ func (s *Server) apiCryptoRates(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	usdtRate, usdtSrc, errUSDT := fetchStablecoinARSRate("usdt")
-	usdcRate, usdcSrc, errUSDC := fetchStablecoinARSRate("usdc")
+	if s.crypto == nil || s.crypto.Rates == nil {
+		writeJSON(w, 503, map[string]any{"success": false, "error": "cotización cripto no disponible"})
+		return
+	}
+	usdt, errUSDT := s.crypto.Rates.Rate(r.Context(), "usdt")
+	usdc, errUSDC := s.crypto.Rates.Rate(r.Context(), "usdc")
 	if errUSDT != nil || errUSDC != nil {
 		errMsg := "no se pudo obtener cotización cripto"
 		if errUSDT != nil && errUSDC == nil {
@@ -2222,60 +2424,19 @@ func (s *Server) apiCryptoRates(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, 200, map[string]any{
 		"success":      true,
-		"usdt_ars":     usdtRate,
-		"usdc_ars":     usdcRate,
-		"usdt_source":  usdtSrc,
-		"usdc_source":  usdcSrc,
+		"usdt_ars":     usdt.ARS,
+		"usdc_ars":     usdc.ARS,
+		"usdt_source":  usdt.Source,
+		"usdc_source":  usdc.Source,
 		"provider":     "criptoya",
-		"fetched_at":   time.Now().UTC().Format(time.RFC3339),
+		"fetched_at":   usdt.FetchedAt.UTC().Format(time.RFC3339),
+		"stale":        usdt.Stale || usdc.Stale,
 		"disclaimer":   "Cotización de referencia, puede variar al momento del pago.",
 		"network":      "BSC",
 		"wallet_token": "USDT/USDC",
 	})
 }
 
-func fetchStablecoinARSRate(symbol string) (float64, string, error) {
-	client := &http.Client{Timeout: 6 * time.Second}
-	exchanges := []string{"binance", "belo", "fiwind", "ripio", "letsbit"}
-	for _, ex := range exchanges {
-		endpoint := fmt.Sprintf("https://criptoya.com/api/%s/%s/ars", ex, strings.ToLower(symbol))
-		req, err := http.NewRequest(http.MethodGet, endpoint, nil)
-		if err != nil {
-			continue
-		}
-		res, err := client.Do(req)
-		if err != nil {
-			continue
-		}
-		body, _ := io.ReadAll(res.Body)
-		_ = res.Body.Close()
-		if res.StatusCode < 200 || res.StatusCode >= 300 {
-			continue
-		}
-
-		var payload map[string]any
-		if err := json.Unmarshal(body, &payload); err != nil {
-			continue
-		}
-		if v := pickCryptoARSValue(payload); v > 0 {
-			return v, ex, nil
-		}
-	}
-	return 0, "", fmt.Errorf("sin cotización para %s", symbol)
-}
-
-func pickCryptoARSValue(payload map[string]any) float64 {
-	keys := []string{"totalAsk", "ask", "price", "close"}
-	for _, k := range keys {
-		if raw, ok := payload[k]; ok {
-			if v, ok := raw.(float64); ok && v > 0 {
-				return v
-			}
-		}
-	}
-	return 0
-}
-
 func (s *Server) render(w http.ResponseWriter, name string, data any) {
 	if m, ok := data.(map[string]any); ok {
 		if _, exists := m["Year"]; !exists {
@@ -2565,141 +2726,1876 @@ func (s *Server) handleAdminOrders(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/admin/auth", 302)
 		return
 	}
-	page := 1
-	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
-		page = p
-	}
-	var mpStatus *string
-	filterApproved := false
-	if r.URL.Query().Get("approved") == "1" {
-		st := "approved"
-		mpStatus = &st
-		filterApproved = true
+	q := r.URL.Query()
+	f := orderFilterFromQuery(q)
+	if strings.ToLower(q.Get("format")) == "csv" {
+		s.writeOrdersCSV(w, r, f)
+		return
 	}
-	list, total, err := s.orders.Orders.List(r.Context(), nil, mpStatus, page, 20)
+	list, total, err := s.orders.Orders.List(r.Context(), f)
 	if err != nil {
 		http.Error(w, "err", 500)
 		return
 	}
-	pages := (int(total) + 19) / 20
-	data := map[string]any{"Orders": list, "Page": page, "Pages": pages, "AdminToken": s.readAdminToken(r), "FilterApproved": filterApproved}
+	pages := (int(total) + f.PageSize - 1) / f.PageSize
+	q.Del("page")
+	q.Del("format")
+	data := map[string]any{
+		"Orders":          list,
+		"Total":           total,
+		"Page":            f.Page,
+		"Pages":           pages,
+		"Query":           template.URL(q.Encode()),
+		"F":               q,
+		"AdminToken":      s.readAdminToken(r),
+		"FilterApproved":  f.PaymentStatus == "approved",
+		"Statuses":        []domain.OrderStatus{domain.OrderStatusAwaitingPay, domain.OrderStatusFinished, domain.OrderStatusInPrint, domain.OrderStatusShipped, domain.OrderStatusCancelled, domain.OrderStatusPartRefunded, domain.OrderStatusRefunded, domain.OrderStatusQuoted, domain.OrderStatusPendingQuote},
+		"PaymentMethods":  []string{"mercadopago", "transferencia", "cripto", "efectivo"},
+		"ShippingMethods": []string{"envio", "cadete", "retiro"},
+	}
 	s.render(w, "admin_orders.html", data)
 }
 
-func (s *Server) handleAdminConfirmPayment(w http.ResponseWriter, r *http.Request) {
-	if !s.isAdminSession(r) {
-		http.Redirect(w, r, "/admin/auth", 302)
+// apiOrders es la búsqueda de órdenes del admin en JSON; acepta los mismos parámetros que /admin/orders.
+func (s *Server) apiOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", 405)
 		return
 	}
-
-	data := map[string]any{
-		"AdminToken": s.readAdminToken(r),
+	if !s.requireAdmin(w, r) {
+		return
 	}
+	f := orderFilterFromQuery(r.URL.Query())
+	list, total, err := s.orders.Orders.List(r.Context(), f)
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	out := make([]map[string]any, 0, len(list))
+	for _, o := range list {
+		out = append(out, map[string]any{
+			"id":              o.ID,
+			"created_at":      o.CreatedAt,
+			"status":          o.Status,
+			"mp_status":       o.PaymentStatus,
+			"email":           o.Email,
+			"name":            o.Name,
+			"dni":             o.DNI,
+			"phone":           o.Phone,
+			"payment_method":  o.PaymentMethod,
+			"shipping_method": o.ShippingMethod,
+			"province":        o.Province,
+			"total":           o.Total,
+			"items":           len(o.Items),
+		})
+	}
+	writeJSON(w, 200, map[string]any{
+		"orders":    out,
+		"total":     total,
+		"page":      f.Page,
+		"page_size": f.PageSize,
+	})
+}
 
-	if r.Method == http.MethodPost {
-		orderIDStr := strings.TrimSpace(r.FormValue("order_id"))
-		if orderIDStr == "" {
-			data["Error"] = "UUID de orden requerido"
-			s.render(w, "admin_confirm_payment.html", data)
-			return
-		}
-
-		orderID, err := uuid.Parse(orderIDStr)
+// writeOrdersCSV exporta todas las órdenes que cumplen el filtro (sin paginar).
+func (s *Server) writeOrdersCSV(w http.ResponseWriter, r *http.Request, f domain.OrderFilter) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=ordenes_%s.csv", time.Now().Format("2006-01-02")))
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"order_id", "created_at", "status", "mp_status", "email", "name", "dni", "phone", "payment_method", "shipping_method", "province", "address", "items", "shipping_cost", "discount", "total"})
+	f.PageSize = 200
+	for f.Page = 1; ; f.Page++ {
+		list, _, err := s.orders.Orders.List(r.Context(), f)
 		if err != nil {
-			data["Error"] = "UUID inválido: " + err.Error()
-			data["OrderID"] = orderIDStr
-			s.render(w, "admin_confirm_payment.html", data)
-			return
-		}
-
-		// Buscar la orden
-		order, err := s.orders.Orders.FindByID(r.Context(), orderID)
-		if err != nil || order == nil {
-			data["Error"] = "Orden no encontrada"
-			data["OrderID"] = orderIDStr
-			s.render(w, "admin_confirm_payment.html", data)
-			return
+			log.Error().Err(err).Msg("admin: error exportando órdenes")
+			break
 		}
-
-		// Verificar que sea un método con confirmación manual
-		if order.PaymentMethod != "transferencia" && order.PaymentMethod != "cripto" {
-			data["Error"] = "Esta orden no requiere confirmación manual. Método de pago: " + order.PaymentMethod
-			data["OrderID"] = orderIDStr
-			data["Order"] = order
-			s.render(w, "admin_confirm_payment.html", data)
-			return
+		for _, o := range list {
+			_ = cw.Write([]string{
+				o.ID.String(), o.CreatedAt.Format(time.RFC3339), string(o.Status), o.PaymentStatus, csvText(o.Email), csvText(o.Name), csvText(o.DNI), csvText(o.Phone),
+				o.PaymentMethod, o.ShippingMethod, csvText(o.Province), csvText(o.Address), strconv.Itoa(len(o.Items)),
+				fmt.Sprintf("%.2f", o.ShippingCost), fmt.Sprintf("%.2f", o.DiscountAmount), fmt.Sprintf("%.2f", o.Total),
+			})
 		}
-
-		// Actualizar estado
-		order.Status = domain.OrderStatusFinished
-		order.MPStatus = "approved"
-		order.Notified = true
-
-		// Guardar
-		if err := s.orders.Orders.Save(r.Context(), order); err != nil {
-			data["Error"] = "Error guardando orden: " + err.Error()
-			data["OrderID"] = orderIDStr
-			data["Order"] = order
-			s.render(w, "admin_confirm_payment.html", data)
-			return
+		if len(list) < f.PageSize {
+			break
 		}
+	}
+	cw.Flush()
+}
 
-		// Enviar email de confirmación (asíncrono)
-		go s.sendOrderNotify(order, true)
-
-		data["Success"] = fmt.Sprintf("Pago confirmado exitosamente. Email de confirmación enviado a %s", order.Email)
-		data["Order"] = order
-		s.render(w, "admin_confirm_payment.html", data)
-		return
+// csvText neutraliza los datos que carga el comprador para que Excel o Sheets no los interpreten como
+// fórmula al abrir el CSV: si empiezan con =, +, -, @, tab o CR se les antepone un apóstrofo.
+func csvText(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
 	}
+	return v
+}
 
-	// GET: mostrar formulario
-	s.render(w, "admin_confirm_payment.html", data)
+// orderFilterFromQuery arma el filtro de órdenes desde los parámetros de /admin/orders y /api/orders.
+// Las fechas van en formato 2006-01-02 y "to" incluye el día completo.
+func orderFilterFromQuery(q url.Values) domain.OrderFilter {
+	f := domain.OrderFilter{
+		Email:          q.Get("email"),
+		Name:           q.Get("name"),
+		DNI:            q.Get("dni"),
+		Phone:          q.Get("phone"),
+		IDPrefix:       q.Get("id"),
+		PaymentMethod:  q.Get("payment_method"),
+		ShippingMethod: q.Get("shipping_method"),
+		Province:       q.Get("province"),
+		Sort:           q.Get("sort"),
+		PageSize:       20,
+	}
+	if st := q.Get("status"); st != "" {
+		status := domain.OrderStatus(st)
+		f.Status = &status
+	}
+	f.PaymentStatus = q.Get("mp_status")
+	if q.Get("approved") == "1" {
+		f.PaymentStatus = "approved"
+	}
+	if t, err := time.ParseInLocation("2006-01-02", q.Get("from"), time.Local); err == nil {
+		f.From = &t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", q.Get("to"), time.Local); err == nil {
+		t = t.AddDate(0, 0, 1)
+		f.To = &t
+	}
+	if v, err := strconv.ParseFloat(strings.ReplaceAll(q.Get("min_total"), ",", "."), 64); err == nil {
+		f.MinTotal = &v
+	}
+	if v, err := strconv.ParseFloat(strings.ReplaceAll(q.Get("max_total"), ",", "."), 64); err == nil {
+		f.MaxTotal = &v
+	}
+	if p, err := strconv.Atoi(q.Get("page")); err == nil && p > 0 {
+		f.Page = p
+	} else {
+		f.Page = 1
+	}
+	if ps, err := strconv.Atoi(q.Get("page_size")); err == nil && ps > 0 && ps <= 200 {
+		f.PageSize = ps
+	}
+	return f
 }
 
-func (s *Server) handleAdminSales(w http.ResponseWriter, r *http.Request) {
+// handleAdminOrderDetail muestra una orden completa (items, cliente, envío, cobros e historial) y permite
+// corregir contacto y envío, agregar o quitar items mientras está impaga y cambiarle el estado según las
+// transiciones permitidas. Las ediciones quedan en la auditoría de la orden.
+func (s *Server) handleAdminOrderDetail(w http.ResponseWriter, r *http.Request) {
 	if !s.isAdminSession(r) {
 		http.Redirect(w, r, "/admin/auth", 302)
 		return
 	}
-	q := r.URL.Query()
-	layout := "admin_sales.html"
-
-	const layoutIn = "2006-01-02"
-	var (
-		to   time.Time
-		from time.Time
-		err  error
-	)
-	if ds := q.Get("to"); ds != "" {
-		to, err = time.Parse(layoutIn, ds)
-		if err != nil {
-			to = time.Now()
-		}
-	} else {
-		to = time.Now()
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/orders/"), "/")
+	idStr, doc, _ := strings.Cut(rest, "/")
+	orderID, err := uuid.Parse(idStr)
+	if err != nil {
+		http.NotFound(w, r)
+		return
 	}
-	if ds := q.Get("from"); ds != "" {
-		from, err = time.Parse(layoutIn, ds)
+	o, err := s.orders.Orders.FindByID(r.Context(), orderID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if doc != "" {
+		s.serveOrderDocument(w, r, o, doc)
+		return
+	}
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.Method == http.MethodPost {
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		msg, err := s.applyAdminOrderEdit(r, o, actor)
 		if err != nil {
-			from = to.AddDate(0, 0, -29)
+			data["Error"] = err.Error()
+		} else {
+			data["Success"] = msg
 		}
-	} else {
-		from = to.AddDate(0, 0, -29)
 	}
-	if from.After(to) {
-		from, to = to, from
+	history, err := s.orders.History(r.Context(), o.ID)
+	if err != nil {
+		log.Error().Err(err).Str("order_id", o.ID.String()).Msg("admin: no se pudo leer el historial de estados")
 	}
-
-	ordersAll, err := s.orders.Orders.ListInRange(r.Context(), from, to)
+	audit, err := s.orders.AuditTrail(r.Context(), o.ID)
 	if err != nil {
-		http.Error(w, "err", 500)
-		return
+		log.Error().Err(err).Str("order_id", o.ID.String()).Msg("admin: no se pudo leer la auditoría")
 	}
-
-	orders := make([]domain.Order, 0, len(ordersAll))
+	data["Order"] = o
+	data["History"] = history
+	data["Audit"] = audit
+	data["NextStatuses"] = o.Status.NextStatuses()
+	data["ItemsEditable"] = o.CheckItemsEditable() == nil
+	if s.payments != nil {
+		if bal, pays, err := s.payments.Balance(r.Context(), o); err == nil {
+			data["Balance"] = bal
+			data["Payments"] = pays
+		}
+	}
+	if s.refunds != nil {
+		if list, err := s.refunds.ListByOrder(r.Context(), o.ID); err == nil {
+			data["Refunds"] = list
+		}
+	}
+	if s.receipts != nil {
+		if list, err := s.receipts.Receipts.ListByOrder(r.Context(), o.ID); err == nil {
+			data["Receipts"] = list
+		}
+	}
+	if s.rmas != nil {
+		if list, err := s.rmas.ListByOrder(r.Context(), o.ID); err == nil {
+			data["RMAs"] = list
+		}
+	}
+	if s.warranties != nil {
+		if cov, err := s.warranties.Coverage(r.Context(), o); err == nil {
+			data["Warranty"] = cov
+		}
+		if list, err := s.warranties.ClaimsByOrder(r.Context(), o.ID); err == nil {
+			data["WarrantyClaims"] = list
+		}
+	}
+	if s.whatsapp != nil {
+		if list, err := s.whatsapp.ListByOrder(r.Context(), o.ID); err == nil {
+			data["WhatsAppLog"] = list
+		}
+	}
+	if s.invoices.Enabled() {
+		data["InvoicingEnabled"] = true
+		if inv, err := s.invoices.ForOrder(r.Context(), o.ID); err == nil {
+			data["Invoice"] = inv
+		} else if err := s.invoices.CanInvoice(o); err != nil {
+			data["InvoiceBlocked"] = strings.TrimPrefix(err.Error(), domain.ErrInvoiceNotAllowed.Error()+": ")
+		}
+		if inv, err := s.invoices.Unfinished(r.Context(), o.ID); err == nil {
+			data["InvoiceUnfinished"] = inv
+		}
+		if buyer, err := s.invoices.Buyer(r.Context(), o); err == nil {
+			data["Buyer"] = buyer
+		}
+	}
+	s.render(w, "admin_order.html", data)
+}
+
+// serveOrderDocument descarga el remito (packing-slip.pdf), el comprobante (receipt.pdf) o la factura
+// electrónica (invoice.pdf) de la orden.
+func (s *Server) serveOrderDocument(w http.ResponseWriter, r *http.Request, o *domain.Order, doc string) {
+	if s.docs == nil {
+		http.NotFound(w, r)
+		return
+	}
+	var (
+		data []byte
+		name string
+		err  error
+	)
+	short := o.Number()
+	switch doc {
+	case "invoice.pdf":
+		var inv *domain.Invoice
+		data, inv, err = s.invoices.PDF(r.Context(), o)
+		if errors.Is(err, domain.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if inv != nil {
+			name = fmt.Sprintf("factura-%s-%04d-%08d.pdf", inv.Type, inv.PointOfSale, inv.Number)
+		}
+	case "packing-slip.pdf":
+		data, err = s.docs.PackingSlip(o)
+		name = "remito-" + short + ".pdf"
+	case "receipt.pdf":
+		data, err = s.docs.Receipt(o)
+		name = "comprobante-" + short + ".pdf"
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("order_id", o.ID.String()).Str("doc", doc).Msg("admin: error generando PDF")
+		http.Error(w, "error generando PDF", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%s", name))
+	_, _ = w.Write(data)
+}
+
+// applyAdminOrderEdit aplica la acción del formulario de /admin/orders/{id} y devuelve el mensaje de éxito.
+func (s *Server) applyAdminOrderEdit(r *http.Request, o *domain.Order, actor string) (string, error) {
+	ctx := r.Context()
+	switch r.FormValue("action") {
+	case "status":
+		to := domain.OrderStatus(strings.TrimSpace(r.FormValue("status")))
+		note := strings.TrimSpace(r.FormValue("note"))
+		// El seguimiento se guarda antes del cambio de estado para que el aviso de despacho lo incluya.
+		if tracking := strings.TrimSpace(r.FormValue("tracking")); to == domain.OrderStatusShipped && tracking != "" {
+			o.TrackingNumber = tracking
+			if err := s.orders.Orders.Save(ctx, o); err != nil {
+				return "", err
+			}
+		}
+		if err := s.orders.UpdateStatus(ctx, o, to, domain.StatusChange{Actor: actor, Source: domain.StatusSourceAdmin, Note: note}); err != nil {
+			return "", err
+		}
+		if to == domain.OrderStatusCancelled && note != "" {
+			o.CancelReason = note
+			_ = s.orders.Orders.Save(ctx, o)
+		}
+		return "Estado actualizado a " + string(to) + ".", nil
+	case "contact":
+		c := domain.OrderContact{
+			Name:          r.FormValue("name"),
+			Email:         r.FormValue("email"),
+			Phone:         r.FormValue("phone"),
+			DNI:           r.FormValue("dni"),
+			Address:       r.FormValue("address"),
+			PostalCode:    r.FormValue("postal_code"),
+			Province:      r.FormValue("province"),
+			DeliveryNotes: r.FormValue("delivery_notes"),
+		}
+		if err := s.orders.UpdateContact(ctx, o, c, actor); err != nil {
+			return "", err
+		}
+		return "Datos de contacto y envío actualizados.", nil
+	case "add_item":
+		it, err := s.adminOrderItemFromForm(r)
+		if err != nil {
+			return "", err
+		}
+		if err := s.orders.AddItem(ctx, o, it, actor); err != nil {
+			return "", err
+		}
+		s.requoteAfterEdit(ctx, o)
+		return fmt.Sprintf("Item agregado. Nuevo total: $%.2f.", o.Total), nil
+	case "remove_item":
+		itemID, err := uuid.Parse(r.FormValue("item_id"))
+		if err != nil {
+			return "", errors.New("item inválido")
+		}
+		if err := s.orders.RemoveItem(ctx, o, itemID, actor); err != nil {
+			return "", err
+		}
+		s.requoteAfterEdit(ctx, o)
+		return fmt.Sprintf("Item quitado. Nuevo total: $%.2f.", o.Total), nil
+	case "warranty_register":
+		itemID, err := uuid.Parse(r.FormValue("item_id"))
+		if err != nil {
+			return "", errors.New("item inválido")
+		}
+		w, err := s.warranties.Register(ctx, o, itemID, r.FormValue("imei"), actor)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("IMEI %s registrado: garantía hasta el %s.", w.IMEI, w.EndsAt.Format("02/01/2006")), nil
+	case "invoice":
+		if err := s.invoices.CanInvoice(o); err != nil {
+			return "", err
+		}
+		if err := s.invoices.SetBuyerTaxData(ctx, o, r.FormValue("tax_id"), r.FormValue("tax_condition")); err != nil {
+			return "", err
+		}
+		inv, err := s.invoices.Issue(ctx, o)
+		if err != nil {
+			return "", err
+		}
+		log.Info().Str("order_id", o.ID.String()).Str("invoice", inv.FullNumber()).Str("actor", actor).Msg("admin: factura emitida")
+		return "Factura " + inv.FullNumber() + " emitida (CAE " + inv.CAE + ").", nil
+	case "invoice_discard":
+		inv, err := s.invoices.Discard(ctx, o, actor)
+		if err != nil {
+			return "", err
+		}
+		return "Factura pendiente " + inv.FullNumber() + " descartada: el número quedó libre.", nil
+	case "whatsapp_ready":
+		if err := s.orders.NotifyReadyForPickup(ctx, o, actor); err != nil {
+			return "", err
+		}
+		return "Aviso de listo para retirar encolado.", nil
+	case "whatsapp_optout":
+		if err := s.orders.WhatsAppOptOut(ctx, o, actor); err != nil {
+			return "", err
+		}
+		return "El comprador ya no recibe mensajes por WhatsApp.", nil
+	}
+	return "", errors.New("acción desconocida")
+}
+
+// adminOrderItemFromForm arma un item a partir de un SKU, EAN o slug de producto. Sin precio se usa el
+// precio de lista del producto, igual que en el carrito.
+func (s *Server) adminOrderItemFromForm(r *http.Request) (domain.OrderItem, error) {
+	ctx := r.Context()
+	code := strings.TrimSpace(r.FormValue("code"))
+	if code == "" {
+		return domain.OrderItem{}, errors.New("indicá SKU, EAN o slug del producto")
+	}
+	qty, err := strconv.Atoi(strings.TrimSpace(r.FormValue("qty")))
+	if err != nil || qty <= 0 {
+		return domain.OrderItem{}, errors.New("cantidad inválida")
+	}
+	p, v, err := s.products.Products.FindVariantBySKU(ctx, code)
+	if err != nil {
+		p, v, err = s.products.Products.FindVariantByEAN(ctx, code)
+	}
+	if err != nil {
+		p, err = s.products.GetBySlug(ctx, code)
+		if err != nil {
+			return domain.OrderItem{}, errors.New("producto no encontrado")
+		}
+		v = variantForColor(p, r.FormValue("color"))
+	}
+	it := domain.OrderItem{ProductID: &p.ID, Title: p.Name, Qty: qty, UnitPrice: p.BasePrice}
+	if v != nil {
+		it.VariantID = &v.ID
+		it.Color = normalizeColorName(v.Color)
+		it.SKU = v.SKU
+		it.EAN = v.EAN
+	}
+	if raw := strings.TrimSpace(r.FormValue("unit_price")); raw != "" {
+		price, err := strconv.ParseFloat(strings.ReplaceAll(raw, ",", "."), 64)
+		if err != nil || price < 0 {
+			return domain.OrderItem{}, errors.New("precio inválido")
+		}
+		it.UnitPrice = price
+	}
+	return it, nil
+}
+
+// requoteAfterEdit vuelve a cotizar una orden cripto cuando cambia su total: el monto en stablecoins
+// fijado antes ya no corresponde.
+func (s *Server) requoteAfterEdit(ctx context.Context, o *domain.Order) {
+	if o.PaymentMethod != "cripto" || s.crypto == nil {
+		return
+	}
+	if err := s.crypto.Quote(ctx, o); err != nil {
+		log.Warn().Err(err).Str("order_id", o.ID.String()).Msg("admin: no se pudo recotizar la orden cripto")
+		return
+	}
+	_ = s.orders.Orders.Save(ctx, o)
+}
+
+func (s *Server) handleAdminConfirmPayment(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+
+	data := map[string]any{
+		"AdminToken": s.readAdminToken(r),
+	}
+
+	if r.Method == http.MethodPost {
+		orderIDStr := strings.TrimSpace(r.FormValue("order_id"))
+		if orderIDStr == "" {
+			data["Error"] = "Número o UUID de orden requerido"
+			s.render(w, "admin_confirm_payment.html", data)
+			return
+		}
+
+		// Buscar la orden
+		order, err := s.findOrderByRef(r.Context(), orderIDStr)
+		if errors.Is(err, errInvalidOrderRef) {
+			data["Error"] = "Número de orden o UUID inválido"
+			data["OrderID"] = orderIDStr
+			s.render(w, "admin_confirm_payment.html", data)
+			return
+		}
+		if err != nil || order == nil {
+			data["Error"] = "Orden no encontrada"
+			data["OrderID"] = orderIDStr
+			s.render(w, "admin_confirm_payment.html", data)
+			return
+		}
+
+		// Método del cobro: por defecto el de la orden; permite registrar p.ej. la mitad por transferencia
+		// de una orden iniciada con MP.
+		method := strings.TrimSpace(r.FormValue("method"))
+		if method == "" {
+			method = order.PaymentMethod
+		}
+		if method != "transferencia" && method != "cripto" && method != "efectivo" {
+			data["Error"] = "Esta orden no requiere confirmación manual. Método de pago: " + method
+			data["OrderID"] = orderIDStr
+			data["Order"] = order
+			s.render(w, "admin_confirm_payment.html", data)
+			return
+		}
+
+		bal, _, err := s.payments.Balance(r.Context(), order)
+		if err != nil {
+			data["Error"] = "Error leyendo pagos: " + err.Error()
+			data["OrderID"] = orderIDStr
+			data["Order"] = order
+			s.render(w, "admin_confirm_payment.html", data)
+			return
+		}
+		if bal.Settled() {
+			data["Error"] = "La orden ya está paga"
+			data["OrderID"] = orderIDStr
+			data["Order"] = order
+			data["Balance"] = bal
+			s.render(w, "admin_confirm_payment.html", data)
+			return
+		}
+		// Monto recibido: vacío = saldo pendiente completo; menor = seña / pago parcial.
+		amount := bal.Balance
+		if raw := strings.TrimSpace(r.FormValue("amount")); raw != "" {
+			v, err := strconv.ParseFloat(strings.ReplaceAll(raw, ",", "."), 64)
+			if err != nil || v <= 0 {
+				data["Error"] = "Monto inválido"
+				data["OrderID"] = orderIDStr
+				data["Order"] = order
+				s.render(w, "admin_confirm_payment.html", data)
+				return
+			}
+			if v > bal.Balance+0.01 {
+				data["Error"] = fmt.Sprintf("El monto supera el saldo pendiente ($%.2f)", bal.Balance)
+				data["OrderID"] = orderIDStr
+				data["Order"] = order
+				data["Balance"] = bal
+				s.render(w, "admin_confirm_payment.html", data)
+				return
+			}
+			amount = v
+		}
+
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		paid, err := s.payments.RecordPayment(r.Context(), order, &domain.Payment{
+			Method: method,
+			Amount: amount,
+			Status: domain.PaymentStatusApproved,
+			Note:   strings.TrimSpace(r.FormValue("note")),
+			Actor:  actor,
+		})
+		if err != nil {
+			data["Error"] = "Error guardando pago: " + err.Error()
+			data["OrderID"] = orderIDStr
+			data["Order"] = order
+			s.render(w, "admin_confirm_payment.html", data)
+			return
+		}
+
+		bal, pays, _ := s.payments.Balance(r.Context(), order)
+		if paid {
+			data["Success"] = fmt.Sprintf("Pago confirmado exitosamente. Email de confirmación enviado a %s", order.Email)
+		} else {
+			data["Success"] = fmt.Sprintf("Pago parcial de $%.2f registrado. Saldo pendiente: $%.2f", amount, bal.Balance)
+		}
+		data["Order"] = order
+		data["Balance"] = bal
+		data["Payments"] = pays
+		s.render(w, "admin_confirm_payment.html", data)
+		return
+	}
+
+	// GET: mostrar formulario (con ?order_id= precarga la orden, p.ej. desde el listado para cobrar en caja)
+	if ref := strings.TrimSpace(r.URL.Query().Get("order_id")); ref != "" {
+		if order, err := s.findOrderByRef(r.Context(), ref); err == nil {
+			data["OrderID"] = order.ID.String()
+			data["Order"] = order
+			if bal, pays, err := s.payments.Balance(r.Context(), order); err == nil {
+				data["Balance"] = bal
+				data["Payments"] = pays
+			}
+		}
+	}
+	s.render(w, "admin_confirm_payment.html", data)
+}
+
+var errInvalidOrderRef = errors.New("referencia de orden inválida")
+
+// findOrderByRef busca una orden por su UUID o por su número correlativo (NM-000123 o 123).
+func (s *Server) findOrderByRef(ctx context.Context, ref string) (*domain.Order, error) {
+	if id, err := uuid.Parse(ref); err == nil {
+		return s.orders.Orders.FindByID(ctx, id)
+	}
+	if seq, ok := domain.ParseOrderNumber(ref); ok {
+		return s.orders.Orders.FindByNumber(ctx, seq)
+	}
+	return nil, errInvalidOrderRef
+}
+
+// handlePayReceipt recibe el comprobante de transferencia/cripto que sube el comprador desde /pay/{id}.
+func (s *Server) handlePayReceipt(w http.ResponseWriter, r *http.Request, idStr string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	uid, err := uuid.Parse(idStr)
+	if err != nil || s.receipts == nil {
+		http.NotFound(w, r)
+		return
+	}
+	back := "/pay/" + uid.String()
+	fail := func(msg string) {
+		http.Redirect(w, r, back+"?receipt=error&msg="+url.QueryEscape(msg), http.StatusSeeOther)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, usecase.MaxReceiptSize+(1<<20))
+	if err := r.ParseMultipartForm(usecase.MaxReceiptSize); err != nil {
+		fail("El archivo es demasiado grande (máx. 10 MB).")
+		return
+	}
+	f, _, err := r.FormFile("receipt")
+	if err != nil {
+		fail("Seleccioná un archivo.")
+		return
+	}
+	defer f.Close()
+	buf, err := io.ReadAll(f)
+	if err != nil {
+		fail("No se pudo leer el archivo.")
+		return
+	}
+	// El tipo se detecta del contenido, no del nombre ni del header que manda el navegador.
+	ct := http.DetectContentType(buf)
+	if i := strings.Index(ct, ";"); i >= 0 {
+		ct = ct[:i]
+	}
+	if _, err := s.receipts.Submit(r.Context(), uid, ct, buf); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		fail(err.Error())
+		return
+	}
+	http.Redirect(w, r, back+"?receipt=ok", http.StatusSeeOther)
+}
+
+// handlePayMP arma una preferencia de MP nueva por el cobro pendiente (la seña o el saldo de una
+// preventa) y redirige a pagarla.
+func (s *Server) handlePayMP(w http.ResponseWriter, r *http.Request, idStr string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	uid, err := uuid.Parse(idStr)
+	if err != nil || s.payments == nil {
+		http.NotFound(w, r)
+		return
+	}
+	o, err := s.orders.Orders.FindByID(r.Context(), uid)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	back := "/pay/" + o.ID.String()
+	if o.PaymentMethod != "mercadopago" || o.Status != domain.OrderStatusAwaitingPay || o.PaymentStatus == "approved" {
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	redirURL, err := s.payments.CreatePreference(r.Context(), o)
+	if err != nil || redirURL == "" {
+		log.Warn().Err(err).Str("order_id", o.ID.String()).Msg("pay: no se pudo crear la preferencia MP")
+		http.Redirect(w, r, back+"?error=mp", http.StatusSeeOther)
+		return
+	}
+	if err := s.orders.Orders.Save(r.Context(), o); err != nil {
+		log.Error().Err(err).Str("order_id", o.ID.String()).Msg("pay: no se pudo guardar la preferencia MP")
+	}
+	http.Redirect(w, r, redirURL, http.StatusSeeOther)
+}
+
+// handlePayRequote vuelve a cotizar una orden cripto cuya cotización venció.
+func (s *Server) handlePayRequote(w http.ResponseWriter, r *http.Request, idStr string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", 405)
+		return
+	}
+	uid, err := uuid.Parse(idStr)
+	if err != nil || s.crypto == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := s.crypto.Requote(r.Context(), uid); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		log.Warn().Err(err).Str("order_id", uid.String()).Msg("cripto: no se pudo recotizar")
+		http.Redirect(w, r, "/pay/"+uid.String()+"?requote=error", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/pay/"+uid.String(), http.StatusSeeOther)
+}
+
+// rmaOption es una opción de un select de devoluciones (valor y texto).
+type rmaOption struct{ Value, Label string }
+
+func rmaResolutionOptions() []rmaOption {
+	out := make([]rmaOption, 0, len(domain.RMAResolutions))
+	for _, v := range domain.RMAResolutions {
+		out = append(out, rmaOption{Value: v, Label: domain.RMAResolutionLabel(v)})
+	}
+	return out
+}
+
+// rmaReturnLine es un item de la orden con las unidades que todavía se pueden devolver.
+type rmaReturnLine struct {
+	Item domain.OrderItem
+	Max  int
+	Qty  int
+}
+
+// handlePayReturn muestra (GET) y recibe (POST) el pedido de devolución del cliente desde la página
+// de su orden. Por cada item se envía qty_<itemID> con las unidades a devolver.
+func (s *Server) handlePayReturn(w http.ResponseWriter, r *http.Request, idStr string) {
+	uid, err := uuid.Parse(idStr)
+	if err != nil || s.rmas == nil {
+		http.NotFound(w, r)
+		return
+	}
+	o, err := s.orders.Orders.FindByID(r.Context(), uid)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data := map[string]any{
+		"Order":       o,
+		"Reasons":     domain.RMAReasons,
+		"Conditions":  domain.RMAConditions,
+		"Resolutions": rmaResolutionOptions(),
+	}
+	if u := readUserSession(w, r); u != nil {
+		data["User"] = u
+	}
+	if err := s.rmas.CanRequest(o); err != nil {
+		data["Blocked"] = err.Error()
+		s.render(w, "rma_request.html", data)
+		return
+	}
+	left, err := s.rmas.Returnable(r.Context(), o)
+	if err != nil {
+		http.Error(w, "error", 500)
+		return
+	}
+	var lines []rmaReturnLine
+	for _, it := range o.Items {
+		if left[it.ID] > 0 {
+			lines = append(lines, rmaReturnLine{Item: it, Max: left[it.ID]})
+		}
+	}
+	if r.Method == http.MethodPost {
+		var req []domain.RMALineRequest
+		for i := range lines {
+			qty, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("qty_" + lines[i].Item.ID.String())))
+			lines[i].Qty = qty
+			req = append(req, domain.RMALineRequest{OrderItemID: lines[i].Item.ID, Qty: qty})
+		}
+		_, err := s.rmas.Request(r.Context(), o, req, r.FormValue("reason"), r.FormValue("condition"), r.FormValue("resolution"), r.FormValue("note"))
+		if err == nil {
+			http.Redirect(w, r, "/pay/"+o.ID.String()+"?rma=ok#devoluciones", http.StatusSeeOther)
+			return
+		}
+		data["Error"] = err.Error()
+		data["Form"] = r.PostForm
+	}
+	if len(lines) == 0 {
+		data["Blocked"] = "todos los productos de la orden ya están en una devolución"
+	}
+	data["Lines"] = lines
+	s.render(w, "rma_request.html", data)
+}
+
+// handleAdminReceipts es la cola de revisión de comprobantes: órdenes por transferencia/cripto pendientes
+// con sus archivos; aprobar registra el cobro igual que /admin/confirm-payment, rechazar avisa al comprador.
+func (s *Server) handleAdminReceipts(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	data := map[string]any{}
+	if r.Method == http.MethodPost {
+		rid, err := uuid.Parse(strings.TrimSpace(r.FormValue("receipt_id")))
+		if err != nil {
+			data["Error"] = "Comprobante inválido"
+		} else {
+			actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+			switch r.FormValue("action") {
+			case "approve":
+				amount := 0.0
+				if raw := strings.TrimSpace(r.FormValue("amount")); raw != "" {
+					amount, err = strconv.ParseFloat(strings.ReplaceAll(raw, ",", "."), 64)
+					if err != nil || amount < 0 {
+						data["Error"] = "Monto inválido"
+						break
+					}
+				}
+				paid, err := s.receipts.Approve(r.Context(), rid, amount, actor)
+				if err != nil {
+					data["Error"] = err.Error()
+				} else if paid {
+					data["Success"] = "Pago confirmado. Se notificó al comprador."
+				} else {
+					data["Success"] = "Pago parcial registrado. La orden sigue esperando el saldo."
+				}
+			case "reject":
+				if err := s.receipts.Reject(r.Context(), rid, strings.TrimSpace(r.FormValue("reason")), actor); err != nil {
+					data["Error"] = err.Error()
+				} else {
+					data["Success"] = "Comprobante rechazado. Se avisó al comprador."
+				}
+			default:
+				data["Error"] = "Acción inválida"
+			}
+		}
+	}
+	list, err := s.receipts.Pending(r.Context())
+	if err != nil {
+		data["Error"] = "Error listando órdenes: " + err.Error()
+	}
+	data["Reviews"] = list
+	s.render(w, "admin_receipts.html", data)
+}
+
+// handleAdminReceiptFile sirve el archivo de un comprobante (sólo admin).
+func (s *Server) handleAdminReceiptFile(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	rid, err := uuid.Parse(r.URL.Query().Get("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	rc, buf, err := s.receipts.Open(r.Context(), rid)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", rc.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", rc.FileName))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write(buf)
+}
+
+// handleAdminRMAs lista las devoluciones, filtrables por estado (?status=).
+func (s *Server) handleAdminRMAs(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	q := r.URL.Query()
+	status := q.Get("status")
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+	const pageSize = 30
+	list, total, err := s.rmas.List(r.Context(), status, page, pageSize)
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	statuses := []rmaOption{}
+	for _, st := range []string{domain.RMAStatusRequested, domain.RMAStatusApproved, domain.RMAStatusReceived, domain.RMAStatusInspected, domain.RMAStatusResolved, domain.RMAStatusRejected} {
+		statuses = append(statuses, rmaOption{Value: st, Label: domain.RMAStatusLabel(st)})
+	}
+	ids := make([]uuid.UUID, 0, len(list))
+	for _, rm := range list {
+		ids = append(ids, rm.OrderID)
+	}
+	s.render(w, "admin_rmas.html", map[string]any{
+		"RMAs":         list,
+		"OrderNumbers": s.orderNumbers(r.Context(), ids...),
+		"Total":        total,
+		"Status":       status,
+		"Statuses":     statuses,
+		"Page":         page,
+		"Pages":        (int(total) + pageSize - 1) / pageSize,
+		"AdminToken":   s.readAdminToken(r),
+	})
+}
+
+// orderNumbers arma el número para mostrar de cada orden; las que no se encuentran quedan con el
+// prefijo del ID, como Order.Number para las órdenes sin correlativo.
+func (s *Server) orderNumbers(ctx context.Context, ids ...uuid.UUID) map[uuid.UUID]string {
+	nums, err := s.orders.Orders.Numbers(ctx, ids)
+	if err != nil {
+		log.Error().Err(err).Msg("admin: no se pudieron leer los números de orden")
+		nums = map[uuid.UUID]string{}
+	}
+	for _, id := range ids {
+		if _, ok := nums[id]; !ok {
+			nums[id] = id.String()[:8]
+		}
+	}
+	return nums
+}
+
+// handleAdminRMADetail muestra una devolución y aplica los pasos del circuito (POST action=approve,
+// reject, receive, inspect o resolve).
+func (s *Server) handleAdminRMADetail(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	id, err := uuid.Parse(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/rmas/"), "/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.Method == http.MethodPost {
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		ctx, note := r.Context(), r.FormValue("note")
+		var msg string
+		switch r.FormValue("action") {
+		case "approve":
+			_, err = s.rmas.Approve(ctx, id, note, actor)
+			msg = "Devolución aprobada. Se avisó al cliente."
+		case "reject":
+			_, err = s.rmas.Reject(ctx, id, note, actor)
+			msg = "Devolución rechazada. Se avisó al cliente."
+		case "receive":
+			_, err = s.rmas.Receive(ctx, id, note, actor)
+			msg = "Producto recibido."
+		case "inspect":
+			_, err = s.rmas.Inspect(ctx, id, r.FormValue("condition"), r.FormValue("resellable") == "1", note, actor)
+			msg = "Inspección registrada."
+		case "resolve":
+			_, err = s.rmas.Resolve(ctx, id, r.FormValue("resolution"), note, actor)
+			msg = "Devolución resuelta. Se avisó al cliente."
+		default:
+			err = errors.New("acción inválida")
+		}
+		if err != nil {
+			data["Error"] = err.Error()
+		} else {
+			data["Success"] = msg
+		}
+	}
+	rma, err := s.rmas.FindByID(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data["RMA"] = rma
+	if o, err := s.orders.Orders.FindByID(r.Context(), rma.OrderID); err == nil {
+		data["Order"] = o
+	}
+	ids := []uuid.UUID{rma.OrderID}
+	if rma.ExchangeOrderID != nil {
+		ids = append(ids, *rma.ExchangeOrderID)
+	}
+	nums := s.orderNumbers(r.Context(), ids...)
+	data["OrderNumbers"] = nums
+	if rma.ExchangeOrderID != nil {
+		data["ExchangeNumber"] = nums[*rma.ExchangeOrderID]
+	}
+	data["Conditions"] = domain.RMAConditions
+	data["Resolutions"] = rmaResolutionOptions()
+	s.render(w, "admin_rma.html", data)
+}
+
+// handleRepair es la página pública de una orden de servicio técnico: estado, historial y presupuesto.
+// Acepta POST en /repair/{id}/approve, /reject y /pay (method=) para que el cliente responda el
+// presupuesto y pague la reparación.
+func (s *Server) handleRepair(w http.ResponseWriter, r *http.Request) {
+	if s.repairs == nil {
+		http.NotFound(w, r)
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/repair/"), "/")
+	idStr, action, _ := strings.Cut(rest, "/")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data := map[string]any{}
+	if u := readUserSession(w, r); u != nil {
+		data["User"] = u
+	}
+	if action != "" {
+		if r.Method != http.MethodPost {
+			http.Redirect(w, r, "/repair/"+id.String(), http.StatusSeeOther)
+			return
+		}
+		ctx := r.Context()
+		switch action {
+		case "approve":
+			_, err = s.repairs.Approve(ctx, id, "cliente")
+		case "reject":
+			_, err = s.repairs.Reject(ctx, id, "cliente")
+		case "pay":
+			var url string
+			if _, url, err = s.repairs.Charge(ctx, id, r.FormValue("method"), "cliente"); err == nil {
+				http.Redirect(w, r, url, http.StatusSeeOther)
+				return
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err == nil {
+			http.Redirect(w, r, "/repair/"+id.String()+"?ok="+action, http.StatusSeeOther)
+			return
+		}
+		if errors.Is(err, domain.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		data["Error"] = err.Error()
+	}
+	t, err := s.repairs.FindByID(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.URL.Query().Get("ok") {
+	case "approve":
+		data["Success"] = "¡Gracias! Aprobaste el presupuesto y ya empezamos con la reparación."
+	case "reject":
+		data["Success"] = "Registramos que no querés reparar el equipo. Te avisamos cuando esté listo para retirar."
+	}
+	s.fillRepairData(r.Context(), t, data)
+	s.render(w, "repair.html", data)
+}
+
+// fillRepairData carga en data lo que comparten la vista pública y la del admin de una orden de servicio.
+func (s *Server) fillRepairData(ctx context.Context, t *domain.RepairTicket, data map[string]any) {
+	data["Ticket"] = t
+	data["Events"], _ = s.repairs.History(ctx, t.ID)
+	data["Chargeable"] = s.repairs.Chargeable(t)
+	data["Methods"] = usecase.RepairPaymentMethods
+	if t.OrderID != nil {
+		if o, err := s.orders.Orders.FindByID(ctx, *t.OrderID); err == nil {
+			data["Order"] = o
+		}
+	}
+	data["Paid"], _ = s.repairs.Paid(ctx, t)
+}
+
+// repairStatusOptions son los estados del servicio técnico para los selects del admin.
+func repairStatusOptions(list []string) []rmaOption {
+	out := make([]rmaOption, 0, len(list))
+	for _, st := range list {
+		out = append(out, rmaOption{Value: st, Label: domain.RepairStatusLabel(st)})
+	}
+	return out
+}
+
+// handleAdminRepairs lista las órdenes de servicio técnico (?status=, ?q= por IMEI, cliente o número)
+// y da de alta un equipo nuevo (POST).
+func (s *Server) handleAdminRepairs(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.Method == http.MethodPost {
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		t := &domain.RepairTicket{
+			Name:        r.FormValue("name"),
+			Email:       r.FormValue("email"),
+			Phone:       r.FormValue("phone"),
+			DNI:         strings.TrimSpace(r.FormValue("dni")),
+			Brand:       r.FormValue("brand"),
+			Model:       r.FormValue("model"),
+			IMEI:        r.FormValue("imei"),
+			Accessories: strings.TrimSpace(r.FormValue("accessories")),
+			Fault:       r.FormValue("fault"),
+		}
+		if email := strings.TrimSpace(t.Email); email != "" {
+			if c, err := s.customers.FindByEmail(r.Context(), strings.ToLower(email)); err == nil {
+				t.CustomerID = &c.ID
+			}
+		}
+		if err := s.repairs.Create(r.Context(), t, actor); err != nil {
+			data["Error"] = err.Error()
+			data["Form"] = r.PostForm
+		} else {
+			http.Redirect(w, r, "/admin/repairs/"+t.ID.String()+"?created=1", http.StatusSeeOther)
+			return
+		}
+	}
+	q := r.URL.Query()
+	f := domain.RepairFilter{Status: q.Get("status"), Query: strings.TrimSpace(q.Get("q")), PageSize: 30}
+	f.Page, _ = strconv.Atoi(q.Get("page"))
+	if f.Page <= 0 {
+		f.Page = 1
+	}
+	list, total, err := s.repairs.List(r.Context(), f)
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	data["Tickets"] = list
+	data["Total"] = total
+	data["Filter"] = f
+	data["Statuses"] = repairStatusOptions(domain.RepairStatuses)
+	data["Page"] = f.Page
+	data["Pages"] = (int(total) + f.PageSize - 1) / f.PageSize
+	s.render(w, "admin_repairs.html", data)
+}
+
+// handleAdminRepairDetail muestra una orden de servicio y aplica las acciones del taller (POST
+// action=diagnostic, advance, approve, reject, add_part, remove_part o charge).
+func (s *Server) handleAdminRepairDetail(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	id, err := uuid.Parse(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/repairs/"), "/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.URL.Query().Get("created") == "1" {
+		data["Success"] = "Orden de servicio creada."
+	}
+	if r.Method == http.MethodPost {
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		ctx := r.Context()
+		var msg string
+		switch r.FormValue("action") {
+		case "diagnostic":
+			budget, _ := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(r.FormValue("budget")), ",", "."), 64)
+			_, err = s.repairs.SetDiagnostic(ctx, id, r.FormValue("diagnostic"), budget, actor)
+			msg = "Diagnóstico guardado."
+		case "advance":
+			to := r.FormValue("to")
+			_, err = s.repairs.Advance(ctx, id, to, r.FormValue("note"), actor)
+			msg = "Estado actualizado a " + domain.RepairStatusLabel(to) + ". Se avisó al cliente."
+		case "approve":
+			_, err = s.repairs.Approve(ctx, id, actor)
+			msg = "Presupuesto aprobado en nombre del cliente."
+		case "reject":
+			_, err = s.repairs.Reject(ctx, id, actor)
+			msg = "Presupuesto rechazado: el equipo queda listo para retirar sin reparar."
+		case "add_part":
+			qty, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("qty")))
+			_, err = s.repairs.AddPart(ctx, id, r.FormValue("code"), qty, actor)
+			msg = "Repuesto agregado y descontado del stock."
+		case "remove_part":
+			var partID uuid.UUID
+			if partID, err = uuid.Parse(r.FormValue("part_id")); err == nil {
+				_, err = s.repairs.RemovePart(ctx, id, partID, actor)
+			}
+			msg = "Repuesto quitado y devuelto al stock."
+		case "charge":
+			var o *domain.Order
+			if o, _, err = s.repairs.Charge(ctx, id, r.FormValue("method"), actor); err == nil {
+				msg = "Orden de cobro " + o.Number() + " generada."
+			}
+		default:
+			err = errors.New("acción inválida")
+		}
+		if err != nil {
+			data["Error"] = err.Error()
+		} else {
+			data["Success"] = msg
+		}
+	}
+	t, err := s.repairs.FindByID(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.fillRepairData(r.Context(), t, data)
+	data["Next"] = repairStatusOptions(t.NextStatuses())
+	s.render(w, "admin_repair.html", data)
+}
+
+// handleWarranty es la consulta pública de garantía por IMEI o número de orden (?q=). Sólo muestra el
+// producto y la cobertura, nunca datos del comprador.
+func (s *Server) handleWarranty(w http.ResponseWriter, r *http.Request) {
+	if s.warranties == nil {
+		http.NotFound(w, r)
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	data := map[string]any{"Query": q}
+	if u := readUserSession(w, r); u != nil {
+		data["User"] = u
+	}
+	if q != "" {
+		o, cov, err := s.warranties.Lookup(r.Context(), q)
+		switch {
+		case errors.Is(err, domain.ErrNotFound):
+			data["Error"] = "No encontramos una compra con ese IMEI o número de orden."
+		case err != nil:
+			data["Error"] = err.Error()
+		default:
+			data["Order"] = o
+			data["Coverage"] = cov
+		}
+	}
+	s.render(w, "warranty.html", data)
+}
+
+// handleAdminWarranties consulta la garantía por IMEI u orden (?q=), lista los reclamos (?status=) y
+// administra las políticas por categoría. POST action=policy_save, policy_delete u open_claim.
+// handleAdminPreOrders lista las variantes en preventa y las preventas abiertas; permite marcar variantes
+// y asignar el stock que llegó.
+func (s *Server) handleAdminPreOrders(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	ctx := r.Context()
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.Method == http.MethodPost {
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		var err error
+		switch r.FormValue("action") {
+		case "configure":
+			var eta *time.Time
+			if v := strings.TrimSpace(r.FormValue("eta")); v != "" {
+				t, perr := time.Parse("2006-01-02", v)
+				if perr != nil {
+					err = errors.New("fecha de llegada inválida")
+					break
+				}
+				eta = &t
+			}
+			pct, _ := strconv.ParseFloat(strings.TrimSpace(r.FormValue("deposit_pct")), 64)
+			enabled := r.FormValue("enabled") != "0"
+			var p *domain.Product
+			var v *domain.Variant
+			if p, v, err = s.preorders.Configure(ctx, r.FormValue("code"), enabled, eta, pct); err == nil {
+				name := v.SKU
+				if p != nil {
+					name = p.Name + " " + v.Color
+				}
+				if enabled {
+					data["Success"] = "Preventa habilitada para " + name + "."
+				} else {
+					data["Success"] = "Preventa deshabilitada para " + name + "."
+				}
+			}
+		case "allocate":
+			var n int
+			if n, err = s.preorders.Allocate(ctx, actor); err == nil {
+				data["Success"] = fmt.Sprintf("Stock asignado a %d preventas; se les pidió el saldo por email.", n)
+			}
+		default:
+			err = errors.New("acción inválida")
+		}
+		if err != nil {
+			data["Error"] = err.Error()
+		}
+	}
+	variants, err := s.preorders.Variants(ctx)
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	open, err := s.preorders.Open(ctx)
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	data["Variants"] = variants
+	data["Open"] = open
+	data["DefaultDepositPct"] = s.preorders.DefaultDepositPct
+	s.render(w, "admin_preorders.html", data)
+}
+
+// handleAdminNotifications lista los avisos del outbox por estado y permite reenviarlos (POST
+// action=resend con id, o resend_dead para todos los muertos).
+func (s *Server) handleAdminNotifications(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	if s.notifications == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := r.Context()
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.Method == http.MethodPost {
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		var err error
+		switch r.FormValue("action") {
+		case "resend":
+			var id uuid.UUID
+			if id, err = uuid.Parse(r.FormValue("id")); err != nil {
+				err = errors.New("aviso inválido")
+				break
+			}
+			if _, err = s.notifications.Resend(ctx, id); err == nil {
+				data["Success"] = "Aviso puesto en cola para reenviar."
+			}
+		case "resend_dead":
+			var n int
+			if n, err = s.notifications.ResendDead(ctx); err == nil {
+				data["Success"] = fmt.Sprintf("%d avisos puestos en cola para reenviar.", n)
+			}
+		default:
+			err = errors.New("acción inválida")
+		}
+		if err != nil {
+			data["Error"] = err.Error()
+		} else {
+			log.Info().Str("actor", actor).Str("action", r.FormValue("action")).Msg("outbox: reenvío manual")
+		}
+	}
+	q := r.URL.Query()
+	status := q.Get("status")
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+	const pageSize = 50
+	list, total, err := s.notifications.List(ctx, domain.OutboxFilter{Status: status, Page: page, PageSize: pageSize})
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	data["Messages"] = list
+	data["Total"] = total
+	data["Status"] = status
+	data["Statuses"] = []rmaOption{
+		{Value: domain.OutboxStatusPending, Label: "Pendientes"},
+		{Value: domain.OutboxStatusDelivered, Label: "Entregados"},
+		{Value: domain.OutboxStatusDead, Label: "Muertos"},
+	}
+	data["Page"] = page
+	data["Pages"] = (int(total) + pageSize - 1) / pageSize
+	s.render(w, "admin_notifications.html", data)
+}
+
+// handleAdminWhatsAppLog lista los mensajes de WhatsApp a los compradores: enviados, fallidos y omitidos.
+func (s *Server) handleAdminWhatsAppLog(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	if s.whatsapp == nil {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	status := q.Get("status")
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+	const pageSize = 50
+	list, total, err := s.whatsapp.List(r.Context(), domain.WhatsAppFilter{Status: status, Page: page, PageSize: pageSize})
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	templates := make([]rmaOption, 0, len(domain.WhatsAppEvents))
+	for _, ev := range domain.WhatsAppEvents {
+		templates = append(templates, rmaOption{Value: s.whatsapp.Templates[ev], Label: domain.WhatsAppEventLabel(ev)})
+	}
+	s.render(w, "admin_whatsapp.html", map[string]any{
+		"AdminToken": s.readAdminToken(r),
+		"Messages":   list,
+		"Total":      total,
+		"Status":     status,
+		"Statuses": []rmaOption{
+			{Value: domain.WhatsAppStatusSent, Label: "Enviados"},
+			{Value: domain.WhatsAppStatusFailed, Label: "Fallidos"},
+			{Value: domain.WhatsAppStatusSkipped, Label: "Omitidos"},
+		},
+		"Configured": s.whatsapp.Sender != nil,
+		"Templates":  templates,
+		"Lang":       s.whatsapp.Language(),
+		"Page":       page,
+		"Pages":      (int(total) + pageSize - 1) / pageSize,
+	})
+}
+
+// handleAdminWebhooks administra los webhooks salientes y muestra el registro de entregas (POST con
+// action: add, update, toggle, rotate, delete, ping, redeliver).
+func (s *Server) handleAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	if s.webhooks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := r.Context()
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.Method == http.MethodPost {
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		_ = r.ParseForm()
+		action := r.FormValue("action")
+		var err error
+		if action == "add" {
+			var e *domain.WebhookEndpoint
+			if e, err = s.webhooks.AddEndpoint(ctx, r.FormValue("name"), r.FormValue("url"), r.Form["events"]); err == nil {
+				data["Success"] = "Webhook agregado. Secreto de firma: " + e.Secret
+			}
+		} else {
+			var id uuid.UUID
+			if id, err = uuid.Parse(r.FormValue("id")); err != nil {
+				err = errors.New("webhook o entrega inválida")
+			} else {
+				switch action {
+				case "update":
+					if _, err = s.webhooks.UpdateEndpoint(ctx, id, r.FormValue("name"), r.FormValue("url"), r.Form["events"]); err == nil {
+						data["Success"] = "Webhook actualizado."
+					}
+				case "toggle":
+					var e *domain.WebhookEndpoint
+					if e, err = s.webhooks.ToggleEndpoint(ctx, id); err == nil {
+						if e.Active {
+							data["Success"] = "Webhook activado."
+						} else {
+							data["Success"] = "Webhook desactivado."
+						}
+					}
+				case "rotate":
+					var e *domain.WebhookEndpoint
+					if e, err = s.webhooks.RotateSecret(ctx, id); err == nil {
+						data["Success"] = "Secreto nuevo: " + e.Secret + ". Actualizalo en el receptor."
+					}
+				case "delete":
+					if err = s.webhooks.DeleteEndpoint(ctx, id); err == nil {
+						data["Success"] = "Webhook borrado."
+					}
+				case "ping":
+					if err = s.webhooks.Ping(ctx, id); err == nil {
+						data["Success"] = "Prueba puesta en cola."
+					}
+				case "redeliver":
+					if _, err = s.webhooks.Redeliver(ctx, id); err == nil {
+						data["Success"] = "Entrega puesta en cola para reenviar."
+					}
+				default:
+					err = errors.New("acción inválida")
+				}
+			}
+		}
+		if err != nil {
+			data["Error"] = err.Error()
+		} else {
+			log.Info().Str("actor", actor).Str("action", action).Msg("webhooks: cambio desde el admin")
+		}
+	}
+	endpoints, err := s.webhooks.Endpoints(ctx)
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	q := r.URL.Query()
+	f := domain.WebhookDeliveryFilter{Status: q.Get("status"), PageSize: 50}
+	f.Page, _ = strconv.Atoi(q.Get("page"))
+	if f.Page <= 0 {
+		f.Page = 1
+	}
+	endpoint := q.Get("endpoint")
+	if id, err := uuid.Parse(endpoint); err == nil {
+		f.EndpointID = &id
+	}
+	deliveries, total, err := s.webhooks.Deliveries(ctx, f)
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	events := make([]rmaOption, 0, len(domain.WebhookEvents))
+	for _, ev := range domain.WebhookEvents {
+		events = append(events, rmaOption{Value: ev, Label: domain.WebhookEventLabel(ev)})
+	}
+	data["Endpoints"] = endpoints
+	data["Events"] = events
+	data["Deliveries"] = deliveries
+	data["Total"] = total
+	data["Status"] = f.Status
+	data["Endpoint"] = endpoint
+	data["Statuses"] = []rmaOption{
+		{Value: domain.WebhookStatusPending, Label: "Pendientes"},
+		{Value: domain.WebhookStatusDelivered, Label: "Entregadas"},
+		{Value: domain.WebhookStatusDead, Label: "Muertas"},
+	}
+	data["Page"] = f.Page
+	data["Pages"] = (int(total) + f.PageSize - 1) / f.PageSize
+	s.render(w, "admin_webhooks.html", data)
+}
+
+// handleAdminNotificationSettings edita las plantillas de cada evento y las reglas que mandan cada evento
+// a un destino de un canal (POST con action: save_template, reset_template, add_rule, toggle_rule,
+// delete_rule, check_low_stock).
+func (s *Server) handleAdminNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	if s.notifications == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := r.Context()
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.Method == http.MethodPost {
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		event := r.FormValue("event")
+		var err error
+		switch r.FormValue("action") {
+		case "save_template":
+			if err = s.notifications.SaveTemplate(ctx, event, r.FormValue("subject"), r.FormValue("body")); err == nil {
+				data["Success"] = "Plantilla de " + domain.NotificationEventLabel(event) + " guardada."
+			}
+		case "reset_template":
+			if err = s.notifications.ResetTemplate(ctx, event); err == nil {
+				data["Success"] = "Plantilla de " + domain.NotificationEventLabel(event) + " restaurada."
+			}
+		case "add_rule":
+			if _, err = s.notifications.AddRule(ctx, event, r.FormValue("channel"), r.FormValue("target")); err == nil {
+				data["Success"] = "Regla agregada."
+			}
+		case "toggle_rule", "delete_rule":
+			var id uuid.UUID
+			if id, err = uuid.Parse(r.FormValue("id")); err != nil {
+				err = errors.New("regla inválida")
+				break
+			}
+			if r.FormValue("action") == "delete_rule" {
+				if err = s.notifications.DeleteRule(ctx, id); err == nil {
+					data["Success"] = "Regla eliminada."
+				}
+				break
+			}
+			var rule *domain.NotificationRule
+			if rule, err = s.notifications.ToggleRule(ctx, id); err == nil {
+				data["Success"] = "Regla pausada."
+				if rule.Active {
+					data["Success"] = "Regla activada."
+				}
+			}
+		case "check_low_stock":
+			var n int
+			if n, err = s.notifications.CheckLowStock(ctx); err == nil {
+				data["Success"] = fmt.Sprintf("%d variantes nuevas con stock bajo.", n)
+			}
+		default:
+			err = errors.New("acción inválida")
+		}
+		if err != nil {
+			data["Error"] = err.Error()
+		} else {
+			log.Info().Str("actor", actor).Str("action", r.FormValue("action")).Str("event", event).Msg("configuración de avisos")
+		}
+	}
+	templates, err := s.notifications.TemplateViews(ctx)
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	rules, err := s.notifications.Rules(ctx)
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	events := make([]rmaOption, 0, len(domain.NotificationEvents))
+	for _, ev := range domain.NotificationEvents {
+		events = append(events, rmaOption{Value: ev, Label: domain.NotificationEventLabel(ev)})
+	}
+	channels := make([]rmaOption, 0, len(domain.NotificationChannels))
+	for _, ch := range domain.NotificationChannels {
+		label := domain.NotificationChannelLabel(ch)
+		if !s.notifications.ChannelReady(ch) {
+			label += " — no configurado"
+		}
+		channels = append(channels, rmaOption{Value: ch, Label: label})
+	}
+	data["Templates"] = templates
+	data["Rules"] = rules
+	data["Events"] = events
+	data["Channels"] = channels
+	var defaults []string
+	for _, r := range s.notifications.DefaultRoutes {
+		if r.Event == domain.EventOrderCreated {
+			defaults = append(defaults, r.Channel+" "+r.Target)
+		}
+	}
+	data["DefaultTargets"] = strings.Join(defaults, ", ")
+	data["LowStockThreshold"] = s.notifications.LowStockThreshold
+	s.render(w, "admin_notification_settings.html", data)
+}
+
+func (s *Server) handleAdminWarranties(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	ctx := r.Context()
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.Method == http.MethodPost {
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		var err error
+		switch r.FormValue("action") {
+		case "policy_save":
+			months, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("months")))
+			if err = s.warranties.SavePolicy(ctx, r.FormValue("category"), months, r.FormValue("terms")); err == nil {
+				data["Success"] = "Política de garantía guardada."
+			}
+		case "policy_delete":
+			if err = s.warranties.DeletePolicy(ctx, r.FormValue("category")); err == nil {
+				data["Success"] = "Política eliminada: la categoría vuelve a la garantía por defecto."
+			}
+		case "open_claim":
+			var orderID, itemID uuid.UUID
+			var o *domain.Order
+			var c *domain.WarrantyClaim
+			if orderID, err = uuid.Parse(r.FormValue("order_id")); err != nil {
+				err = errors.New("orden inválida")
+			} else if itemID, err = uuid.Parse(r.FormValue("item_id")); err != nil {
+				err = errors.New("item inválido")
+			} else if o, err = s.orders.Orders.FindByID(ctx, orderID); err == nil {
+				if c, err = s.warranties.OpenClaim(ctx, o, itemID, r.FormValue("imei"), r.FormValue("problem"), actor); err == nil {
+					http.Redirect(w, r, "/admin/warranties/claims/"+c.ID.String(), http.StatusSeeOther)
+					return
+				}
+			}
+		default:
+			err = errors.New("acción inválida")
+		}
+		if err != nil {
+			data["Error"] = err.Error()
+		}
+	}
+	q := r.URL.Query()
+	if v := strings.TrimSpace(q.Get("q")); v != "" {
+		data["Query"] = v
+		o, cov, err := s.warranties.Lookup(ctx, v)
+		// El admin también puede buscar por número de orden correlativo.
+		if seq, ok := domain.ParseOrderNumber(v); ok && err != nil {
+			if byNum, ferr := s.orders.Orders.FindByNumber(ctx, seq); ferr == nil {
+				o = byNum
+				cov, err = s.warranties.Coverage(ctx, o)
+			}
+		}
+		if errors.Is(err, domain.ErrNotFound) {
+			data["LookupError"] = "Sin resultados para " + v + "."
+		} else if err != nil {
+			data["LookupError"] = err.Error()
+		} else {
+			data["Order"] = o
+			data["Coverage"] = cov
+		}
+	}
+	status := q.Get("status")
+	if _, ok := q["status"]; !ok {
+		status = domain.WarrantyClaimOpen
+	}
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+	const pageSize = 30
+	claims, total, err := s.warranties.ListClaims(ctx, status, page, pageSize)
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+	policies, _ := s.warranties.Policies(ctx)
+	categories, _ := s.products.Products.DistinctCategories(ctx)
+	ids := make([]uuid.UUID, 0, len(claims))
+	for _, c := range claims {
+		ids = append(ids, c.OrderID)
+	}
+	data["Claims"] = claims
+	data["OrderNumbers"] = s.orderNumbers(ctx, ids...)
+	data["Total"] = total
+	data["Status"] = status
+	data["Page"] = page
+	data["Pages"] = (int(total) + pageSize - 1) / pageSize
+	data["Policies"] = policies
+	data["Categories"] = categories
+	data["DefaultMonths"] = s.warranties.DefaultMonths
+	s.render(w, "admin_warranties.html", data)
+}
+
+// handleAdminWarrantyClaim muestra un reclamo de garantía y lo cierra (POST con outcome, cost y note).
+func (s *Server) handleAdminWarrantyClaim(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	id, err := uuid.Parse(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/warranties/claims/"), "/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.Method == http.MethodPost {
+		actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+		cost, _ := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(r.FormValue("cost")), ",", "."), 64)
+		if _, err := s.warranties.ResolveClaim(r.Context(), id, r.FormValue("outcome"), cost, r.FormValue("note"), actor); err != nil {
+			data["Error"] = err.Error()
+		} else {
+			data["Success"] = "Reclamo cerrado."
+		}
+	}
+	c, err := s.warranties.FindClaim(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	data["Claim"] = c
+	data["OrderNumbers"] = s.orderNumbers(r.Context(), c.OrderID)
+	if o, err := s.orders.Orders.FindByID(r.Context(), c.OrderID); err == nil {
+		data["Order"] = o
+		if cov, err := s.warranties.Coverage(r.Context(), o); err == nil {
+			for _, cv := range cov {
+				if cv.Item.ID == c.OrderItemID {
+					data["Coverage"] = cv
+				}
+			}
+		}
+	}
+	outcomes := make([]rmaOption, 0, len(domain.WarrantyOutcomes))
+	for _, v := range domain.WarrantyOutcomes {
+		outcomes = append(outcomes, rmaOption{Value: v, Label: domain.WarrantyOutcomeLabel(v)})
+	}
+	data["Outcomes"] = outcomes
+	s.render(w, "admin_warranty_claim.html", data)
+}
+
+// handleAdminReconcile muestra el último reporte del conciliador de pagos MP; POST fuerza una corrida.
+func (s *Server) handleAdminReconcile(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	if r.Method == http.MethodPost {
+		if _, err := s.payments.Reconcile(r.Context()); err != nil {
+			data["Error"] = "Error conciliando: " + err.Error()
+		}
+	}
+	data["Report"] = s.payments.LastReconcileReport()
+	s.render(w, "admin_reconcile.html", data)
+}
+
+// handleAdminRefund muestra los items de una orden para reembolsar (GET ?order_id=) y ejecuta el reembolso (POST).
+// Por cada item se envían qty_<itemID> y amount_<itemID>; si el monto queda vacío se usa precio unitario × cantidad.
+// Con action=retry|discard y refund_id se reintenta o descarta un reembolso que quedó pendiente.
+func (s *Server) handleAdminRefund(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	data := map[string]any{"AdminToken": s.readAdminToken(r)}
+	orderIDStr := strings.TrimSpace(r.FormValue("order_id"))
+	orderID, err := uuid.Parse(orderIDStr)
+	if err != nil {
+		data["Error"] = "UUID de orden inválido"
+		s.render(w, "admin_refund.html", data)
+		return
+	}
+	actor, _ := s.verifyAdminToken(s.readAdminToken(r))
+	if action := r.FormValue("action"); r.Method == http.MethodPost && (action == "retry" || action == "discard") {
+		refundID, err := uuid.Parse(strings.TrimSpace(r.FormValue("refund_id")))
+		switch {
+		case err != nil:
+			data["Error"] = "ID de reembolso inválido"
+		case action == "retry":
+			rf, err := s.refunds.Retry(r.Context(), refundID, actor)
+			if err != nil {
+				data["Error"] = err.Error()
+			} else {
+				data["Success"] = fmt.Sprintf("Reembolso de $%.2f registrado (%s).", rf.Amount, rf.Status)
+			}
+		default:
+			if err := s.refunds.Discard(r.Context(), refundID, actor); err != nil {
+				data["Error"] = err.Error()
+			} else {
+				data["Success"] = "Reembolso pendiente descartado."
+			}
+		}
+	} else if r.Method == http.MethodPost {
+		order, err := s.orders.Orders.FindByID(r.Context(), orderID)
+		if err != nil {
+			data["Error"] = "Orden no encontrada"
+			s.render(w, "admin_refund.html", data)
+			return
+		}
+		var lines []domain.RefundLineRequest
+		for _, it := range order.Items {
+			qty, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("qty_" + it.ID.String())))
+			amount, _ := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(r.FormValue("amount_"+it.ID.String())), ",", "."), 64)
+			if qty == 0 && amount == 0 {
+				continue
+			}
+			lines = append(lines, domain.RefundLineRequest{OrderItemID: it.ID, Qty: qty, Amount: amount})
+		}
+		rf, err := s.refunds.Refund(r.Context(), orderID, lines, strings.TrimSpace(r.FormValue("reason")), actor)
+		if err != nil {
+			data["Error"] = err.Error()
+		} else {
+			data["Success"] = fmt.Sprintf("Reembolso de $%.2f registrado (%s).", rf.Amount, rf.Status)
+		}
+	}
+	order, err := s.orders.Orders.FindByID(r.Context(), orderID)
+	if err != nil {
+		data["Error"] = "Orden no encontrada"
+		s.render(w, "admin_refund.html", data)
+		return
+	}
+	refunds, _ := s.refunds.ListByOrder(r.Context(), orderID)
+	refundedQty := map[uuid.UUID]int{}
+	for _, rf := range refunds {
+		if !rf.Active() {
+			continue
+		}
+		for _, l := range rf.Lines {
+			refundedQty[l.OrderItemID] += l.Qty
+		}
+	}
+	data["Order"] = order
+	data["Refunds"] = refunds
+	data["RefundedQty"] = refundedQty
+	s.render(w, "admin_refund.html", data)
+}
+
+func (s *Server) handleAdminSales(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdminSession(r) {
+		http.Redirect(w, r, "/admin/auth", 302)
+		return
+	}
+	q := r.URL.Query()
+	layout := "admin_sales.html"
+
+	const layoutIn = "2006-01-02"
+	var (
+		to   time.Time
+		from time.Time
+		err  error
+	)
+	if ds := q.Get("to"); ds != "" {
+		to, err = time.Parse(layoutIn, ds)
+		if err != nil {
+			to = time.Now()
+		}
+	} else {
+		to = time.Now()
+	}
+	if ds := q.Get("from"); ds != "" {
+		from, err = time.Parse(layoutIn, ds)
+		if err != nil {
+			from = to.AddDate(0, 0, -29)
+		}
+	} else {
+		from = to.AddDate(0, 0, -29)
+	}
+	if from.After(to) {
+		from, to = to, from
+	}
+
+	ordersAll, err := s.orders.Orders.ListInRange(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, "err", 500)
+		return
+	}
+
+	orders := make([]domain.Order, 0, len(ordersAll))
 	for _, o := range ordersAll {
-		if strings.EqualFold(o.MPStatus, "approved") {
+		if strings.EqualFold(o.PaymentStatus, "approved") {
 			orders = append(orders, o)
 		}
 	}
@@ -2708,6 +4604,8 @@ func (s *Server) handleAdminSales(w http.ResponseWriter, r *http.Request) {
 	statusCounts := map[string]int{}
 	mpStatusCounts := map[string]int{}
 	shippingMethodCounts := map[string]int{}
+	paymentMethodCounts := map[string]int{}
+	paymentMethodRevenue := map[string]float64{}
 	provinceCounts := map[string]int{}
 	itemsRevenue := 0.0
 	productAgg := map[string]struct {
@@ -2724,12 +4622,18 @@ func (s *Server) handleAdminSales(w http.ResponseWriter, r *http.Request) {
 		totalRevenue += o.Total
 		shippingRevenue += o.ShippingCost
 		statusCounts[string(o.Status)]++
-		if o.MPStatus != "" {
-			mpStatusCounts[o.MPStatus]++
+		if o.PaymentStatus != "" {
+			mpStatusCounts[o.PaymentStatus]++
 		}
 		if o.ShippingMethod != "" {
 			shippingMethodCounts[o.ShippingMethod]++
 		}
+		pm := o.PaymentMethod
+		if pm == "" {
+			pm = "mercadopago"
+		}
+		paymentMethodCounts[pm]++
+		paymentMethodRevenue[pm] += o.Total
 		if o.Province != "" {
 			provinceCounts[o.Province]++
 		}
@@ -2796,9 +4700,9 @@ func (s *Server) handleAdminSales(w http.ResponseWriter, r *http.Request) {
 	if strings.ToLower(q.Get("format")) == "csv" {
 		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=ventas_%s_%s.csv", from.Format(layoutIn), to.Format(layoutIn)))
-		fmt.Fprintln(w, "order_id,created_at,status,mp_status,total,shipping_method,shipping_cost,province")
+		fmt.Fprintln(w, "order_id,created_at,status,mp_status,total,shipping_method,shipping_cost,province,payment_method")
 		for _, o := range orders {
-			fmt.Fprintf(w, "%s,%s,%s,%s,%.2f,%s,%.2f,%s\n", o.ID, o.CreatedAt.Format(time.RFC3339), o.Status, o.MPStatus, o.Total, o.ShippingMethod, o.ShippingCost, strings.ReplaceAll(o.Province, ",", " "))
+			fmt.Fprintf(w, "%s,%s,%s,%s,%.2f,%s,%.2f,%s,%s\n", o.ID, o.CreatedAt.Format(time.RFC3339), o.Status, o.PaymentStatus, o.Total, o.ShippingMethod, o.ShippingCost, strings.ReplaceAll(o.Province, ",", " "), o.PaymentMethod)
 		}
 		return
 	}
@@ -2814,6 +4718,8 @@ func (s *Server) handleAdminSales(w http.ResponseWriter, r *http.Request) {
 		"StatusCounts":         statusCounts,
 		"MPStatusCounts":       mpStatusCounts,
 		"ShippingMethodCounts": shippingMethodCounts,
+		"PaymentMethodCounts":  paymentMethodCounts,
+		"PaymentMethodRevenue": paymentMethodRevenue,
 		"ProvinceCounts":       provinceCounts,
 		"TopProducts":          prodList,
 		"DailySeries":          daySeries,
@@ -2917,151 +4823,13 @@ func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
 	return false
 }
 
-func sendOrderEmail(o *domain.Order, success bool) error {
-	host := os.Getenv("SMTP_HOST")
-	port := os.Getenv("SMTP_PORT")
-	user := os.Getenv("SMTP_USER")
-	pass := os.Getenv("SMTP_PASS")
-	to := os.Getenv("ORDER_NOTIFY_EMAIL")
-	if to == "" {
-		to = "ventas@newmobile.com.ar"
-	}
-	if host == "" || port == "" || user == "" || pass == "" {
-		return nil
-	}
-	addr := host + ":" + port
-	statusTxt := "PAGO FALLIDO"
-	if success {
-		statusTxt = "PAGO APROBADO"
-	} else if o.PaymentMethod == "transferencia" {
-		statusTxt = "PAGO EN PROCESO"
-	} else if o.PaymentMethod == "cripto" {
-		statusTxt = "PAGO CRIPTO EN PROCESO"
-	}
-	var buf bytes.Buffer
-	_, _ = fmt.Fprintf(&buf, "Subject: Nueva orden %s #%s\r\n", statusTxt, o.ID.String())
-	_, _ = fmt.Fprintf(&buf, "From: %s\r\n", user)
-	_, _ = fmt.Fprintf(&buf, "To: %s\r\n", to)
-	buf.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
-	_, _ = fmt.Fprintf(&buf, "Estado: %s\n", statusTxt)
-	_, _ = fmt.Fprintf(&buf, "Orden: %s\n", o.ID)
-	_, _ = fmt.Fprintf(&buf, "Nombre: %s\nEmail: %s\nTel: %s\nDNI: %s\n", o.Name, o.Email, o.Phone, o.DNI)
-	if o.ShippingMethod == "envio" || o.ShippingMethod == "cadete" {
-		_, _ = fmt.Fprintf(&buf, "Envío (%s) a: %s (%s) CP:%s\n", o.ShippingMethod, o.Address, o.Province, o.PostalCode)
-		if o.DeliveryNotes != "" {
-			_, _ = fmt.Fprintf(&buf, "Observaciones: %s\n", o.DeliveryNotes)
-		}
-	} else {
-		buf.WriteString("Retiro en local\n")
-	}
-	buf.WriteString("Items:\n")
-	for _, it := range o.Items {
-		col := formatColorES(it.Color)
-		if col != "" {
-			_, _ = fmt.Fprintf(&buf, "- %s x%d $%.2f Color: %s\n", it.Title, it.Qty, it.UnitPrice, col)
-		} else {
-			_, _ = fmt.Fprintf(&buf, "- %s x%d $%.2f\n", it.Title, it.Qty, it.UnitPrice)
-		}
-	}
-	_, _ = fmt.Fprintf(&buf, "Total: $%.2f (Envío: $%.2f)\n", o.Total, o.ShippingCost)
-	auth := smtp.PlainAuth("", user, pass, host)
-	if err := smtp.SendMail(addr, auth, user, []string{to}, buf.Bytes()); err != nil {
-		return err
-	}
-	return nil
-}
-
-func sendOrderTelegram(o *domain.Order, success bool) error {
-	token := os.Getenv("TELEGRAM_BOT_TOKEN")
-	// Soportar múltiples IDs: TELEGRAM_CHAT_IDS (coma-separado) o fallback TELEGRAM_CHAT_ID
-	rawIDs := os.Getenv("TELEGRAM_CHAT_IDS")
-	if strings.TrimSpace(rawIDs) == "" {
-		rawIDs = os.Getenv("TELEGRAM_CHAT_ID")
-	}
-	if token == "" || strings.TrimSpace(rawIDs) == "" {
-		return fmt.Errorf("telegram vars faltantes")
-	}
-	statusTxt := "PAGO FALLIDO"
-	if success {
-		statusTxt = "PAGO APROBADO"
-	} else if o.PaymentMethod == "transferencia" {
-		statusTxt = "PAGO EN PROCESO"
-	} else if o.PaymentMethod == "cripto" {
-		statusTxt = "PAGO CRIPTO EN PROCESO"
-	}
-	var b strings.Builder
-	b.WriteString("Orden ")
-	b.WriteString(o.ID.String())
-	b.WriteString(" - ")
-	b.WriteString(statusTxt)
-	b.WriteString("\n")
-	fmt.Fprintf(&b, "Nombre: %s\nEmail: %s\nTel: %s\nDNI: %s\n", o.Name, o.Email, o.Phone, o.DNI)
-	if o.ShippingMethod == "envio" || o.ShippingMethod == "cadete" {
-		fmt.Fprintf(&b, "Envío (%s) a: %s (%s %s) CP:%s\n", o.ShippingMethod, o.Address, o.Province, o.ShippingMethod, o.PostalCode)
-		if o.DeliveryNotes != "" {
-			fmt.Fprintf(&b, "Observaciones: %s\n", o.DeliveryNotes)
-		}
-	} else {
-		b.WriteString("Retiro en local\n")
-	}
-	b.WriteString("Items:\n")
-	for _, it := range o.Items {
-		col := formatColorES(it.Color)
-		if col != "" {
-			fmt.Fprintf(&b, "- %s x%d — $%.2f  Color: %s\n", it.Title, it.Qty, it.UnitPrice, col)
-		} else {
-			fmt.Fprintf(&b, "- %s x%d — $%.2f\n", it.Title, it.Qty, it.UnitPrice)
-		}
-	}
-	fmt.Fprintf(&b, "Total: $%.2f (Envio: $%.2f)\n", o.Total, o.ShippingCost)
-	apiURL := "https://api.telegram.org/bot" + token + "/sendMessage"
-	// Separar por coma y enviar a cada destino
-	ids := []string{}
-	for _, part := range strings.Split(rawIDs, ",") {
-		id := strings.TrimSpace(part)
-		if id != "" {
-			ids = append(ids, id)
-		}
-	}
-	if len(ids) == 0 {
-		return fmt.Errorf("telegram chat ids vacios")
-	}
-	var lastErr error
-	for _, id := range ids {
-		form := url.Values{}
-		form.Set("chat_id", id)
-		form.Set("text", b.String())
-		form.Set("disable_web_page_preview", "1")
-		resp, err := http.PostForm(apiURL, form)
-		if err != nil {
-			lastErr = err
-			continue
-		}
-		func() {
-			defer resp.Body.Close()
-			if resp.StatusCode >= 300 {
-				body, _ := io.ReadAll(resp.Body)
-				lastErr = fmt.Errorf("telegram status %d: %s", resp.StatusCode, string(body))
-			}
-		}()
-	}
-	return lastErr
-}
-
-func (s *Server) sendOrderNotify(o *domain.Order, success bool) {
-	// Notificar al admin (Telegram o email de admin)
-	if err := sendOrderTelegram(o, success); err != nil {
-		if os.Getenv("SMTP_HOST") != "" {
-			_ = sendOrderEmail(o, success)
-		}
-	}
-
-	// Enviar email de confirmación al comprador
-	if s.emailService != nil {
-		if err := s.emailService.SendOrderConfirmation(context.Background(), o); err != nil {
-			log.Error().Err(err).Str("order_id", o.ID.String()).Msg("error enviando email al comprador")
-		}
-	}
+// queueOrderNotify encola los avisos de una orden nueva pendiente de pago (al staff y al comprador);
+// se guardan con el próximo Save de la orden y los entrega el worker del outbox.
+func (s *Server) queueOrderNotify(o *domain.Order) {
+	now := time.Now()
+	o.QueueEvent(domain.EventOrderCreated, now)
+	o.QueueNotification(domain.NotifyOrderCustomer, domain.OutboxPayload{}, now)
+	o.QueueWhatsApp(domain.WhatsAppOrderReceived, now)
 }
 
 type sessionUser struct {
@@ -3119,6 +4887,10 @@ func (s *Server) handleGoogleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 	state := uuid.New().String()
 	http.SetCookie(w, &http.Cookie{Name: "oauth_state", Value: state, Path: "/", MaxAge: 300, HttpOnly: true, Secure: false})
+	// next es la página a la que se vuelve después de ingresar (p.ej. /cuenta); sólo rutas locales.
+	if next := r.URL.Query().Get("next"); safeLocalPath(next) {
+		http.SetCookie(w, &http.Cookie{Name: "login_next", Value: url.QueryEscape(next), Path: "/", MaxAge: 300, HttpOnly: true, Secure: false})
+	}
 	loginURL := s.oauthCfg.AuthCodeURL(state, oauth2.AccessTypeOnline)
 	http.Redirect(w, r, loginURL, 302)
 }
@@ -3150,23 +4922,41 @@ func (s *Server) handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body)
 	var info struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
 	}
 	_ = json.Unmarshal(body, &info)
 	if info.Email == "" {
 		http.Error(w, "email", 400)
 		return
 	}
-	if s.customers != nil {
-		if cust, err := s.customers.FindByEmail(r.Context(), info.Email); err != nil && err == domain.ErrNotFound {
-			_ = s.customers.Save(r.Context(), &domain.Customer{ID: uuid.New(), Email: info.Email, Name: info.Name})
-		} else if cust == nil && err == nil {
-			_ = s.customers.Save(r.Context(), &domain.Customer{ID: uuid.New(), Email: info.Email, Name: info.Name})
+	// La sesión y la cuenta del cliente se identifican por email: uno sin verificar permitiría entrar
+	// a la cuenta (y a las órdenes) de otro.
+	if !info.EmailVerified {
+		log.Warn().Str("email", info.Email).Msg("login: Google devolvió un email sin verificar")
+		http.Error(w, "email no verificado", 403)
+		return
+	}
+	if s.accounts != nil {
+		if _, err := s.accounts.Login(r.Context(), info.Email, info.Name); err != nil {
+			log.Error().Err(err).Str("email", info.Email).Msg("login: no se pudo registrar el cliente")
 		}
 	}
 	writeUserSession(w, &sessionUser{Email: info.Email, Name: info.Name})
-	http.Redirect(w, r, "/", 302)
+	dest := "/"
+	if c, err := r.Cookie("login_next"); err == nil {
+		if next, err := url.QueryUnescape(c.Value); err == nil && safeLocalPath(next) {
+			dest = next
+		}
+		http.SetCookie(w, &http.Cookie{Name: "login_next", Value: "", Path: "/", MaxAge: -1})
+	}
+	http.Redirect(w, r, dest, 302)
+}
+
+// safeLocalPath indica si p es una ruta de este sitio ("/cuenta"), para no redirigir a otro dominio.
+func safeLocalPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.Contains(p, "\\")
 }
 
 func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
@@ -3174,6 +4964,278 @@ func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", 302)
 }
 
+// handleLogin es la página de ingreso: pide el email para mandar el link de ingreso y, si está
+// configurado, ofrece entrar con Google. next es la página a la que se vuelve (por defecto /cuenta).
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if !safeLocalPath(next) {
+		next = "/cuenta"
+	}
+	if readUserSession(w, r) != nil {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+	data := map[string]any{"Next": next, "Google": s.oauthCfg != nil, "EmailLogin": s.loginLinks != nil}
+	if r.Method == http.MethodPost && s.loginLinks != nil {
+		email := strings.TrimSpace(r.FormValue("email"))
+		data["Email"] = email
+		if !emailRe.MatchString(email) {
+			data["Error"] = "Revisá el email: no parece válido."
+			s.render(w, "login.html", data)
+			return
+		}
+		ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if ip == "" {
+			ip = r.RemoteAddr
+		}
+		err := s.loginLinks.RequestLink(r.Context(), email, ip, next)
+		switch {
+		case err == nil:
+			data["Sent"] = true
+		case errors.Is(err, domain.ErrLoginRateLimited):
+			data["Error"] = "Pediste demasiados links de ingreso. Esperá un rato y volvé a probar."
+		default:
+			log.Error().Err(err).Str("email", email).Msg("login: no se pudo mandar el link de ingreso")
+			data["Error"] = "No pudimos mandarte el email. Probá de nuevo en unos minutos."
+		}
+	}
+	s.render(w, "login.html", data)
+}
+
+// handleEmailLogin abre el link de ingreso del email. El GET sólo muestra el botón de confirmar y el
+// token se usa en el POST: así los antivirus y previsualizadores de correo que abren los links no lo
+// gastan antes que el cliente.
+func (s *Server) handleEmailLogin(w http.ResponseWriter, r *http.Request) {
+	if s.loginLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	token := r.FormValue("token")
+	data := map[string]any{"Next": "/cuenta", "Google": s.oauthCfg != nil, "EmailLogin": true}
+	if r.Method != http.MethodPost {
+		data["Token"] = token
+		s.render(w, "login.html", data)
+		return
+	}
+	c, next, err := s.loginLinks.Verify(r.Context(), token)
+	if err != nil {
+		if !errors.Is(err, domain.ErrLoginLinkInvalid) {
+			log.Error().Err(err).Msg("login: error usando el link de ingreso")
+		}
+		data["Error"] = "El link de ingreso no es válido, ya se usó o venció. Pedí uno nuevo."
+		s.render(w, "login.html", data)
+		return
+	}
+	writeUserSession(w, &sessionUser{Email: c.Email, Name: c.Name})
+	if !safeLocalPath(next) {
+		next = "/cuenta"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// accountCustomer devuelve el usuario y el cliente de la sesión. Sin sesión manda a /login y vuelve a
+// la misma página; ok es false si ya respondió.
+func (s *Server) accountCustomer(w http.ResponseWriter, r *http.Request) (*sessionUser, *domain.Customer, bool) {
+	u := readUserSession(w, r)
+	if u == nil {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.Path), http.StatusSeeOther)
+		return nil, nil, false
+	}
+	c, err := s.accounts.Customer(r.Context(), u.Email)
+	if errors.Is(err, domain.ErrNotFound) {
+		// Sesión abierta antes de que existiera la cuenta: se registra ahora.
+		c, err = s.accounts.Login(r.Context(), u.Email, u.Name)
+	}
+	if err != nil {
+		http.Error(w, "error", 500)
+		return nil, nil, false
+	}
+	return u, c, true
+}
+
+// handleAccount es "Mi cuenta": órdenes, datos personales y de facturación, y direcciones guardadas.
+// Los formularios hacen POST a /cuenta con action y vuelven con ?ok=<action>.
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if s.accounts == nil {
+		http.NotFound(w, r)
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/cuenta"), "/")
+	if idStr, ok := strings.CutPrefix(rest, "ordenes/"); ok {
+		s.handleAccountOrder(w, r, idStr)
+		return
+	}
+	if rest != "" {
+		http.NotFound(w, r)
+		return
+	}
+	u, c, ok := s.accountCustomer(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	data := map[string]any{"User": u}
+	// Profile y AddressForm son lo que muestran los formularios; si el POST falla, lo que mandó el cliente.
+	profile := domain.CustomerProfile{Name: c.Name, Phone: c.Phone, DNI: c.DNI, TaxID: c.TaxID, TaxCondition: c.TaxCondition}
+	var newAddress domain.CustomerAddress
+	if r.Method == http.MethodPost {
+		action := r.FormValue("action")
+		var err error
+		switch action {
+		case "profile":
+			profile = domain.CustomerProfile{
+				Name:         r.FormValue("name"),
+				Phone:        r.FormValue("phone"),
+				DNI:          r.FormValue("dni"),
+				TaxID:        r.FormValue("tax_id"),
+				TaxCondition: r.FormValue("tax_condition"),
+			}
+			err = s.accounts.UpdateProfile(ctx, c, profile)
+		case "address_save":
+			a := domain.CustomerAddress{
+				Label:      r.FormValue("label"),
+				Address:    r.FormValue("address"),
+				PostalCode: r.FormValue("postal_code"),
+				Province:   r.FormValue("province"),
+				Notes:      r.FormValue("notes"),
+				IsDefault:  r.FormValue("is_default") != "",
+			}
+			if v := r.FormValue("id"); v != "" {
+				if a.ID, err = uuid.Parse(v); err != nil {
+					err = domain.ErrNotFound
+				}
+			}
+			if err == nil {
+				_, err = s.accounts.SaveAddress(ctx, c, a)
+			}
+			if a.ID == uuid.Nil {
+				data["NewAddressFailed"] = true
+				newAddress = a
+			}
+		case "address_default", "address_delete":
+			id, perr := uuid.Parse(r.FormValue("id"))
+			switch {
+			case perr != nil:
+				err = domain.ErrNotFound
+			case action == "address_default":
+				err = s.accounts.SetDefaultAddress(ctx, c, id)
+			default:
+				err = s.accounts.DeleteAddress(ctx, c, id)
+			}
+		default:
+			http.Redirect(w, r, "/cuenta", http.StatusSeeOther)
+			return
+		}
+		if err == nil {
+			anchor := "#direcciones"
+			if action == "profile" {
+				anchor = "#datos"
+			}
+			http.Redirect(w, r, "/cuenta?ok="+action+anchor, http.StatusSeeOther)
+			return
+		}
+		if errors.Is(err, domain.ErrNotFound) {
+			err = errors.New("la dirección no existe")
+		}
+		data["Error"] = err.Error()
+	}
+	switch r.URL.Query().Get("ok") {
+	case "profile":
+		data["Success"] = "Guardamos tus datos."
+	case "address_save":
+		data["Success"] = "Guardamos la dirección."
+	case "address_default":
+		data["Success"] = "Cambiamos tu dirección predeterminada."
+	case "address_delete":
+		data["Success"] = "Borramos la dirección."
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	const pageSize = 10
+	orders, total, err := s.accounts.ListOrders(ctx, c, page, pageSize)
+	if err != nil {
+		http.Error(w, "error", 500)
+		return
+	}
+	addresses, err := s.accounts.Addresses(ctx, c)
+	if err != nil {
+		http.Error(w, "error", 500)
+		return
+	}
+	provinces := make([]string, 0, len(provinceCosts))
+	for p := range provinceCosts {
+		provinces = append(provinces, p)
+	}
+	sort.Strings(provinces)
+	pages := int((total + pageSize - 1) / pageSize)
+	if pages < 1 {
+		pages = 1
+	}
+	data["Customer"] = c
+	data["Profile"] = profile
+	data["Orders"] = orders
+	data["Total"] = total
+	data["Page"] = page
+	data["Pages"] = pages
+	forms := make([]accountAddressForm, 0, len(addresses))
+	for _, a := range addresses {
+		forms = append(forms, accountAddressForm{Address: a, Edit: true, Provinces: provinces})
+	}
+	data["Addresses"] = forms
+	data["NewAddress"] = accountAddressForm{Address: newAddress, Provinces: provinces}
+	s.render(w, "account.html", data)
+}
+
+// accountAddressForm es el formulario de una dirección de "Mi cuenta": una guardada (Edit) o una nueva.
+type accountAddressForm struct {
+	Address   domain.CustomerAddress
+	Edit      bool
+	Provinces []string
+}
+
+// handleAccountOrder es el detalle de una orden del cliente: estado, seguimiento del envío e historial.
+func (s *Server) handleAccountOrder(w http.ResponseWriter, r *http.Request, idStr string) {
+	u, c, ok := s.accountCustomer(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	o, events, err := s.accounts.FindOrder(r.Context(), c, id)
+	if errors.Is(err, domain.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error", 500)
+		return
+	}
+	// Para el cliente alcanza con los cambios de estado; las notas del staff no se muestran.
+	var history []accountStatusStep
+	for _, ev := range events {
+		if ev.From == ev.To {
+			continue
+		}
+		history = append(history, accountStatusStep{At: ev.CreatedAt, Label: ev.To.CustomerLabel(o.ShippingMethod)})
+	}
+	s.render(w, "account_order.html", map[string]any{
+		"User":    u,
+		"Order":   o,
+		"History": history,
+	})
+}
+
+// accountStatusStep es un cambio de estado de la orden como lo ve el cliente.
+type accountStatusStep struct {
+	At    time.Time
+	Label string
+}
+
 func (s *Server) handleAdminLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method", 405)
@@ -3371,8 +5433,18 @@ func (s *Server) handleAdminImportCSV(w http.ResponseWriter, r *http.Request) {
 		updatedV += uv
 	}
 
+	// Con el stock nuevo se asignan las preventas que estaban esperando.
+	allocated := 0
+	if s.preorders != nil {
+		n, err := s.preorders.Allocate(r.Context(), "importación")
+		if err != nil {
+			log.Error().Err(err).Msg("preventa: error asignando stock después de la importación")
+		}
+		allocated = n
+	}
+
 	// devolver también resumen del reporte
-	resp := map[string]any{"created_products": createdP, "updated_products": updatedP, "created_variants": createdV, "updated_variants": updatedV, "unmatched": unmatched}
+	resp := map[string]any{"created_products": createdP, "updated_products": updatedP, "created_variants": createdV, "updated_variants": updatedV, "unmatched": unmatched, "preorders_allocated": allocated}
 	if s.lastImport != nil {
 		resp["report"] = map[string]any{
 			"timestamp":        s.lastImport.Timestamp.Format(time.RFC3339),
@@ -0,0 +1,259 @@
+// Package afip implementa InvoiceIssuer contra los web services de AFIP: WSAA para obtener el ticket de
+// acceso (firmando el pedido con el certificado del contribuyente) y WSFEv1 para autorizar facturas.
+package afip
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+)
+
+const (
+	wsaaHomologacion = "https://wsaahomo.afip.gov.ar/ws/services/LoginCms"
+	wsaaProduccion   = "https://wsaa.afip.gov.ar/ws/services/LoginCms"
+	wsfeHomologacion = "https://wswhomo.afip.gov.ar/wsfev1/service.asmx"
+	wsfeProduccion   = "https://servicios1.afip.gov.ar/wsfev1/service.asmx"
+)
+
+type Config struct {
+	CUIT     string
+	CertFile string // certificado X.509 (PEM) emitido por AFIP para el alias del sistema
+	KeyFile  string // clave privada RSA (PEM) del certificado
+	// Production usa los servicios de producción; si no, los de homologación.
+	Production bool
+	// TicketFile guarda el ticket de acceso entre reinicios: WSAA rechaza pedir otro mientras el
+	// anterior siga vigente (hasta 12 horas).
+	TicketFile string
+}
+
+type Client struct {
+	cuit       int64
+	cert       *x509.Certificate
+	key        crypto.PrivateKey
+	production bool
+	wsaaURL    string
+	wsfeURL    string
+	ticketFile string
+	http       *http.Client
+
+	mu     sync.Mutex
+	ticket *ticket
+}
+
+// ticket es el ticket de acceso (TA) que devuelve WSAA.
+type ticket struct {
+	Token   string    `json:"token"`
+	Sign    string    `json:"sign"`
+	Expires time.Time `json:"expires"`
+}
+
+func NewClient(cfg Config) (*Client, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, cfg.CUIT)
+	if len(digits) != 11 {
+		return nil, fmt.Errorf("afip: CUIT inválido %q", cfg.CUIT)
+	}
+	cuit, _ := strconv.ParseInt(digits, 10, 64)
+	cert, err := readCertificate(cfg.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := readPrivateKey(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		cuit:       cuit,
+		cert:       cert,
+		key:        key,
+		production: cfg.Production,
+		wsaaURL:    wsaaHomologacion,
+		wsfeURL:    wsfeHomologacion,
+		ticketFile: cfg.TicketFile,
+		http:       &http.Client{Timeout: 30 * time.Second},
+	}
+	if cfg.Production {
+		c.wsaaURL, c.wsfeURL = wsaaProduccion, wsfeProduccion
+	}
+	c.loadTicket()
+	return c, nil
+}
+
+func (c *Client) Environment() string {
+	if c.production {
+		return "produccion"
+	}
+	return "homologacion"
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("afip: leyendo certificado: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("afip: el certificado no está en formato PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func readPrivateKey(path string) (crypto.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("afip: leyendo clave privada: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("afip: la clave privada no está en formato PEM")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// auth devuelve un ticket de acceso vigente para wsfe, pidiéndole uno nuevo a WSAA si hace falta.
+func (c *Client) auth(ctx context.Context) (*ticket, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ticket != nil && time.Until(c.ticket.Expires) > 5*time.Minute {
+		return c.ticket, nil
+	}
+	t, err := c.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.ticket = t
+	c.saveTicket()
+	return t, nil
+}
+
+// login firma el pedido de ticket (TRA) en CMS con el certificado y lo envía a WSAA.
+func (c *Client) login(ctx context.Context) (*ticket, error) {
+	now := time.Now()
+	tra := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?><loginTicketRequest version="1.0"><header><uniqueId>%d</uniqueId><generationTime>%s</generationTime><expirationTime>%s</expirationTime></header><service>wsfe</service></loginTicketRequest>`,
+		now.Unix(), now.Add(-10*time.Minute).Format(time.RFC3339), now.Add(10*time.Minute).Format(time.RFC3339))
+	sd, err := pkcs7.NewSignedData([]byte(tra))
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSigner(c.cert, c.key, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("afip: firmando TRA: %w", err)
+	}
+	cms, err := sd.Finish()
+	if err != nil {
+		return nil, err
+	}
+	body := `<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:wsaa="http://wsaa.view.sua.dvadac.desein.afip.gov"><soapenv:Header/><soapenv:Body><wsaa:loginCms><wsaa:in0>` +
+		base64.StdEncoding.EncodeToString(cms) + `</wsaa:in0></wsaa:loginCms></soapenv:Body></soapenv:Envelope>`
+
+	var resp struct {
+		Body struct {
+			Return string `xml:"loginCmsResponse>loginCmsReturn"`
+		} `xml:"Body"`
+	}
+	if err := c.post(ctx, c.wsaaURL, `""`, body, &resp); err != nil {
+		if strings.Contains(err.Error(), "alreadyAuthenticated") {
+			return nil, fmt.Errorf("%w (WSAA no emite otro ticket hasta que venza el anterior; configurá AFIP_TA_FILE para conservarlo entre reinicios)", err)
+		}
+		return nil, err
+	}
+	var lt struct {
+		Expiration string `xml:"header>expirationTime"`
+		Token      string `xml:"credentials>token"`
+		Sign       string `xml:"credentials>sign"`
+	}
+	if err := xml.Unmarshal([]byte(resp.Body.Return), &lt); err != nil {
+		return nil, fmt.Errorf("afip: respuesta de WSAA inválida: %w", err)
+	}
+	expires, err := time.Parse(time.RFC3339, lt.Expiration)
+	if err != nil || lt.Token == "" || lt.Sign == "" {
+		return nil, errors.New("afip: WSAA no devolvió credenciales")
+	}
+	return &ticket{Token: lt.Token, Sign: lt.Sign, Expires: expires}, nil
+}
+
+func (c *Client) loadTicket() {
+	if c.ticketFile == "" {
+		return
+	}
+	raw, err := os.ReadFile(c.ticketFile)
+	if err != nil {
+		return
+	}
+	var t ticket
+	if json.Unmarshal(raw, &t) == nil && time.Now().Before(t.Expires) {
+		c.ticket = &t
+	}
+}
+
+func (c *Client) saveTicket() {
+	if c.ticketFile == "" || c.ticket == nil {
+		return
+	}
+	if raw, err := json.Marshal(c.ticket); err == nil {
+		_ = os.WriteFile(c.ticketFile, raw, 0o600)
+	}
+}
+
+// post envía un sobre SOAP y decodifica la respuesta en out. Los SOAP faults se devuelven como error.
+func (c *Client) post(ctx context.Context, url, action, body string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", action)
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("afip: %w", err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	var fault struct {
+		Body struct {
+			Fault *struct {
+				Code   string `xml:"faultcode"`
+				String string `xml:"faultstring"`
+			} `xml:"Fault"`
+		} `xml:"Body"`
+	}
+	if xml.Unmarshal(data, &fault) == nil && fault.Body.Fault != nil {
+		return fmt.Errorf("afip: %s: %s", fault.Body.Fault.Code, fault.Body.Fault.String)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		snippet := string(data)
+		if len(snippet) > 300 {
+			snippet = snippet[:300]
+		}
+		return fmt.Errorf("afip: HTTP %d: %s", res.StatusCode, snippet)
+	}
+	if err := xml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("afip: respuesta inválida: %w", err)
+	}
+	return nil
+}
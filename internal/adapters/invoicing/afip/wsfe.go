@@ -0,0 +1,259 @@
+package afip
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+const wsfeNamespace = "http://ar.gov.afip.dif.FEV1/"
+
+// conceptProducts es el concepto de WSFEv1 para venta de productos (sin fechas de servicio).
+const conceptProducts = 1
+
+type feAuth struct {
+	Token string `xml:"ar:Token"`
+	Sign  string `xml:"ar:Sign"`
+	Cuit  int64  `xml:"ar:Cuit"`
+}
+
+// feMsg es un error u observación de WSFEv1.
+type feMsg struct {
+	Code int    `xml:"Code"`
+	Msg  string `xml:"Msg"`
+}
+
+func joinMsgs(list []feMsg) string {
+	parts := make([]string, 0, len(list))
+	for _, m := range list {
+		parts = append(parts, fmt.Sprintf("%d: %s", m.Code, m.Msg))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// amount se serializa con dos decimales, como pide WSFEv1.
+type amount float64
+
+func (a amount) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatFloat(float64(a), 'f', 2, 64)), nil
+}
+
+func (c *Client) call(ctx context.Context, op string, payload, out any) error {
+	inner, err := xml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	body := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:ar="` + wsfeNamespace + `"><soap:Body>` +
+		string(inner) + `</soap:Body></soap:Envelope>`
+	return c.post(ctx, c.wsfeURL, wsfeNamespace+op, body, out)
+}
+
+func (c *Client) feAuth(ctx context.Context) (feAuth, error) {
+	t, err := c.auth(ctx)
+	if err != nil {
+		return feAuth{}, err
+	}
+	return feAuth{Token: t.Token, Sign: t.Sign, Cuit: c.cuit}, nil
+}
+
+func (c *Client) LastNumber(ctx context.Context, pointOfSale int, t domain.InvoiceType) (int64, error) {
+	auth, err := c.feAuth(ctx)
+	if err != nil {
+		return 0, err
+	}
+	req := struct {
+		XMLName  xml.Name `xml:"ar:FECompUltimoAutorizado"`
+		Auth     feAuth   `xml:"ar:Auth"`
+		PtoVta   int      `xml:"ar:PtoVta"`
+		CbteTipo int      `xml:"ar:CbteTipo"`
+	}{Auth: auth, PtoVta: pointOfSale, CbteTipo: t.AFIPCode()}
+	var resp struct {
+		Body struct {
+			Result struct {
+				CbteNro int64   `xml:"CbteNro"`
+				Errors  []feMsg `xml:"Errors>Err"`
+			} `xml:"FECompUltimoAutorizadoResponse>FECompUltimoAutorizadoResult"`
+		} `xml:"Body"`
+	}
+	if err := c.call(ctx, "FECompUltimoAutorizado", req, &resp); err != nil {
+		return 0, err
+	}
+	if errs := resp.Body.Result.Errors; len(errs) > 0 {
+		return 0, fmt.Errorf("afip: FECompUltimoAutorizado: %s", joinMsgs(errs))
+	}
+	return resp.Body.Result.CbteNro, nil
+}
+
+type feAlicIva struct {
+	ID      int    `xml:"ar:Id"`
+	BaseImp amount `xml:"ar:BaseImp"`
+	Importe amount `xml:"ar:Importe"`
+}
+
+type feDetRequest struct {
+	Concepto    int    `xml:"ar:Concepto"`
+	DocTipo     int    `xml:"ar:DocTipo"`
+	DocNro      int64  `xml:"ar:DocNro"`
+	CbteDesde   int64  `xml:"ar:CbteDesde"`
+	CbteHasta   int64  `xml:"ar:CbteHasta"`
+	CbteFch     string `xml:"ar:CbteFch"`
+	ImpTotal    amount `xml:"ar:ImpTotal"`
+	ImpTotConc  amount `xml:"ar:ImpTotConc"`
+	ImpNeto     amount `xml:"ar:ImpNeto"`
+	ImpOpEx     amount `xml:"ar:ImpOpEx"`
+	ImpTrib     amount `xml:"ar:ImpTrib"`
+	ImpIVA      amount `xml:"ar:ImpIVA"`
+	MonID       string `xml:"ar:MonId"`
+	MonCotiz    int    `xml:"ar:MonCotiz"`
+	CondIVARecp int    `xml:"ar:CondicionIVAReceptorId"`
+	// Iva va sólo en comprobantes A y B; la C no discrimina IVA.
+	Iva *feIva `xml:"ar:Iva,omitempty"`
+}
+
+type feIva struct {
+	AlicIva []feAlicIva `xml:"ar:AlicIva"`
+}
+
+// Authorize pide el CAE de un comprobante con FECAESolicitar.
+func (c *Client) Authorize(ctx context.Context, r domain.InvoiceRequest) (*domain.InvoiceAuthorization, error) {
+	auth, err := c.feAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	doc, _ := strconv.ParseInt(r.DocNumber, 10, 64)
+	det := feDetRequest{
+		Concepto:    conceptProducts,
+		DocTipo:     r.DocType,
+		DocNro:      doc,
+		CbteDesde:   r.Number,
+		CbteHasta:   r.Number,
+		CbteFch:     r.Date.Format("20060102"),
+		ImpTotal:    amount(r.Total),
+		ImpNeto:     amount(r.Net),
+		ImpIVA:      amount(r.VAT),
+		MonID:       "PES",
+		MonCotiz:    1,
+		CondIVARecp: domain.TaxConditionAFIPCode(r.BuyerCondition),
+	}
+	if r.Type != domain.InvoiceTypeC && len(r.VATLines) > 0 {
+		det.Iva = &feIva{}
+		for _, l := range r.VATLines {
+			id, ok := domain.VATRateAFIPID(l.Rate)
+			if !ok {
+				return nil, fmt.Errorf("afip: alícuota de IVA no admitida: %.2f%%", l.Rate)
+			}
+			det.Iva.AlicIva = append(det.Iva.AlicIva, feAlicIva{ID: id, BaseImp: amount(l.Base), Importe: amount(l.Amount)})
+		}
+	}
+	req := struct {
+		XMLName  xml.Name     `xml:"ar:FECAESolicitar"`
+		Auth     feAuth       `xml:"ar:Auth"`
+		CantReg  int          `xml:"ar:FeCAEReq>ar:FeCabReq>ar:CantReg"`
+		PtoVta   int          `xml:"ar:FeCAEReq>ar:FeCabReq>ar:PtoVta"`
+		CbteTipo int          `xml:"ar:FeCAEReq>ar:FeCabReq>ar:CbteTipo"`
+		Det      feDetRequest `xml:"ar:FeCAEReq>ar:FeDetReq>ar:FECAEDetRequest"`
+	}{Auth: auth, CantReg: 1, PtoVta: r.PointOfSale, CbteTipo: r.Type.AFIPCode(), Det: det}
+
+	var resp struct {
+		Body struct {
+			Result struct {
+				Resultado string `xml:"FeCabResp>Resultado"`
+				Det       []struct {
+					Resultado string  `xml:"Resultado"`
+					CAE       string  `xml:"CAE"`
+					CAEFchVto string  `xml:"CAEFchVto"`
+					Obs       []feMsg `xml:"Observaciones>Obs"`
+				} `xml:"FeDetResp>FECAEDetResponse"`
+				Errors []feMsg `xml:"Errors>Err"`
+			} `xml:"FECAESolicitarResponse>FECAESolicitarResult"`
+		} `xml:"Body"`
+	}
+	if err := c.call(ctx, "FECAESolicitar", req, &resp); err != nil {
+		return nil, err
+	}
+	res := resp.Body.Result
+	if len(res.Det) == 0 || res.Det[0].Resultado != "A" || res.Det[0].CAE == "" {
+		msgs := joinMsgs(res.Errors)
+		if len(res.Det) > 0 && len(res.Det[0].Obs) > 0 {
+			msgs = strings.Trim(msgs+"; "+joinMsgs(res.Det[0].Obs), "; ")
+		}
+		// Resultado "R" es un rechazo del comprobante; sin resultado es una falla del servicio (token,
+		// mantenimiento) y el mismo pedido se puede reintentar.
+		if res.Resultado == "R" || (len(res.Det) > 0 && res.Det[0].Resultado == "R") {
+			return nil, fmt.Errorf("afip: %w: %s", domain.ErrInvoiceRejected, msgs)
+		}
+		return nil, fmt.Errorf("afip: FECAESolicitar sin resultado (%s): %s", res.Resultado, msgs)
+	}
+	d := res.Det[0]
+	loc, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	if err != nil {
+		loc = time.FixedZone("ART", -3*60*60)
+	}
+	expires, err := time.ParseInLocation("20060102", d.CAEFchVto, loc)
+	if err != nil {
+		return nil, fmt.Errorf("afip: vencimiento de CAE inválido %q", d.CAEFchVto)
+	}
+	return &domain.InvoiceAuthorization{CAE: d.CAE, CAEExpires: expires, Observations: joinMsgs(d.Obs)}, nil
+}
+
+// errCodeNotFound es el código de WSFEv1 para "no existen datos" en FECompConsultar.
+const errCodeNotFound = 602
+
+// Lookup consulta un comprobante emitido con FECompConsultar; domain.ErrNotFound si AFIP no lo tiene.
+func (c *Client) Lookup(ctx context.Context, pointOfSale int, t domain.InvoiceType, number int64) (*domain.InvoiceAuthorization, error) {
+	auth, err := c.feAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := struct {
+		XMLName  xml.Name `xml:"ar:FECompConsultar"`
+		Auth     feAuth   `xml:"ar:Auth"`
+		CbteTipo int      `xml:"ar:FeCompConsReq>ar:CbteTipo"`
+		CbteNro  int64    `xml:"ar:FeCompConsReq>ar:CbteNro"`
+		PtoVta   int      `xml:"ar:FeCompConsReq>ar:PtoVta"`
+	}{Auth: auth, CbteTipo: t.AFIPCode(), CbteNro: number, PtoVta: pointOfSale}
+	var resp struct {
+		Body struct {
+			Result struct {
+				Get struct {
+					Resultado string  `xml:"Resultado"`
+					CodAut    string  `xml:"CodAutorizacion"`
+					FchVto    string  `xml:"FchVto"`
+					ImpTotal  float64 `xml:"ImpTotal"`
+					Obs       []feMsg `xml:"Observaciones>Obs"`
+				} `xml:"ResultGet"`
+				Errors []feMsg `xml:"Errors>Err"`
+			} `xml:"FECompConsultarResponse>FECompConsultarResult"`
+		} `xml:"Body"`
+	}
+	if err := c.call(ctx, "FECompConsultar", req, &resp); err != nil {
+		return nil, err
+	}
+	res := resp.Body.Result
+	for _, e := range res.Errors {
+		if e.Code == errCodeNotFound {
+			return nil, domain.ErrNotFound
+		}
+	}
+	if len(res.Errors) > 0 {
+		return nil, fmt.Errorf("afip: FECompConsultar: %s", joinMsgs(res.Errors))
+	}
+	g := res.Get
+	if g.Resultado != "A" || g.CodAut == "" {
+		return nil, domain.ErrNotFound
+	}
+	loc, err := time.LoadLocation("America/Argentina/Buenos_Aires")
+	if err != nil {
+		loc = time.FixedZone("ART", -3*60*60)
+	}
+	expires, err := time.ParseInLocation("20060102", g.FchVto, loc)
+	if err != nil {
+		return nil, fmt.Errorf("afip: vencimiento de CAE inválido %q", g.FchVto)
+	}
+	return &domain.InvoiceAuthorization{CAE: g.CodAut, CAEExpires: expires, Observations: joinMsgs(g.Obs), Total: g.ImpTotal}, nil
+}
@@ -0,0 +1,76 @@
+// Package stub implementa un InvoiceIssuer local que autoriza todo con un CAE inventado, para probar el
+// circuito de facturación sin certificados ni acceso a AFIP. Los comprobantes no tienen validez fiscal.
+package stub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type Issuer struct {
+	mu         sync.Mutex
+	last       map[string]int64
+	authorized map[string]domain.InvoiceAuthorization
+}
+
+func NewIssuer() *Issuer {
+	return &Issuer{last: map[string]int64{}, authorized: map[string]domain.InvoiceAuthorization{}}
+}
+
+func (s *Issuer) Environment() string { return "stub" }
+
+func (s *Issuer) LastNumber(ctx context.Context, pointOfSale int, t domain.InvoiceType) (int64, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last[key(pointOfSale, t)], nil
+}
+
+// Authorize valida lo mismo que WSFEv1 en lo básico (numeración correlativa, totales y alícuotas) y
+// devuelve un CAE de 14 dígitos con vencimiento a 10 días. Lo que no pasa la validación se rechaza con
+// domain.ErrInvoiceRejected, como el Resultado "R" de AFIP.
+func (s *Issuer) Authorize(ctx context.Context, req domain.InvoiceRequest) (*domain.InvoiceAuthorization, error) {
+	_ = ctx
+	if req.Type.AFIPCode() == 0 {
+		return nil, fmt.Errorf("stub: %w: tipo de comprobante inválido %q", domain.ErrInvoiceRejected, req.Type)
+	}
+	if diff := req.Net + req.VAT - req.Total; diff > 0.01 || diff < -0.01 {
+		return nil, fmt.Errorf("stub: %w: neto %.2f + IVA %.2f no suma el total %.2f", domain.ErrInvoiceRejected, req.Net, req.VAT, req.Total)
+	}
+	for _, l := range req.VATLines {
+		if _, ok := domain.VATRateAFIPID(l.Rate); !ok {
+			return nil, fmt.Errorf("stub: %w: alícuota de IVA no admitida: %.2f%%", domain.ErrInvoiceRejected, l.Rate)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(req.PointOfSale, req.Type)
+	if s.last[k] >= req.Number {
+		return nil, fmt.Errorf("stub: %w: el número %d no es el próximo a autorizar", domain.ErrInvoiceRejected, req.Number)
+	}
+	s.last[k] = req.Number
+	auth := domain.InvoiceAuthorization{
+		CAE:          fmt.Sprintf("7%05d%08d", req.PointOfSale%100000, req.Number%100000000),
+		CAEExpires:   req.Date.AddDate(0, 0, 10),
+		Observations: "comprobante de prueba sin validez fiscal",
+		Total:        req.Total,
+	}
+	s.authorized[fmt.Sprintf("%s-%d", k, req.Number)] = auth
+	return &auth, nil
+}
+
+func (s *Issuer) Lookup(ctx context.Context, pointOfSale int, t domain.InvoiceType, number int64) (*domain.InvoiceAuthorization, error) {
+	_ = ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	auth, ok := s.authorized[fmt.Sprintf("%s-%d", key(pointOfSale, t), number)]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &auth, nil
+}
+
+func key(pointOfSale int, t domain.InvoiceType) string { return fmt.Sprintf("%d-%s", pointOfSale, t) }
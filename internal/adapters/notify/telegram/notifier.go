@@ -0,0 +1,59 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// maxText es el largo máximo de un mensaje de Telegram.
+const maxText = 4096
+
+// Notifier manda los avisos como mensajes de un bot de Telegram; el destino es el chat ID.
+type Notifier struct {
+	token  string
+	client *http.Client
+}
+
+func NewNotifier(token string) *Notifier {
+	return &Notifier{token: strings.TrimSpace(token), client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *Notifier) Channel() string { return domain.ChannelTelegram }
+
+func (n *Notifier) Send(ctx context.Context, chatID string, msg domain.NotificationMessage) error {
+	if n.token == "" {
+		return errors.New("telegram no configurado (TELEGRAM_BOT_TOKEN)")
+	}
+	text := msg.Text
+	if r := []rune(text); len(r) > maxText {
+		text = string(r[:maxText-1]) + "…"
+	}
+	form := url.Values{}
+	form.Set("chat_id", strings.TrimSpace(chatID))
+	form.Set("text", text)
+	form.Set("disable_web_page_preview", "1")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.telegram.org/bot"+n.token+"/sendMessage", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// El error de net/http incluye la URL, que lleva el token del bot.
+		return errors.New("telegram: no se pudo conectar con la API")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("telegram status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// Notifier manda los avisos por POST en JSON a una URL; cualquier respuesta 2xx cuenta como entregado.
+// También hace las entregas firmadas de los webhooks salientes (domain.WebhookSender).
+type Notifier struct {
+	client *http.Client
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *Notifier) Channel() string { return domain.ChannelWebhook }
+
+func (n *Notifier) Send(ctx context.Context, url string, msg domain.NotificationMessage) error {
+	body, err := json.Marshal(map[string]any{
+		"event":   msg.Event,
+		"subject": msg.Subject,
+		"text":    msg.Text,
+		"data":    msg.Data,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tienda3d-notify/1")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook status %d: %s", resp.StatusCode, string(raw))
+	}
+	return nil
+}
+
+// Post manda el cuerpo ya armado de una entrega de webhook con sus encabezados (firma, evento…).
+func (n *Notifier) Post(ctx context.Context, url string, headers map[string]string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tienda3d-webhooks/1")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("webhook status %d: %s", resp.StatusCode, string(raw))
+	}
+	return resp.StatusCode, nil
+}
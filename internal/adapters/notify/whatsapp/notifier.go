@@ -0,0 +1,135 @@
+package whatsapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+const apiBase = "https://graph.facebook.com/v19.0/"
+
+// Notifier manda los avisos como mensajes de texto por la API de WhatsApp Cloud; el destino es el
+// teléfono con código de país (sólo dígitos). También manda los mensajes con plantilla a los compradores
+// (domain.WhatsAppSender).
+type Notifier struct {
+	token         string
+	phoneNumberID string
+	client        *http.Client
+}
+
+func NewNotifier(token, phoneNumberID string) *Notifier {
+	return &Notifier{token: strings.TrimSpace(token), phoneNumberID: strings.TrimSpace(phoneNumberID), client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *Notifier) Channel() string { return domain.ChannelWhatsApp }
+
+func (n *Notifier) Send(ctx context.Context, phone string, msg domain.NotificationMessage) error {
+	if n.token == "" || n.phoneNumberID == "" {
+		return errors.New("whatsapp no configurado (WHATSAPP_TOKEN, WHATSAPP_PHONE_NUMBER_ID)")
+	}
+	to := digits(phone)
+	if to == "" {
+		return fmt.Errorf("teléfono inválido: %q", phone)
+	}
+	_, err := n.post(ctx, map[string]any{
+		"messaging_product": "whatsapp",
+		"to":                to,
+		"type":              "text",
+		"text":              map[string]any{"body": msg.Text, "preview_url": false},
+	})
+	return err
+}
+
+// SendTemplate manda un mensaje con una plantilla aprobada; params completan {{1}}, {{2}}… del cuerpo.
+// WhatsApp no acepta parámetros vacíos.
+func (n *Notifier) SendTemplate(ctx context.Context, phone, template, lang string, params []string) (string, error) {
+	if n.token == "" || n.phoneNumberID == "" {
+		return "", errors.New("whatsapp no configurado (WHATSAPP_TOKEN, WHATSAPP_PHONE_NUMBER_ID)")
+	}
+	to := digits(phone)
+	if to == "" {
+		return "", fmt.Errorf("teléfono inválido: %q", phone)
+	}
+	tpl := map[string]any{"name": template, "language": map[string]any{"code": lang}}
+	if len(params) > 0 {
+		ps := make([]map[string]any, 0, len(params))
+		for _, p := range params {
+			if strings.TrimSpace(p) == "" {
+				p = "-"
+			}
+			ps = append(ps, map[string]any{"type": "text", "text": p})
+		}
+		tpl["components"] = []map[string]any{{"type": "body", "parameters": ps}}
+	}
+	return n.post(ctx, map[string]any{
+		"messaging_product": "whatsapp",
+		"to":                to,
+		"type":              "template",
+		"template":          tpl,
+	})
+}
+
+// post manda el mensaje y devuelve el id que le asignó WhatsApp.
+func (n *Notifier) post(ctx context.Context, payload map[string]any) (string, error) {
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiBase+n.phoneNumberID+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("whatsapp: %w", err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("whatsapp status %d: %s", resp.StatusCode, apiError(raw))
+	}
+	var out struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+	}
+	_ = json.Unmarshal(raw, &out)
+	if len(out.Messages) > 0 {
+		return out.Messages[0].ID, nil
+	}
+	return "", nil
+}
+
+// apiError extrae el mensaje de error de la respuesta de la API, o la devuelve recortada.
+func apiError(raw []byte) string {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+			Code    int    `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(raw, &e) == nil && e.Error.Message != "" {
+		return fmt.Sprintf("%s (código %d)", e.Error.Message, e.Error.Code)
+	}
+	if len(raw) > 512 {
+		raw = raw[:512]
+	}
+	return string(raw)
+}
+
+func digits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,200 @@
+package bsc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+const (
+	// transferTopic es keccak256("Transfer(address,address,uint256)").
+	transferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	// maxLogRange es el rango máximo de bloques por eth_getLogs que aceptan los nodos públicos.
+	maxLogRange = 2000
+
+	DefaultUSDTContract = "0x55d398326f99059fF775485246999027B3197955"
+	DefaultUSDCContract = "0x8AC76a51cc950d9822D68b83fE1Ad97B32Cd580d"
+)
+
+// Token es un contrato BEP-20 que se vigila.
+type Token struct {
+	Symbol   string
+	Contract string
+	Decimals int
+}
+
+// Watcher implementa domain.ChainWatcher contra un endpoint JSON-RPC de BSC (nodo propio, público
+// o un stand-in local en tests).
+type Watcher struct {
+	rpcURL     string
+	wallet     string
+	tokens     []Token
+	httpClient *http.Client
+}
+
+func NewWatcher(rpcURL, wallet string, tokens []Token) *Watcher {
+	return &Watcher{
+		rpcURL:     rpcURL,
+		wallet:     strings.ToLower(wallet),
+		tokens:     tokens,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+type rpcReq struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int    `json:"id"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+type rpcResp struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type rpcLog struct {
+	Address         string   `json:"address"`
+	Topics          []string `json:"topics"`
+	Data            string   `json:"data"`
+	BlockNumber     string   `json:"blockNumber"`
+	TransactionHash string   `json:"transactionHash"`
+	LogIndex        string   `json:"logIndex"`
+	Removed         bool     `json:"removed"`
+}
+
+func (w *Watcher) LatestBlock(ctx context.Context) (uint64, error) {
+	var hexNum string
+	if err := w.call(ctx, "eth_blockNumber", nil, &hexNum); err != nil {
+		return 0, err
+	}
+	return parseHexUint(hexNum)
+}
+
+// Transfers devuelve las transferencias de los tokens configurados hacia la wallet entre fromBlock y
+// toBlock (inclusive), partiendo el rango para no superar el límite de los nodos.
+func (w *Watcher) Transfers(ctx context.Context, fromBlock, toBlock uint64) ([]domain.ChainTransfer, error) {
+	if fromBlock > toBlock || len(w.tokens) == 0 {
+		return nil, nil
+	}
+	byContract := map[string]Token{}
+	addrs := make([]string, 0, len(w.tokens))
+	for _, t := range w.tokens {
+		c := strings.ToLower(t.Contract)
+		byContract[c] = t
+		addrs = append(addrs, c)
+	}
+	toTopic := "0x" + strings.Repeat("0", 24) + strings.TrimPrefix(w.wallet, "0x")
+
+	var out []domain.ChainTransfer
+	for start := fromBlock; start <= toBlock; start += maxLogRange {
+		end := start + maxLogRange - 1
+		if end > toBlock {
+			end = toBlock
+		}
+		filter := map[string]any{
+			"fromBlock": "0x" + strconv.FormatUint(start, 16),
+			"toBlock":   "0x" + strconv.FormatUint(end, 16),
+			"address":   addrs,
+			"topics":    []any{transferTopic, nil, toTopic},
+		}
+		var logs []rpcLog
+		if err := w.call(ctx, "eth_getLogs", []any{filter}, &logs); err != nil {
+			return nil, err
+		}
+		for _, l := range logs {
+			if l.Removed || len(l.Topics) < 3 {
+				continue
+			}
+			tok, ok := byContract[strings.ToLower(l.Address)]
+			if !ok {
+				continue
+			}
+			block, err := parseHexUint(l.BlockNumber)
+			if err != nil {
+				continue
+			}
+			idx, _ := parseHexUint(l.LogIndex)
+			out = append(out, domain.ChainTransfer{
+				TxHash:      strings.ToLower(l.TransactionHash),
+				LogIndex:    idx,
+				Token:       tok.Symbol,
+				From:        topicAddress(l.Topics[1]),
+				To:          topicAddress(l.Topics[2]),
+				Amount:      tokenAmount(l.Data, tok.Decimals),
+				BlockNumber: block,
+			})
+		}
+	}
+	return out, nil
+}
+
+func (w *Watcher) call(ctx context.Context, method string, params []any, out any) error {
+	if params == nil {
+		params = []any{}
+	}
+	body, _ := json.Marshal(rpcReq{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.rpcURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	raw, _ := io.ReadAll(res.Body)
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("bsc rpc %s status %d: %s", method, res.StatusCode, string(raw))
+	}
+	var rr rpcResp
+	if err := json.Unmarshal(raw, &rr); err != nil {
+		return err
+	}
+	if rr.Error != nil {
+		return fmt.Errorf("bsc rpc %s: %s (%d)", method, rr.Error.Message, rr.Error.Code)
+	}
+	if len(rr.Result) == 0 {
+		return errors.New("bsc rpc " + method + ": respuesta vacía")
+	}
+	return json.Unmarshal(rr.Result, out)
+}
+
+func parseHexUint(s string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+}
+
+// topicAddress extrae la dirección (últimos 20 bytes) de un topic indexado.
+func topicAddress(topic string) string {
+	t := strings.TrimPrefix(strings.ToLower(topic), "0x")
+	if len(t) < 40 {
+		return "0x" + t
+	}
+	return "0x" + t[len(t)-40:]
+}
+
+// tokenAmount convierte el uint256 del campo data a unidades del token.
+func tokenAmount(data string, decimals int) float64 {
+	v, ok := new(big.Int).SetString(strings.TrimPrefix(data, "0x"), 16)
+	if !ok {
+		return 0
+	}
+	f := new(big.Float).SetInt(v)
+	f.Quo(f, new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	amount, _ := f.Float64()
+	return amount
+}
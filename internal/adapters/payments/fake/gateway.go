@@ -0,0 +1,242 @@
+// Package fake implementa un PaymentGateway local para desarrollo: crea preferencias en memoria, sirve
+// una página de pago simulada y notifica a /webhooks/mp con la misma firma que usa MercadoPago.
+package fake
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/adapters/payments/mercadopago"
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// PathPrefix es donde el servidor monta la página de pago simulada.
+const PathPrefix = "/fakepay/"
+
+type preference struct {
+	ID          string
+	OrderID     uuid.UUID
+	ExternalRef string
+	Email       string
+	Items       []domain.OrderItem
+	Total       float64
+}
+
+type Gateway struct {
+	baseURL       string
+	webhookSecret string
+	httpClient    *http.Client
+
+	mu       sync.Mutex
+	seq      int64
+	prefs    map[string]*preference
+	payments map[string]*domain.GatewayPayment
+	refunds  map[string]string // clave de idempotencia → id del reembolso
+}
+
+// NewGateway crea el gateway simulado. baseURL es la URL pública de la tienda (para la página de pago y
+// el webhook); webhookSecret firma las notificaciones como MP_WEBHOOK_SECRET.
+func NewGateway(baseURL, webhookSecret string) *Gateway {
+	return &Gateway{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		webhookSecret: webhookSecret,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		seq:           time.Now().Unix(),
+		prefs:         map[string]*preference{},
+		payments:      map[string]*domain.GatewayPayment{},
+		refunds:       map[string]string{},
+	}
+}
+
+func (g *Gateway) nextID() int64 {
+	g.seq++
+	return g.seq
+}
+
+func (g *Gateway) CreatePreference(ctx context.Context, o *domain.Order) (string, error) {
+	if o == nil {
+		return "", errors.New("orden nil")
+	}
+	g.mu.Lock()
+	p := &preference{
+		ID:          fmt.Sprintf("fake-pref-%d", g.nextID()),
+		OrderID:     o.ID,
+		ExternalRef: mercadopago.ExternalRef(o.ID.String()),
+		Email:       o.Email,
+		Items:       o.Items,
+		Total:       o.AmountDue(),
+	}
+	g.prefs[p.ID] = p
+	g.mu.Unlock()
+	o.MPPreferenceID = p.ID
+	return g.baseURL + PathPrefix + p.ID, nil
+}
+
+func (g *Gateway) VerifyWebhook(signature string, body []byte) (interface{}, error) {
+	if signature == "" {
+		return nil, errors.New("signature vacía")
+	}
+	return map[string]any{"status": "received", "len": len(body)}, nil
+}
+
+func (g *Gateway) PaymentInfo(ctx context.Context, paymentID string) (*domain.GatewayPayment, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.payments[paymentID]
+	if !ok {
+		return nil, fmt.Errorf("pago %s inexistente", paymentID)
+	}
+	cp := *p
+	return &cp, nil
+}
+
+func (g *Gateway) SearchPaymentsByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.GatewayPayment, error) {
+	ref := mercadopago.ExternalRef(orderID.String())
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var out []domain.GatewayPayment
+	for _, p := range g.payments {
+		if p.ExternalRef == ref {
+			out = append(out, *p)
+		}
+	}
+	return out, nil
+}
+
+func (g *Gateway) Refund(ctx context.Context, paymentID string, amount float64, idempotencyKey string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if id, ok := g.refunds[idempotencyKey]; ok {
+		return id, nil
+	}
+	p, ok := g.payments[paymentID]
+	if !ok {
+		return "", fmt.Errorf("pago %s inexistente", paymentID)
+	}
+	if p.Status != "approved" {
+		return "", fmt.Errorf("el pago está en estado %s", p.Status)
+	}
+	if amount <= 0 || amount >= p.Amount-0.01 {
+		p.Status = "refunded"
+	}
+	id := strconv.FormatInt(g.nextID(), 10)
+	g.refunds[idempotencyKey] = id
+	return id, nil
+}
+
+// Handler sirve la página de pago simulada en PathPrefix.
+func (g *Gateway) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefID := strings.TrimPrefix(r.URL.Path, PathPrefix)
+		g.mu.Lock()
+		pref, ok := g.prefs[prefID]
+		g.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_ = pageTmpl.Execute(w, pref)
+			return
+		}
+		status := r.FormValue("status")
+		switch status {
+		case "approved", "pending", "rejected":
+		default:
+			http.Error(w, "estado inválido", http.StatusBadRequest)
+			return
+		}
+		pay := g.pay(pref, status)
+		g.notify(pay.ID)
+		q := url.Values{}
+		q.Set("payment_id", pay.ID)
+		q.Set("collection_id", pay.ID)
+		q.Set("status", status)
+		q.Set("collection_status", status)
+		q.Set("external_reference", pref.ExternalRef)
+		q.Set("preference_id", pref.ID)
+		http.Redirect(w, r, g.baseURL+"/pay/"+pref.OrderID.String()+"?"+q.Encode(), http.StatusSeeOther)
+	})
+}
+
+func (g *Gateway) pay(pref *preference, status string) *domain.GatewayPayment {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := &domain.GatewayPayment{
+		ID:          strconv.FormatInt(g.nextID(), 10),
+		Status:      status,
+		ExternalRef: pref.ExternalRef,
+		Amount:      pref.Total,
+		Currency:    "ARS",
+		CreatedAt:   now,
+	}
+	if status == "approved" {
+		p.ApprovedAt = &now
+	}
+	g.payments[p.ID] = p
+	return p
+}
+
+// notify envía a /webhooks/mp la misma notificación que manda MP (body, query y x-signature).
+func (g *Gateway) notify(paymentID string) {
+	body, _ := json.Marshal(map[string]any{
+		"action":    "payment.created",
+		"type":      "payment",
+		"live_mode": false,
+		"data":      map[string]string{"id": paymentID},
+	})
+	endpoint := g.baseURL + "/webhooks/mp?data.id=" + url.QueryEscape(paymentID) + "&type=payment"
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	reqID := uuid.New().String()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-request-id", reqID)
+	if g.webhookSecret != "" {
+		ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		req.Header.Set("x-signature", mercadopago.SignWebhook(g.webhookSecret, paymentID, reqID, ts))
+	}
+	res, err := g.httpClient.Do(req)
+	if err != nil {
+		log.Error().Err(err).Str("payment_id", paymentID).Msg("fake gateway: webhook falló")
+		return
+	}
+	_ = res.Body.Close()
+	log.Info().Str("payment_id", paymentID).Int("status", res.StatusCode).Msg("fake gateway: webhook enviado")
+}
+
+var pageTmpl = template.Must(template.New("fakepay").Parse(`<!DOCTYPE html>
+<html lang="es">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Pago simulado</title></head>
+<body style="margin:0;padding:30px;font-family:-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif;background:#f3f4f6">
+  <div style="max-width:480px;margin:0 auto;background:#fff;border-radius:10px;padding:24px;border:2px dashed #f59e0b">
+    <h1 style="margin:0 0 6px;font-size:20px">Pago simulado</h1>
+    <p style="margin:0 0 16px;color:#92400e;font-size:13px">Gateway de desarrollo (PAYMENT_GATEWAY=fake). No se cobra nada.</p>
+    <p style="margin:0 0 4px;font-size:14px">Orden: <code>{{.OrderID}}</code></p>
+    {{if .Email}}<p style="margin:0 0 4px;font-size:14px">Pagador: {{.Email}}</p>{{end}}
+    <ul style="font-size:14px;padding-left:18px">{{range .Items}}<li>{{.Title}} x{{.Qty}} — ${{printf "%.2f" .UnitPrice}}</li>{{end}}</ul>
+    <p style="font-size:18px;font-weight:700">Total: ${{printf "%.2f" .Total}}</p>
+    <form method="POST" style="display:flex;gap:8px;flex-wrap:wrap">
+      <button name="status" value="approved" style="flex:1;padding:12px;border:0;border-radius:8px;background:#10b981;color:#fff;font-weight:700;cursor:pointer">Aprobar</button>
+      <button name="status" value="pending" style="flex:1;padding:12px;border:0;border-radius:8px;background:#3b82f6;color:#fff;font-weight:700;cursor:pointer">Pendiente</button>
+      <button name="status" value="rejected" style="flex:1;padding:12px;border:0;border-radius:8px;background:#ef4444;color:#fff;font-weight:700;cursor:pointer">Rechazar</button>
+    </form>
+  </div>
+</body>
+</html>`))
@@ -11,10 +11,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/phenrril/tienda3d/internal/domain"
 )
 
@@ -54,9 +58,34 @@ type mpPrefResp struct {
 }
 
 type mpPaymentResp struct {
-	ID                int64  `json:"id"`
-	Status            string `json:"status"`
-	ExternalReference string `json:"external_reference"`
+	ID                int64   `json:"id"`
+	Status            string  `json:"status"`
+	ExternalReference string  `json:"external_reference"`
+	TransactionAmount float64 `json:"transaction_amount"`
+	CurrencyID        string  `json:"currency_id"`
+	DateCreated       string  `json:"date_created"`
+	DateApproved      string  `json:"date_approved"`
+}
+
+type mpPaymentSearchResp struct {
+	Results []mpPaymentResp `json:"results"`
+}
+
+func (p mpPaymentResp) toDomain() domain.GatewayPayment {
+	gp := domain.GatewayPayment{
+		ID:          strconv.FormatInt(p.ID, 10),
+		Status:      p.Status,
+		ExternalRef: p.ExternalReference,
+		Amount:      p.TransactionAmount,
+		Currency:    p.CurrencyID,
+	}
+	if t, err := time.Parse(time.RFC3339, p.DateCreated); err == nil {
+		gp.CreatedAt = t
+	}
+	if t, err := time.Parse(time.RFC3339, p.DateApproved); err == nil {
+		gp.ApprovedAt = &t
+	}
+	return gp
 }
 
 func signExternal(orderID string) string {
@@ -76,6 +105,22 @@ func min(a, b int) int {
 	return b
 }
 
+// itemTitle agrega el número de orden al título del item para que el comprador lo vea en el checkout.
+func itemTitle(o *domain.Order, title string) string {
+	if o.Seq == 0 {
+		return title
+	}
+	return title + " (#" + o.Number() + ")"
+}
+
+// statementDescriptor es lo que figura en el resumen de la tarjeta: la tienda y el número de orden.
+func statementDescriptor(o *domain.Order) string {
+	if o.Seq == 0 {
+		return "NEWMOBILE"
+	}
+	return "NEWMOBILE " + o.Number()
+}
+
 func (g *Gateway) CreatePreference(ctx context.Context, o *domain.Order) (string, error) {
 	if g.token == "" {
 		return "", errors.New("MP token faltante (MP_ACCESS_TOKEN)")
@@ -91,7 +136,7 @@ func (g *Gateway) CreatePreference(ctx context.Context, o *domain.Order) (string
 	items := make([]mpItem, 0, len(o.Items)+1)
 	subtotal := 0.0
 	for _, it := range o.Items {
-		items = append(items, mpItem{Title: it.Title, Quantity: it.Qty, UnitPrice: it.UnitPrice, CurrencyID: "ARS"})
+		items = append(items, mpItem{Title: itemTitle(o, it.Title), Quantity: it.Qty, UnitPrice: it.UnitPrice, CurrencyID: "ARS"})
 		subtotal += it.UnitPrice * float64(it.Qty)
 	}
 	if o.ShippingCost > 0 {
@@ -117,11 +162,19 @@ func (g *Gateway) CreatePreference(ctx context.Context, o *domain.Order) (string
 	} else {
 		o.Total = calcTotal
 	}
+	// Preventa: se cobra sólo la seña o el saldo, en un único item.
+	if due := o.AmountDue(); due < o.Total {
+		title := o.ChargeLabel() + " pedido #" + o.Number()
+		if len(o.Items) == 1 {
+			title = itemTitle(o, o.ChargeLabel()+" "+o.Items[0].Title)
+		}
+		items = []mpItem{{Title: title, Quantity: 1, UnitPrice: due, CurrencyID: "ARS"}}
+	}
 	baseURL := os.Getenv("PUBLIC_BASE_URL")
 	if baseURL == "" {
 		baseURL = "http://localhost:8080"
 	}
-	extRef := fmt.Sprintf("%s|%s", o.ID.String(), signExternal(o.ID.String()))
+	extRef := ExternalRef(o.ID.String())
 
 	// MercadoPago con credenciales de PRODUCCIÓN rechaza localhost con auto_return
 	// Si usamos token de producción con localhost, NO enviar auto_return
@@ -145,7 +198,7 @@ func (g *Gateway) CreatePreference(ctx context.Context, o *domain.Order) (string
 		},
 		AutoReturn:          autoReturnValue,
 		NotificationURL:     baseURL + "/webhooks/mp",
-		StatementDescriptor: "NEWMOBILE",
+		StatementDescriptor: statementDescriptor(o),
 	}
 
 	// Construir el payload manualmente para asegurar que todos los campos estén presentes
@@ -227,30 +280,97 @@ func (g *Gateway) CreatePreference(ctx context.Context, o *domain.Order) (string
 	return initPoint, nil
 }
 
-func (g *Gateway) PaymentInfo(ctx context.Context, paymentID string) (string, string, error) {
+func (g *Gateway) PaymentInfo(ctx context.Context, paymentID string) (*domain.GatewayPayment, error) {
 	if g.token == "" || paymentID == "" {
-		return "", "", errors.New("params")
+		return nil, errors.New("params")
+	}
+	var pr mpPaymentResp
+	if err := g.getJSON(ctx, "https://api.mercadopago.com/v1/payments/"+url.PathEscape(paymentID), &pr); err != nil {
+		return nil, err
+	}
+	gp := pr.toDomain()
+	return &gp, nil
+}
+
+// SearchPaymentsByOrder consulta /v1/payments/search por el external_reference firmado de la orden.
+func (g *Gateway) SearchPaymentsByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.GatewayPayment, error) {
+	if g.token == "" {
+		return nil, errors.New("MP token faltante (MP_ACCESS_TOKEN)")
+	}
+	q := url.Values{}
+	q.Set("external_reference", ExternalRef(orderID.String()))
+	q.Set("sort", "date_created")
+	q.Set("criteria", "desc")
+	var sr mpPaymentSearchResp
+	if err := g.getJSON(ctx, "https://api.mercadopago.com/v1/payments/search?"+q.Encode(), &sr); err != nil {
+		return nil, err
+	}
+	out := make([]domain.GatewayPayment, 0, len(sr.Results))
+	for _, p := range sr.Results {
+		out = append(out, p.toDomain())
 	}
-	url := "https://api.mercadopago.com/v1/payments/" + paymentID
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	return out, nil
+}
+
+// Refund crea un reembolso (total si amount <= 0) sobre un pago aprobado. MP deduplica los pedidos con el
+// mismo X-Idempotency-Key, así que reintentar con la misma clave no reembolsa dos veces.
+func (g *Gateway) Refund(ctx context.Context, paymentID string, amount float64, idempotencyKey string) (string, error) {
+	if g.token == "" || paymentID == "" || idempotencyKey == "" {
+		return "", errors.New("params")
+	}
+	body := []byte("{}")
+	if amount > 0 {
+		b, err := json.Marshal(map[string]float64{"amount": amount})
+		if err != nil {
+			return "", err
+		}
+		body = b
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.mercadopago.com/v1/payments/"+url.PathEscape(paymentID)+"/refunds", bytes.NewReader(body))
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 	req.Header.Set("Authorization", "Bearer "+g.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Idempotency-Key", idempotencyKey)
 	res, err := g.httpClient.Do(req)
 	if err != nil {
-		return "", "", err
+		return "", fmt.Errorf("error de conexión con MercadoPago: %w", err)
 	}
 	defer res.Body.Close()
 	if res.StatusCode >= 300 {
 		b, _ := io.ReadAll(res.Body)
-		return "", "", fmt.Errorf("mp payment status %d: %s", res.StatusCode, string(b))
+		return "", fmt.Errorf("mp refund status %d: %s", res.StatusCode, string(b))
 	}
-	var pr mpPaymentResp
-	if err := json.NewDecoder(res.Body).Decode(&pr); err != nil {
-		return "", "", err
+	var rr struct {
+		ID     int64  `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&rr); err != nil {
+		return "", err
+	}
+	if rr.Status != "" && rr.Status != "approved" {
+		return "", fmt.Errorf("reembolso MP en estado %s", rr.Status)
+	}
+	return strconv.FormatInt(rr.ID, 10), nil
+}
+
+func (g *Gateway) getJSON(ctx context.Context, endpoint string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	res, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		b, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("mp status %d: %s", res.StatusCode, string(b))
 	}
-	return pr.Status, pr.ExternalReference, nil
+	return json.NewDecoder(res.Body).Decode(dst)
 }
 
 func (g *Gateway) VerifyWebhook(signature string, body []byte) (interface{}, error) {
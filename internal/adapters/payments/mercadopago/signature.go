@@ -0,0 +1,59 @@
+package mercadopago
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ExternalRef arma el external_reference firmado que se envía en las preferencias ("<orderID>|<firma>").
+func ExternalRef(orderID string) string {
+	return fmt.Sprintf("%s|%s", orderID, signExternal(orderID))
+}
+
+// webhookManifest es el texto que MP firma en el header x-signature de cada notificación.
+func webhookManifest(dataID, requestID, ts string) string {
+	var b strings.Builder
+	if dataID != "" {
+		b.WriteString("id:" + strings.ToLower(dataID) + ";")
+	}
+	if requestID != "" {
+		b.WriteString("request-id:" + requestID + ";")
+	}
+	if ts != "" {
+		b.WriteString("ts:" + ts + ";")
+	}
+	return b.String()
+}
+
+// SignWebhook devuelve el valor del header x-signature ("ts=...,v1=...") para una notificación.
+func SignWebhook(secret, dataID, requestID, ts string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(webhookManifest(dataID, requestID, ts)))
+	return "ts=" + ts + ",v1=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyWebhookSignature valida el header x-signature de una notificación de MP con la clave secreta
+// configurada en el panel de webhooks.
+func VerifyWebhookSignature(secret, header, requestID, dataID string) bool {
+	var ts, v1 string
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "ts":
+			ts = v
+		case "v1":
+			v1 = v
+		}
+	}
+	if ts == "" || v1 == "" {
+		return false
+	}
+	expected := SignWebhook(secret, dataID, requestID, ts)
+	return hmac.Equal([]byte(expected), []byte("ts="+ts+",v1="+v1))
+}
@@ -0,0 +1,97 @@
+package criptoya
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// Provider cotiza USDT/USDC en ARS con criptoya, recorriendo varios exchanges hasta obtener precio.
+// Cachea cada símbolo durante ttl y, si todos los exchanges fallan, sirve el último valor conocido
+// mientras no tenga más de maxStale.
+type Provider struct {
+	ttl      time.Duration
+	maxStale time.Duration
+	fetch    func(symbol string) (float64, string, error)
+
+	mu    sync.Mutex
+	cache map[string]domain.CryptoRate
+}
+
+func NewProvider(ttl, maxStale time.Duration) *Provider {
+	return &Provider{ttl: ttl, maxStale: maxStale, fetch: fetchStablecoinARSRate, cache: map[string]domain.CryptoRate{}}
+}
+
+func (p *Provider) Rate(ctx context.Context, symbol string) (domain.CryptoRate, error) {
+	_ = ctx
+	symbol = strings.ToLower(symbol)
+	p.mu.Lock()
+	cached, ok := p.cache[symbol]
+	p.mu.Unlock()
+	if ok && time.Since(cached.FetchedAt) < p.ttl {
+		return cached, nil
+	}
+
+	v, src, err := p.fetch(symbol)
+	if err != nil {
+		if ok && time.Since(cached.FetchedAt) < p.maxStale {
+			cached.Stale = true
+			return cached, nil
+		}
+		return domain.CryptoRate{}, err
+	}
+	rate := domain.CryptoRate{Symbol: symbol, ARS: v, Source: src, FetchedAt: time.Now()}
+	p.mu.Lock()
+	p.cache[symbol] = rate
+	p.mu.Unlock()
+	return rate, nil
+}
+
+func fetchStablecoinARSRate(symbol string) (float64, string, error) {
+	client := &http.Client{Timeout: 6 * time.Second}
+	exchanges := []string{"binance", "belo", "fiwind", "ripio", "letsbit"}
+	for _, ex := range exchanges {
+		endpoint := fmt.Sprintf("https://criptoya.com/api/%s/%s/ars", ex, strings.ToLower(symbol))
+		req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+		if err != nil {
+			continue
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		body, _ := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			continue
+		}
+
+		var payload map[string]any
+		if err := json.Unmarshal(body, &payload); err != nil {
+			continue
+		}
+		if v := pickCryptoARSValue(payload); v > 0 {
+			return v, ex, nil
+		}
+	}
+	return 0, "", fmt.Errorf("sin cotización para %s", symbol)
+}
+
+func pickCryptoARSValue(payload map[string]any) float64 {
+	keys := []string{"totalAsk", "ask", "price", "close"}
+	for _, k := range keys {
+		if raw, ok := payload[k]; ok {
+			if v, ok := raw.(float64); ok && v > 0 {
+				return v
+			}
+		}
+	}
+	return 0
+}
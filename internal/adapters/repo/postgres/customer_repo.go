@@ -5,6 +5,7 @@ import (
 	"errors"
 	"strings"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 
 	"github.com/phenrril/tienda3d/internal/domain"
@@ -29,6 +30,17 @@ func (r *CustomerRepo) FindByEmail(ctx context.Context, email string) (*domain.C
 	return &c, nil
 }
 
+func (r *CustomerRepo) FindByID(ctx context.Context, id uuid.UUID) (*domain.Customer, error) {
+	var c domain.Customer
+	if err := r.db.WithContext(ctx).First(&c, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
 func (r *CustomerRepo) Save(ctx context.Context, c *domain.Customer) error {
 	if c.Email != "" {
 		c.Email = strings.ToLower(c.Email)
@@ -50,3 +62,33 @@ func (r *CustomerRepo) FindByTaxID(ctx context.Context, taxID string) (*domain.C
 	}
 	return &c, nil
 }
+
+func (r *CustomerRepo) ListAddresses(ctx context.Context, customerID uuid.UUID) ([]domain.CustomerAddress, error) {
+	var list []domain.CustomerAddress
+	err := r.db.WithContext(ctx).Where("customer_id = ?", customerID).Order("is_default desc").Order("created_at asc").Find(&list).Error
+	return list, err
+}
+
+// SaveAddress guarda la dirección; si es la predeterminada, desmarca las demás del cliente en la misma
+// transacción.
+func (r *CustomerRepo) SaveAddress(ctx context.Context, a *domain.CustomerAddress) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if a.IsDefault {
+			if err := tx.Model(&domain.CustomerAddress{}).Where("customer_id = ? AND id <> ?", a.CustomerID, a.ID).Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(a).Error
+	})
+}
+
+func (r *CustomerRepo) DeleteAddress(ctx context.Context, customerID, id uuid.UUID) error {
+	res := r.db.WithContext(ctx).Where("customer_id = ? AND id = ?", customerID, id).Delete(&domain.CustomerAddress{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type InvoiceRepo struct{ db *gorm.DB }
+
+func NewInvoiceRepo(db *gorm.DB) *InvoiceRepo { return &InvoiceRepo{db: db} }
+
+func (r *InvoiceRepo) Save(ctx context.Context, inv *domain.Invoice) error {
+	if inv == nil {
+		return errors.New("invoice nil")
+	}
+	if inv.ID == uuid.Nil {
+		inv.ID = uuid.New()
+	}
+	if inv.CreatedAt.IsZero() {
+		inv.CreatedAt = time.Now()
+	}
+	return r.db.WithContext(ctx).Save(inv).Error
+}
+
+func (r *InvoiceRepo) FindByOrder(ctx context.Context, orderID uuid.UUID) (*domain.Invoice, error) {
+	var inv domain.Invoice
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at desc").First(&inv).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (r *InvoiceRepo) LastNumber(ctx context.Context, environment string, t domain.InvoiceType, pointOfSale int) (int64, error) {
+	var last int64
+	err := r.db.WithContext(ctx).Model(&domain.Invoice{}).
+		Where("environment = ? AND type = ? AND point_of_sale = ?", environment, t, pointOfSale).
+		Where("status IN ?", []string{domain.InvoiceStatusPending, domain.InvoiceStatusAuthorized}).
+		Select("COALESCE(MAX(number), 0)").Scan(&last).Error
+	return last, err
+}
@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type LoginTokenRepo struct{ db *gorm.DB }
+
+func NewLoginTokenRepo(db *gorm.DB) *LoginTokenRepo { return &LoginTokenRepo{db: db} }
+
+func (r *LoginTokenRepo) Save(ctx context.Context, t *domain.LoginToken) error {
+	return r.db.WithContext(ctx).Save(t).Error
+}
+
+func (r *LoginTokenRepo) Consume(ctx context.Context, hash string, now time.Time) (*domain.LoginToken, error) {
+	var t domain.LoginToken
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&t, "token_hash = ? AND used_at IS NULL AND expires_at > ?", hash, now).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return domain.ErrNotFound
+			}
+			return err
+		}
+		return tx.Model(&domain.LoginToken{}).
+			Where("email = ? AND used_at IS NULL", t.Email).
+			Update("used_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.UsedAt = &now
+	return &t, nil
+}
+
+func (r *LoginTokenRepo) CountSince(ctx context.Context, email, ip string, since time.Time) (int64, int64, error) {
+	var byEmail, byIP int64
+	if err := r.db.WithContext(ctx).Model(&domain.LoginToken{}).Where("email = ? AND created_at >= ?", email, since).Count(&byEmail).Error; err != nil {
+		return 0, 0, err
+	}
+	if ip != "" {
+		if err := r.db.WithContext(ctx).Model(&domain.LoginToken{}).Where("ip = ? AND created_at >= ?", ip, since).Count(&byIP).Error; err != nil {
+			return 0, 0, err
+		}
+	}
+	return byEmail, byIP, nil
+}
+
+func (r *LoginTokenRepo) DeleteBefore(ctx context.Context, t time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Where("created_at < ?", t).Delete(&domain.LoginToken{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,82 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type NotificationRepo struct{ db *gorm.DB }
+
+func NewNotificationRepo(db *gorm.DB) *NotificationRepo { return &NotificationRepo{db: db} }
+
+func (r *NotificationRepo) ListTemplates(ctx context.Context) ([]domain.NotificationTemplate, error) {
+	var list []domain.NotificationTemplate
+	if err := r.db.WithContext(ctx).Order("event asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *NotificationRepo) FindTemplate(ctx context.Context, event string) (*domain.NotificationTemplate, error) {
+	var t domain.NotificationTemplate
+	if err := r.db.WithContext(ctx).First(&t, "event = ?", event).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *NotificationRepo) SaveTemplate(ctx context.Context, t *domain.NotificationTemplate) error {
+	if t == nil || t.Event == "" {
+		return errors.New("notification template sin evento")
+	}
+	return r.db.WithContext(ctx).Save(t).Error
+}
+
+func (r *NotificationRepo) DeleteTemplate(ctx context.Context, event string) error {
+	return r.db.WithContext(ctx).Delete(&domain.NotificationTemplate{}, "event = ?", event).Error
+}
+
+func (r *NotificationRepo) ListRules(ctx context.Context) ([]domain.NotificationRule, error) {
+	var list []domain.NotificationRule
+	if err := r.db.WithContext(ctx).Order("event asc, created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *NotificationRepo) SaveRule(ctx context.Context, rule *domain.NotificationRule) error {
+	if rule == nil {
+		return errors.New("notification rule nil")
+	}
+	if rule.ID == uuid.Nil {
+		rule.ID = uuid.New()
+	}
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = time.Now()
+	}
+	return r.db.WithContext(ctx).Save(rule).Error
+}
+
+func (r *NotificationRepo) FindRule(ctx context.Context, id uuid.UUID) (*domain.NotificationRule, error) {
+	var rule domain.NotificationRule
+	if err := r.db.WithContext(ctx).First(&rule, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *NotificationRepo) DeleteRule(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&domain.NotificationRule{}, "id = ?", id).Error
+}
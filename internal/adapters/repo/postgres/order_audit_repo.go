@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type OrderAuditRepo struct{ db *gorm.DB }
+
+func NewOrderAuditRepo(db *gorm.DB) *OrderAuditRepo { return &OrderAuditRepo{db: db} }
+
+func (r *OrderAuditRepo) Save(ctx context.Context, e *domain.OrderAuditEntry) error {
+	if e == nil {
+		return errors.New("audit entry nil")
+	}
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	return r.db.WithContext(ctx).Create(e).Error
+}
+
+func (r *OrderAuditRepo) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.OrderAuditEntry, error) {
+	var list []domain.OrderAuditEntry
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type OrderEventRepo struct{ db *gorm.DB }
+
+func NewOrderEventRepo(db *gorm.DB) *OrderEventRepo { return &OrderEventRepo{db: db} }
+
+func (r *OrderEventRepo) Save(ctx context.Context, ev *domain.OrderStatusEvent) error {
+	if ev == nil {
+		return errors.New("event nil")
+	}
+	if ev.ID == uuid.Nil {
+		ev.ID = uuid.New()
+	}
+	if ev.CreatedAt.IsZero() {
+		ev.CreatedAt = time.Now()
+	}
+	return r.db.WithContext(ctx).Create(ev).Error
+}
+
+func (r *OrderEventRepo) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.OrderStatusEvent, error) {
+	var list []domain.OrderStatusEvent
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -3,6 +3,7 @@ package postgres
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,9 +27,66 @@ func (r *OrderRepo) Save(ctx context.Context, o *domain.Order) error {
 		return err
 	}
 	if count == 0 {
+		return r.create(ctx, o)
+	}
+
+	// El número correlativo y la fecha del opt-in de WhatsApp no se actualizan: se fijan al crear la orden.
+	// El estado tampoco: cambia sólo con UpdateStatus/CancelUnpaid, que son condicionales, así una copia
+	// vieja de la orden no pisa una transición hecha mientras tanto.
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&domain.Order{}).Where("id = ?", o.ID).Updates(map[string]any{
+			"email":            o.Email,
+			"name":             o.Name,
+			"phone":            o.Phone,
+			"dni":              o.DNI,
+			"address":          o.Address,
+			"postal_code":      o.PostalCode,
+			"province":         o.Province,
+			"delivery_notes":   o.DeliveryNotes,
+			"mp_preference_id": o.MPPreferenceID,
+			"mp_status":        o.PaymentStatus,
+			"mp_payment_id":    o.MPPaymentID,
+			"total":            o.Total,
+			"shipping_method":  o.ShippingMethod,
+			"shipping_cost":    o.ShippingCost,
+			"payment_method":  o.PaymentMethod,
+			"discount_amount":  o.DiscountAmount,
+			"crypto_amount":    o.CryptoAmount,
+			"crypto_rate":      o.CryptoRate,
+			"crypto_source":    o.CryptoSource,
+			"crypto_expires":   o.CryptoExpires,
+			"crypto_tx_hash":   o.CryptoTxHash,
+			"customer_id":      o.CustomerID,
+			"notified":         o.Notified,
+			"reminder_sent_at": o.ReminderSentAt,
+			"cancel_reason":    o.CancelReason,
+			"pre_order":        o.PreOrder,
+			"pre_order_eta":    o.PreOrderETA,
+			"deposit_amount":   o.DepositAmount,
+			"charge_amount":    o.ChargeAmount,
+			"allocated_at":     o.AllocatedAt,
+			"tracking_number":  o.TrackingNumber,
+			"whats_app_phone":  o.WhatsAppPhone,
+			"whats_app_opt_in": o.WhatsAppOptIn,
+		}).Error; err != nil {
+			return err
+		}
+		return saveOutbox(tx, o)
+	})
+}
 
+// create inserta una orden nueva con sus items. El número correlativo se toma en la misma transacción:
+// el UPDATE del contador bloquea su fila hasta el commit, así que las órdenes concurrentes esperan su
+// turno y un rollback devuelve el número sin dejar huecos.
+func (r *OrderRepo) create(ctx context.Context, o *domain.Order) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		seq, err := nextOrderSeq(tx)
+		if err != nil {
+			return err
+		}
 		core := domain.Order{
 			ID:             o.ID,
+			Seq:            seq,
 			Status:         o.Status,
 			Email:          o.Email,
 			Name:           o.Name,
@@ -37,17 +95,35 @@ func (r *OrderRepo) Save(ctx context.Context, o *domain.Order) error {
 			Address:        o.Address,
 			PostalCode:     o.PostalCode,
 			Province:       o.Province,
+			DeliveryNotes:  o.DeliveryNotes,
 			MPPreferenceID: o.MPPreferenceID,
-			MPStatus:       o.MPStatus,
+			PaymentStatus:  o.PaymentStatus,
+			MPPaymentID:    o.MPPaymentID,
 			Total:          o.Total,
 			ShippingMethod: o.ShippingMethod,
 			ShippingCost:   o.ShippingCost,
 			PaymentMethod:  o.PaymentMethod,
 			DiscountAmount: o.DiscountAmount,
+			CryptoAmount:   o.CryptoAmount,
+			CryptoRate:     o.CryptoRate,
+			CryptoSource:   o.CryptoSource,
+			CryptoExpires:  o.CryptoExpires,
+			CryptoTxHash:   o.CryptoTxHash,
 			CustomerID:     o.CustomerID,
 			Notified:       o.Notified,
+			ReminderSentAt: o.ReminderSentAt,
+			CancelReason:   o.CancelReason,
+			PreOrder:       o.PreOrder,
+			PreOrderETA:    o.PreOrderETA,
+			DepositAmount:  o.DepositAmount,
+			ChargeAmount:   o.ChargeAmount,
+			AllocatedAt:    o.AllocatedAt,
+			TrackingNumber: o.TrackingNumber,
+			WhatsAppPhone:  o.WhatsAppPhone,
+			WhatsAppOptIn:  o.WhatsAppOptIn,
 		}
-		if err := r.db.WithContext(ctx).Create(&core).Error; err != nil {
+		core.WhatsAppOptInAt = o.WhatsAppOptInAt
+		if err := tx.Create(&core).Error; err != nil {
 			return err
 		}
 
@@ -58,32 +134,37 @@ func (r *OrderRepo) Save(ctx context.Context, o *domain.Order) error {
 					o.Items[i].ID = uuid.New()
 				}
 			}
-			if err := r.db.WithContext(ctx).Create(&o.Items).Error; err != nil {
+			if err := tx.Create(&o.Items).Error; err != nil {
 				return err
 			}
 		}
+		o.Seq = seq
+		return saveOutbox(tx, o)
+	})
+}
+
+// saveOutbox guarda los avisos encolados en la orden dentro de la transacción del cambio.
+func saveOutbox(tx *gorm.DB, o *domain.Order) error {
+	if len(o.Outbox) == 0 {
 		return nil
 	}
+	if err := tx.Create(&o.Outbox).Error; err != nil {
+		return err
+	}
+	o.Outbox = nil
+	return nil
+}
 
-	return r.db.WithContext(ctx).Model(&domain.Order{}).Where("id = ?", o.ID).Updates(map[string]any{
-		"status":           o.Status,
-		"email":            o.Email,
-		"name":             o.Name,
-		"phone":            o.Phone,
-		"dni":              o.DNI,
-		"address":          o.Address,
-		"postal_code":      o.PostalCode,
-		"province":         o.Province,
-		"mp_preference_id": o.MPPreferenceID,
-		"mp_status":        o.MPStatus,
-		"total":            o.Total,
-		"shipping_method":  o.ShippingMethod,
-		"shipping_cost":    o.ShippingCost,
-		"payment_method":  o.PaymentMethod,
-		"discount_amount":  o.DiscountAmount,
-		"customer_id":      o.CustomerID,
-		"notified":         o.Notified,
-	}).Error
+// nextOrderSeq incrementa y devuelve el contador de números de orden.
+func nextOrderSeq(tx *gorm.DB) (int64, error) {
+	var seq int64
+	err := tx.Raw(`INSERT INTO order_sequences (name, last) VALUES (?, 1)
+		ON CONFLICT (name) DO UPDATE SET last = order_sequences.last + 1
+		RETURNING last`, domain.OrderNumberPrefix).Scan(&seq).Error
+	if err != nil {
+		return 0, err
+	}
+	return seq, nil
 }
 
 func (r *OrderRepo) FindByID(ctx context.Context, id uuid.UUID) (*domain.Order, error) {
@@ -108,11 +189,154 @@ func (r *OrderRepo) FindByPreferenceID(ctx context.Context, prefID string) (*dom
 	return &o, nil
 }
 
-func (r *OrderRepo) UpdateStatus(ctx context.Context, id uuid.UUID, st domain.OrderStatus) error {
-	return r.db.WithContext(ctx).Model(&domain.Order{}).Where("id = ?", id).Update("status", st).Error
+func (r *OrderRepo) FindByNumber(ctx context.Context, seq int64) (*domain.Order, error) {
+	var o domain.Order
+	if err := r.db.WithContext(ctx).Preload("Items").First(&o, "seq = ?", seq).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (r *OrderRepo) Numbers(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]string, error) {
+	out := map[uuid.UUID]string{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	var rows []domain.Order
+	if err := r.db.WithContext(ctx).Model(&domain.Order{}).Select("id", "seq").Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		out[rows[i].ID] = rows[i].Number()
+	}
+	return out, nil
+}
+
+func (r *OrderRepo) UpdateStatus(ctx context.Context, o *domain.Order, st domain.OrderStatus) error {
+	if o == nil {
+		return errors.New("order nil")
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&domain.Order{}).Where("id = ? AND status = ?", o.ID, o.Status).Update("status", st)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return domain.ErrStatusConflict
+		}
+		return saveOutbox(tx, o)
+	})
+}
+
+// unpaidCond restringe un UPDATE a las órdenes que siguen esperando el pago: un pago o un comprobante que
+// llegó después de leer la orden la saca del alcance.
+const unpaidCond = `status = ? AND COALESCE(mp_status, '') NOT IN ('approved', 'partial')
+	AND NOT EXISTS (SELECT 1 FROM payment_receipts rc WHERE rc.order_id = orders.id AND rc.status = ?)`
+
+func (r *OrderRepo) CancelUnpaid(ctx context.Context, o *domain.Order) (bool, error) {
+	if o == nil {
+		return false, errors.New("order nil")
+	}
+	done := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&domain.Order{}).Where("id = ?", o.ID).Where(unpaidCond, domain.OrderStatusAwaitingPay, domain.ReceiptStatusPending).
+			Updates(map[string]any{"status": domain.OrderStatusCancelled, "cancel_reason": o.CancelReason})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		done = true
+		return saveOutbox(tx, o)
+	})
+	return done, err
+}
+
+func (r *OrderRepo) MarkReminderSent(ctx context.Context, id uuid.UUID, at time.Time) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&domain.Order{}).Where("id = ? AND reminder_sent_at IS NULL", id).
+		Where(unpaidCond, domain.OrderStatusAwaitingPay, domain.ReceiptStatusPending).Update("reminder_sent_at", at)
+	return res.RowsAffected > 0, res.Error
 }
 
-func (r *OrderRepo) List(ctx context.Context, status *domain.OrderStatus, mpStatus *string, page, pageSize int) ([]domain.Order, int64, error) {
+func (r *OrderRepo) List(ctx context.Context, f domain.OrderFilter) ([]domain.Order, int64, error) {
+	if f.Page <= 0 {
+		f.Page = 1
+	}
+	if f.PageSize <= 0 {
+		f.PageSize = 20
+	}
+	q := r.db.WithContext(ctx).Model(&domain.Order{})
+	if f.Status != nil {
+		q = q.Where("status = ?", *f.Status)
+	}
+	if f.PaymentStatus != "" {
+		q = q.Where("mp_status = ?", f.PaymentStatus)
+	}
+	if v := strings.TrimSpace(f.Email); v != "" {
+		q = q.Where("LOWER(email) LIKE ?", "%"+strings.ToLower(v)+"%")
+	}
+	if v := strings.TrimSpace(f.Name); v != "" {
+		q = q.Where("LOWER(name) LIKE ?", "%"+strings.ToLower(v)+"%")
+	}
+	if v := domain.OnlyDigits(f.DNI); v != "" {
+		q = q.Where("regexp_replace(dni, '[^0-9]', '', 'g') LIKE ?", "%"+v+"%")
+	}
+	if v := domain.OnlyDigits(f.Phone); v != "" {
+		q = q.Where("regexp_replace(phone, '[^0-9]', '', 'g') LIKE ?", "%"+v+"%")
+	}
+	if v := strings.ToLower(strings.TrimSpace(f.IDPrefix)); v != "" {
+		if seq, ok := domain.ParseOrderNumber(v); ok {
+			q = q.Where("(seq = ? OR CAST(id AS TEXT) LIKE ?)", seq, v+"%")
+		} else {
+			q = q.Where("CAST(id AS TEXT) LIKE ?", v+"%")
+		}
+	}
+	if f.PaymentMethod != "" {
+		q = q.Where("payment_method = ?", f.PaymentMethod)
+	}
+	if f.ShippingMethod != "" {
+		q = q.Where("shipping_method = ?", f.ShippingMethod)
+	}
+	if v := strings.TrimSpace(f.Province); v != "" {
+		q = q.Where("LOWER(province) LIKE ?", "%"+strings.ToLower(v)+"%")
+	}
+	if f.From != nil {
+		q = q.Where("created_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		q = q.Where("created_at < ?", *f.To)
+	}
+	if f.MinTotal != nil {
+		q = q.Where("total >= ?", *f.MinTotal)
+	}
+	if f.MaxTotal != nil {
+		q = q.Where("total <= ?", *f.MaxTotal)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	switch f.Sort {
+	case "oldest":
+		q = q.Order("created_at asc")
+	case "total_desc":
+		q = q.Order("total desc").Order("created_at desc")
+	case "total_asc":
+		q = q.Order("total asc").Order("created_at desc")
+	case "name":
+		q = q.Order("LOWER(name) asc").Order("created_at desc")
+	default:
+		q = q.Order("created_at desc")
+	}
+	var list []domain.Order
+	if err := q.Offset((f.Page - 1) * f.PageSize).Limit(f.PageSize).Preload("Items").Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+func (r *OrderRepo) ListByCustomer(ctx context.Context, customerID uuid.UUID, email string, page, pageSize int) ([]domain.Order, int64, error) {
 	if page <= 0 {
 		page = 1
 	}
@@ -120,11 +344,10 @@ func (r *OrderRepo) List(ctx context.Context, status *domain.OrderStatus, mpStat
 		pageSize = 20
 	}
 	q := r.db.WithContext(ctx).Model(&domain.Order{})
-	if status != nil {
-		q = q.Where("status = ?", *status)
-	}
-	if mpStatus != nil && *mpStatus != "" {
-		q = q.Where("mp_status = ?", *mpStatus)
+	if e := strings.ToLower(strings.TrimSpace(email)); e != "" {
+		q = q.Where("(customer_id = ? OR LOWER(email) = ?)", customerID, e)
+	} else {
+		q = q.Where("customer_id = ?", customerID)
 	}
 	var total int64
 	if err := q.Count(&total).Error; err != nil {
@@ -137,6 +360,17 @@ func (r *OrderRepo) List(ctx context.Context, status *domain.OrderStatus, mpStat
 	return list, total, nil
 }
 
+func (r *OrderRepo) LinkGuestOrders(ctx context.Context, customerID uuid.UUID, email string) (int64, error) {
+	e := strings.ToLower(strings.TrimSpace(email))
+	if e == "" {
+		return 0, nil
+	}
+	res := r.db.WithContext(ctx).Model(&domain.Order{}).
+		Where("customer_id IS NULL AND LOWER(email) = ?", e).
+		UpdateColumn("customer_id", customerID)
+	return res.RowsAffected, res.Error
+}
+
 func (r *OrderRepo) ListInRange(ctx context.Context, from, to time.Time) ([]domain.Order, error) {
 
 	if to.Before(from) {
@@ -151,3 +385,45 @@ func (r *OrderRepo) ListInRange(ctx context.Context, from, to time.Time) ([]doma
 	}
 	return list, nil
 }
+
+func (r *OrderRepo) ListAwaitingPayment(ctx context.Context, paymentMethod string, from, to time.Time) ([]domain.Order, error) {
+	var list []domain.Order
+	q := r.db.WithContext(ctx).Where("status = ? AND created_at BETWEEN ? AND ?", domain.OrderStatusAwaitingPay, from, to)
+	if paymentMethod != "" {
+		q = q.Where("payment_method = ?", paymentMethod)
+	}
+	if err := q.Order("created_at asc").Preload("Items").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *OrderRepo) ListPreOrders(ctx context.Context) ([]domain.Order, error) {
+	var list []domain.Order
+	q := r.db.WithContext(ctx).Where("pre_order = ? AND status = ?", true, domain.OrderStatusAwaitingPay)
+	if err := q.Order("created_at asc").Preload("Items").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *OrderRepo) SaveItem(ctx context.Context, it *domain.OrderItem) error {
+	if it == nil {
+		return errors.New("item nil")
+	}
+	if it.ID == uuid.Nil {
+		it.ID = uuid.New()
+	}
+	return r.db.WithContext(ctx).Save(it).Error
+}
+
+func (r *OrderRepo) DeleteItem(ctx context.Context, orderID, itemID uuid.UUID) error {
+	res := r.db.WithContext(ctx).Where("id = ? AND order_id = ?", itemID, orderID).Delete(&domain.OrderItem{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
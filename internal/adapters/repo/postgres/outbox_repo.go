@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type OutboxRepo struct{ db *gorm.DB }
+
+func NewOutboxRepo(db *gorm.DB) *OutboxRepo { return &OutboxRepo{db: db} }
+
+func (r *OutboxRepo) Save(ctx context.Context, m *domain.OutboxMessage) error {
+	if m == nil {
+		return errors.New("outbox message nil")
+	}
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return r.db.WithContext(ctx).Save(m).Error
+}
+
+func (r *OutboxRepo) FindByID(ctx context.Context, id uuid.UUID) (*domain.OutboxMessage, error) {
+	var m domain.OutboxMessage
+	if err := r.db.WithContext(ctx).First(&m, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *OutboxRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]domain.OutboxMessage, error) {
+	var list []domain.OutboxMessage
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", domain.OutboxStatusPending, now).
+			Order("next_attempt_at asc").Limit(limit).Find(&list).Error; err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return nil
+		}
+		ids := make([]uuid.UUID, len(list))
+		for i := range list {
+			ids[i] = list[i].ID
+			list[i].NextAttemptAt = now.Add(lease)
+		}
+		return tx.Model(&domain.OutboxMessage{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(lease)).Error
+	})
+	return list, err
+}
+
+func (r *OutboxRepo) List(ctx context.Context, f domain.OutboxFilter) ([]domain.OutboxMessage, int64, error) {
+	if f.Page <= 0 {
+		f.Page = 1
+	}
+	if f.PageSize <= 0 {
+		f.PageSize = 50
+	}
+	q := r.db.WithContext(ctx).Model(&domain.OutboxMessage{})
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if f.Kind != "" {
+		q = q.Where("kind = ?", f.Kind)
+	}
+	if f.OrderID != nil {
+		q = q.Where("order_id = ?", *f.OrderID)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []domain.OutboxMessage
+	if err := q.Order("created_at desc").Offset((f.Page - 1) * f.PageSize).Limit(f.PageSize).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type PaymentRepo struct{ db *gorm.DB }
+
+func NewPaymentRepo(db *gorm.DB) *PaymentRepo { return &PaymentRepo{db: db} }
+
+func (r *PaymentRepo) Save(ctx context.Context, p *domain.Payment) error {
+	if p == nil {
+		return errors.New("payment nil")
+	}
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return r.db.WithContext(ctx).Save(p).Error
+}
+
+func (r *PaymentRepo) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.Payment, error) {
+	var list []domain.Payment
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *PaymentRepo) FindByExternalID(ctx context.Context, method, externalID string) (*domain.Payment, error) {
+	var p domain.Payment
+	if err := r.db.WithContext(ctx).First(&p, "method = ? AND external_id = ?", method, externalID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
@@ -51,6 +51,17 @@ func (r *ProductRepo) FindBySlug(ctx context.Context, slug string) (*domain.Prod
 	return &p, nil
 }
 
+func (r *ProductRepo) FindByID(ctx context.Context, id uuid.UUID) (*domain.Product, error) {
+	var p domain.Product
+	if err := r.db.WithContext(ctx).Preload("Variants").First(&p, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
 func (r *ProductRepo) List(ctx context.Context, f domain.ProductFilter) ([]domain.Product, int64, error) {
 	var list []domain.Product
 	q := r.db.WithContext(ctx).Model(&domain.Product{})
@@ -221,6 +232,39 @@ func (r *ProductRepo) ListVariants(ctx context.Context, productID uuid.UUID) ([]
 	return list, nil
 }
 
+func (r *ProductRepo) ListPreOrderVariants(ctx context.Context) ([]domain.Variant, error) {
+	var list []domain.Variant
+	if err := r.db.WithContext(ctx).Where("pre_order = ?", true).Order("product_id asc, created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *ProductRepo) ListLowStockVariants(ctx context.Context, threshold int) ([]domain.Variant, error) {
+	var list []domain.Variant
+	err := r.db.WithContext(ctx).
+		Joins("JOIN products ON products.id = variants.product_id AND products.active = ?", true).
+		Where("variants.stock <= ? AND variants.pre_order = ? AND variants.low_stock_alerted_at IS NULL", threshold, false).
+		Order("variants.stock asc, variants.product_id asc").Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *ProductRepo) MarkLowStockAlerted(ctx context.Context, ids []uuid.UUID, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Model(&domain.Variant{}).Where("id IN ?", ids).Update("low_stock_alerted_at", at).Error
+}
+
+func (r *ProductRepo) ClearLowStockAlerts(ctx context.Context, threshold int) error {
+	return r.db.WithContext(ctx).Model(&domain.Variant{}).
+		Where("low_stock_alerted_at IS NOT NULL AND stock > ?", threshold).
+		Update("low_stock_alerted_at", nil).Error
+}
+
 func (r *ProductRepo) FindVariantByEAN(ctx context.Context, ean string) (*domain.Product, *domain.Variant, error) {
 	var v domain.Variant
 	if err := r.db.WithContext(ctx).First(&v, "ean = ?", ean).Error; err != nil {
@@ -251,6 +295,21 @@ func (r *ProductRepo) FindVariantBySKU(ctx context.Context, sku string) (*domain
 	return &p, &v, nil
 }
 
+func (r *ProductRepo) FindVariantByID(ctx context.Context, id uuid.UUID) (*domain.Product, *domain.Variant, error) {
+	var v domain.Variant
+	if err := r.db.WithContext(ctx).First(&v, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, domain.ErrNotFound
+		}
+		return nil, nil, err
+	}
+	var p domain.Product
+	if err := r.db.WithContext(ctx).First(&p, "id = ?", v.ProductID).Error; err != nil {
+		return nil, nil, err
+	}
+	return &p, &v, nil
+}
+
 func (r *ProductRepo) UpdateVariantStock(ctx context.Context, variantID uuid.UUID, delta int) error {
 	return r.db.WithContext(ctx).Model(&domain.Variant{}).Where("id = ?", variantID).UpdateColumn("stock", gorm.Expr("COALESCE(stock,0) + ?", delta)).Error
 }
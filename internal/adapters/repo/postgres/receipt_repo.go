@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type ReceiptRepo struct{ db *gorm.DB }
+
+func NewReceiptRepo(db *gorm.DB) *ReceiptRepo { return &ReceiptRepo{db: db} }
+
+func (r *ReceiptRepo) Save(ctx context.Context, rc *domain.PaymentReceipt) error {
+	if rc == nil {
+		return errors.New("receipt nil")
+	}
+	if rc.ID == uuid.Nil {
+		rc.ID = uuid.New()
+	}
+	if rc.CreatedAt.IsZero() {
+		rc.CreatedAt = time.Now()
+	}
+	return r.db.WithContext(ctx).Save(rc).Error
+}
+
+func (r *ReceiptRepo) FindByID(ctx context.Context, id uuid.UUID) (*domain.PaymentReceipt, error) {
+	var rc domain.PaymentReceipt
+	if err := r.db.WithContext(ctx).First(&rc, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &rc, nil
+}
+
+func (r *ReceiptRepo) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.PaymentReceipt, error) {
+	var list []domain.PaymentReceipt
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
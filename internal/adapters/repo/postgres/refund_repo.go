@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type RefundRepo struct{ db *gorm.DB }
+
+func NewRefundRepo(db *gorm.DB) *RefundRepo { return &RefundRepo{db: db} }
+
+func (r *RefundRepo) Save(ctx context.Context, rf *domain.Refund) error {
+	if rf == nil {
+		return errors.New("refund nil")
+	}
+	if rf.ID == uuid.Nil {
+		rf.ID = uuid.New()
+	}
+	if rf.CreatedAt.IsZero() {
+		rf.CreatedAt = time.Now()
+	}
+	for i := range rf.Lines {
+		if rf.Lines[i].ID == uuid.Nil {
+			rf.Lines[i].ID = uuid.New()
+		}
+		rf.Lines[i].RefundID = rf.ID
+	}
+	return r.db.WithContext(ctx).Create(rf).Error
+}
+
+func (r *RefundRepo) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.Refund, error) {
+	var list []domain.Refund
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at asc").Preload("Lines").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *RefundRepo) Update(ctx context.Context, rf *domain.Refund) error {
+	if rf == nil {
+		return errors.New("refund nil")
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&domain.Refund{}).Where("id = ?", rf.ID).Updates(map[string]any{
+			"status":      rf.Status,
+			"payment_id":  rf.PaymentID,
+			"external_id": rf.ExternalID,
+		}).Error; err != nil {
+			return err
+		}
+		for _, l := range rf.Lines {
+			if err := tx.Model(&domain.RefundLine{}).Where("id = ?", l.ID).Update("restocked", l.Restocked).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *RefundRepo) FindByID(ctx context.Context, id uuid.UUID) (*domain.Refund, error) {
+	var rf domain.Refund
+	if err := r.db.WithContext(ctx).Preload("Lines").First(&rf, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &rf, nil
+}
@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type RepairRepo struct{ db *gorm.DB }
+
+func NewRepairRepo(db *gorm.DB) *RepairRepo { return &RepairRepo{db: db} }
+
+func (r *RepairRepo) Save(ctx context.Context, t *domain.RepairTicket) error {
+	if t == nil {
+		return errors.New("ticket nil")
+	}
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return r.db.WithContext(ctx).Omit("Parts").Save(t).Error
+}
+
+func (r *RepairRepo) FindByID(ctx context.Context, id uuid.UUID) (*domain.RepairTicket, error) {
+	return r.findOne(ctx, "id = ?", id)
+}
+
+func (r *RepairRepo) FindByOrder(ctx context.Context, orderID uuid.UUID) (*domain.RepairTicket, error) {
+	return r.findOne(ctx, "order_id = ?", orderID)
+}
+
+func (r *RepairRepo) findOne(ctx context.Context, where string, arg any) (*domain.RepairTicket, error) {
+	var t domain.RepairTicket
+	err := r.db.WithContext(ctx).Preload("Parts", func(db *gorm.DB) *gorm.DB { return db.Order("created_at asc") }).
+		Where(where, arg).First(&t).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *RepairRepo) List(ctx context.Context, f domain.RepairFilter) ([]domain.RepairTicket, int64, error) {
+	if f.Page <= 0 {
+		f.Page = 1
+	}
+	if f.PageSize <= 0 {
+		f.PageSize = 20
+	}
+	q := r.db.WithContext(ctx).Model(&domain.RepairTicket{})
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if v := strings.ToLower(strings.TrimSpace(f.Query)); v != "" {
+		like := "%" + v + "%"
+		cond := "imei LIKE ? OR LOWER(name) LIKE ? OR LOWER(email) LIKE ? OR CAST(id AS TEXT) LIKE ?"
+		args := []any{like, like, like, strings.TrimPrefix(v, "st-") + "%"}
+		if d := domain.OnlyDigits(v); d != "" {
+			cond += " OR regexp_replace(phone, '[^0-9]', '', 'g') LIKE ?"
+			args = append(args, "%"+d+"%")
+		}
+		q = q.Where(cond, args...)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []domain.RepairTicket
+	if err := q.Order("created_at desc").Offset((f.Page - 1) * f.PageSize).Limit(f.PageSize).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+func (r *RepairRepo) SavePart(ctx context.Context, p *domain.RepairPart) error {
+	if p == nil {
+		return errors.New("repuesto nil")
+	}
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return r.db.WithContext(ctx).Save(p).Error
+}
+
+func (r *RepairRepo) DeletePart(ctx context.Context, ticketID, partID uuid.UUID) error {
+	res := r.db.WithContext(ctx).Where("id = ? AND ticket_id = ?", partID, ticketID).Delete(&domain.RepairPart{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *RepairRepo) SaveEvent(ctx context.Context, e *domain.RepairEvent) error {
+	if e == nil {
+		return errors.New("evento nil")
+	}
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	return r.db.WithContext(ctx).Create(e).Error
+}
+
+func (r *RepairRepo) ListEvents(ctx context.Context, ticketID uuid.UUID) ([]domain.RepairEvent, error) {
+	var list []domain.RepairEvent
+	if err := r.db.WithContext(ctx).Where("ticket_id = ?", ticketID).Order("created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type RMARepo struct{ db *gorm.DB }
+
+func NewRMARepo(db *gorm.DB) *RMARepo { return &RMARepo{db: db} }
+
+func (r *RMARepo) Save(ctx context.Context, rma *domain.RMA) error {
+	if rma == nil {
+		return errors.New("rma nil")
+	}
+	if rma.ID == uuid.Nil || rma.CreatedAt.IsZero() {
+		if rma.ID == uuid.Nil {
+			rma.ID = uuid.New()
+		}
+		rma.CreatedAt = time.Now()
+		for i := range rma.Lines {
+			if rma.Lines[i].ID == uuid.Nil {
+				rma.Lines[i].ID = uuid.New()
+			}
+			rma.Lines[i].RMAID = rma.ID
+		}
+		return r.db.WithContext(ctx).Create(rma).Error
+	}
+	rma.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Session(&gorm.Session{FullSaveAssociations: true}).Omit("created_at").Save(rma).Error
+}
+
+func (r *RMARepo) FindByID(ctx context.Context, id uuid.UUID) (*domain.RMA, error) {
+	var rma domain.RMA
+	if err := r.db.WithContext(ctx).Preload("Lines").First(&rma, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &rma, nil
+}
+
+func (r *RMARepo) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.RMA, error) {
+	var list []domain.RMA
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at asc").Preload("Lines").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *RMARepo) List(ctx context.Context, status string, page, pageSize int) ([]domain.RMA, int64, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	q := r.db.WithContext(ctx).Model(&domain.RMA{})
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []domain.RMA
+	if err := q.Order("created_at desc").Offset((page - 1) * pageSize).Limit(pageSize).Preload("Lines").Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type WarrantyRepo struct{ db *gorm.DB }
+
+func NewWarrantyRepo(db *gorm.DB) *WarrantyRepo { return &WarrantyRepo{db: db} }
+
+func (r *WarrantyRepo) ListPolicies(ctx context.Context) ([]domain.WarrantyPolicy, error) {
+	var list []domain.WarrantyPolicy
+	if err := r.db.WithContext(ctx).Order("category asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *WarrantyRepo) FindPolicy(ctx context.Context, category string) (*domain.WarrantyPolicy, error) {
+	var p domain.WarrantyPolicy
+	if err := r.db.WithContext(ctx).First(&p, "LOWER(category) = LOWER(?)", category).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *WarrantyRepo) SavePolicy(ctx context.Context, p *domain.WarrantyPolicy) error {
+	if p == nil || p.Category == "" {
+		return errors.New("política sin categoría")
+	}
+	return r.db.WithContext(ctx).Save(p).Error
+}
+
+func (r *WarrantyRepo) DeletePolicy(ctx context.Context, category string) error {
+	res := r.db.WithContext(ctx).Where("category = ?", category).Delete(&domain.WarrantyPolicy{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (r *WarrantyRepo) Save(ctx context.Context, w *domain.Warranty) error {
+	if w == nil {
+		return errors.New("garantía nil")
+	}
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return r.db.WithContext(ctx).Save(w).Error
+}
+
+func (r *WarrantyRepo) FindByIMEI(ctx context.Context, imei string) (*domain.Warranty, error) {
+	var w domain.Warranty
+	if err := r.db.WithContext(ctx).First(&w, "imei = ?", imei).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (r *WarrantyRepo) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.Warranty, error) {
+	var list []domain.Warranty
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *WarrantyRepo) SaveClaim(ctx context.Context, c *domain.WarrantyClaim) error {
+	if c == nil {
+		return errors.New("reclamo nil")
+	}
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return r.db.WithContext(ctx).Save(c).Error
+}
+
+func (r *WarrantyRepo) FindClaim(ctx context.Context, id uuid.UUID) (*domain.WarrantyClaim, error) {
+	var c domain.WarrantyClaim
+	if err := r.db.WithContext(ctx).First(&c, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *WarrantyRepo) ListClaims(ctx context.Context, status string, page, pageSize int) ([]domain.WarrantyClaim, int64, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	q := r.db.WithContext(ctx).Model(&domain.WarrantyClaim{})
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []domain.WarrantyClaim
+	if err := q.Order("created_at desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+func (r *WarrantyRepo) ListClaimsByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.WarrantyClaim, error) {
+	var list []domain.WarrantyClaim
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type WebhookRepo struct{ db *gorm.DB }
+
+func NewWebhookRepo(db *gorm.DB) *WebhookRepo { return &WebhookRepo{db: db} }
+
+func (r *WebhookRepo) ListEndpoints(ctx context.Context) ([]domain.WebhookEndpoint, error) {
+	var list []domain.WebhookEndpoint
+	if err := r.db.WithContext(ctx).Order("created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *WebhookRepo) FindEndpoint(ctx context.Context, id uuid.UUID) (*domain.WebhookEndpoint, error) {
+	var e domain.WebhookEndpoint
+	if err := r.db.WithContext(ctx).First(&e, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (r *WebhookRepo) SaveEndpoint(ctx context.Context, e *domain.WebhookEndpoint) error {
+	if e == nil {
+		return errors.New("webhook nil")
+	}
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return r.db.WithContext(ctx).Save(e).Error
+}
+
+func (r *WebhookRepo) DeleteEndpoint(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&domain.WebhookEndpoint{}, "id = ?", id).Error
+}
+
+func (r *WebhookRepo) SaveDelivery(ctx context.Context, d *domain.WebhookDelivery) error {
+	if d == nil {
+		return errors.New("webhook delivery nil")
+	}
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return r.db.WithContext(ctx).Save(d).Error
+}
+
+func (r *WebhookRepo) FindDelivery(ctx context.Context, id uuid.UUID) (*domain.WebhookDelivery, error) {
+	var d domain.WebhookDelivery
+	if err := r.db.WithContext(ctx).First(&d, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrNotFound
+		}
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *WebhookRepo) ClaimDueDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]domain.WebhookDelivery, error) {
+	var list []domain.WebhookDelivery
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", domain.WebhookStatusPending, now).
+			Order("next_attempt_at asc").Limit(limit).Find(&list).Error; err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return nil
+		}
+		ids := make([]uuid.UUID, len(list))
+		for i := range list {
+			ids[i] = list[i].ID
+			list[i].NextAttemptAt = now.Add(lease)
+		}
+		return tx.Model(&domain.WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(lease)).Error
+	})
+	return list, err
+}
+
+func (r *WebhookRepo) ListDeliveries(ctx context.Context, f domain.WebhookDeliveryFilter) ([]domain.WebhookDelivery, int64, error) {
+	if f.Page <= 0 {
+		f.Page = 1
+	}
+	if f.PageSize <= 0 {
+		f.PageSize = 50
+	}
+	q := r.db.WithContext(ctx).Model(&domain.WebhookDelivery{})
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if f.EndpointID != nil {
+		q = q.Where("endpoint_id = ?", *f.EndpointID)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []domain.WebhookDelivery
+	if err := q.Order("created_at desc").Offset((f.Page - 1) * f.PageSize).Limit(f.PageSize).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type WhatsAppLogRepo struct{ db *gorm.DB }
+
+func NewWhatsAppLogRepo(db *gorm.DB) *WhatsAppLogRepo { return &WhatsAppLogRepo{db: db} }
+
+func (r *WhatsAppLogRepo) Save(ctx context.Context, m *domain.WhatsAppMessage) error {
+	if m == nil {
+		return errors.New("whatsapp message nil")
+	}
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+	return r.db.WithContext(ctx).Save(m).Error
+}
+
+func (r *WhatsAppLogRepo) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.WhatsAppMessage, error) {
+	var list []domain.WhatsAppMessage
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("created_at asc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *WhatsAppLogRepo) List(ctx context.Context, f domain.WhatsAppFilter) ([]domain.WhatsAppMessage, int64, error) {
+	if f.Page <= 0 {
+		f.Page = 1
+	}
+	if f.PageSize <= 0 {
+		f.PageSize = 50
+	}
+	q := r.db.WithContext(ctx).Model(&domain.WhatsAppMessage{})
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if f.OrderID != nil {
+		q = q.Where("order_id = ?", *f.OrderID)
+	}
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var list []domain.WhatsAppMessage
+	if err := q.Order("created_at desc").Offset((f.Page - 1) * f.PageSize).Limit(f.PageSize).Find(&list).Error; err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
@@ -2,9 +2,11 @@ package localfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -20,15 +22,43 @@ func (s *Storage) SaveImage(ctx context.Context, filename string, data []byte) (
 	return s.save(ctx, "images", filename, data)
 }
 
+// ReceiptsDir es el subdirectorio de comprobantes de pago; el servidor no lo expone en /uploads/.
+const ReceiptsDir = "receipts"
+
+func (s *Storage) SaveReceipt(ctx context.Context, filename string, data []byte) (string, error) {
+	return s.save(ctx, ReceiptsDir, filepath.Base(filename), data)
+}
+
+// ReadReceipt lee un comprobante guardado con SaveReceipt; rechaza rutas fuera de su directorio.
+func (s *Storage) ReadReceipt(ctx context.Context, path string) ([]byte, error) {
+	_ = ctx
+	dir, err := filepath.Abs(filepath.Join(s.base, ReceiptsDir))
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(abs, dir+string(os.PathSeparator)) {
+		return nil, errors.New("ruta de comprobante inválida")
+	}
+	return os.ReadFile(abs)
+}
+
 func (s *Storage) save(ctx context.Context, sub, filename string, data []byte) (string, error) {
 	_ = ctx
 	dir := filepath.Join(s.base, sub)
+	perm := os.FileMode(0644)
+	if sub == ReceiptsDir {
+		perm = 0600
+	}
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return "", err
 	}
 	fname := fmt.Sprintf("%d-%s", time.Now().UnixNano(), filename)
 	path := filepath.Join(dir, fname)
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := os.WriteFile(path, data, perm); err != nil {
 		return "", err
 	}
 	return path, nil
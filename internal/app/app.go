@@ -7,14 +7,18 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"gorm.io/gorm"
 
+	"github.com/phenrril/tienda3d/internal/adapters/documents/pdf"
 	"github.com/phenrril/tienda3d/internal/adapters/email/smtp"
 	"github.com/phenrril/tienda3d/internal/adapters/httpserver"
+	"github.com/phenrril/tienda3d/internal/adapters/payments/fake"
 	"github.com/phenrril/tienda3d/internal/adapters/payments/mercadopago"
 	"github.com/phenrril/tienda3d/internal/adapters/repo/postgres"
 	"github.com/phenrril/tienda3d/internal/adapters/storage/localfs"
@@ -30,6 +34,20 @@ type App struct {
 	QuoteUC          *usecase.QuoteUC
 	OrderUC          *usecase.OrderUC
 	PaymentUC        *usecase.PaymentUC
+	RefundUC         *usecase.RefundUC
+	CryptoUC         *usecase.CryptoUC
+	ReceiptUC        *usecase.ReceiptUC
+	ExpiryUC         *usecase.ExpiryUC
+	InvoiceUC        *usecase.InvoiceUC
+	RMAUC            *usecase.RMAUC
+	RepairUC         *usecase.RepairUC
+	WarrantyUC       *usecase.WarrantyUC
+	PreOrderUC       *usecase.PreOrderUC
+	NotificationUC   *usecase.NotificationUC
+	WebhookUC        *usecase.WebhookUC
+	WhatsAppUC       *usecase.WhatsAppUC
+	AccountUC        *usecase.AccountUC
+	LoginLinkUC      *usecase.LoginLinkUC
 	ModelRepo        domain.UploadedModelRepo
 	ShippingMethod   string  `gorm:"size:30"`
 	ShippingCost     float64 `gorm:"type:decimal(12,2)"`
@@ -39,12 +57,27 @@ type App struct {
 	StarProduct      domain.StarProductRepo
 	OAuthConfig      *oauth2.Config
 	EmailService     domain.EmailService
+	Documents        domain.OrderDocuments
 }
 
 func NewApp(db *gorm.DB) (*App, error) {
 
 	prodRepo := postgres.NewProductRepo(db)
 	orderRepo := postgres.NewOrderRepo(db)
+	refundRepo := postgres.NewRefundRepo(db)
+	paymentRepo := postgres.NewPaymentRepo(db)
+	receiptRepo := postgres.NewReceiptRepo(db)
+	orderEventRepo := postgres.NewOrderEventRepo(db)
+	orderAuditRepo := postgres.NewOrderAuditRepo(db)
+	invoiceRepo := postgres.NewInvoiceRepo(db)
+	rmaRepo := postgres.NewRMARepo(db)
+	repairRepo := postgres.NewRepairRepo(db)
+	warrantyRepo := postgres.NewWarrantyRepo(db)
+	outboxRepo := postgres.NewOutboxRepo(db)
+	notificationRepo := postgres.NewNotificationRepo(db)
+	whatsAppLogRepo := postgres.NewWhatsAppLogRepo(db)
+	webhookRepo := postgres.NewWebhookRepo(db)
+	loginTokenRepo := postgres.NewLoginTokenRepo(db)
 	modelRepo := postgres.NewUploadedModelRepo(db)
 	custRepo := postgres.NewCustomerRepo(db)
 	featuredRepo := postgres.NewFeaturedProductRepo(db)
@@ -74,7 +107,19 @@ func NewApp(db *gorm.DB) (*App, error) {
 	if secretKey == "" {
 	}
 
-	payment := mercadopago.NewGateway(token)
+	var payment domain.PaymentGateway = mercadopago.NewGateway(token)
+	if strings.EqualFold(os.Getenv("PAYMENT_GATEWAY"), "fake") {
+		if appEnv == "production" || appEnv == "prod" {
+			log.Error().Msg("PAYMENT_GATEWAY=fake ignorado en producción; se usa MercadoPago")
+		} else {
+			base := os.Getenv("PUBLIC_BASE_URL")
+			if base == "" {
+				base = "http://localhost:8080"
+			}
+			payment = fake.NewGateway(base, os.Getenv("MP_WEBHOOK_SECRET"))
+			log.Warn().Str("base_url", base).Msg("usando gateway de pagos simulado (PAYMENT_GATEWAY=fake)")
+		}
+	}
 
 	var oauthCfg *oauth2.Config
 	googleID := os.Getenv("GOOGLE_CLIENT_ID")
@@ -95,11 +140,29 @@ func NewApp(db *gorm.DB) (*App, error) {
 
 	// Inicializar servicio de email
 	emailService := smtp.NewSMTPService()
+	docs := pdf.NewGenerator()
+	emailService.Documents = docs
 
 	app := &App{}
-	app.ProductUC = &usecase.ProductUC{Products: prodRepo}
-	app.OrderUC = &usecase.OrderUC{Orders: orderRepo, Products: prodRepo}
-	app.PaymentUC = &usecase.PaymentUC{Orders: orderRepo, Gateway: payment}
+	app.WebhookUC = newWebhookUC(webhookRepo, prodRepo)
+	app.ProductUC = &usecase.ProductUC{Products: prodRepo, Webhooks: app.WebhookUC}
+	app.NotificationUC = newNotificationUC(outboxRepo, orderRepo, receiptRepo, prodRepo, notificationRepo, emailService)
+	app.NotificationUC.Webhooks = app.WebhookUC
+	app.WhatsAppUC = newWhatsAppUC(whatsAppLogRepo)
+	app.NotificationUC.WhatsApp = app.WhatsAppUC
+	app.OrderUC = &usecase.OrderUC{Orders: orderRepo, Products: prodRepo, Events: orderEventRepo, Audit: orderAuditRepo, Notifications: app.NotificationUC}
+	app.PaymentUC = &usecase.PaymentUC{Orders: orderRepo, Payments: paymentRepo, Gateway: payment, Transitions: app.OrderUC, Notifications: app.NotificationUC, Products: prodRepo, Webhooks: app.WebhookUC}
+	app.RefundUC = &usecase.RefundUC{Orders: orderRepo, Refunds: refundRepo, Payments: paymentRepo, Products: prodRepo, Gateway: payment, Email: emailService, Transitions: app.OrderUC, Webhooks: app.WebhookUC}
+	app.CryptoUC = newCryptoUC(orderRepo, app.PaymentUC)
+	app.ReceiptUC = &usecase.ReceiptUC{Orders: orderRepo, Receipts: receiptRepo, Storage: storage, Payments: app.PaymentUC, Email: emailService, Notifications: app.NotificationUC}
+	app.ExpiryUC = newExpiryUC(app.OrderUC, receiptRepo, emailService)
+	app.InvoiceUC = newInvoiceUC(orderRepo, custRepo, invoiceRepo, docs)
+	app.RMAUC = &usecase.RMAUC{Orders: orderRepo, RMAs: rmaRepo, Products: prodRepo, Audit: orderAuditRepo, Email: emailService, Refunds: app.RefundUC, Transitions: app.OrderUC, Window: envDuration("RMA_WINDOW", 30*24*time.Hour), Webhooks: app.WebhookUC}
+	app.RepairUC = &usecase.RepairUC{Tickets: repairRepo, Products: prodRepo, Orders: orderRepo, Email: emailService, Transitions: app.OrderUC, Payments: app.PaymentUC, Crypto: app.CryptoUC, Webhooks: app.WebhookUC}
+	app.WarrantyUC = &usecase.WarrantyUC{Warranties: warrantyRepo, Orders: orderRepo, Products: prodRepo, Payments: paymentRepo, Events: orderEventRepo, Audit: orderAuditRepo, DefaultMonths: int(envUint("WARRANTY_MONTHS", 6))}
+	app.PreOrderUC = &usecase.PreOrderUC{Products: prodRepo, Orders: orderRepo, Payments: app.PaymentUC, Audit: orderAuditRepo, Email: emailService, DefaultDepositPct: float64(envUint("PREORDER_DEPOSIT_PCT", 30)), Webhooks: app.WebhookUC}
+	app.AccountUC = &usecase.AccountUC{Customers: custRepo, Orders: orderRepo, Events: orderEventRepo}
+	app.LoginLinkUC = newLoginLinkUC(loginTokenRepo, emailService, app.AccountUC)
 	app.DB = db
 	app.ModelRepo = modelRepo
 	app.Storage = storage
@@ -108,6 +171,7 @@ func NewApp(db *gorm.DB) (*App, error) {
 	app.StarProduct = starRepo
 	app.OAuthConfig = oauthCfg
 	app.EmailService = emailService
+	app.Documents = docs
 
 	funcMap := template.FuncMap{
 		"add": func(a, b int) int { return a + b },
@@ -258,12 +322,16 @@ func NewApp(db *gorm.DB) (*App, error) {
 }
 
 func (a *App) HTTPHandler() http.Handler {
-	return httpserver.New(a.Tmpl, a.ProductUC, a.QuoteUC, a.OrderUC, a.PaymentUC, a.ModelRepo, a.Storage, a.Customers, a.FeaturedProducts, a.StarProduct, a.OAuthConfig, a.EmailService)
+	return httpserver.New(a.Tmpl, a.ProductUC, a.QuoteUC, a.OrderUC, a.PaymentUC, a.RefundUC, a.ReceiptUC, a.CryptoUC, a.ExpiryUC, a.InvoiceUC, a.RMAUC, a.RepairUC, a.WarrantyUC, a.PreOrderUC, a.NotificationUC, a.WhatsAppUC, a.WebhookUC, a.AccountUC, a.LoginLinkUC, a.ModelRepo, a.Storage, a.Customers, a.FeaturedProducts, a.StarProduct, a.OAuthConfig, a.EmailService, a.Documents)
 }
 
 func (a *App) MigrateAndSeed() error {
 	if err := a.DB.AutoMigrate(
-		&domain.Product{}, &domain.Variant{}, &domain.Image{}, &domain.Order{}, &domain.OrderItem{}, &domain.UploadedModel{}, &domain.Quote{}, &domain.Page{}, &domain.Customer{}, &domain.FeaturedProduct{}, &domain.StarProduct{},
+		&domain.Product{}, &domain.Variant{}, &domain.Image{}, &domain.Order{}, &domain.OrderItem{}, &domain.UploadedModel{}, &domain.Quote{}, &domain.Page{}, &domain.Customer{}, &domain.CustomerAddress{}, &domain.FeaturedProduct{}, &domain.StarProduct{},
+		&domain.Refund{}, &domain.RefundLine{}, &domain.Payment{}, &domain.PaymentReceipt{}, &domain.OrderStatusEvent{}, &domain.OrderAuditEntry{}, &domain.Invoice{}, &domain.RMA{}, &domain.RMALine{},
+		&domain.RepairTicket{}, &domain.RepairPart{}, &domain.RepairEvent{}, &domain.WarrantyPolicy{}, &domain.Warranty{}, &domain.WarrantyClaim{},
+		&domain.OutboxMessage{}, &domain.NotificationTemplate{}, &domain.NotificationRule{}, &domain.WhatsAppMessage{},
+		&domain.WebhookEndpoint{}, &domain.WebhookDelivery{}, &domain.LoginToken{},
 	); err != nil {
 		return err
 	}
@@ -276,6 +344,32 @@ func (a *App) MigrateAndSeed() error {
 	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal_net DECIMAL(12,2) DEFAULT 0").Error
 	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS vat_amount DECIMAL(12,2) DEFAULT 0").Error
 	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_notes TEXT").Error
+	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS mp_payment_id VARCHAR(40)").Error
+	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS crypto_amount DECIMAL(18,6) DEFAULT 0").Error
+	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS crypto_rate DECIMAL(14,4) DEFAULT 0").Error
+	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS crypto_source VARCHAR(40)").Error
+	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS crypto_expires TIMESTAMPTZ").Error
+	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS crypto_tx_hash VARCHAR(80)").Error
+	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS reminder_sent_at TIMESTAMPTZ").Error
+	_ = a.DB.Exec("ALTER TABLE orders ADD COLUMN IF NOT EXISTS cancel_reason VARCHAR(255)").Error
+
+	// Órdenes pagadas antes de existir el ledger: un único movimiento aprobado por el total.
+	// gen_random_uuid() es nativa desde PostgreSQL 13 (antes, de la extensión pgcrypto).
+	if err := a.DB.Exec(`INSERT INTO payments (id, order_id, method, amount, currency, external_id, status, note, approved_at, created_at, updated_at)
+		SELECT gen_random_uuid(), o.id, COALESCE(o.payment_method, ''), o.total, 'ARS', COALESCE(o.mp_payment_id, ''), 'approved', 'backfill', o.updated_at, o.updated_at, o.updated_at
+		FROM orders o WHERE o.mp_status = 'approved' AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.order_id = o.id)`).Error; err != nil {
+		return fmt.Errorf("backfill del ledger de pagos: %w", err)
+	}
+
+	// Números de orden correlativos: contador por tienda, numeración de las órdenes previas por fecha de
+	// creación y el contador arrancando después de la última.
+	_ = a.DB.Exec("CREATE TABLE IF NOT EXISTS order_sequences (name VARCHAR(20) PRIMARY KEY, last BIGINT NOT NULL DEFAULT 0)").Error
+	_ = a.DB.Exec(`UPDATE orders o SET seq = n.rn + (SELECT COALESCE(MAX(seq), 0) FROM orders)
+		FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS rn FROM orders WHERE seq = 0) n
+		WHERE o.id = n.id`).Error
+	_ = a.DB.Exec(`INSERT INTO order_sequences (name, last) SELECT ?, COALESCE(MAX(seq), 0) FROM orders
+		ON CONFLICT (name) DO UPDATE SET last = GREATEST(order_sequences.last, EXCLUDED.last)`, domain.OrderNumberPrefix).Error
+	_ = a.DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_seq ON orders (seq) WHERE seq > 0").Error
 
 	_ = a.DB.Exec("CREATE INDEX IF NOT EXISTS idx_orders_payment_method ON orders(payment_method)").Error
 	_ = a.DB.Exec("CREATE INDEX IF NOT EXISTS idx_orders_customer_id ON orders(customer_id)").Error
@@ -290,6 +384,18 @@ func (a *App) MigrateAndSeed() error {
 
 	_ = a.DB.Exec("CREATE INDEX IF NOT EXISTS idx_order_items_variant_id ON order_items(variant_id)").Error
 
+	// Preventas asignadas antes de registrar el stock tomado por item: la asignación descontó todas sus
+	// unidades, salvo las que ya se repusieron con un reembolso.
+	_ = a.DB.Exec(`UPDATE order_items i SET stock_taken = i.qty FROM orders o
+		WHERE i.order_id = o.id AND o.allocated_at IS NOT NULL AND i.variant_id IS NOT NULL AND i.stock_taken = 0
+		AND NOT EXISTS (SELECT 1 FROM refund_lines l WHERE l.order_item_id = i.id AND l.restocked)`).Error
+
+	// Numeración de facturas: las rechazadas o descartadas liberan el número, así que la unicidad vale sólo
+	// para las pendientes y autorizadas.
+	_ = a.DB.Exec("DROP INDEX IF EXISTS idx_invoices_number").Error
+	_ = a.DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_number_active ON invoices (environment, type, point_of_sale, number)
+		WHERE status IN ('pending', 'authorized')`).Error
+
 	_ = a.DB.Exec("ALTER TABLE customers ADD COLUMN IF NOT EXISTS tax_id VARCHAR(30)").Error
 	_ = a.DB.Exec("ALTER TABLE customers ADD COLUMN IF NOT EXISTS tax_condition VARCHAR(4)").Error
 	_ = a.DB.Exec("ALTER TABLE customers ADD COLUMN IF NOT EXISTS price_list VARCHAR(40)").Error
@@ -0,0 +1,278 @@
+package app
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/adapters/email/smtp"
+	"github.com/phenrril/tienda3d/internal/adapters/invoicing/afip"
+	"github.com/phenrril/tienda3d/internal/adapters/invoicing/stub"
+	"github.com/phenrril/tienda3d/internal/adapters/notify/telegram"
+	"github.com/phenrril/tienda3d/internal/adapters/notify/webhook"
+	"github.com/phenrril/tienda3d/internal/adapters/notify/whatsapp"
+	"github.com/phenrril/tienda3d/internal/adapters/payments/bsc"
+	"github.com/phenrril/tienda3d/internal/adapters/pricing/criptoya"
+	"github.com/phenrril/tienda3d/internal/domain"
+	"github.com/phenrril/tienda3d/internal/usecase"
+)
+
+// StartBackground lanza los procesos periódicos de la aplicación. Se detienen al cancelar ctx.
+func (a *App) StartBackground(ctx context.Context) {
+	if every := envDuration("NOTIFY_INTERVAL", 30*time.Second); every > 0 && a.NotificationUC != nil {
+		log.Info().Dur("every", every).Int("max_attempts", a.NotificationUC.MaxAttempts).Msg("entrega de avisos (outbox) activa")
+		go a.NotificationUC.RunDispatcher(ctx, every)
+	}
+	if every := envDuration("WEBHOOK_INTERVAL", 30*time.Second); every > 0 && a.WebhookUC != nil {
+		log.Info().Dur("every", every).Int("max_attempts", a.WebhookUC.MaxAttempts).Msg("entrega de webhooks salientes activa")
+		go a.WebhookUC.RunDispatcher(ctx, every)
+	}
+	if every := envDuration("LOW_STOCK_INTERVAL", time.Hour); every > 0 && a.NotificationUC != nil {
+		log.Info().Dur("every", every).Int("threshold", a.NotificationUC.LowStockThreshold).Msg("aviso de stock bajo activo")
+		go a.NotificationUC.RunLowStockCheck(ctx, every)
+	}
+	if every := envDuration("MP_RECONCILE_INTERVAL", 15*time.Minute); every > 0 && a.PaymentUC != nil {
+		log.Info().Dur("every", every).Msg("conciliador de pagos MP activo")
+		go a.PaymentUC.RunReconciler(ctx, every)
+	}
+	if every := envDuration("CRYPTO_WATCH_INTERVAL", time.Minute); every > 0 && a.CryptoUC != nil && a.CryptoUC.Watcher != nil {
+		log.Info().Dur("every", every).Uint64("confirmations", a.CryptoUC.Confirmations).Msg("verificación on-chain de pagos cripto activa")
+		go a.CryptoUC.RunWatcher(ctx, every)
+	}
+	if every := envDuration("ORDER_EXPIRY_INTERVAL", 10*time.Minute); every > 0 && a.ExpiryUC != nil && len(a.ExpiryUC.Deadlines) > 0 {
+		log.Info().Dur("every", every).Msg("vencimiento de órdenes impagas activo")
+		go a.ExpiryUC.RunExpirer(ctx, every)
+	}
+	if every := envDuration("INVOICE_AUTO_INTERVAL", 15*time.Minute); every > 0 && a.InvoiceUC.Enabled() {
+		log.Info().Dur("every", every).Str("env", a.InvoiceUC.Issuer.Environment()).Msg("facturación electrónica automática activa")
+		go a.InvoiceUC.RunInvoicer(ctx, every)
+	}
+}
+
+// newInvoiceUC arma la facturación electrónica según INVOICE_ISSUER: "afip" (WSAA/WSFEv1 con el
+// certificado del contribuyente), "stub" (CAE inventado, sólo fuera de producción) o vacío (deshabilitada).
+func newInvoiceUC(orders domain.OrderRepo, customers domain.CustomerRepo, invoices domain.InvoiceRepo, docs domain.InvoiceDocuments) *usecase.InvoiceUC {
+	uc := &usecase.InvoiceUC{
+		Orders:          orders,
+		Customers:       customers,
+		Invoices:        invoices,
+		Documents:       docs,
+		SellerCUIT:      strings.NewReplacer("-", "", " ", "").Replace(os.Getenv("AFIP_CUIT")),
+		SellerCondition: strings.ToUpper(envOr("AFIP_TAX_CONDITION", domain.TaxConditionRI)),
+		PointOfSale:     int(envUint("AFIP_POINT_OF_SALE", 1)),
+	}
+	appEnv := strings.ToLower(os.Getenv("APP_ENV"))
+	switch strings.ToLower(os.Getenv("INVOICE_ISSUER")) {
+	case "afip":
+		client, err := afip.NewClient(afip.Config{
+			CUIT:       uc.SellerCUIT,
+			CertFile:   os.Getenv("AFIP_CERT"),
+			KeyFile:    os.Getenv("AFIP_KEY"),
+			Production: strings.EqualFold(os.Getenv("AFIP_PRODUCTION"), "true"),
+			TicketFile: os.Getenv("AFIP_TA_FILE"),
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("facturación AFIP deshabilitada")
+			return uc
+		}
+		uc.Issuer = client
+	case "stub":
+		if appEnv == "production" || appEnv == "prod" {
+			log.Error().Msg("INVOICE_ISSUER=stub ignorado en producción")
+			return uc
+		}
+		if uc.SellerCUIT == "" {
+			uc.SellerCUIT = "20111111112"
+		}
+		uc.Issuer = stub.NewIssuer()
+		log.Warn().Msg("usando emisor de facturas simulado (INVOICE_ISSUER=stub): los comprobantes no tienen validez fiscal")
+	}
+	return uc
+}
+
+// newExpiryUC arma los plazos de pago por método. Un plazo en "0" hace que ese método no venza.
+func newExpiryUC(orders *usecase.OrderUC, receipts domain.ReceiptRepo, email domain.EmailService) *usecase.ExpiryUC {
+	deadlines := map[string]time.Duration{}
+	for method, def := range map[string]time.Duration{
+		"transferencia": 48 * time.Hour,
+		"cripto":        24 * time.Hour,
+		"efectivo":      0,
+		"mercadopago":   0,
+	} {
+		if d := envDuration("PAYMENT_DEADLINE_"+strings.ToUpper(method), def); d > 0 {
+			deadlines[method] = d
+		}
+	}
+	return &usecase.ExpiryUC{
+		Orders:         orders.Orders,
+		Receipts:       receipts,
+		Email:          email,
+		Transitions:    orders,
+		Deadlines:      deadlines,
+		ReminderBefore: envDuration("PAYMENT_REMINDER_BEFORE", 6*time.Hour),
+	}
+}
+
+// newNotificationUC arma la entrega de avisos con los canales configurados. Mientras un evento no tenga
+// reglas cargadas en el admin, las órdenes, comprobantes y stock bajo van a TELEGRAM_CHAT_IDS o, sin
+// Telegram, a ORDER_NOTIFY_EMAIL, como antes de las reglas.
+func newNotificationUC(outbox domain.OutboxRepo, orders domain.OrderRepo, receipts domain.ReceiptRepo, products domain.ProductRepo, rules domain.NotificationRepo, email *smtp.SMTPService) *usecase.NotificationUC {
+	notifiers := map[string]domain.Notifier{domain.ChannelWebhook: webhook.NewNotifier()}
+	tgToken := strings.TrimSpace(os.Getenv("TELEGRAM_BOT_TOKEN"))
+	if tgToken != "" {
+		notifiers[domain.ChannelTelegram] = telegram.NewNotifier(tgToken)
+	}
+	if email.Enabled() {
+		notifiers[domain.ChannelEmail] = email
+	}
+	if tok, id := os.Getenv("WHATSAPP_TOKEN"), os.Getenv("WHATSAPP_PHONE_NUMBER_ID"); tok != "" && id != "" {
+		notifiers[domain.ChannelWhatsApp] = whatsapp.NewNotifier(tok, id)
+	}
+
+	var targets []domain.NotificationRule
+	rawIDs := os.Getenv("TELEGRAM_CHAT_IDS")
+	if strings.TrimSpace(rawIDs) == "" {
+		rawIDs = os.Getenv("TELEGRAM_CHAT_ID")
+	}
+	if tgToken != "" {
+		for _, id := range strings.Split(rawIDs, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				targets = append(targets, domain.NotificationRule{Channel: domain.ChannelTelegram, Target: id, Active: true})
+			}
+		}
+	}
+	if len(targets) == 0 && email.Enabled() {
+		targets = append(targets, domain.NotificationRule{Channel: domain.ChannelEmail, Target: envOr("ORDER_NOTIFY_EMAIL", "ventas@newmobile.com.ar"), Active: true})
+	}
+	var routes []domain.NotificationRule
+	for _, ev := range []string{domain.EventOrderCreated, domain.EventOrderPaid, domain.EventReceiptReceived, domain.EventLowStock} {
+		for _, t := range targets {
+			t.Event = ev
+			routes = append(routes, t)
+		}
+	}
+
+	baseURL := strings.TrimRight(envOr("PUBLIC_BASE_URL", envOr("BASE_URL", "http://localhost:8080")), "/")
+	return &usecase.NotificationUC{
+		Outbox:            outbox,
+		Orders:            orders,
+		Receipts:          receipts,
+		Products:          products,
+		Templates:         rules,
+		Notifiers:         notifiers,
+		DefaultRoutes:     routes,
+		Email:             email,
+		MaxAttempts:       int(envUint("NOTIFY_MAX_ATTEMPTS", 10)),
+		LowStockThreshold: int(envUint("LOW_STOCK_THRESHOLD", 2)),
+		StoreName:         envOr("STORE_NAME", "NewMobile"),
+		BaseURL:           baseURL,
+	}
+}
+
+// newWebhookUC arma los webhooks salientes. Los webhooks se cargan desde el admin; sin ninguno los
+// eventos no generan entregas.
+func newWebhookUC(webhooks domain.WebhookRepo, products domain.ProductRepo) *usecase.WebhookUC {
+	return &usecase.WebhookUC{
+		Webhooks:    webhooks,
+		Sender:      webhook.NewNotifier(),
+		Products:    products,
+		MaxAttempts: int(envUint("WEBHOOK_MAX_ATTEMPTS", 8)),
+	}
+}
+
+// newLoginLinkUC arma el ingreso por email. Los links se firman con SESSION_KEY, la misma clave que la
+// cookie de sesión que abren.
+func newLoginLinkUC(tokens domain.LoginTokenRepo, email domain.EmailService, accounts *usecase.AccountUC) *usecase.LoginLinkUC {
+	return &usecase.LoginLinkUC{
+		Tokens:      tokens,
+		Email:       email,
+		Accounts:    accounts,
+		BaseURL:     strings.TrimRight(envOr("PUBLIC_BASE_URL", envOr("BASE_URL", "http://localhost:8080")), "/"),
+		Secret:      []byte(envOr("SESSION_KEY", "dev-insecure")),
+		TTL:         envDuration("LOGIN_LINK_TTL", 15*time.Minute),
+		MaxPerEmail: int(envUint("LOGIN_LINK_MAX_PER_EMAIL", 3)),
+		MaxPerIP:    int(envUint("LOGIN_LINK_MAX_PER_IP", 10)),
+		Window:      envDuration("LOGIN_LINK_WINDOW", time.Hour),
+	}
+}
+
+// newWhatsAppUC arma los mensajes de WhatsApp a los compradores. Sin WHATSAPP_TOKEN y
+// WHATSAPP_PHONE_NUMBER_ID no se manda nada y los mensajes quedan registrados como omitidos.
+func newWhatsAppUC(messages domain.WhatsAppLogRepo) *usecase.WhatsAppUC {
+	uc := &usecase.WhatsAppUC{
+		Log: messages,
+		Templates: map[string]string{
+			domain.WhatsAppOrderReceived:    envOr("WHATSAPP_TEMPLATE_ORDER_RECEIVED", "pedido_recibido"),
+			domain.WhatsAppPaymentConfirmed: envOr("WHATSAPP_TEMPLATE_PAYMENT_CONFIRMED", "pago_confirmado"),
+			domain.WhatsAppReadyForPickup:   envOr("WHATSAPP_TEMPLATE_READY_FOR_PICKUP", "listo_para_retirar"),
+			domain.WhatsAppShipped:          envOr("WHATSAPP_TEMPLATE_SHIPPED", "pedido_enviado"),
+		},
+		Lang: envOr("WHATSAPP_TEMPLATE_LANG", "es_AR"),
+	}
+	if tok, id := os.Getenv("WHATSAPP_TOKEN"), os.Getenv("WHATSAPP_PHONE_NUMBER_ID"); tok != "" && id != "" {
+		uc.Sender = whatsapp.NewNotifier(tok, id)
+	}
+	return uc
+}
+
+// newCryptoUC arma la cotización y verificación de pagos USDT/USDC en BSC. Sin BSC_RPC_URL sólo se
+// cotizan los montos esperados y la confirmación sigue siendo manual.
+func newCryptoUC(orders domain.OrderRepo, payments *usecase.PaymentUC) *usecase.CryptoUC {
+	uc := &usecase.CryptoUC{
+		Orders:        orders,
+		Payments:      payments,
+		Rates:         criptoya.NewProvider(envDuration("CRYPTO_RATE_CACHE_TTL", time.Minute), 30*time.Minute),
+		QuoteTTL:      envDuration("CRYPTO_QUOTE_TTL", 30*time.Minute),
+		Wallet:        envOr("CRYPTO_WALLET", "0xe1d34cd635b31144fc7b26c586e3d6decbbfbc5a"),
+		Confirmations: envUint("BSC_CONFIRMATIONS", 15),
+		StartLookback: envUint("BSC_START_LOOKBACK_BLOCKS", 20000),
+	}
+	if rpc := os.Getenv("BSC_RPC_URL"); rpc != "" {
+		uc.Watcher = bsc.NewWatcher(rpc, uc.Wallet, []bsc.Token{
+			{Symbol: "USDT", Contract: envOr("BSC_USDT_CONTRACT", bsc.DefaultUSDTContract), Decimals: 18},
+			{Symbol: "USDC", Contract: envOr("BSC_USDC_CONTRACT", bsc.DefaultUSDCContract), Decimals: 18},
+		})
+	}
+	return uc
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envUint(key string, def uint64) uint64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		log.Warn().Str("key", key).Str("value", raw).Msg("entero inválido, usando valor por defecto")
+		return def
+	}
+	return v
+}
+
+// envDuration lee una duración Go (p.ej. "15m", "2h") desde el entorno; "0" deshabilita.
+func envDuration(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	if raw == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Warn().Str("key", key).Str("value", raw).Msg("duración inválida, usando valor por defecto")
+		return def
+	}
+	return d
+}
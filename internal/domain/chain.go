@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// ChainTransfer es una transferencia BEP-20 (USDT/USDC) recibida en la wallet de la tienda.
+type ChainTransfer struct {
+	TxHash      string
+	LogIndex    uint64
+	Token       string // "USDT" o "USDC"
+	From        string
+	To          string
+	Amount      float64 // en unidades del token (ya dividido por sus decimales)
+	BlockNumber uint64
+}
+
+// CryptoRate es la cotización en ARS de una stablecoin.
+type CryptoRate struct {
+	Symbol    string
+	ARS       float64
+	Source    string
+	FetchedAt time.Time
+	Stale     bool // se sirvió el último valor conocido porque los proveedores fallaron
+}
@@ -0,0 +1,38 @@
+package domain
+
+import "strings"
+
+// colorNames traduce los códigos hex más comunes del selector de colores a un nombre.
+var colorNames = map[string]string{
+	"#111827": "Negro",
+	"#000000": "Negro",
+	"#ffffff": "Blanco",
+	"#ff0000": "Rojo",
+	"#dc2626": "Rojo",
+	"#10b981": "Verde",
+	"#3b82f6": "Azul",
+	"#6366f1": "Violeta",
+	"#f59e0b": "Amarillo",
+	"#ef4444": "Rojo",
+	"#8b5cf6": "Violeta",
+	"#ec4899": "Rosa",
+	"#14b8a6": "Turquesa",
+	"#f472b6": "Rosa",
+	"#fcd34d": "Amarillo",
+	"#a3e635": "Lima",
+	"#334155": "Gris oscuro",
+	"#64748b": "Gris",
+}
+
+// ColorName transforma códigos hex conocidos en nombres simples y limpia espacios. Para hex que no
+// matchean, deja el valor original.
+func ColorName(c string) string {
+	s := strings.TrimSpace(c)
+	if s == "" {
+		return s
+	}
+	if name, ok := colorNames[strings.ToLower(s)]; ok {
+		return name
+	}
+	return s
+}
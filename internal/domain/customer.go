@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,8 +12,55 @@ type Customer struct {
 	Email        string    `gorm:"size:140;uniqueIndex"`
 	Name         string    `gorm:"size:140"`
 	Phone        string    `gorm:"size:60"`
+	DNI          string    `gorm:"size:20"`
 	TaxID        string    `gorm:"size:30"`
 	TaxCondition string    `gorm:"size:4"` // RI, MT, EX, CF
 	PriceList    string    `gorm:"size:40"`
-	CreatedAt    time.Time
+	// LastLoginAt es el último ingreso a "Mi cuenta"; nil si nunca entró (cliente creado en un checkout).
+	LastLoginAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TaxConditionLabel nombra la condición frente al IVA cargada, o "" si no tiene.
+func (c Customer) TaxConditionLabel() string {
+	if c.TaxCondition == "" {
+		return ""
+	}
+	return TaxConditionLabel(c.TaxCondition)
+}
+
+// CustomerAddress es una dirección de envío guardada en la cuenta del cliente.
+type CustomerAddress struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	CustomerID uuid.UUID `gorm:"type:uuid;index"`
+	// Label es el nombre que le da el cliente ("Casa", "Trabajo").
+	Label      string `gorm:"size:60"`
+	Address    string `gorm:"size:255"`
+	PostalCode string `gorm:"size:20"`
+	Province   string `gorm:"size:80"`
+	Notes      string `gorm:"size:255"`
+	IsDefault  bool   `gorm:"not null;default:false"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (CustomerAddress) TableName() string { return "customer_addresses" }
+
+// SameAs indica si la dirección es la misma que otra (sin distinguir mayúsculas ni espacios).
+func (a CustomerAddress) SameAs(address, postalCode string) bool {
+	return normalizeAddress(a.Address) == normalizeAddress(address) && normalizeAddress(a.PostalCode) == normalizeAddress(postalCode)
+}
+
+func normalizeAddress(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// CustomerProfile son los datos que el cliente edita desde "Mi cuenta".
+type CustomerProfile struct {
+	Name         string
+	Phone        string
+	DNI          string
+	TaxID        string
+	TaxCondition string
 }
@@ -0,0 +1,306 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Condiciones frente al IVA (Customer.TaxCondition y la del emisor).
+const (
+	TaxConditionRI = "RI" // responsable inscripto
+	TaxConditionMT = "MT" // monotributo
+	TaxConditionEX = "EX" // exento
+	TaxConditionCF = "CF" // consumidor final
+)
+
+// TaxConditionLabel es el nombre de la condición frente al IVA tal como se imprime en la factura.
+func TaxConditionLabel(c string) string {
+	switch c {
+	case TaxConditionRI:
+		return "IVA Responsable Inscripto"
+	case TaxConditionMT:
+		return "Responsable Monotributo"
+	case TaxConditionEX:
+		return "IVA Sujeto Exento"
+	}
+	return "Consumidor Final"
+}
+
+// TaxConditionAFIPCode es el código de condición IVA del receptor que pide WSFEv1 (CondicionIVAReceptorId).
+func TaxConditionAFIPCode(c string) int {
+	switch c {
+	case TaxConditionRI:
+		return 1
+	case TaxConditionEX:
+		return 4
+	case TaxConditionMT:
+		return 6
+	}
+	return 5
+}
+
+// ErrInvoiceNotAllowed indica que la orden no se puede facturar (impaga, sin datos del comprador, etc.).
+var ErrInvoiceNotAllowed = errors.New("no se puede facturar la orden")
+
+// ErrInvoiceRejected indica que el emisor rechazó el comprobante en forma definitiva (Resultado "R" de
+// AFIP): reintentar lo mismo no sirve. Los errores de conexión o del servicio no lo usan.
+var ErrInvoiceRejected = errors.New("el emisor rechazó el comprobante")
+
+type InvoiceType string
+
+const (
+	InvoiceTypeA InvoiceType = "A"
+	InvoiceTypeB InvoiceType = "B"
+	InvoiceTypeC InvoiceType = "C"
+)
+
+// AFIPCode es el tipo de comprobante de AFIP (CbteTipo) de la factura.
+func (t InvoiceType) AFIPCode() int {
+	switch t {
+	case InvoiceTypeA:
+		return 1
+	case InvoiceTypeB:
+		return 6
+	case InvoiceTypeC:
+		return 11
+	}
+	return 0
+}
+
+// DiscriminatesVAT indica si la factura muestra el IVA por separado (sólo la A).
+func (t InvoiceType) DiscriminatesVAT() bool { return t == InvoiceTypeA }
+
+// InvoiceTypeFor elige la letra de la factura según la condición del emisor y la del comprador: un
+// responsable inscripto emite A a inscriptos y monotributistas y B al resto; monotributistas y exentos
+// emiten siempre C.
+func InvoiceTypeFor(seller, buyer string) (InvoiceType, error) {
+	switch seller {
+	case TaxConditionRI:
+		if buyer == TaxConditionRI || buyer == TaxConditionMT {
+			return InvoiceTypeA, nil
+		}
+		return InvoiceTypeB, nil
+	case TaxConditionMT, TaxConditionEX:
+		return InvoiceTypeC, nil
+	}
+	return "", fmt.Errorf("condición frente al IVA del emisor inválida: %q", seller)
+}
+
+// Tipos de documento del receptor (DocTipo de AFIP).
+const (
+	DocTypeCUIT = 80
+	DocTypeDNI  = 96
+	DocTypeNone = 99 // consumidor final sin identificar
+)
+
+// VATRateAFIPID devuelve el id de alícuota de IVA de AFIP para un porcentaje.
+func VATRateAFIPID(rate float64) (int, bool) {
+	switch math.Round(rate*10) / 10 {
+	case 0:
+		return 3, true
+	case 2.5:
+		return 9, true
+	case 5:
+		return 8, true
+	case 10.5:
+		return 4, true
+	case 21:
+		return 5, true
+	case 27:
+		return 6, true
+	}
+	return 0, false
+}
+
+// InvoiceVAT es la base imponible y el IVA de una alícuota.
+type InvoiceVAT struct {
+	Rate   float64 `json:"rate"`
+	Base   float64 `json:"base"`
+	Amount float64 `json:"amount"`
+}
+
+// shippingVATRate es la alícuota con la que se factura el envío.
+const shippingVATRate = 21.0
+
+// InvoiceAmounts reparte el total cobrado de la orden por alícuota de IVA. Los precios de la tienda
+// incluyen IVA: el descuento por medio de pago se prorratea y de cada bruto se separa neto e IVA.
+func InvoiceAmounts(o *Order) (lines []InvoiceVAT, net, vat float64) {
+	gross := map[float64]float64{}
+	var rates []float64
+	addGross := func(rate, v float64) {
+		if v == 0 {
+			return
+		}
+		if _, ok := gross[rate]; !ok {
+			rates = append(rates, rate)
+		}
+		gross[rate] += v
+	}
+	for _, it := range o.Items {
+		addGross(it.VATRate, it.Subtotal())
+	}
+	addGross(shippingVATRate, o.ShippingCost)
+
+	base := o.ItemsSubtotal() + o.ShippingCost
+	factor := 1.0
+	if base > 0 {
+		factor = o.Total / base
+	}
+	remaining := roundCents(o.Total)
+	for i, rate := range rates {
+		g := roundCents(gross[rate] * factor)
+		if i == len(rates)-1 {
+			// La última alícuota absorbe el redondeo para que la suma dé exactamente el total.
+			g = remaining
+		}
+		remaining = roundCents(remaining - g)
+		n := roundCents(g / (1 + rate/100))
+		lines = append(lines, InvoiceVAT{Rate: rate, Base: n, Amount: roundCents(g - n)})
+		net += n
+		vat += g - n
+	}
+	return lines, roundCents(net), roundCents(vat)
+}
+
+func roundCents(v float64) float64 { return math.Round(v*100) / 100 }
+
+// InvoiceRequest es lo que se le pide autorizar al emisor de comprobantes (un comprobante por vez).
+type InvoiceRequest struct {
+	Type           InvoiceType
+	PointOfSale    int
+	Number         int64
+	Date           time.Time
+	DocType        int
+	DocNumber      string
+	BuyerCondition string
+	Net            float64
+	VAT            float64
+	Total          float64
+	VATLines       []InvoiceVAT
+}
+
+// InvoiceAuthorization es la respuesta del emisor: el CAE y su vencimiento.
+type InvoiceAuthorization struct {
+	CAE        string
+	CAEExpires time.Time
+	// Observations son las observaciones que AFIP devuelve aun aprobando el comprobante.
+	Observations string
+	// Total es el importe del comprobante autorizado; lo informa InvoiceIssuer.Lookup.
+	Total float64
+}
+
+// Estados de una factura: el número se reserva guardándola pendiente antes de pedir el CAE, así un corte
+// a mitad de camino se recupera consultando ese número en vez de emitir otro comprobante. Una rechazada
+// por el emisor o descartada por el admin libera su número para la próxima factura.
+const (
+	InvoiceStatusPending    = "pending"
+	InvoiceStatusAuthorized = "authorized"
+	InvoiceStatusRejected   = "rejected"
+	InvoiceStatusDiscarded  = "discarded"
+)
+
+// Invoice es una factura electrónica para una orden: pendiente con el número reservado, autorizada con
+// CAE, o rechazada/descartada (Observations guarda el motivo). El número es único entre las pendientes y
+// autorizadas de cada entorno, tipo y punto de venta (índice parcial idx_invoices_number_active).
+type Invoice struct {
+	ID      uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OrderID uuid.UUID `gorm:"type:uuid;index"`
+	Status  string    `gorm:"size:12;not null;default:authorized"`
+	// Environment distingue produccion, homologacion y stub: la numeración es propia de cada uno.
+	Environment     string      `gorm:"size:20"`
+	Type            InvoiceType `gorm:"type:varchar(2)"`
+	PointOfSale     int
+	Number          int64
+	IssuerCUIT      string       `gorm:"size:11"`
+	IssuerCondition string       `gorm:"size:4"`
+	DocType         int          `gorm:"not null"`
+	DocNumber       string       `gorm:"size:20"`
+	BuyerName       string       `gorm:"size:140"`
+	BuyerAddress    string       `gorm:"size:255"`
+	BuyerCondition  string       `gorm:"size:4"`
+	Net             float64      `gorm:"type:decimal(12,2)"`
+	VAT             float64      `gorm:"type:decimal(12,2)"`
+	Total           float64      `gorm:"type:decimal(12,2)"`
+	VATLines        []InvoiceVAT `gorm:"serializer:json;type:text"`
+	CAE             string       `gorm:"size:20"`
+	CAEExpires      time.Time
+	Observations    string `gorm:"type:text"`
+	QRData          string `gorm:"type:text"` // URL del QR de AFIP
+	IssuedAt        time.Time
+	CreatedAt       time.Time
+}
+
+// Authorized indica si la factura ya tiene CAE.
+func (inv *Invoice) Authorized() bool { return inv.Status == InvoiceStatusAuthorized }
+
+// Pending indica si la factura tiene el número reservado y todavía no el CAE.
+func (inv *Invoice) Pending() bool { return inv.Status == InvoiceStatusPending }
+
+// Voided indica si la factura quedó rechazada o descartada: su número ya no está reservado.
+func (inv *Invoice) Voided() bool {
+	return inv.Status == InvoiceStatusRejected || inv.Status == InvoiceStatusDiscarded
+}
+
+// Request arma el pedido de autorización del comprobante, fechado en IssuedAt.
+func (inv *Invoice) Request() InvoiceRequest {
+	return InvoiceRequest{
+		Type:           inv.Type,
+		PointOfSale:    inv.PointOfSale,
+		Number:         inv.Number,
+		Date:           inv.IssuedAt,
+		DocType:        inv.DocType,
+		DocNumber:      inv.DocNumber,
+		BuyerCondition: inv.BuyerCondition,
+		Net:            inv.Net,
+		VAT:            inv.VAT,
+		Total:          inv.Total,
+		VATLines:       inv.VATLines,
+	}
+}
+
+// FullNumber es el número de comprobante como se imprime: "B 0001-00000123".
+func (inv *Invoice) FullNumber() string {
+	return fmt.Sprintf("%s %04d-%08d", inv.Type, inv.PointOfSale, inv.Number)
+}
+
+// AFIPQRURL arma la URL del código QR que exige AFIP (RG 4291) para los comprobantes electrónicos.
+func (inv *Invoice) AFIPQRURL() string {
+	cuit, _ := strconv.ParseInt(inv.IssuerCUIT, 10, 64)
+	doc, _ := strconv.ParseInt(OnlyDigits(inv.DocNumber), 10, 64)
+	cae, _ := strconv.ParseInt(inv.CAE, 10, 64)
+	payload, _ := json.Marshal(map[string]any{
+		"ver":        1,
+		"fecha":      inv.IssuedAt.Format("2006-01-02"),
+		"cuit":       cuit,
+		"ptoVta":     inv.PointOfSale,
+		"tipoCmp":    inv.Type.AFIPCode(),
+		"nroCmp":     inv.Number,
+		"importe":    inv.Total,
+		"moneda":     "PES",
+		"ctz":        1,
+		"tipoDocRec": inv.DocType,
+		"nroDocRec":  doc,
+		"tipoCodAut": "E",
+		"codAut":     cae,
+	})
+	return "https://www.afip.gob.ar/fe/qr/?p=" + base64.StdEncoding.EncodeToString(payload)
+}
+
+// OnlyDigits deja sólo los dígitos de s (DNI, CUIT, IMEI o teléfono escritos con separadores).
+func OnlyDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
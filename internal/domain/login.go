@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrLoginLinkInvalid es un link de ingreso inexistente, vencido o ya usado; no se distingue cuál
+	// para no dar pistas.
+	ErrLoginLinkInvalid = errors.New("el link de ingreso no es válido o ya venció")
+	// ErrLoginRateLimited indica que se pidieron demasiados links para el email o desde la IP.
+	ErrLoginRateLimited = errors.New("pediste demasiados links de ingreso; esperá un rato y volvé a probar")
+)
+
+// LoginToken es un link de ingreso por email. Sólo se guarda el hash del token: quien lea la tabla no
+// puede armar el link. Se usa una sola vez y vence a los pocos minutos.
+type LoginToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Email     string    `gorm:"size:140;index"`
+	TokenHash string    `gorm:"size:64;uniqueIndex"`
+	// IP es desde donde se pidió el link, para limitar los pedidos por IP.
+	IP string `gorm:"size:64;index"`
+	// Next es la página a la que se vuelve después de ingresar.
+	Next      string    `gorm:"size:255"`
+	ExpiresAt time.Time `gorm:"index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (LoginToken) TableName() string { return "login_tokens" }
+
+// NewLoginToken arma un link de ingreso para el email y devuelve el token en claro, que sólo viaja en
+// el email.
+func NewLoginToken(email, ip, next string, now time.Time, ttl time.Duration) (LoginToken, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return LoginToken{}, "", errors.New("no se pudo generar el link de ingreso")
+	}
+	raw := base64.RawURLEncoding.EncodeToString(b)
+	return LoginToken{
+		ID:        uuid.New(),
+		Email:     email,
+		TokenHash: HashLoginToken(raw),
+		IP:        ip,
+		Next:      next,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}, raw, nil
+}
+
+// HashLoginToken es el hash con el que se guarda y se busca un token de ingreso.
+func HashLoginToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,173 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Eventos que generan avisos al staff. Cada uno tiene su plantilla y sus reglas de envío.
+const (
+	EventOrderCreated    = "order_created"
+	EventOrderPaid       = "order_paid"
+	EventOrderShipped    = "order_shipped"
+	EventOrderCancelled  = "order_cancelled"
+	EventReceiptReceived = "receipt_received"
+	EventLowStock        = "low_stock"
+)
+
+// NotificationEvents son los eventos en el orden en que se muestran en el admin.
+var NotificationEvents = []string{EventOrderCreated, EventOrderPaid, EventOrderShipped, EventOrderCancelled, EventReceiptReceived, EventLowStock}
+
+// NotificationEventLabel nombra el evento para el admin.
+func NotificationEventLabel(event string) string {
+	switch event {
+	case EventOrderCreated:
+		return "Orden creada"
+	case EventOrderPaid:
+		return "Orden pagada"
+	case EventOrderShipped:
+		return "Orden despachada"
+	case EventOrderCancelled:
+		return "Orden cancelada"
+	case EventReceiptReceived:
+		return "Comprobante recibido"
+	case EventLowStock:
+		return "Stock bajo"
+	}
+	return event
+}
+
+// ValidNotificationEvent indica si el evento existe.
+func ValidNotificationEvent(event string) bool {
+	for _, e := range NotificationEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Canales por los que se envían los avisos.
+const (
+	ChannelTelegram = "telegram"
+	ChannelEmail    = "email"
+	ChannelWhatsApp = "whatsapp"
+	ChannelWebhook  = "webhook"
+)
+
+var NotificationChannels = []string{ChannelTelegram, ChannelEmail, ChannelWhatsApp, ChannelWebhook}
+
+// NotificationChannelLabel nombra el canal y lo que se carga como destino.
+func NotificationChannelLabel(channel string) string {
+	switch channel {
+	case ChannelTelegram:
+		return "Telegram (chat ID)"
+	case ChannelEmail:
+		return "Email"
+	case ChannelWhatsApp:
+		return "WhatsApp (teléfono)"
+	case ChannelWebhook:
+		return "Webhook (URL)"
+	}
+	return channel
+}
+
+// NormalizeNotificationTarget valida el destino de una regla según el canal y lo devuelve limpio: chat ID
+// (o @canal) de Telegram, dirección de email, teléfono con código de país o URL http(s).
+func NormalizeNotificationTarget(channel, target string) (string, error) {
+	t := strings.TrimSpace(target)
+	if t == "" {
+		return "", errors.New("falta el destino")
+	}
+	switch channel {
+	case ChannelTelegram:
+		if strings.HasPrefix(t, "@") && len(t) > 1 {
+			return t, nil
+		}
+		if d := strings.TrimPrefix(t, "-"); d != "" && strings.Trim(d, "0123456789") == "" {
+			return t, nil
+		}
+		return "", errors.New("el destino de Telegram es un chat ID numérico o @canal")
+	case ChannelEmail:
+		a, err := mail.ParseAddress(t)
+		if err != nil {
+			return "", errors.New("email inválido")
+		}
+		return a.Address, nil
+	case ChannelWhatsApp:
+		d := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			if r == '+' || r == ' ' || r == '-' || r == '(' || r == ')' {
+				return -1
+			}
+			return 'x'
+		}, t)
+		if strings.Contains(d, "x") || len(d) < 8 || len(d) > 15 {
+			return "", errors.New("el teléfono de WhatsApp va con código de país, p.ej. +5491155555555")
+		}
+		return d, nil
+	case ChannelWebhook:
+		u, err := url.Parse(t)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", errors.New("la URL del webhook tiene que ser http(s)://…")
+		}
+		return u.String(), nil
+	}
+	return "", errors.New("canal desconocido")
+}
+
+// NotificationTemplate es la plantilla (text/template) de un evento. Subject sólo se usa en el email;
+// Body es el texto de todos los canales. Sin plantilla guardada se usa la predeterminada.
+type NotificationTemplate struct {
+	Event     string `gorm:"size:40;primaryKey"`
+	Subject   string `gorm:"size:200"`
+	Body      string `gorm:"type:text"`
+	UpdatedAt time.Time
+}
+
+// NotificationRule manda los avisos de un evento a un destino de un canal.
+type NotificationRule struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Event     string    `gorm:"size:40;index"`
+	Channel   string    `gorm:"size:20"`
+	Target    string    `gorm:"size:300"`
+	Active    bool      `gorm:"not null;default:true"`
+	CreatedAt time.Time
+}
+
+// Key identifica el destino de la regla ("canal:destino").
+func (r NotificationRule) Key() string { return r.Channel + ":" + r.Target }
+
+func (r NotificationRule) EventLabel() string   { return NotificationEventLabel(r.Event) }
+func (r NotificationRule) ChannelLabel() string { return NotificationChannelLabel(r.Channel) }
+
+// NotificationMessage es un aviso ya armado para un canal. Data son los datos del evento para los canales
+// que los mandan estructurados (webhook).
+type NotificationMessage struct {
+	Event   string
+	Subject string
+	Text    string
+	Data    map[string]any
+}
+
+// Notifier envía avisos por un canal.
+type Notifier interface {
+	Channel() string
+	Send(ctx context.Context, target string, msg NotificationMessage) error
+}
+
+// LowStockItem es una variante con poco stock al momento de detectarla.
+type LowStockItem struct {
+	Product string `json:"product"`
+	Color   string `json:"color,omitempty"`
+	SKU     string `json:"sku,omitempty"`
+	Stock   int    `json:"stock"`
+}
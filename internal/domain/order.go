@@ -1,6 +1,10 @@
 package domain
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,22 +20,30 @@ const (
 	OrderStatusFinished     OrderStatus = "finished"
 	OrderStatusShipped      OrderStatus = "shipped"
 	OrderStatusCancelled    OrderStatus = "cancelled"
+	OrderStatusPartRefunded OrderStatus = "partially_refunded"
+	OrderStatusRefunded     OrderStatus = "refunded"
 )
 
 type Order struct {
 	ID             uuid.UUID   `gorm:"type:uuid;primaryKey"`
+	Seq            int64       `gorm:"not null;default:0"` // número correlativo de la tienda; ver Number
 	Status         OrderStatus `gorm:"type:varchar(30);index"`
 	Items          []OrderItem
-	Email          string     `gorm:"size:140"`
-	Name           string     `gorm:"size:140"`
-	Phone          string     `gorm:"size:50"`
-	DNI            string     `gorm:"size:30"`
-	Address        string     `gorm:"size:255"`
-	PostalCode     string     `gorm:"size:20"`
-	Province       string     `gorm:"size:80"`
-	DeliveryNotes  string     `gorm:"type:text"`
-	MPPreferenceID string     `gorm:"size:140"`
-	MPStatus       string     `gorm:"size:60"`
+	Email          string `gorm:"size:140"`
+	Name           string `gorm:"size:140"`
+	Phone          string `gorm:"size:50"`
+	DNI            string `gorm:"size:30"`
+	Address        string `gorm:"size:255"`
+	PostalCode     string `gorm:"size:20"`
+	Province       string `gorm:"size:80"`
+	DeliveryNotes  string `gorm:"type:text"`
+	MPPreferenceID string `gorm:"size:140"`
+	// PaymentStatus es el estado de cobro de la orden con cualquier medio, no sólo MercadoPago (la columna
+	// se llama mp_status por historia): "approved" cuando el ledger cubre el total (ComputeBalance), "partial"
+	// con una seña o pago parcial, el estado que informó MP mientras no haya nada acreditado, o
+	// "<medio>_pending" para los pagos manuales.
+	PaymentStatus  string     `gorm:"column:mp_status;size:60"`
+	MPPaymentID    string     `gorm:"size:40"`
 	CustomerID     *uuid.UUID `gorm:"type:uuid;index"`
 	SubtotalNet    float64    `gorm:"type:decimal(12,2);default:0"`
 	VATAmount      float64    `gorm:"type:decimal(12,2);default:0"`
@@ -40,12 +52,172 @@ type Order struct {
 	ShippingCost   float64    `gorm:"type:decimal(12,2)"`
 	PaymentMethod  string     `gorm:"size:30;index"`
 	DiscountAmount float64    `gorm:"type:decimal(12,2)"`
+	CryptoAmount   float64    `gorm:"type:decimal(18,6);default:0"` // monto exacto esperado en USDT/USDC
+	CryptoRate     float64    `gorm:"type:decimal(14,4);default:0"` // ARS por unidad usada en la cotización
+	CryptoSource   string     `gorm:"size:40"`
+	CryptoExpires  *time.Time // vencimiento de la cotización; después hay que volver a cotizar
+	CryptoTxHash   string     `gorm:"size:80"`
 	Notified       bool       `gorm:"not null;default:false"`
+	ReminderSentAt *time.Time // aviso de vencimiento del plazo de pago
+	CancelReason   string     `gorm:"size:255"`
+	// Preventa: se cobra la seña al comprar y el saldo cuando llega el stock.
+	PreOrder      bool       `gorm:"not null;default:false;index"`
+	PreOrderETA   *time.Time // llegada estimada informada al comprar
+	DepositAmount float64    `gorm:"type:decimal(12,2);default:0"`
+	ChargeAmount  float64    `gorm:"type:decimal(12,2);default:0"` // monto del próximo cobro (seña o saldo); 0 = total
+	AllocatedAt   *time.Time // se le asignó stock y se pidió el saldo
+	// TrackingNumber es el código de seguimiento del envío, cargado al despachar.
+	TrackingNumber string `gorm:"size:80"`
+	// WhatsApp: el comprador aceptó en el checkout recibir el estado del pedido al número en E.164.
+	WhatsAppPhone   string `gorm:"size:20"`
+	WhatsAppOptIn   bool   `gorm:"not null;default:false"`
+	WhatsAppOptInAt *time.Time
+	// Outbox son los avisos que se guardan junto con el próximo Save de la orden (no es una columna).
+	Outbox []OutboxMessage `gorm:"-"`
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// OrderNumberPrefix antecede al número correlativo de las órdenes (NM-000123).
+const OrderNumberPrefix = "NM"
+
+// Number es el número de orden que ve el comprador. Las órdenes todavía sin correlativo muestran los
+// primeros 8 caracteres del UUID, como antes.
+func (o *Order) Number() string {
+	if o.Seq > 0 {
+		return FormatOrderNumber(o.Seq)
+	}
+	return o.ID.String()[:8]
+}
+
+// FormatOrderNumber arma el número de orden a partir del correlativo.
+func FormatOrderNumber(seq int64) string { return fmt.Sprintf("%s-%06d", OrderNumberPrefix, seq) }
+
+// ParseOrderNumber reconoce un número de orden escrito como "NM-000123", "nm123" o "123".
+func ParseOrderNumber(s string) (int64, bool) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimPrefix(s, "#")
+	s = strings.TrimPrefix(s, OrderNumberPrefix)
+	s = strings.TrimPrefix(s, "-")
+	if s == "" || len(s) > 12 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// CustomerStatus es el estado de la orden como lo ve el cliente.
+func (o *Order) CustomerStatus() string { return o.Status.CustomerLabel(o.ShippingMethod) }
+
+// QueueNotification encola un aviso de la orden; se persiste en la misma transacción que el próximo Save.
+func (o *Order) QueueNotification(kind string, p OutboxPayload, now time.Time) {
+	o.Outbox = append(o.Outbox, NewOutboxMessage(kind, &o.ID, p, now))
+}
+
+// QueueEvent encola el evento de la orden para los destinos de sus reglas (ver QueueNotification).
+func (o *Order) QueueEvent(event string, now time.Time) {
+	o.QueueNotification(NotifyEvent, OutboxPayload{Event: event}, now)
+}
+
+// QueueWhatsApp encola el mensaje de WhatsApp del evento (WhatsAppOrderReceived…) si el comprador
+// aceptó recibirlos.
+func (o *Order) QueueWhatsApp(event string, now time.Time) {
+	if !o.WhatsAppOptIn || o.WhatsAppPhone == "" {
+		return
+	}
+	o.QueueNotification(NotifyWhatsApp, OutboxPayload{Event: event}, now)
+}
+
+// PaymentStatusText resume para el staff el estado del pago: aprobado o, si falta, cómo se espera.
+func (o *Order) PaymentStatusText(paid bool) string {
+	switch {
+	case paid:
+		return "PAGO APROBADO"
+	case o.PaymentMethod == "transferencia":
+		return "PAGO EN PROCESO"
+	case o.PaymentMethod == "cripto":
+		return "PAGO CRIPTO EN PROCESO"
+	case o.PaymentMethod == "efectivo":
+		return "PAGO EN EFECTIVO PENDIENTE"
+	}
+	return "PAGO FALLIDO"
+}
+
+// CryptoQuoteExpired indica si la cotización cripto fijada en la orden ya venció.
+func (o *Order) CryptoQuoteExpired(now time.Time) bool {
+	return o.CryptoExpires != nil && now.After(*o.CryptoExpires)
+}
+
+// AmountDue es lo que hay que cobrar en el próximo pago: la seña o el saldo de una preventa, o el total.
+func (o *Order) AmountDue() float64 {
+	if o.ChargeAmount > 0 && o.ChargeAmount < o.Total {
+		return o.ChargeAmount
+	}
+	return o.Total
+}
+
+// AwaitingDeposit indica si es una preventa que todavía no tiene stock asignado (se cobra la seña).
+func (o *Order) AwaitingDeposit() bool { return o.PreOrder && o.AllocatedAt == nil }
+
+// ChargeLabel nombra el cobro pendiente para las vistas y los medios de pago.
+func (o *Order) ChargeLabel() string {
+	switch {
+	case !o.PreOrder:
+		return "Total"
+	case o.AllocatedAt == nil:
+		return "Seña"
+	}
+	return "Saldo"
+}
+
+// CheckItemsEditable indica si se pueden agregar o quitar items: sólo antes del pago, y no si ya hay una
+// preferencia de MP creada con el total anterior.
+func (o *Order) CheckItemsEditable() error {
+	switch o.Status {
+	case OrderStatusPendingQuote, OrderStatusQuoted, OrderStatusAwaitingPay:
+	default:
+		return fmt.Errorf("no se pueden editar los items de una orden en estado %s", o.Status)
+	}
+	if o.PaymentMethod == "mercadopago" && o.MPPreferenceID != "" {
+		return errors.New("la orden ya tiene una preferencia de MercadoPago con el total anterior")
+	}
+	if o.PreOrder {
+		return errors.New("no se pueden editar los items de una preventa: la seña se calculó con los originales")
+	}
+	return nil
+}
+
+// PaymentDiscountRate es el descuento por medio de pago que se aplica sobre productos + envío.
+func PaymentDiscountRate(method string) float64 {
+	switch method {
+	case "transferencia":
+		return 0.05
+	case "cripto":
+		return 0.10
+	}
+	return 0
+}
+
+// ItemsSubtotal suma los items de la orden, sin envío ni descuento.
+func (o *Order) ItemsSubtotal() float64 {
+	total := 0.0
+	for _, it := range o.Items {
+		total += it.Subtotal()
+	}
+	return total
+}
+
+// RecalculateTotals recalcula descuento y total a partir de los items, el envío y el medio de pago.
+func (o *Order) RecalculateTotals() {
+	subtotal := o.ItemsSubtotal() + o.ShippingCost
+	o.DiscountAmount = subtotal * PaymentDiscountRate(o.PaymentMethod)
+	o.Total = subtotal - o.DiscountAmount
+}
+
 type OrderItem struct {
 	ID             uuid.UUID  `gorm:"type:uuid;primaryKey"`
 	OrderID        uuid.UUID  `gorm:"type:uuid;index"`
@@ -62,4 +234,30 @@ type OrderItem struct {
 	VATRate        float64    `gorm:"type:decimal(5,2);default:21.00"`
 	VATAmount      float64    `gorm:"type:decimal(12,2);default:0"`
 	UnitPriceGross float64    `gorm:"type:decimal(12,2);default:0"`
+	// StockTaken son las unidades que ya se descontaron del stock de la variante (al quedar paga la orden,
+	// al asignar una preventa o al armar un cambio); sólo esas vuelven al stock cuando se reembolsan o se devuelven.
+	StockTaken int `gorm:"not null;default:0"`
+}
+
+// Subtotal es el precio unitario por la cantidad.
+func (it OrderItem) Subtotal() float64 { return it.UnitPrice * float64(it.Qty) }
+
+// TakeStock marca como tomadas las unidades del item que faltaba descontar y devuelve cuántas son.
+func (it *OrderItem) TakeStock() int {
+	n := it.Qty - it.StockTaken
+	if n <= 0 {
+		return 0
+	}
+	it.StockTaken = it.Qty
+	return n
+}
+
+// ReleaseStock descuenta hasta qty unidades de las tomadas del stock y devuelve cuántas hay que reponer.
+func (it *OrderItem) ReleaseStock(qty int) int {
+	n := min(qty, it.StockTaken)
+	if n <= 0 {
+		return 0
+	}
+	it.StockTaken -= n
+	return n
 }
@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Acciones registradas en la auditoría de órdenes.
+const (
+	OrderAuditContact     = "contacto"
+	OrderAuditItemAdded   = "item_agregado"
+	OrderAuditItemRemoved = "item_quitado"
+	OrderAuditRMA         = "devolucion"
+	OrderAuditWarranty    = "garantia"
+	OrderAuditPreOrder    = "preventa"
+	OrderAuditWhatsApp    = "whatsapp"
+)
+
+// OrderAuditEntry registra una edición manual de una orden (datos de contacto/envío o items).
+type OrderAuditEntry struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OrderID   uuid.UUID `gorm:"type:uuid;index"`
+	Actor     string    `gorm:"size:140"`
+	Action    string    `gorm:"size:30"`
+	Detail    string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// OrderContact son los datos de contacto y envío que el staff puede corregir en una orden.
+type OrderContact struct {
+	Name          string
+	Email         string
+	Phone         string
+	DNI           string
+	Address       string
+	PostalCode    string
+	Province      string
+	DeliveryNotes string
+}
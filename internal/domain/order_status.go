@@ -0,0 +1,117 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidTransition indica un cambio de estado de orden no permitido.
+var ErrInvalidTransition = errors.New("transición de estado inválida")
+
+// ErrStatusConflict indica que la orden cambió de estado entre que se leyó y se quiso actualizar.
+var ErrStatusConflict = errors.New("la orden cambió de estado mientras tanto")
+
+// orderTransitions define a qué estados puede pasar una orden desde cada estado. Una orden cancelada
+// puede volver a awaiting_payment o a finished porque MP y la conciliación pueden informar pagos tardíos.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPendingQuote: {OrderStatusQuoted, OrderStatusCancelled},
+	OrderStatusQuoted:       {OrderStatusAwaitingPay, OrderStatusFinished, OrderStatusCancelled},
+	OrderStatusAwaitingPay:  {OrderStatusFinished, OrderStatusCancelled},
+	OrderStatusCancelled:    {OrderStatusAwaitingPay, OrderStatusFinished},
+	OrderStatusFinished:     {OrderStatusInPrint, OrderStatusShipped, OrderStatusPartRefunded, OrderStatusRefunded},
+	OrderStatusInPrint:      {OrderStatusFinished, OrderStatusShipped, OrderStatusPartRefunded, OrderStatusRefunded},
+	OrderStatusShipped:      {OrderStatusPartRefunded, OrderStatusRefunded},
+	OrderStatusPartRefunded: {OrderStatusShipped, OrderStatusRefunded},
+	OrderStatusRefunded:     {},
+}
+
+// CanTransitionTo indica si la orden puede pasar de s a to. Quedarse en el mismo estado siempre vale.
+func (s OrderStatus) CanTransitionTo(to OrderStatus) bool {
+	if s == to {
+		return true
+	}
+	for _, st := range orderTransitions[s] {
+		if st == to {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStatuses devuelve los estados a los que puede pasar una orden en estado s.
+func (s OrderStatus) NextStatuses() []OrderStatus {
+	return append([]OrderStatus(nil), orderTransitions[s]...)
+}
+
+// ValidateTransition devuelve ErrInvalidTransition (con el detalle) si la orden no puede pasar de s a to.
+func (s OrderStatus) ValidateTransition(to OrderStatus) error {
+	if _, ok := orderTransitions[to]; !ok {
+		return fmt.Errorf("%w: estado desconocido %q", ErrInvalidTransition, to)
+	}
+	if !s.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s → %s", ErrInvalidTransition, s, to)
+	}
+	return nil
+}
+
+// CustomerLabel es el nombre del estado para mostrarle al cliente en "Mi cuenta". Para el cliente una
+// orden finished ya está paga y en preparación; shipped se lee como entregada si la retiró en el local.
+func (s OrderStatus) CustomerLabel(shippingMethod string) string {
+	switch s {
+	case OrderStatusPendingQuote:
+		return "Esperando cotización"
+	case OrderStatusQuoted:
+		return "Cotizada"
+	case OrderStatusAwaitingPay:
+		return "Pendiente de pago"
+	case OrderStatusFinished:
+		return "Pagada, en preparación"
+	case OrderStatusInPrint:
+		return "En preparación"
+	case OrderStatusShipped:
+		if shippingMethod == "retiro" {
+			return "Entregada"
+		}
+		return "Enviada"
+	case OrderStatusCancelled:
+		return "Cancelada"
+	case OrderStatusPartRefunded:
+		return "Reembolsada en parte"
+	case OrderStatusRefunded:
+		return "Reembolsada"
+	}
+	return string(s)
+}
+
+// Orígenes de un cambio de estado.
+const (
+	StatusSourceCheckout = "checkout"
+	StatusSourcePayment  = "payment"
+	StatusSourceAdmin    = "admin"
+	StatusSourceExpiry   = "expiry"
+	StatusSourceRefund   = "refund"
+	StatusSourceRMA      = "rma"
+	StatusSourceRepair   = "repair"
+)
+
+// StatusChange describe quién y por qué cambia el estado de una orden.
+type StatusChange struct {
+	Actor  string
+	Source string
+	Note   string
+}
+
+// OrderStatusEvent es una entrada del historial de estados de una orden. From vacío marca la creación.
+type OrderStatusEvent struct {
+	ID        uuid.UUID   `gorm:"type:uuid;primaryKey"`
+	OrderID   uuid.UUID   `gorm:"type:uuid;index"`
+	From      OrderStatus `gorm:"type:varchar(30)"`
+	To        OrderStatus `gorm:"type:varchar(30)"`
+	Actor     string      `gorm:"size:140"`
+	Source    string      `gorm:"size:30"`
+	Note      string      `gorm:"type:text"`
+	CreatedAt time.Time   `gorm:"index"`
+}
@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateTransition(t *testing.T) {
+	cases := []struct {
+		from, to OrderStatus
+		ok       bool
+	}{
+		{OrderStatusPendingQuote, OrderStatusQuoted, true},
+		{OrderStatusPendingQuote, OrderStatusFinished, false},
+		{OrderStatusQuoted, OrderStatusAwaitingPay, true},
+		{OrderStatusAwaitingPay, OrderStatusFinished, true},
+		{OrderStatusAwaitingPay, OrderStatusCancelled, true},
+		{OrderStatusAwaitingPay, OrderStatusShipped, false},
+		// Pagos tardíos informados por MP o la conciliación.
+		{OrderStatusCancelled, OrderStatusAwaitingPay, true},
+		{OrderStatusCancelled, OrderStatusFinished, true},
+		{OrderStatusCancelled, OrderStatusShipped, false},
+		{OrderStatusFinished, OrderStatusInPrint, true},
+		{OrderStatusFinished, OrderStatusCancelled, false},
+		{OrderStatusInPrint, OrderStatusPartRefunded, true},
+		{OrderStatusInPrint, OrderStatusRefunded, true},
+		{OrderStatusShipped, OrderStatusFinished, false},
+		{OrderStatusPartRefunded, OrderStatusRefunded, true},
+		{OrderStatusPartRefunded, OrderStatusShipped, true},
+		{OrderStatusRefunded, OrderStatusFinished, false},
+		{OrderStatusRefunded, OrderStatusRefunded, true},
+		{OrderStatusFinished, OrderStatus("perdida"), false},
+	}
+	for _, c := range cases {
+		err := c.from.ValidateTransition(c.to)
+		if c.ok && err != nil {
+			t.Errorf("%s → %s: error inesperado %v", c.from, c.to, err)
+		}
+		if !c.ok && !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("%s → %s: quería ErrInvalidTransition, dio %v", c.from, c.to, err)
+		}
+	}
+}
+
+// Todos los destinos de la tabla son estados conocidos, para que ninguna orden quede en un estado sin salida
+// por un error de tipeo.
+func TestOrderTransitionsTargetsKnownStatuses(t *testing.T) {
+	for from, tos := range orderTransitions {
+		for _, to := range tos {
+			if _, ok := orderTransitions[to]; !ok {
+				t.Errorf("%s → %s: destino que no está en la tabla", from, to)
+			}
+			if !from.CanTransitionTo(to) {
+				t.Errorf("%s → %s: CanTransitionTo no acepta una transición de la tabla", from, to)
+			}
+		}
+	}
+	if got := OrderStatusRefunded.NextStatuses(); len(got) != 0 {
+		t.Errorf("refunded es final, NextStatuses = %v", got)
+	}
+}
@@ -0,0 +1,180 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OutboxStatusPending   = "pending"
+	OutboxStatusDelivered = "delivered"
+	OutboxStatusDead      = "dead"
+)
+
+// Avisos que pasan por el outbox.
+const (
+	// NotifyEvent es un evento (ver EventOrderCreated…) que se manda a los destinos de sus reglas.
+	NotifyEvent = "event"
+	// NotifyOrderCustomer es el email de confirmación al comprador.
+	NotifyOrderCustomer = "order_customer"
+	// NotifyWhatsApp es un mensaje de WhatsApp al comprador (ver WhatsAppOrderReceived…).
+	NotifyWhatsApp = "whatsapp"
+	// NotifyOrderAdmin y NotifyReceiptAdmin son los avisos al staff anteriores a los eventos; los que
+	// hayan quedado en el outbox se entregan como order_created/order_paid y receipt_received.
+	NotifyOrderAdmin   = "order_admin"
+	NotifyReceiptAdmin = "receipt_admin"
+)
+
+// OutboxMessage es un aviso pendiente de entrega. Se guarda en la misma transacción que el cambio de la
+// orden que lo origina, así no se pierde si el envío falla o el proceso se reinicia; un worker lo
+// entrega con reintentos.
+type OutboxMessage struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	Kind          string     `gorm:"size:40;index"`
+	OrderID       *uuid.UUID `gorm:"type:uuid;index"`
+	Payload       string     `gorm:"type:text"` // parámetros del aviso en JSON (ver OutboxPayload)
+	Status        string     `gorm:"size:20;index"`
+	Attempts      int        `gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `gorm:"index"`
+	LastError     string     `gorm:"type:text"`
+	DeliveredAt   *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (OutboxMessage) TableName() string { return "notification_outbox" }
+
+// OutboxPayload son los parámetros de un aviso. Los datos de la orden se leen al entregarlo.
+type OutboxPayload struct {
+	Event     string         `json:"event,omitempty"`
+	Success   bool           `json:"success,omitempty"`
+	ReceiptID *uuid.UUID     `json:"receipt_id,omitempty"`
+	LowStock  []LowStockItem `json:"low_stock,omitempty"`
+	// Sent son los destinos ("canal:destino") que ya recibieron el evento; un reintento no los repite.
+	Sent []string `json:"sent,omitempty"`
+}
+
+// EventName es el evento del aviso, incluidos los avisos al staff anteriores a los eventos.
+func (m *OutboxMessage) EventName() string {
+	switch m.Kind {
+	case NotifyEvent:
+		return m.Params().Event
+	case NotifyOrderAdmin:
+		if m.Params().Success {
+			return EventOrderPaid
+		}
+		return EventOrderCreated
+	case NotifyReceiptAdmin:
+		return EventReceiptReceived
+	}
+	return ""
+}
+
+// NewOutboxMessage arma un aviso pendiente para entregar en cuanto el worker lo tome.
+func NewOutboxMessage(kind string, orderID *uuid.UUID, p OutboxPayload, now time.Time) OutboxMessage {
+	raw, _ := json.Marshal(p)
+	return OutboxMessage{
+		ID:            uuid.New(),
+		Kind:          kind,
+		OrderID:       orderID,
+		Payload:       string(raw),
+		Status:        OutboxStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// Params decodifica el payload del aviso.
+func (m *OutboxMessage) Params() OutboxPayload {
+	var p OutboxPayload
+	_ = json.Unmarshal([]byte(m.Payload), &p)
+	return p
+}
+
+// SetParams reemplaza el payload del aviso.
+func (m *OutboxMessage) SetParams(p OutboxPayload) {
+	raw, _ := json.Marshal(p)
+	m.Payload = string(raw)
+}
+
+// MarkDelivered registra la entrega.
+func (m *OutboxMessage) MarkDelivered(now time.Time) {
+	m.Attempts++
+	m.Status = OutboxStatusDelivered
+	m.DeliveredAt = &now
+	m.LastError = ""
+}
+
+// MarkFailed registra un intento fallido: reprograma el próximo con backoff exponencial o, agotados los
+// intentos, lo deja muerto hasta que un admin lo reenvíe.
+func (m *OutboxMessage) MarkFailed(err error, now time.Time, maxAttempts int) {
+	m.Attempts++
+	m.LastError = err.Error()
+	if m.Attempts >= maxAttempts {
+		m.Status = OutboxStatusDead
+		return
+	}
+	m.NextAttemptAt = now.Add(OutboxBackoff(m.Attempts))
+}
+
+// Requeue vuelve a dejar pendiente un aviso (reenvío manual), con los intentos en cero. Un aviso ya
+// entregado se vuelve a mandar a todos sus destinos; uno fallido, sólo a los que faltaron.
+func (m *OutboxMessage) Requeue(now time.Time) {
+	if m.Status == OutboxStatusDelivered {
+		p := m.Params()
+		p.Sent = nil
+		m.SetParams(p)
+	}
+	m.Status = OutboxStatusPending
+	m.Attempts = 0
+	m.NextAttemptAt = now
+}
+
+// OutboxBackoff es la espera antes del reintento número attempts: 30s, 1m, 2m, 4m… hasta 6 horas.
+func OutboxBackoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts && d < 6*time.Hour; i++ {
+		d *= 2
+	}
+	if d > 6*time.Hour {
+		d = 6 * time.Hour
+	}
+	return d
+}
+
+// OutboxKindLabel nombra el tipo de aviso para el admin.
+func OutboxKindLabel(kind string) string {
+	switch kind {
+	case NotifyEvent, NotifyOrderAdmin:
+		return "Aviso al staff"
+	case NotifyOrderCustomer:
+		return "Confirmación al comprador"
+	case NotifyReceiptAdmin:
+		return "Comprobante recibido"
+	case NotifyWhatsApp:
+		return "WhatsApp al comprador"
+	}
+	return kind
+}
+
+// KindLabel nombra el aviso; para los eventos, el evento.
+func (m *OutboxMessage) KindLabel() string {
+	if ev := m.EventName(); ev != "" {
+		return NotificationEventLabel(ev)
+	}
+	if m.Kind == NotifyWhatsApp {
+		return "WhatsApp: " + WhatsAppEventLabel(m.Params().Event)
+	}
+	return OutboxKindLabel(m.Kind)
+}
+
+// OutboxFilter son los criterios del listado de avisos del admin.
+type OutboxFilter struct {
+	Status   string
+	Kind     string
+	OrderID  *uuid.UUID
+	Page     int
+	PageSize int
+}
@@ -0,0 +1,74 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GatewayPayment es la vista normalizada de un pago tal como lo informa el gateway (MP).
+type GatewayPayment struct {
+	ID          string
+	Status      string
+	ExternalRef string
+	Amount      float64
+	Currency    string
+	CreatedAt   time.Time
+	ApprovedAt  *time.Time
+}
+
+const (
+	PaymentStatusApproved = "approved"
+	PaymentStatusPending  = "pending"
+	PaymentStatusRejected = "rejected"
+	PaymentStatusRefunded = "refunded"
+	// PaymentStatusChargedBack es un contracargo: el comprador desconoció el pago ante su banco.
+	PaymentStatusChargedBack = "charged_back"
+)
+
+// Payment es un movimiento del ledger de cobros de una orden (MP, transferencia, cripto, efectivo, seña).
+// Los reembolsos y contracargos se registran con monto negativo y estado refunded o charged_back; el
+// pago original queda aprobado.
+type Payment struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OrderID    uuid.UUID `gorm:"type:uuid;index"`
+	Method     string    `gorm:"size:30"`
+	Amount     float64   `gorm:"type:decimal(12,2)"`
+	Currency   string    `gorm:"size:10"`
+	ExternalID string    `gorm:"size:80;index"`
+	Status     string    `gorm:"size:20;index"`
+	Note       string    `gorm:"type:text"`
+	Actor      string    `gorm:"size:140"`
+	ApprovedAt *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// OrderBalance es el estado de cuenta de una orden calculado a partir de su ledger.
+type OrderBalance struct {
+	Total    float64
+	Paid     float64
+	Refunded float64
+	Pending  float64
+	Balance  float64
+}
+
+// Settled indica que lo cobrado cubre el total de la orden.
+func (b OrderBalance) Settled() bool { return b.Total > 0 && b.Balance <= 0.01 }
+
+// ComputeBalance suma los movimientos aprobados del ledger contra el total de la orden.
+func ComputeBalance(total float64, pays []Payment) OrderBalance {
+	b := OrderBalance{Total: total}
+	for _, p := range pays {
+		switch {
+		case p.Status == PaymentStatusApproved && p.Amount > 0:
+			b.Paid += p.Amount
+		case p.Status == PaymentStatusRefunded || p.Status == PaymentStatusChargedBack:
+			b.Refunded += -p.Amount
+		case p.Status == PaymentStatusPending:
+			b.Pending += p.Amount
+		}
+	}
+	b.Balance = total - b.Paid
+	return b
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputeBalance(t *testing.T) {
+	cases := []struct {
+		name    string
+		total   float64
+		pays    []Payment
+		want    OrderBalance
+		settled bool
+	}{
+		{
+			name:  "sin pagos",
+			total: 1000,
+			want:  OrderBalance{Total: 1000, Balance: 1000},
+		},
+		{
+			name:    "un pago aprobado por el total",
+			total:   1000,
+			pays:    []Payment{{Amount: 1000, Status: PaymentStatusApproved}},
+			want:    OrderBalance{Total: 1000, Paid: 1000},
+			settled: true,
+		},
+		{
+			name:  "seña y saldo pendiente",
+			total: 1000,
+			pays: []Payment{
+				{Amount: 300, Status: PaymentStatusApproved},
+				{Amount: 700, Status: PaymentStatusPending},
+			},
+			want: OrderBalance{Total: 1000, Paid: 300, Pending: 700, Balance: 700},
+		},
+		{
+			name:  "pago rechazado no suma",
+			total: 1000,
+			pays: []Payment{
+				{Amount: 1000, Status: PaymentStatusRejected},
+				{Amount: 400, Status: PaymentStatusApproved},
+			},
+			want: OrderBalance{Total: 1000, Paid: 400, Balance: 600},
+		},
+		{
+			name:  "reembolso parcial y contracargo aparte del pago aprobado",
+			total: 1000,
+			pays: []Payment{
+				{Amount: 1000, Status: PaymentStatusApproved},
+				{Amount: -250, Status: PaymentStatusRefunded},
+				{Amount: -100, Status: PaymentStatusChargedBack},
+			},
+			want:    OrderBalance{Total: 1000, Paid: 1000, Refunded: 350},
+			settled: true,
+		},
+		{
+			name:    "diferencia de redondeo se considera cubierta",
+			total:   1000,
+			pays:    []Payment{{Amount: 999.995, Status: PaymentStatusApproved}},
+			want:    OrderBalance{Total: 1000, Paid: 999.995, Balance: 0.005},
+			settled: true,
+		},
+		{
+			name:  "orden sin importe nunca queda saldada",
+			total: 0,
+			want:  OrderBalance{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ComputeBalance(c.total, c.pays)
+			for _, f := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"Total", got.Total, c.want.Total},
+				{"Paid", got.Paid, c.want.Paid},
+				{"Refunded", got.Refunded, c.want.Refunded},
+				{"Pending", got.Pending, c.want.Pending},
+				{"Balance", got.Balance, c.want.Balance},
+			} {
+				if math.Abs(f.got-f.want) > 1e-9 {
+					t.Errorf("%s = %.3f, quería %.3f", f.name, f.got, f.want)
+				}
+			}
+			if got.Settled() != c.settled {
+				t.Errorf("Settled() = %v, quería %v", got.Settled(), c.settled)
+			}
+		})
+	}
+}
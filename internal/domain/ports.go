@@ -10,6 +10,7 @@ import (
 type ProductRepo interface {
 	Save(ctx context.Context, p *Product) error
 	FindBySlug(ctx context.Context, slug string) (*Product, error)
+	FindByID(ctx context.Context, id uuid.UUID) (*Product, error)
 	List(ctx context.Context, filter ProductFilter) ([]Product, int64, error)
 	AddImages(ctx context.Context, productID uuid.UUID, imgs []Image) error
 	DistinctCategories(ctx context.Context) ([]string, error)
@@ -18,7 +19,17 @@ type ProductRepo interface {
 	ListVariants(ctx context.Context, productID uuid.UUID) ([]Variant, error)
 	FindVariantByEAN(ctx context.Context, ean string) (*Product, *Variant, error)
 	FindVariantBySKU(ctx context.Context, sku string) (*Product, *Variant, error)
+	FindVariantByID(ctx context.Context, id uuid.UUID) (*Product, *Variant, error)
 	UpdateVariantStock(ctx context.Context, variantID uuid.UUID, delta int) error
+	// ListPreOrderVariants devuelve las variantes marcadas para preventa, tengan o no stock.
+	ListPreOrderVariants(ctx context.Context) ([]Variant, error)
+	// ListLowStockVariants devuelve las variantes de productos activos con stock <= threshold que todavía
+	// no se avisaron (sin contar las de preventa).
+	ListLowStockVariants(ctx context.Context, threshold int) ([]Variant, error)
+	// MarkLowStockAlerted marca las variantes como avisadas.
+	MarkLowStockAlerted(ctx context.Context, ids []uuid.UUID, at time.Time) error
+	// ClearLowStockAlerts desmarca las variantes que volvieron a tener más de threshold unidades.
+	ClearLowStockAlerts(ctx context.Context, threshold int) error
 	DeleteVariant(ctx context.Context, variantID uuid.UUID) error
 	// Imágenes
 	ClearImages(ctx context.Context, productID uuid.UUID) ([]string, error)
@@ -29,9 +40,14 @@ type ProductRepo interface {
 
 type CustomerRepo interface {
 	FindByEmail(ctx context.Context, email string) (*Customer, error)
+	FindByID(ctx context.Context, id uuid.UUID) (*Customer, error)
 	Save(ctx context.Context, c *Customer) error
 	// Opcionales
 	FindByTaxID(ctx context.Context, taxID string) (*Customer, error)
+	// Direcciones guardadas en "Mi cuenta"; la predeterminada primero.
+	ListAddresses(ctx context.Context, customerID uuid.UUID) ([]CustomerAddress, error)
+	SaveAddress(ctx context.Context, a *CustomerAddress) error
+	DeleteAddress(ctx context.Context, customerID, id uuid.UUID) error
 }
 
 type ProductFilter struct {
@@ -44,13 +60,94 @@ type ProductFilter struct {
 	Query           string
 }
 
+// OrderFilter son los criterios de búsqueda de órdenes del admin. Los textos buscan coincidencias
+// parciales sin distinguir mayúsculas; From/To filtran por fecha de creación en [From, To).
+type OrderFilter struct {
+	Status         *OrderStatus
+	PaymentStatus  string
+	Email          string
+	Name           string
+	DNI            string
+	Phone          string
+	IDPrefix       string // prefijo del UUID o número de orden (NM-000123 / 123)
+	PaymentMethod  string
+	ShippingMethod string
+	Province       string
+	From           *time.Time
+	To             *time.Time
+	MinTotal       *float64
+	MaxTotal       *float64
+	// Sort: newest (default), oldest, total_desc, total_asc, name.
+	Sort     string
+	Page     int
+	PageSize int
+}
+
 type OrderRepo interface {
+	// Save crea la orden o actualiza sus datos; el estado de una orden existente sólo cambia con UpdateStatus.
 	Save(ctx context.Context, o *Order) error
 	FindByID(ctx context.Context, id uuid.UUID) (*Order, error)
 	FindByPreferenceID(ctx context.Context, prefID string) (*Order, error)
-	UpdateStatus(ctx context.Context, id uuid.UUID, st OrderStatus) error
-	List(ctx context.Context, status *OrderStatus, mpStatus *string, page, pageSize int) ([]Order, int64, error)
+	// FindByNumber busca la orden por su número correlativo (Order.Seq).
+	FindByNumber(ctx context.Context, seq int64) (*Order, error)
+	// Numbers devuelve el número para mostrar (Order.Number) de cada orden de ids que exista.
+	Numbers(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]string, error)
+	// UpdateStatus cambia el estado de la orden a st y guarda en la misma transacción los avisos encolados en
+	// o.Outbox, sólo si en la base sigue en o.Status; si no, devuelve ErrStatusConflict.
+	UpdateStatus(ctx context.Context, o *Order, st OrderStatus) error
+	// CancelUnpaid cancela la orden con o.CancelReason y guarda los avisos encolados, sólo si en la base
+	// sigue en awaiting_payment sin pagos acreditados ni comprobantes por revisar; false si ya no.
+	CancelUnpaid(ctx context.Context, o *Order) (bool, error)
+	// MarkReminderSent marca el recordatorio de pago si la orden sigue impaga y sin recordatorio; false si no.
+	MarkReminderSent(ctx context.Context, id uuid.UUID, at time.Time) (bool, error)
+	List(ctx context.Context, f OrderFilter) ([]Order, int64, error)
 	ListInRange(ctx context.Context, from, to time.Time) ([]Order, error)
+	// ListAwaitingPayment devuelve las órdenes en awaiting_payment de un método de pago creadas en [from, to].
+	ListAwaitingPayment(ctx context.Context, paymentMethod string, from, to time.Time) ([]Order, error)
+	// ListPreOrders devuelve las preventas que todavía no se terminaron de pagar, las más viejas primero.
+	ListPreOrders(ctx context.Context) ([]Order, error)
+	// ListByCustomer devuelve las órdenes del cliente (por CustomerID o por email), las más nuevas primero.
+	ListByCustomer(ctx context.Context, customerID uuid.UUID, email string, page, pageSize int) ([]Order, int64, error)
+	// LinkGuestOrders asigna al cliente las órdenes sin cliente hechas con su email; devuelve cuántas.
+	LinkGuestOrders(ctx context.Context, customerID uuid.UUID, email string) (int64, error)
+	SaveItem(ctx context.Context, it *OrderItem) error
+	DeleteItem(ctx context.Context, orderID, itemID uuid.UUID) error
+}
+
+// LoginTokenRepo guarda los links de ingreso por email.
+type LoginTokenRepo interface {
+	Save(ctx context.Context, t *LoginToken) error
+	// Consume marca como usado el token vigente con ese hash (y los demás pendientes del mismo email) y
+	// lo devuelve; ErrNotFound si no hay uno vigente. Dos pedidos simultáneos no pueden usarlo los dos.
+	Consume(ctx context.Context, hash string, now time.Time) (*LoginToken, error)
+	// CountSince cuenta los links pedidos desde since para el email y desde la IP.
+	CountSince(ctx context.Context, email, ip string, since time.Time) (byEmail, byIP int64, err error)
+	// DeleteBefore borra los tokens creados antes de t.
+	DeleteBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+type OrderEventRepo interface {
+	Save(ctx context.Context, ev *OrderStatusEvent) error
+	ListByOrder(ctx context.Context, orderID uuid.UUID) ([]OrderStatusEvent, error)
+}
+
+type OrderAuditRepo interface {
+	Save(ctx context.Context, e *OrderAuditEntry) error
+	ListByOrder(ctx context.Context, orderID uuid.UUID) ([]OrderAuditEntry, error)
+}
+
+type PaymentRepo interface {
+	Save(ctx context.Context, p *Payment) error
+	ListByOrder(ctx context.Context, orderID uuid.UUID) ([]Payment, error)
+	FindByExternalID(ctx context.Context, method, externalID string) (*Payment, error)
+}
+
+type RefundRepo interface {
+	Save(ctx context.Context, rf *Refund) error
+	// Update guarda el estado, los datos del gateway y la reposición de stock de las líneas.
+	Update(ctx context.Context, rf *Refund) error
+	FindByID(ctx context.Context, id uuid.UUID) (*Refund, error)
+	ListByOrder(ctx context.Context, orderID uuid.UUID) ([]Refund, error)
 }
 
 type QuoteRepo interface {
@@ -97,12 +194,92 @@ type PricingService interface {
 type PaymentGateway interface {
 	CreatePreference(ctx context.Context, o *Order) (initPoint string, err error)
 	VerifyWebhook(signature string, body []byte) (event interface{}, err error)
-	PaymentInfo(ctx context.Context, paymentID string) (status string, externalRef string, err error)
+	PaymentInfo(ctx context.Context, paymentID string) (*GatewayPayment, error)
+	// SearchPaymentsByOrder busca en el gateway los pagos asociados a la orden (por external_reference).
+	SearchPaymentsByOrder(ctx context.Context, orderID uuid.UUID) ([]GatewayPayment, error)
+	// Refund devuelve amount del pago indicado y retorna el id del reembolso en el gateway. Repetir el
+	// pedido con la misma idempotencyKey no vuelve a reembolsar.
+	Refund(ctx context.Context, paymentID string, amount float64, idempotencyKey string) (refundID string, err error)
 }
 
 type FileStorage interface {
 	SaveModel(ctx context.Context, filename string, data []byte) (string, error)
 	SaveImage(ctx context.Context, filename string, data []byte) (string, error)
+	// SaveReceipt guarda un comprobante de pago fuera del directorio público.
+	SaveReceipt(ctx context.Context, filename string, data []byte) (string, error)
+	ReadReceipt(ctx context.Context, path string) ([]byte, error)
+}
+
+// ChainWatcher consulta una red (BSC) por transferencias de stablecoins hacia la wallet de la tienda.
+type ChainWatcher interface {
+	LatestBlock(ctx context.Context) (uint64, error)
+	Transfers(ctx context.Context, fromBlock, toBlock uint64) ([]ChainTransfer, error)
+}
+
+// CryptoRateProvider cotiza stablecoins (usdt/usdc) en ARS.
+type CryptoRateProvider interface {
+	Rate(ctx context.Context, symbol string) (CryptoRate, error)
+}
+
+type ReceiptRepo interface {
+	Save(ctx context.Context, rc *PaymentReceipt) error
+	FindByID(ctx context.Context, id uuid.UUID) (*PaymentReceipt, error)
+	ListByOrder(ctx context.Context, orderID uuid.UUID) ([]PaymentReceipt, error)
+}
+
+type OutboxRepo interface {
+	Save(ctx context.Context, m *OutboxMessage) error
+	FindByID(ctx context.Context, id uuid.UUID) (*OutboxMessage, error)
+	// ClaimDue toma hasta limit avisos pendientes vencidos y les corre el próximo intento lease hacia
+	// adelante, para que otra instancia no los tome mientras se entregan.
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxMessage, error)
+	// List devuelve los avisos filtrados, los más nuevos primero.
+	List(ctx context.Context, f OutboxFilter) ([]OutboxMessage, int64, error)
+}
+
+// WebhookRepo guarda los webhooks salientes y el registro de sus entregas.
+type WebhookRepo interface {
+	ListEndpoints(ctx context.Context) ([]WebhookEndpoint, error)
+	FindEndpoint(ctx context.Context, id uuid.UUID) (*WebhookEndpoint, error)
+	SaveEndpoint(ctx context.Context, e *WebhookEndpoint) error
+	DeleteEndpoint(ctx context.Context, id uuid.UUID) error
+	SaveDelivery(ctx context.Context, d *WebhookDelivery) error
+	FindDelivery(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error)
+	// ClaimDueDeliveries toma hasta limit entregas pendientes vencidas y les corre el próximo intento
+	// lease hacia adelante, igual que OutboxRepo.ClaimDue.
+	ClaimDueDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	// ListDeliveries devuelve las entregas filtradas, las más nuevas primero.
+	ListDeliveries(ctx context.Context, f WebhookDeliveryFilter) ([]WebhookDelivery, int64, error)
+}
+
+// WebhookSender hace el POST de una entrega y devuelve el código HTTP de la respuesta (0 si no la hubo);
+// una respuesta que no es 2xx es un error.
+type WebhookSender interface {
+	Post(ctx context.Context, url string, headers map[string]string, body []byte) (int, error)
+}
+
+// NotificationRepo guarda las plantillas y las reglas de envío de los avisos al staff.
+type NotificationRepo interface {
+	ListTemplates(ctx context.Context) ([]NotificationTemplate, error)
+	FindTemplate(ctx context.Context, event string) (*NotificationTemplate, error)
+	SaveTemplate(ctx context.Context, t *NotificationTemplate) error
+	DeleteTemplate(ctx context.Context, event string) error
+	ListRules(ctx context.Context) ([]NotificationRule, error)
+	SaveRule(ctx context.Context, r *NotificationRule) error
+	FindRule(ctx context.Context, id uuid.UUID) (*NotificationRule, error)
+	DeleteRule(ctx context.Context, id uuid.UUID) error
+}
+
+// WhatsAppSender manda mensajes con plantillas aprobadas de WhatsApp Business. Devuelve el id del mensaje.
+type WhatsAppSender interface {
+	SendTemplate(ctx context.Context, to, template, lang string, params []string) (string, error)
+}
+
+// WhatsAppLogRepo guarda el registro de mensajes de WhatsApp a compradores.
+type WhatsAppLogRepo interface {
+	Save(ctx context.Context, m *WhatsAppMessage) error
+	ListByOrder(ctx context.Context, orderID uuid.UUID) ([]WhatsAppMessage, error)
+	List(ctx context.Context, f WhatsAppFilter) ([]WhatsAppMessage, int64, error)
 }
 
 type Clock interface{ Now() time.Time }
@@ -113,4 +290,83 @@ func (RealClock) Now() time.Time { return time.Now() }
 
 type EmailService interface {
 	SendOrderConfirmation(ctx context.Context, order *Order) error
-}
\ No newline at end of file
+	SendRefundNotice(ctx context.Context, order *Order, refund *Refund) error
+	SendReceiptRejected(ctx context.Context, order *Order, reason string) error
+	SendPaymentReminder(ctx context.Context, order *Order, due time.Time) error
+	SendOrderCancelled(ctx context.Context, order *Order, reason string) error
+	SendRMAUpdate(ctx context.Context, order *Order, rma *RMA) error
+	SendRepairUpdate(ctx context.Context, t *RepairTicket) error
+	SendPreOrderBalance(ctx context.Context, order *Order) error
+	// SendLoginLink manda el link de ingreso a "Mi cuenta"; devuelve error si el email no salió.
+	SendLoginLink(ctx context.Context, to, link string, validFor time.Duration) error
+}
+
+// OrderDocuments genera los PDF imprimibles de una orden.
+type OrderDocuments interface {
+	PackingSlip(o *Order) ([]byte, error)
+	Receipt(o *Order) ([]byte, error)
+}
+
+type RMARepo interface {
+	// Save crea la devolución con sus líneas o actualiza una existente (incluidas las líneas).
+	Save(ctx context.Context, r *RMA) error
+	FindByID(ctx context.Context, id uuid.UUID) (*RMA, error)
+	ListByOrder(ctx context.Context, orderID uuid.UUID) ([]RMA, error)
+	// List devuelve las devoluciones en el estado indicado (todas si status es vacío), las más nuevas primero.
+	List(ctx context.Context, status string, page, pageSize int) ([]RMA, int64, error)
+}
+
+type RepairRepo interface {
+	// Save crea o actualiza la orden de servicio; los repuestos se guardan con SavePart.
+	Save(ctx context.Context, t *RepairTicket) error
+	FindByID(ctx context.Context, id uuid.UUID) (*RepairTicket, error)
+	// FindByOrder devuelve la orden de servicio que se cobra con la orden indicada.
+	FindByOrder(ctx context.Context, orderID uuid.UUID) (*RepairTicket, error)
+	List(ctx context.Context, f RepairFilter) ([]RepairTicket, int64, error)
+	SavePart(ctx context.Context, p *RepairPart) error
+	DeletePart(ctx context.Context, ticketID, partID uuid.UUID) error
+	SaveEvent(ctx context.Context, e *RepairEvent) error
+	// ListEvents devuelve el historial de la orden de servicio, del más viejo al más nuevo.
+	ListEvents(ctx context.Context, ticketID uuid.UUID) ([]RepairEvent, error)
+}
+
+type WarrantyRepo interface {
+	ListPolicies(ctx context.Context) ([]WarrantyPolicy, error)
+	// FindPolicy devuelve ErrNotFound si la categoría no tiene política propia.
+	FindPolicy(ctx context.Context, category string) (*WarrantyPolicy, error)
+	SavePolicy(ctx context.Context, p *WarrantyPolicy) error
+	DeletePolicy(ctx context.Context, category string) error
+	Save(ctx context.Context, w *Warranty) error
+	FindByIMEI(ctx context.Context, imei string) (*Warranty, error)
+	ListByOrder(ctx context.Context, orderID uuid.UUID) ([]Warranty, error)
+	SaveClaim(ctx context.Context, c *WarrantyClaim) error
+	FindClaim(ctx context.Context, id uuid.UUID) (*WarrantyClaim, error)
+	ListClaims(ctx context.Context, status string, page, pageSize int) ([]WarrantyClaim, int64, error)
+	ListClaimsByOrder(ctx context.Context, orderID uuid.UUID) ([]WarrantyClaim, error)
+}
+
+// InvoiceIssuer autoriza comprobantes electrónicos (AFIP WSFEv1 o un stub para pruebas).
+type InvoiceIssuer interface {
+	// Environment identifica la numeración del emisor: produccion, homologacion o stub.
+	Environment() string
+	// LastNumber devuelve el último número autorizado para el punto de venta y tipo.
+	LastNumber(ctx context.Context, pointOfSale int, t InvoiceType) (int64, error)
+	// Authorize pide el CAE; si el emisor rechaza el comprobante devuelve un error que envuelve
+	// ErrInvoiceRejected, distinto de los de conexión (que se pueden reintentar con el mismo número).
+	Authorize(ctx context.Context, req InvoiceRequest) (*InvoiceAuthorization, error)
+	// Lookup consulta un comprobante ya emitido; ErrNotFound si el emisor no lo tiene autorizado.
+	Lookup(ctx context.Context, pointOfSale int, t InvoiceType, number int64) (*InvoiceAuthorization, error)
+}
+
+type InvoiceRepo interface {
+	Save(ctx context.Context, inv *Invoice) error
+	FindByOrder(ctx context.Context, orderID uuid.UUID) (*Invoice, error)
+	// LastNumber devuelve el mayor número guardado (autorizado o reservado) para el entorno, tipo y punto
+	// de venta (0 si no hay); las rechazadas y descartadas no cuentan porque liberaron su número.
+	LastNumber(ctx context.Context, environment string, t InvoiceType, pointOfSale int) (int64, error)
+}
+
+// InvoiceDocuments genera el PDF de una factura con el QR de AFIP.
+type InvoiceDocuments interface {
+	Invoice(inv *Invoice, o *Order) ([]byte, error)
+}
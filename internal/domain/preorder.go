@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrPreOrderMixed se devuelve al comprar juntos productos en preventa y productos con stock: la
+// preventa se cobra y se entrega por separado.
+var ErrPreOrderMixed = errors.New("los productos en preventa se compran por separado")
+
+// DepositFor calcula la seña de una preventa: pct por ciento del monto, redondeado al peso hacia arriba
+// y nunca mayor que el monto.
+func DepositFor(amount, pct float64) float64 {
+	if amount <= 0 || pct <= 0 {
+		return 0
+	}
+	d := math.Ceil(amount * pct / 100)
+	if d > amount {
+		return amount
+	}
+	return d
+}
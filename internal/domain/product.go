@@ -44,10 +44,18 @@ type Variant struct {
 	Cost          float64           `gorm:"type:decimal(12,2);default:0"`
 	Stock         int               `gorm:"type:int;default:0"`
 	ImageURL      string            `gorm:"size:255"`
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	PreOrder      bool              `gorm:"not null;default:false;index"` // se vende sin stock, con seña
+	PreOrderETA   *time.Time        // fecha estimada de llegada que se le muestra al cliente
+	DepositPct    float64           `gorm:"type:decimal(5,2);default:0"` // 0 = seña por defecto (PREORDER_DEPOSIT_PCT)
+	// LowStockAlertedAt es cuándo se avisó al staff que quedaba poco stock; se limpia al reponer.
+	LowStockAlertedAt *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
 
+// PreOrderable indica si la variante se vende en preventa: está marcada y no tiene stock.
+func (v Variant) PreOrderable() bool { return v.PreOrder && v.Stock <= 0 }
+
 type Image struct {
 	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
 	ProductID uuid.UUID `gorm:"type:uuid;index"`
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ReceiptStatusPending  = "pending"
+	ReceiptStatusApproved = "approved"
+	ReceiptStatusRejected = "rejected"
+)
+
+// PaymentReceipt es el comprobante (imagen o PDF) que sube el comprador de una orden por transferencia
+// o cripto para que un admin lo revise.
+type PaymentReceipt struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OrderID     uuid.UUID `gorm:"type:uuid;index"`
+	FileName    string    `gorm:"size:200"`
+	ContentType string    `gorm:"size:60"`
+	Path        string    `gorm:"size:300"` // ubicación en FileStorage (no es pública)
+	Size        int64
+	Status      string `gorm:"size:20;index"`
+	ReviewNote  string `gorm:"type:text"`
+	ReviewedBy  string `gorm:"size:140"`
+	ReviewedAt  *time.Time
+	CreatedAt   time.Time
+}
+
+// ReceiptReview agrupa una orden pendiente de pago con los comprobantes que subió el comprador.
+type ReceiptReview struct {
+	Order    Order
+	Balance  OrderBalance
+	Receipts []PaymentReceipt
+}
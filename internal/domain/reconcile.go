@@ -0,0 +1,29 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReconcileEntry describe una diferencia detectada entre una orden local y su pago en MP.
+type ReconcileEntry struct {
+	OrderID       uuid.UUID
+	Email         string
+	Total         float64
+	OrderStatus   OrderStatus
+	LocalMPStatus string
+	GatewayStatus string
+	PaymentID     string
+	Applied       bool
+	Note          string
+}
+
+// ReconcileReport resume una corrida del conciliador de pagos pendientes.
+type ReconcileReport struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Checked    int
+	Entries    []ReconcileEntry
+	Errors     []string
+}
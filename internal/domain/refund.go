@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// RefundStatusPending es un reembolso guardado antes de pedirlo al gateway: si el pedido falla o no se
+	// sabe si llegó, se reintenta con la misma clave de idempotencia o se descarta a mano.
+	RefundStatusPending   = "pending"
+	RefundStatusApproved  = "approved"
+	RefundStatusManual    = "manual"
+	RefundStatusDiscarded = "discarded"
+)
+
+// Refund registra una devolución de dinero (total o parcial) sobre una orden.
+type Refund struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OrderID    uuid.UUID `gorm:"type:uuid;index"`
+	PaymentID  string    `gorm:"size:40"` // pago del gateway que se reembolsó
+	ExternalID string    `gorm:"size:60"` // id del reembolso en el gateway
+	Amount     float64   `gorm:"type:decimal(12,2)"`
+	Reason     string    `gorm:"type:text"`
+	Status     string    `gorm:"size:20"`
+	Actor      string    `gorm:"size:140"`
+	Lines      []RefundLine
+	CreatedAt  time.Time
+}
+
+// RefundLine detalla qué parte de un item de la orden se devolvió.
+type RefundLine struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	RefundID    uuid.UUID  `gorm:"type:uuid;index"`
+	OrderItemID uuid.UUID  `gorm:"type:uuid;index"`
+	VariantID   *uuid.UUID `gorm:"type:uuid"`
+	Title       string     `gorm:"size:180"`
+	Qty         int        `gorm:"not null;default:0"`
+	Amount      float64    `gorm:"type:decimal(12,2)"`
+	Restocked   bool       `gorm:"not null;default:false"`
+	SkipRestock bool       `gorm:"not null;default:false"`
+}
+
+// Settled indica si el dinero del reembolso ya se devolvió (por el gateway o por fuera del sistema).
+func (rf *Refund) Settled() bool {
+	return rf.Status == RefundStatusApproved || rf.Status == RefundStatusManual
+}
+
+// Active indica si el reembolso descuenta de lo que queda por reembolsar: los pendientes reservan su
+// monto hasta que se confirman o se descartan.
+func (rf *Refund) Active() bool { return rf.Settled() || rf.Status == RefundStatusPending }
+
+// IdempotencyKey es la clave con la que se pide el reembolso al gateway; es fija por reembolso para que
+// un reintento no devuelva el dinero dos veces.
+func (rf *Refund) IdempotencyKey() string { return "refund-" + rf.ID.String() }
+
+// RefundLineRequest es lo que pide el admin para un item: unidades a devolver y monto.
+type RefundLineRequest struct {
+	OrderItemID uuid.UUID
+	Qty         int
+	Amount      float64
+	// SkipRestock no repone el stock de lo devuelto (p.ej. una devolución que llegó dañada).
+	SkipRestock bool
+}
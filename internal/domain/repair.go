@@ -0,0 +1,184 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Estados de una orden de servicio técnico.
+const (
+	RepairStatusReceived         = "received"          // el equipo ingresó al taller
+	RepairStatusDiagnosing       = "diagnosing"        // en revisión
+	RepairStatusAwaitingApproval = "awaiting_approval" // presupuesto enviado, esperando respuesta del cliente
+	RepairStatusRepairing        = "repairing"
+	RepairStatusReady            = "ready" // listo para retirar (reparado o sin reparar si no se aprobó)
+	RepairStatusDelivered        = "delivered"
+)
+
+// RepairStatuses son los estados en el orden del circuito.
+var RepairStatuses = []string{RepairStatusReceived, RepairStatusDiagnosing, RepairStatusAwaitingApproval, RepairStatusRepairing, RepairStatusReady, RepairStatusDelivered}
+
+// repairTransitions define el circuito del taller. Desde diagnóstico se puede pasar directo a reparar
+// (garantía, arreglos sin cargo) o a listo (sin reparación posible); un presupuesto rechazado pasa a listo.
+var repairTransitions = map[string][]string{
+	RepairStatusReceived:         {RepairStatusDiagnosing},
+	RepairStatusDiagnosing:       {RepairStatusAwaitingApproval, RepairStatusRepairing, RepairStatusReady},
+	RepairStatusAwaitingApproval: {RepairStatusRepairing, RepairStatusReady},
+	RepairStatusRepairing:        {RepairStatusReady},
+	RepairStatusReady:            {RepairStatusDelivered},
+}
+
+// RepairStatusLabel es el nombre del estado para mostrar al cliente y en el admin.
+func RepairStatusLabel(st string) string {
+	switch st {
+	case RepairStatusReceived:
+		return "Recibido"
+	case RepairStatusDiagnosing:
+		return "En diagnóstico"
+	case RepairStatusAwaitingApproval:
+		return "Esperando aprobación del presupuesto"
+	case RepairStatusRepairing:
+		return "En reparación"
+	case RepairStatusReady:
+		return "Listo para retirar"
+	case RepairStatusDelivered:
+		return "Entregado"
+	}
+	return st
+}
+
+// RepairTicket es una orden de servicio técnico: el equipo que deja el cliente, el diagnóstico, el
+// presupuesto y los repuestos usados. El cobro se hace con una Order común (OrderID).
+type RepairTicket struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	Status     string     `gorm:"size:30;index"`
+	CustomerID *uuid.UUID `gorm:"type:uuid;index"`
+	Name       string     `gorm:"size:140"`
+	Email      string     `gorm:"size:140;index"`
+	Phone      string     `gorm:"size:50"`
+	DNI        string     `gorm:"size:30"`
+	Brand      string     `gorm:"size:60"`
+	Model      string     `gorm:"size:120"`
+	IMEI       string     `gorm:"size:20;index"`
+	// Accessories es lo que se recibió junto con el equipo (cargador, funda, chip…).
+	Accessories string `gorm:"size:255"`
+	Fault       string `gorm:"type:text"` // falla que reporta el cliente
+	Diagnostic  string `gorm:"type:text"`
+	// EstimatedBudget es el presupuesto que se le pasa al cliente; ApprovedBudget lo que aceptó y se cobra.
+	EstimatedBudget float64 `gorm:"type:decimal(12,2);default:0"`
+	ApprovedBudget  float64 `gorm:"type:decimal(12,2);default:0"`
+	ApprovedAt      *time.Time
+	BudgetRejected  bool         `gorm:"not null;default:false"`
+	StaffNote       string       `gorm:"type:text"`
+	OrderID         *uuid.UUID   `gorm:"type:uuid;index"` // orden con la que se cobra la reparación
+	Parts           []RepairPart `gorm:"foreignKey:TicketID"`
+	DeliveredAt     *time.Time
+	CreatedAt       time.Time `gorm:"index"`
+	UpdatedAt       time.Time
+}
+
+// RepairPart es un repuesto del stock (una variante) usado en la reparación.
+type RepairPart struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	TicketID  uuid.UUID  `gorm:"type:uuid;index"`
+	ProductID *uuid.UUID `gorm:"type:uuid"`
+	VariantID uuid.UUID  `gorm:"type:uuid;index"`
+	Title     string     `gorm:"size:180"`
+	SKU       string     `gorm:"size:120"`
+	Qty       int        `gorm:"not null"`
+	UnitCost  float64    `gorm:"type:decimal(12,2);default:0"`
+	Actor     string     `gorm:"size:140"`
+	CreatedAt time.Time
+}
+
+// RepairEvent registra un cambio de estado (o un hito) de la orden de servicio.
+type RepairEvent struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	TicketID  uuid.UUID `gorm:"type:uuid;index"`
+	From      string    `gorm:"size:30"`
+	To        string    `gorm:"size:30"`
+	Actor     string    `gorm:"size:140"`
+	Note      string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// RepairFilter son los criterios del listado de órdenes de servicio del admin.
+type RepairFilter struct {
+	Status string
+	// Query busca por IMEI, nombre, email o teléfono (coincidencia parcial).
+	Query    string
+	Page     int
+	PageSize int
+}
+
+// Number es el identificador corto que se le comunica al cliente.
+func (t *RepairTicket) Number() string { return "ST-" + strings.ToUpper(t.ID.String()[:8]) }
+
+// StatusLabel es RepairStatusLabel del estado actual, para las vistas.
+func (t *RepairTicket) StatusLabel() string { return RepairStatusLabel(t.Status) }
+
+// Device es marca y modelo del equipo.
+func (t *RepairTicket) Device() string { return strings.TrimSpace(t.Brand + " " + t.Model) }
+
+// NextStatuses devuelve los estados a los que puede pasar la orden de servicio.
+func (t *RepairTicket) NextStatuses() []string {
+	return append([]string(nil), repairTransitions[t.Status]...)
+}
+
+// ValidateTransition devuelve ErrInvalidTransition si la orden de servicio no puede pasar a to.
+func (t *RepairTicket) ValidateTransition(to string) error {
+	for _, st := range repairTransitions[t.Status] {
+		if st == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: servicio técnico %s → %s", ErrInvalidTransition, t.Status, to)
+}
+
+// FromLabel y ToLabel son los nombres de los estados del evento, para las vistas.
+func (e RepairEvent) FromLabel() string { return RepairStatusLabel(e.From) }
+func (e RepairEvent) ToLabel() string   { return RepairStatusLabel(e.To) }
+
+// PartsEditable indica si todavía se pueden cargar o quitar repuestos.
+func (t *RepairTicket) PartsEditable() bool {
+	switch t.Status {
+	case RepairStatusDiagnosing, RepairStatusAwaitingApproval, RepairStatusRepairing:
+		return true
+	}
+	return false
+}
+
+// PartsCost es el costo de los repuestos usados.
+func (t *RepairTicket) PartsCost() float64 {
+	total := 0.0
+	for _, p := range t.Parts {
+		total += p.UnitCost * float64(p.Qty)
+	}
+	return total
+}
+
+// ValidIMEI indica si s es un IMEI de 15 dígitos con el dígito verificador (Luhn) correcto.
+func ValidIMEI(s string) bool {
+	if len(s) != 15 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 15; i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
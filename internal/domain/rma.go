@@ -0,0 +1,161 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Estados de una devolución (RMA).
+const (
+	RMAStatusRequested = "requested" // pedida por el cliente, a revisar
+	RMAStatusApproved  = "approved"  // aprobada, esperando que llegue el producto
+	RMAStatusRejected  = "rejected"
+	RMAStatusReceived  = "received"  // el producto llegó al local
+	RMAStatusInspected = "inspected" // revisado: estado y si se puede revender
+	RMAStatusResolved  = "resolved"  // reembolsado, cambiado o reparado
+)
+
+// rmaTransitions define el circuito de una devolución; rechazar se puede hasta recibir el producto.
+var rmaTransitions = map[string][]string{
+	RMAStatusRequested: {RMAStatusApproved, RMAStatusRejected},
+	RMAStatusApproved:  {RMAStatusReceived, RMAStatusRejected},
+	RMAStatusReceived:  {RMAStatusInspected},
+	RMAStatusInspected: {RMAStatusResolved},
+}
+
+// Resoluciones de una devolución.
+const (
+	RMAResolutionRefund   = "refund"
+	RMAResolutionExchange = "exchange"
+	RMAResolutionRepair   = "repair"
+)
+
+// RMAResolutions son las resoluciones válidas, en el orden en que se ofrecen.
+var RMAResolutions = []string{RMAResolutionRefund, RMAResolutionExchange, RMAResolutionRepair}
+
+// RMAReasons son los motivos que puede elegir el cliente.
+var RMAReasons = []string{"Producto defectuoso", "No es lo que pedí", "Llegó dañado", "Me arrepentí de la compra", "Otro"}
+
+// Estados del producto devuelto (declarado por el cliente o revisado por el staff).
+var RMAConditions = []string{"Sin abrir", "Abierto, sin uso", "Usado", "Dañado"}
+
+// RMAStatusLabel es el nombre del estado para mostrar al cliente y en el admin.
+func RMAStatusLabel(st string) string {
+	switch st {
+	case RMAStatusRequested:
+		return "Solicitada"
+	case RMAStatusApproved:
+		return "Aprobada"
+	case RMAStatusRejected:
+		return "Rechazada"
+	case RMAStatusReceived:
+		return "Recibida"
+	case RMAStatusInspected:
+		return "Revisada"
+	case RMAStatusResolved:
+		return "Resuelta"
+	}
+	return st
+}
+
+// RMAResolutionLabel es el nombre de la resolución para mostrar.
+func RMAResolutionLabel(r string) string {
+	switch r {
+	case RMAResolutionRefund:
+		return "Reembolso"
+	case RMAResolutionExchange:
+		return "Cambio"
+	case RMAResolutionRepair:
+		return "Reparación"
+	}
+	return r
+}
+
+// RMA es una devolución de uno o más items de una orden.
+type RMA struct {
+	ID      uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OrderID uuid.UUID `gorm:"type:uuid;index"`
+	Status  string    `gorm:"size:20;index"`
+	Reason  string    `gorm:"size:120"`
+	// Condition es el estado del producto que declara el cliente; InspectedCondition el que constata el staff.
+	Condition          string `gorm:"size:40"`
+	InspectedCondition string `gorm:"size:40"`
+	// Resellable indica si lo devuelto vuelve al stock al resolver.
+	Resellable bool `gorm:"not null;default:false"`
+	// RequestedResolution es lo que pidió el cliente; Resolution lo que se resolvió.
+	RequestedResolution string     `gorm:"size:20"`
+	Resolution          string     `gorm:"size:20"`
+	CustomerNote        string     `gorm:"type:text"`
+	StaffNote           string     `gorm:"type:text"`
+	RefundID            *uuid.UUID `gorm:"type:uuid"`
+	ExchangeOrderID     *uuid.UUID `gorm:"type:uuid"`
+	Lines               []RMALine
+	ApprovedAt          *time.Time
+	ReceivedAt          *time.Time
+	InspectedAt         *time.Time
+	ResolvedAt          *time.Time
+	CreatedAt           time.Time `gorm:"index"`
+	UpdatedAt           time.Time
+}
+
+// RMALine es un item de la orden (y cuántas unidades) incluido en la devolución.
+type RMALine struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	RMAID       uuid.UUID  `gorm:"type:uuid;index"`
+	OrderItemID uuid.UUID  `gorm:"type:uuid;index"`
+	ProductID   *uuid.UUID `gorm:"type:uuid"`
+	VariantID   *uuid.UUID `gorm:"type:uuid"`
+	Title       string     `gorm:"size:180"`
+	Color       string     `gorm:"size:60"`
+	SKU         string     `gorm:"size:120"`
+	Qty         int        `gorm:"not null"`
+	UnitPrice   float64    `gorm:"type:decimal(12,2)"`
+	Restocked   bool       `gorm:"not null;default:false"`
+}
+
+// RMALineRequest son las unidades de un item que el cliente quiere devolver.
+type RMALineRequest struct {
+	OrderItemID uuid.UUID
+	Qty         int
+}
+
+// Number es el identificador corto que se le comunica al cliente.
+func (r *RMA) Number() string { return "RMA-" + r.ID.String()[:8] }
+
+// Open indica si la devolución sigue en curso.
+func (r *RMA) Open() bool { return r.Status != RMAStatusRejected && r.Status != RMAStatusResolved }
+
+// NextStatuses devuelve los estados a los que puede pasar la devolución.
+func (r *RMA) NextStatuses() []string { return append([]string(nil), rmaTransitions[r.Status]...) }
+
+// ValidateTransition devuelve ErrInvalidTransition si la devolución no puede pasar a to.
+func (r *RMA) ValidateTransition(to string) error {
+	for _, st := range rmaTransitions[r.Status] {
+		if st == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: devolución %s → %s", ErrInvalidTransition, r.Status, to)
+}
+
+// Amount es lo cobrado por las unidades devueltas.
+func (r *RMA) Amount() float64 {
+	total := 0.0
+	for _, l := range r.Lines {
+		total += l.UnitPrice * float64(l.Qty)
+	}
+	return total
+}
+
+// StatusLabel es RMAStatusLabel del estado actual, para las vistas.
+func (r *RMA) StatusLabel() string { return RMAStatusLabel(r.Status) }
+
+// ResolutionLabel es RMAResolutionLabel de la resolución aplicada (o la pedida, si todavía no se resolvió).
+func (r *RMA) ResolutionLabel() string {
+	if r.Resolution != "" {
+		return RMAResolutionLabel(r.Resolution)
+	}
+	return RMAResolutionLabel(r.RequestedResolution)
+}
@@ -0,0 +1,160 @@
+package domain
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WarrantyPolicy es la garantía de la tienda para una categoría de productos. Las categorías sin
+// política usan la duración por defecto (WARRANTY_MONTHS).
+type WarrantyPolicy struct {
+	Category  string `gorm:"size:100;primaryKey"`
+	Months    int    `gorm:"not null"`
+	Terms     string `gorm:"type:text"` // condiciones que se le muestran al cliente
+	UpdatedAt time.Time
+}
+
+// Warranty registra una unidad vendida por IMEI, con la duración y las condiciones vigentes al momento
+// de registrarla: un cambio posterior de la política no afecta a lo ya vendido.
+type Warranty struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OrderID     uuid.UUID `gorm:"type:uuid;index"`
+	OrderItemID uuid.UUID `gorm:"type:uuid;index"`
+	IMEI        string    `gorm:"size:20;uniqueIndex"`
+	Title       string    `gorm:"size:180"`
+	Category    string    `gorm:"size:100"`
+	Months      int       `gorm:"not null"`
+	Terms       string    `gorm:"type:text"`
+	StartsAt    time.Time // fecha de pago de la orden
+	EndsAt      time.Time `gorm:"index"`
+	Actor       string    `gorm:"size:140"`
+	CreatedAt   time.Time
+}
+
+// Estados de cobertura de un item vendido.
+const (
+	WarrantyStatusActive  = "active"
+	WarrantyStatusExpired = "expired"
+	WarrantyStatusNone    = "none"   // la categoría no tiene garantía
+	WarrantyStatusUnpaid  = "unpaid" // la orden no está pagada: la garantía todavía no empezó
+)
+
+// WarrantyStatusLabel es el nombre del estado de cobertura para mostrar.
+func WarrantyStatusLabel(st string) string {
+	switch st {
+	case WarrantyStatusActive:
+		return "En garantía"
+	case WarrantyStatusExpired:
+		return "Garantía vencida"
+	case WarrantyStatusNone:
+		return "Sin garantía"
+	case WarrantyStatusUnpaid:
+		return "Pendiente de pago"
+	}
+	return st
+}
+
+// WarrantyCoverage es la cobertura de un item de una orden. Units son las unidades registradas por IMEI
+// (en una búsqueda por IMEI, sólo la encontrada).
+type WarrantyCoverage struct {
+	OrderID  uuid.UUID
+	Item     OrderItem
+	Units    []Warranty
+	Months   int
+	Terms    string
+	StartsAt *time.Time
+	EndsAt   *time.Time
+	Status   string
+}
+
+// StatusLabel es WarrantyStatusLabel del estado de la cobertura, para las vistas.
+func (c WarrantyCoverage) StatusLabel() string { return WarrantyStatusLabel(c.Status) }
+
+// Active indica si el item está en garantía.
+func (c WarrantyCoverage) Active() bool { return c.Status == WarrantyStatusActive }
+
+// Pending son las unidades del item que todavía no tienen IMEI registrado.
+func (c WarrantyCoverage) Pending() int {
+	if n := c.Item.Qty - len(c.Units); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// WarrantyStatusAt calcula el estado de cobertura en now para una garantía de months meses que empezó
+// en start (nil si la orden no está pagada).
+func WarrantyStatusAt(start *time.Time, months int, now time.Time) (string, *time.Time) {
+	if start == nil {
+		return WarrantyStatusUnpaid, nil
+	}
+	if months <= 0 {
+		return WarrantyStatusNone, nil
+	}
+	end := start.AddDate(0, months, 0)
+	if now.After(end) {
+		return WarrantyStatusExpired, &end
+	}
+	return WarrantyStatusActive, &end
+}
+
+// Estados y resultados de un reclamo de garantía.
+const (
+	WarrantyClaimOpen     = "open"
+	WarrantyClaimResolved = "resolved"
+
+	WarrantyOutcomeRepaired = "repaired"
+	WarrantyOutcomeReplaced = "replaced"
+	WarrantyOutcomeRefunded = "refunded"
+	WarrantyOutcomeRejected = "rejected" // no cubierto (golpe, humedad, fuera de término…)
+)
+
+// WarrantyOutcomes son los resultados posibles al cerrar un reclamo.
+var WarrantyOutcomes = []string{WarrantyOutcomeRepaired, WarrantyOutcomeReplaced, WarrantyOutcomeRefunded, WarrantyOutcomeRejected}
+
+// WarrantyOutcomeLabel es el nombre del resultado de un reclamo para mostrar.
+func WarrantyOutcomeLabel(o string) string {
+	switch o {
+	case WarrantyOutcomeRepaired:
+		return "Reparado"
+	case WarrantyOutcomeReplaced:
+		return "Reemplazado"
+	case WarrantyOutcomeRefunded:
+		return "Reembolsado"
+	case WarrantyOutcomeRejected:
+		return "Rechazado (no cubierto)"
+	}
+	return o
+}
+
+// WarrantyClaim es un reclamo de garantía sobre un item vendido. InWarranty guarda si el item estaba
+// cubierto al abrir el reclamo; Cost es lo que le costó a la tienda resolverlo.
+type WarrantyClaim struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	OrderID     uuid.UUID  `gorm:"type:uuid;index"`
+	OrderItemID uuid.UUID  `gorm:"type:uuid;index"`
+	WarrantyID  *uuid.UUID `gorm:"type:uuid;index"`
+	IMEI        string     `gorm:"size:20;index"`
+	Title       string     `gorm:"size:180"`
+	Problem     string     `gorm:"type:text"`
+	InWarranty  bool       `gorm:"not null;default:false"`
+	Status      string     `gorm:"size:20;index"`
+	Outcome     string     `gorm:"size:20"`
+	Cost        float64    `gorm:"type:decimal(12,2);default:0"`
+	Resolution  string     `gorm:"type:text"`
+	Actor       string     `gorm:"size:140"`
+	ResolvedBy  string     `gorm:"size:140"`
+	ResolvedAt  *time.Time
+	CreatedAt   time.Time `gorm:"index"`
+	UpdatedAt   time.Time
+}
+
+// Number es el identificador corto del reclamo.
+func (c *WarrantyClaim) Number() string { return "GT-" + strings.ToUpper(c.ID.String()[:8]) }
+
+// Open indica si el reclamo todavía no se cerró.
+func (c *WarrantyClaim) Open() bool { return c.Status == WarrantyClaimOpen }
+
+// OutcomeLabel es WarrantyOutcomeLabel del resultado, para las vistas.
+func (c *WarrantyClaim) OutcomeLabel() string { return WarrantyOutcomeLabel(c.Outcome) }
@@ -0,0 +1,221 @@
+package domain
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Eventos que se publican a los webhooks salientes (contabilidad, logística…).
+const (
+	WebhookOrderCreated   = "order.created"
+	WebhookOrderPaid      = "order.paid"
+	WebhookOrderShipped   = "order.shipped"
+	WebhookOrderCancelled = "order.cancelled"
+	WebhookProductUpdated = "product.updated"
+	WebhookStockChanged   = "stock.changed"
+	// WebhookPing es la prueba que se manda desde el admin a un solo webhook.
+	WebhookPing = "ping"
+)
+
+// WebhookEvents son los eventos a los que se puede suscribir un webhook, en el orden del admin.
+var WebhookEvents = []string{WebhookOrderCreated, WebhookOrderPaid, WebhookOrderShipped, WebhookOrderCancelled, WebhookProductUpdated, WebhookStockChanged}
+
+// WebhookEventLabel nombra el evento para el admin.
+func WebhookEventLabel(event string) string {
+	switch event {
+	case WebhookOrderCreated:
+		return "Orden creada"
+	case WebhookOrderPaid:
+		return "Orden pagada"
+	case WebhookOrderShipped:
+		return "Orden despachada"
+	case WebhookOrderCancelled:
+		return "Orden cancelada"
+	case WebhookProductUpdated:
+		return "Producto actualizado"
+	case WebhookStockChanged:
+		return "Cambio de stock"
+	case WebhookPing:
+		return "Prueba"
+	}
+	return event
+}
+
+// WebhookEventForNotification devuelve el evento de webhook de un evento de orden del outbox
+// (EventOrderCreated…), o "" si no se publica.
+func WebhookEventForNotification(event string) string {
+	switch event {
+	case EventOrderCreated:
+		return WebhookOrderCreated
+	case EventOrderPaid:
+		return WebhookOrderPaid
+	case EventOrderShipped:
+		return WebhookOrderShipped
+	case EventOrderCancelled:
+		return WebhookOrderCancelled
+	}
+	return ""
+}
+
+// WebhookEndpoint es una URL que recibe por POST los eventos a los que está suscripta, firmados con
+// su secreto.
+type WebhookEndpoint struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Name      string    `gorm:"size:80"`
+	URL       string    `gorm:"size:500"`
+	Secret    string    `gorm:"size:100"`
+	Events    []string  `gorm:"type:jsonb;serializer:json"`
+	Active    bool      `gorm:"not null;default:true"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (WebhookEndpoint) TableName() string { return "webhook_endpoints" }
+
+// Subscribed indica si el webhook recibe el evento.
+func (e WebhookEndpoint) Subscribed(event string) bool {
+	for _, ev := range e.Events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+// EventLabels nombra los eventos suscriptos.
+func (e WebhookEndpoint) EventLabels() []string {
+	out := make([]string, 0, len(e.Events))
+	for _, ev := range e.Events {
+		out = append(out, WebhookEventLabel(ev))
+	}
+	return out
+}
+
+// SecretHint muestra sólo el final del secreto.
+func (e WebhookEndpoint) SecretHint() string {
+	if len(e.Secret) <= 4 {
+		return "••••"
+	}
+	return "••••" + e.Secret[len(e.Secret)-4:]
+}
+
+// NewWebhookSecret genera un secreto de firma al azar.
+func NewWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.New("no se pudo generar el secreto")
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// WebhookSignature firma el cuerpo de una entrega: HMAC-SHA256 con el secreto del webhook sobre
+// "<timestamp>.<cuerpo>", en hexadecimal y con el prefijo sha256=. El receptor la recalcula con el
+// timestamp de X-Webhook-Timestamp para validar el origen y descartar reenvíos viejos.
+func WebhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+const (
+	WebhookStatusPending   = "pending"
+	WebhookStatusDelivered = "delivered"
+	WebhookStatusDead      = "dead"
+)
+
+// WebhookDelivery es el envío de un evento a un webhook. Queda pendiente hasta que el receptor responda
+// 2xx; cada intento fallido se reprograma con backoff y, agotados los intentos, queda muerta hasta
+// que un admin la reenvíe. El cuerpo se arma al publicar el evento y no cambia entre intentos.
+type WebhookDelivery struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	EndpointID uuid.UUID `gorm:"type:uuid;index"`
+	// EventID identifica el evento; es el mismo en todos los webhooks y en los reenvíos.
+	EventID       uuid.UUID `gorm:"type:uuid;index"`
+	Event         string    `gorm:"size:40;index"`
+	Payload       string    `gorm:"type:text"`
+	Status        string    `gorm:"size:20;index"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"index"`
+	ResponseCode  int
+	LastError     string `gorm:"type:text"`
+	// RedeliveryOf es la entrega original cuando ésta es un reenvío manual.
+	RedeliveryOf *uuid.UUID `gorm:"type:uuid"`
+	DeliveredAt  *time.Time
+	CreatedAt    time.Time `gorm:"index"`
+	UpdatedAt    time.Time
+}
+
+func (WebhookDelivery) TableName() string { return "webhook_deliveries" }
+
+func (d WebhookDelivery) EventLabel() string { return WebhookEventLabel(d.Event) }
+
+// NewWebhookDelivery arma una entrega pendiente para mandar en cuanto el worker la tome.
+func NewWebhookDelivery(endpointID, eventID uuid.UUID, event, payload string, now time.Time) WebhookDelivery {
+	return WebhookDelivery{
+		ID:            uuid.New(),
+		EndpointID:    endpointID,
+		EventID:       eventID,
+		Event:         event,
+		Payload:       payload,
+		Status:        WebhookStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// MarkDelivered registra la entrega con el código que respondió el receptor.
+func (d *WebhookDelivery) MarkDelivered(code int, now time.Time) {
+	d.Attempts++
+	d.ResponseCode = code
+	d.Status = WebhookStatusDelivered
+	d.DeliveredAt = &now
+	d.LastError = ""
+}
+
+// MarkFailed registra un intento fallido (code es 0 si no hubo respuesta) y reprograma el próximo con el
+// mismo backoff que el outbox de avisos; agotados los intentos queda muerta.
+func (d *WebhookDelivery) MarkFailed(err error, code int, now time.Time, maxAttempts int) {
+	d.Attempts++
+	d.ResponseCode = code
+	d.LastError = err.Error()
+	if d.Attempts >= maxAttempts {
+		d.Status = WebhookStatusDead
+		return
+	}
+	d.NextAttemptAt = now.Add(OutboxBackoff(d.Attempts))
+}
+
+// Kill deja la entrega muerta sin más intentos (p.ej. el webhook se borró).
+func (d *WebhookDelivery) Kill(err error) {
+	d.Status = WebhookStatusDead
+	d.LastError = err.Error()
+}
+
+// Redelivery arma un reenvío manual de la entrega: el mismo evento y cuerpo, como una entrega nueva,
+// así el registro conserva los intentos de la original.
+func (d WebhookDelivery) Redelivery(now time.Time) WebhookDelivery {
+	r := NewWebhookDelivery(d.EndpointID, d.EventID, d.Event, d.Payload, now)
+	id := d.ID
+	if d.RedeliveryOf != nil {
+		id = *d.RedeliveryOf
+	}
+	r.RedeliveryOf = &id
+	return r
+}
+
+// WebhookDeliveryFilter son los criterios del registro de entregas del admin.
+type WebhookDeliveryFilter struct {
+	Status     string
+	EndpointID *uuid.UUID
+	Page       int
+	PageSize   int
+}
@@ -0,0 +1,38 @@
+package domain
+
+import "testing"
+
+func TestWebhookSignature(t *testing.T) {
+	// Los valores esperados se calcularon aparte (HMAC-SHA256 de "<timestamp>.<cuerpo>"), como lo haría el receptor.
+	cases := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{"evento", "whsec_test", 1700000000, `{"event":"order.created"}`, "sha256=44ccdd37cc0cde29381624e0495514ce79007393020fddb05c89075cd26cc6bd"},
+		{"vacío", "", 0, "", "sha256=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3"},
+		{"cuerpo con punto", "k", 1, "a.b", "sha256=3a291a6ef707d00430135e613ee22385a140c627f418cc26d716d44b467630b0"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := WebhookSignature(c.secret, c.timestamp, []byte(c.body)); got != c.want {
+				t.Errorf("WebhookSignature = %s, quería %s", got, c.want)
+			}
+		})
+	}
+}
+
+func TestWebhookSignatureDependsOnEveryInput(t *testing.T) {
+	base := WebhookSignature("whsec_test", 1700000000, []byte(`{"a":1}`))
+	for name, sig := range map[string]string{
+		"secreto":   WebhookSignature("whsec_otro", 1700000000, []byte(`{"a":1}`)),
+		"timestamp": WebhookSignature("whsec_test", 1700000001, []byte(`{"a":1}`)),
+		"cuerpo":    WebhookSignature("whsec_test", 1700000000, []byte(`{"a":2}`)),
+	} {
+		if sig == base {
+			t.Errorf("cambiar el %s no cambia la firma", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Mensajes de WhatsApp al comprador. Cada uno sale con una plantilla aprobada en WhatsApp Business.
+const (
+	WhatsAppOrderReceived    = "order_received"
+	WhatsAppPaymentConfirmed = "payment_confirmed"
+	WhatsAppReadyForPickup   = "ready_for_pickup"
+	WhatsAppShipped          = "shipped"
+)
+
+var WhatsAppEvents = []string{WhatsAppOrderReceived, WhatsAppPaymentConfirmed, WhatsAppReadyForPickup, WhatsAppShipped}
+
+// WhatsAppEventLabel nombra el mensaje para el admin.
+func WhatsAppEventLabel(event string) string {
+	switch event {
+	case WhatsAppOrderReceived:
+		return "Pedido recibido"
+	case WhatsAppPaymentConfirmed:
+		return "Pago confirmado"
+	case WhatsAppReadyForPickup:
+		return "Listo para retirar"
+	case WhatsAppShipped:
+		return "Despachado"
+	}
+	return event
+}
+
+const (
+	WhatsAppStatusSent    = "sent"
+	WhatsAppStatusFailed  = "failed"
+	WhatsAppStatusSkipped = "skipped" // no se intentó: sin opt-in, sin plantilla o sin WhatsApp configurado
+)
+
+// WhatsAppMessage registra cada envío de WhatsApp a un comprador, salga o no.
+type WhatsAppMessage struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	OrderID    uuid.UUID `gorm:"type:uuid;index"`
+	Event      string    `gorm:"size:40"`
+	To         string    `gorm:"size:20"`
+	Template   string    `gorm:"size:80"`
+	Params     []string  `gorm:"type:jsonb;serializer:json"`
+	Status     string    `gorm:"size:20;index"`
+	ProviderID string    `gorm:"size:120"` // id del mensaje en WhatsApp (wamid)
+	Error      string    `gorm:"type:text"`
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+func (WhatsAppMessage) TableName() string { return "whatsapp_messages" }
+
+func (m WhatsAppMessage) EventLabel() string { return WhatsAppEventLabel(m.Event) }
+
+// WhatsAppFilter son los criterios del registro de envíos del admin.
+type WhatsAppFilter struct {
+	Status   string
+	OrderID  *uuid.UUID
+	Page     int
+	PageSize int
+}
+
+// NormalizeARPhone arma el número en E.164 a partir del código de área y el número que se cargan en el
+// checkout: saca el 0 del área y el 15 de los celulares, y agrega el 9 que WhatsApp usa para los
+// celulares argentinos (+54 9 341 6543210). Sin mobile, el número queda como línea fija (+54 341…).
+func NormalizeARPhone(areaCode, number string, mobile bool) (string, error) {
+	area := OnlyDigits(areaCode)
+	num := OnlyDigits(number)
+	area = strings.TrimPrefix(area, "0")
+	if len(area) < 2 || len(area) > 4 {
+		return "", errors.New("código de área inválido")
+	}
+	if len(area)+len(num) == 12 && strings.HasPrefix(num, "15") {
+		num = num[2:]
+	}
+	if len(area)+len(num) != 10 {
+		return "", errors.New("el código de área y el número tienen que sumar 10 dígitos")
+	}
+	if mobile {
+		return "+549" + area + num, nil
+	}
+	return "+54" + area + num, nil
+}
@@ -0,0 +1,241 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// Mismos formatos que pide el checkout.
+var (
+	accountDNIRe    = regexp.MustCompile(`^\d{7,8}$`)
+	accountPostalRe = regexp.MustCompile(`^\d{4,5}$`)
+)
+
+// AccountUC es el área "Mi cuenta" de los clientes que ingresan con Google o con un link por email: sus
+// órdenes, direcciones guardadas y datos de facturación.
+type AccountUC struct {
+	Customers domain.CustomerRepo
+	Orders    domain.OrderRepo
+	Events    domain.OrderEventRepo
+	Clock     domain.Clock
+}
+
+// Login registra el ingreso del cliente: lo crea si es la primera vez y le asigna las órdenes que hizo
+// como invitado con el mismo email.
+func (uc *AccountUC) Login(ctx context.Context, email, name string) (*domain.Customer, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil, errors.New("email vacío")
+	}
+	now := uc.now()
+	c, err := uc.Customers.FindByEmail(ctx, email)
+	if errors.Is(err, domain.ErrNotFound) {
+		c = &domain.Customer{ID: uuid.New(), Email: email, Name: strings.TrimSpace(name), CreatedAt: now}
+	} else if err != nil {
+		return nil, err
+	}
+	if c.Name == "" {
+		c.Name = strings.TrimSpace(name)
+	}
+	firstLogin := c.LastLoginAt == nil
+	c.LastLoginAt = &now
+	if err := uc.Customers.Save(ctx, c); err != nil {
+		return nil, err
+	}
+	// Se revisa en cada ingreso y no sólo en el primero: pueden quedar órdenes sin cliente de checkouts
+	// en los que no se pudo guardar, o cargadas a mano desde el admin.
+	n, err := uc.Orders.LinkGuestOrders(ctx, c.ID, email)
+	if err != nil {
+		log.Error().Err(err).Str("customer", c.ID.String()).Msg("cuenta: no se pudieron vincular las órdenes de invitado")
+	} else if n > 0 {
+		log.Info().Int64("orders", n).Bool("first_login", firstLogin).Str("customer", c.ID.String()).Msg("cuenta: órdenes de invitado vinculadas")
+	}
+	return c, nil
+}
+
+// Customer devuelve el cliente de la sesión.
+func (uc *AccountUC) Customer(ctx context.Context, email string) (*domain.Customer, error) {
+	return uc.Customers.FindByEmail(ctx, email)
+}
+
+// UpdateProfile valida y guarda los datos personales y de facturación del cliente. El email no se
+// edita: es con el que ingresa.
+func (uc *AccountUC) UpdateProfile(ctx context.Context, c *domain.Customer, p domain.CustomerProfile) error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Phone = strings.TrimSpace(p.Phone)
+	p.DNI = domain.OnlyDigits(p.DNI)
+	p.TaxID = domain.OnlyDigits(p.TaxID)
+	p.TaxCondition = strings.ToUpper(strings.TrimSpace(p.TaxCondition))
+	if p.Name == "" {
+		return errors.New("el nombre es obligatorio")
+	}
+	if p.DNI != "" && !accountDNIRe.MatchString(p.DNI) {
+		return errors.New("el DNI debe tener 7 u 8 dígitos")
+	}
+	switch p.TaxCondition {
+	case "", domain.TaxConditionRI, domain.TaxConditionMT, domain.TaxConditionEX, domain.TaxConditionCF:
+	default:
+		return fmt.Errorf("condición frente al IVA inválida: %q", p.TaxCondition)
+	}
+	if p.TaxID != "" && len(p.TaxID) != 11 {
+		return errors.New("el CUIT debe tener 11 dígitos")
+	}
+	if p.TaxID != "" && p.TaxCondition == "" {
+		return errors.New("elegí la condición frente al IVA del CUIT")
+	}
+	c.Name, c.Phone, c.DNI, c.TaxID, c.TaxCondition = p.Name, p.Phone, p.DNI, p.TaxID, p.TaxCondition
+	return uc.Customers.Save(ctx, c)
+}
+
+// ListOrders lista las órdenes del cliente, las más nuevas primero.
+func (uc *AccountUC) ListOrders(ctx context.Context, c *domain.Customer, page, pageSize int) ([]domain.Order, int64, error) {
+	return uc.Orders.ListByCustomer(ctx, c.ID, c.Email, page, pageSize)
+}
+
+// FindOrder devuelve una orden del cliente con su historial de estados. Si la orden es de otro cliente
+// responde domain.ErrNotFound, igual que si no existiera.
+func (uc *AccountUC) FindOrder(ctx context.Context, c *domain.Customer, id uuid.UUID) (*domain.Order, []domain.OrderStatusEvent, error) {
+	o, err := uc.Orders.FindByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	owned := o.CustomerID != nil && *o.CustomerID == c.ID
+	if !owned && !strings.EqualFold(strings.TrimSpace(o.Email), c.Email) {
+		return nil, nil, domain.ErrNotFound
+	}
+	var events []domain.OrderStatusEvent
+	if uc.Events != nil {
+		if events, err = uc.Events.ListByOrder(ctx, o.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+	return o, events, nil
+}
+
+func (uc *AccountUC) Addresses(ctx context.Context, c *domain.Customer) ([]domain.CustomerAddress, error) {
+	return uc.Customers.ListAddresses(ctx, c.ID)
+}
+
+// SaveAddress crea (a.ID nulo) o edita una dirección del cliente. La primera que guarda queda como
+// predeterminada.
+func (uc *AccountUC) SaveAddress(ctx context.Context, c *domain.Customer, a domain.CustomerAddress) (*domain.CustomerAddress, error) {
+	a.Label = strings.TrimSpace(a.Label)
+	a.Address = strings.TrimSpace(a.Address)
+	a.PostalCode = strings.TrimSpace(a.PostalCode)
+	a.Province = strings.TrimSpace(a.Province)
+	a.Notes = strings.TrimSpace(a.Notes)
+	if a.Address == "" || a.Province == "" {
+		return nil, errors.New("completá la dirección y la provincia")
+	}
+	if !accountPostalRe.MatchString(a.PostalCode) {
+		return nil, errors.New("el código postal debe tener 4 o 5 dígitos")
+	}
+	list, err := uc.Customers.ListAddresses(ctx, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := uc.now()
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+		a.CreatedAt = now
+		a.IsDefault = a.IsDefault || len(list) == 0
+	} else {
+		var cur *domain.CustomerAddress
+		for i := range list {
+			if list[i].ID == a.ID {
+				cur = &list[i]
+			}
+		}
+		if cur == nil {
+			return nil, domain.ErrNotFound
+		}
+		a.CreatedAt = cur.CreatedAt
+		// La predeterminada se cambia marcando otra, no desmarcándola.
+		a.IsDefault = a.IsDefault || cur.IsDefault
+	}
+	a.CustomerID = c.ID
+	a.UpdatedAt = now
+	if err := uc.Customers.SaveAddress(ctx, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// SetDefaultAddress marca la dirección como predeterminada.
+func (uc *AccountUC) SetDefaultAddress(ctx context.Context, c *domain.Customer, id uuid.UUID) error {
+	list, err := uc.Customers.ListAddresses(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	for _, a := range list {
+		if a.ID == id {
+			a.IsDefault = true
+			a.UpdatedAt = uc.now()
+			return uc.Customers.SaveAddress(ctx, &a)
+		}
+	}
+	return domain.ErrNotFound
+}
+
+// DeleteAddress borra una dirección del cliente; si era la predeterminada pasa a serlo la más vieja.
+func (uc *AccountUC) DeleteAddress(ctx context.Context, c *domain.Customer, id uuid.UUID) error {
+	if err := uc.Customers.DeleteAddress(ctx, c.ID, id); err != nil {
+		return err
+	}
+	// ListAddresses ordena la predeterminada primero y después por antigüedad.
+	list, err := uc.Customers.ListAddresses(ctx, c.ID)
+	if err != nil || len(list) == 0 || list[0].IsDefault {
+		return err
+	}
+	list[0].IsDefault = true
+	return uc.Customers.SaveAddress(ctx, &list[0])
+}
+
+// RememberAddress guarda la dirección de envío de un checkout en la cuenta del cliente, salvo que ya
+// la tenga. Los errores sólo se registran: no frenan la compra.
+func (uc *AccountUC) RememberAddress(ctx context.Context, customerID uuid.UUID, address, postalCode, province, notes string) {
+	if uc == nil || strings.TrimSpace(address) == "" || address == "(sin dirección)" {
+		return
+	}
+	list, err := uc.Customers.ListAddresses(ctx, customerID)
+	if err != nil {
+		log.Error().Err(err).Str("customer", customerID.String()).Msg("cuenta: no se pudieron leer las direcciones")
+		return
+	}
+	for _, a := range list {
+		if a.SameAs(address, postalCode) {
+			return
+		}
+	}
+	now := uc.now()
+	a := domain.CustomerAddress{
+		ID:         uuid.New(),
+		CustomerID: customerID,
+		Address:    strings.TrimSpace(address),
+		PostalCode: strings.TrimSpace(postalCode),
+		Province:   strings.TrimSpace(province),
+		Notes:      strings.TrimSpace(notes),
+		IsDefault:  len(list) == 0,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := uc.Customers.SaveAddress(ctx, &a); err != nil {
+		log.Error().Err(err).Str("customer", customerID.String()).Msg("cuenta: no se pudo guardar la dirección del checkout")
+	}
+}
+
+func (uc *AccountUC) now() time.Time {
+	if uc.Clock != nil {
+		return uc.Clock.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,248 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+const (
+	// cryptoMatchLookback limita la antigüedad de las órdenes cripto que se buscan en la cadena.
+	cryptoMatchLookback = 7 * 24 * time.Hour
+	// cryptoQuoteGrace acepta transferencias enviadas justo antes del vencimiento que se confirman después.
+	cryptoQuoteGrace = 30 * time.Minute
+)
+
+type CryptoUC struct {
+	Orders   domain.OrderRepo
+	Payments *PaymentUC
+	Watcher  domain.ChainWatcher
+	Rates    domain.CryptoRateProvider
+	// QuoteTTL es cuánto tiempo se respeta el monto cotizado al crear la orden.
+	QuoteTTL time.Duration
+	// Wallet es la dirección BSC donde reciben los pagos; se muestra en /pay.
+	Wallet string
+	// Confirmations es la cantidad de bloques que tiene que tener encima una transferencia para aceptarla.
+	Confirmations uint64
+	// StartLookback es cuántos bloques hacia atrás se revisan en la primera pasada tras arrancar.
+	StartLookback uint64
+
+	mu        sync.Mutex
+	lastBlock uint64
+}
+
+// Quote cotiza la orden cripto con la tasa USDT/ARS vigente y fija en ella el monto exacto a enviar, la
+// tasa, su fuente y el vencimiento. No guarda la orden.
+func (uc *CryptoUC) Quote(ctx context.Context, o *domain.Order) error {
+	if uc.Rates == nil {
+		return errors.New("sin proveedor de cotizaciones")
+	}
+	rate, err := uc.Rates.Rate(ctx, "usdt")
+	if err != nil {
+		return err
+	}
+	if err := uc.AssignExpectedAmount(ctx, o, rate.ARS); err != nil {
+		return err
+	}
+	now := time.Now()
+	o.CryptoRate = rate.ARS
+	o.CryptoSource = rate.Source
+	o.CryptoExpires = nil
+	if uc.QuoteTTL > 0 {
+		exp := now.Add(uc.QuoteTTL)
+		o.CryptoExpires = &exp
+	}
+	return nil
+}
+
+// Requote vuelve a cotizar una orden cripto pendiente cuya cotización venció.
+func (uc *CryptoUC) Requote(ctx context.Context, orderID uuid.UUID) (*domain.Order, error) {
+	o, err := uc.Orders.FindByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if o.PaymentMethod != "cripto" || o.Status != domain.OrderStatusAwaitingPay {
+		return nil, errors.New("la orden no está esperando un pago cripto")
+	}
+	if o.CryptoAmount > 0 && !o.CryptoQuoteExpired(time.Now()) {
+		return o, nil
+	}
+	if err := uc.Quote(ctx, o); err != nil {
+		return nil, err
+	}
+	if err := uc.Orders.Save(ctx, o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// AssignExpectedAmount fija en la orden el monto exacto en USDT/USDC que tiene que enviar el comprador:
+// el monto a cobrar (el total, o la seña o el saldo de una preventa) convertido con rate (ARS por unidad)
+// más un offset de centavos que no use otra orden con cotización vigente, para poder identificar la
+// transferencia en la cadena.
+func (uc *CryptoUC) AssignExpectedAmount(ctx context.Context, o *domain.Order, rate float64) error {
+	if rate <= 0 {
+		return errors.New("cotización inválida")
+	}
+	pending, err := uc.quotedOrders(ctx)
+	if err != nil {
+		return err
+	}
+	used := map[int64]bool{}
+	for _, p := range pending {
+		if p.ID != o.ID {
+			used[cryptoCents(p.CryptoAmount)] = true
+		}
+	}
+	base := int64(math.Ceil(o.AmountDue() / rate * 100))
+	for k := int64(1); k < 100; k++ {
+		if !used[base+k] {
+			o.CryptoAmount = float64(base+k) / 100
+			return nil
+		}
+	}
+	return errors.New("no hay montos únicos disponibles")
+}
+
+// quotedOrders devuelve las órdenes cripto pendientes con un monto cotizado que todavía se acepta.
+func (uc *CryptoUC) quotedOrders(ctx context.Context) ([]domain.Order, error) {
+	now := time.Now()
+	list, err := uc.Orders.ListAwaitingPayment(ctx, "cripto", now.Add(-cryptoMatchLookback), now)
+	if err != nil {
+		return nil, err
+	}
+	out := list[:0]
+	for _, o := range list {
+		if o.CryptoAmount > 0 && !o.CryptoQuoteExpired(now.Add(-cryptoQuoteGrace)) {
+			out = append(out, o)
+		}
+	}
+	return out, nil
+}
+
+// Scan revisa los bloques nuevos con las confirmaciones requeridas y da por pagadas las órdenes cripto
+// cuyo monto esperado coincide con una transferencia recibida. Devuelve cuántas órdenes se confirmaron.
+func (uc *CryptoUC) Scan(ctx context.Context) (int, error) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	latest, err := uc.Watcher.LatestBlock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	conf := uc.Confirmations
+	if conf == 0 {
+		conf = 1
+	}
+	if latest+1 < conf {
+		return 0, nil
+	}
+	tip := latest + 1 - conf
+	from := uc.lastBlock + 1
+	if uc.lastBlock == 0 {
+		from = 0
+		if tip > uc.StartLookback {
+			from = tip - uc.StartLookback
+		}
+	}
+	if from > tip {
+		return 0, nil
+	}
+	transfers, err := uc.Watcher.Transfers(ctx, from, tip)
+	if err != nil {
+		return 0, err
+	}
+	if len(transfers) == 0 {
+		uc.lastBlock = tip
+		return 0, nil
+	}
+
+	pending, err := uc.quotedOrders(ctx)
+	if err != nil {
+		return 0, err
+	}
+	byCents := map[int64]*domain.Order{}
+	for i := range pending {
+		byCents[cryptoCents(pending[i].CryptoAmount)] = &pending[i]
+	}
+
+	// El rango sólo se da por revisado si todas las transferencias se procesaron; si no, el próximo Scan lo
+	// vuelve a leer (las ya acreditadas se saltean por saldo cubierto y por el hash en el ledger).
+	failed := 0
+	matched := 0
+	for _, t := range transfers {
+		o, ok := byCents[cryptoCents(t.Amount)]
+		if !ok || math.Abs(t.Amount-o.CryptoAmount) > 0.005 {
+			continue
+		}
+		delete(byCents, cryptoCents(t.Amount))
+		bal, _, err := uc.Payments.Balance(ctx, o)
+		if err != nil {
+			log.Error().Err(err).Str("order_id", o.ID.String()).Msg("cripto: error leyendo saldo")
+			failed++
+			continue
+		}
+		if bal.Settled() {
+			continue
+		}
+		// En una preventa se cobra la seña (o el saldo pedido), no necesariamente todo lo adeudado.
+		amount := bal.Balance
+		if due := o.AmountDue(); due < amount {
+			amount = due
+		}
+		o.CryptoTxHash = t.TxHash
+		if _, err := uc.Payments.RecordPayment(ctx, o, &domain.Payment{
+			Method:     "cripto",
+			Amount:     amount,
+			ExternalID: t.TxHash,
+			Status:     domain.PaymentStatusApproved,
+			Note:       fmt.Sprintf("%.2f %s on-chain desde %s (bloque %d)", t.Amount, t.Token, t.From, t.BlockNumber),
+			Actor:      "bsc",
+		}); err != nil {
+			log.Error().Err(err).Str("order_id", o.ID.String()).Str("tx", t.TxHash).Msg("cripto: error registrando pago")
+			failed++
+			continue
+		}
+		if o.AwaitingDeposit() && o.PaymentStatus == "partial" {
+			// Seña cobrada: la cotización deja de valer hasta que se pida el saldo.
+			o.CryptoAmount = 0
+			o.CryptoExpires = nil
+			if err := uc.Orders.Save(ctx, o); err != nil {
+				log.Error().Err(err).Str("order_id", o.ID.String()).Msg("cripto: error descartando la cotización de la seña")
+			}
+		}
+		matched++
+		log.Info().Str("order_id", o.ID.String()).Str("tx", t.TxHash).Float64("amount", t.Amount).Str("token", t.Token).Msg("cripto: pago confirmado on-chain")
+	}
+	if failed > 0 {
+		return matched, fmt.Errorf("%d transferencias sin procesar; se reintentan los bloques %d-%d", failed, from, tip)
+	}
+	uc.lastBlock = tip
+	return matched, nil
+}
+
+// RunWatcher ejecuta Scan periódicamente hasta que se cancele el contexto.
+func (uc *CryptoUC) RunWatcher(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := uc.Scan(ctx); err != nil {
+				log.Error().Err(err).Msg("cripto: error consultando la cadena")
+			}
+		}
+	}
+}
+
+func cryptoCents(amount float64) int64 { return int64(math.Round(amount * 100)) }
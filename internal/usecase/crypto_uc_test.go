@@ -0,0 +1,132 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// awaitingOrders devuelve siempre las mismas órdenes cripto pendientes; sólo implementa lo que usa CryptoUC.
+type awaitingOrders struct {
+	domain.OrderRepo
+	list []domain.Order
+}
+
+func (r *awaitingOrders) ListAwaitingPayment(ctx context.Context, method string, from, to time.Time) ([]domain.Order, error) {
+	return append([]domain.Order(nil), r.list...), nil
+}
+
+func quoted(amount float64, expires time.Duration) domain.Order {
+	o := domain.Order{ID: uuid.New(), PaymentMethod: "cripto", CryptoAmount: amount}
+	if expires != 0 {
+		exp := time.Now().Add(expires)
+		o.CryptoExpires = &exp
+	}
+	return o
+}
+
+func TestAssignExpectedAmount(t *testing.T) {
+	self := quoted(10.01, time.Hour)
+	self.Total = 10000
+	cases := []struct {
+		name    string
+		order   domain.Order
+		pending []domain.Order
+		want    float64
+	}{
+		{
+			name:  "sin otras órdenes toma el primer centavo",
+			order: domain.Order{ID: uuid.New(), Total: 10000},
+			want:  10.01,
+		},
+		{
+			name:    "saltea los montos usados",
+			order:   domain.Order{ID: uuid.New(), Total: 10000},
+			pending: []domain.Order{quoted(10.01, time.Hour), quoted(10.02, 0), quoted(10.04, time.Hour)},
+			want:    10.03,
+		},
+		{
+			name:    "una cotización vencida hace rato libera su monto",
+			order:   domain.Order{ID: uuid.New(), Total: 10000},
+			pending: []domain.Order{quoted(10.01, -time.Hour)},
+			want:    10.01,
+		},
+		{
+			name:    "una cotización recién vencida todavía reserva su monto",
+			order:   domain.Order{ID: uuid.New(), Total: 10000},
+			pending: []domain.Order{quoted(10.01, -10*time.Minute)},
+			want:    10.02,
+		},
+		{
+			name:    "las órdenes sin cotizar no reservan nada",
+			order:   domain.Order{ID: uuid.New(), Total: 10000},
+			pending: []domain.Order{quoted(0, time.Hour)},
+			want:    10.01,
+		},
+		{
+			name:    "recotizar la misma orden puede reusar su monto",
+			order:   self,
+			pending: []domain.Order{self},
+			want:    10.01,
+		},
+		{
+			name:  "redondea hacia arriba antes de sumar el offset",
+			order: domain.Order{ID: uuid.New(), Total: 10000.5},
+			want:  10.02,
+		},
+		{
+			name:  "una preventa cotiza la seña",
+			order: domain.Order{ID: uuid.New(), Total: 10000, ChargeAmount: 3000},
+			want:  3.01,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			uc := &CryptoUC{Orders: &awaitingOrders{list: c.pending}}
+			o := c.order
+			if err := uc.AssignExpectedAmount(context.Background(), &o, 1000); err != nil {
+				t.Fatalf("error inesperado: %v", err)
+			}
+			if cryptoCents(o.CryptoAmount) != cryptoCents(c.want) {
+				t.Errorf("CryptoAmount = %.2f, quería %.2f", o.CryptoAmount, c.want)
+			}
+		})
+	}
+}
+
+// Órdenes cotizadas una tras otra por el mismo total reciben montos distintos, hasta agotar los offsets.
+func TestAssignExpectedAmountUnique(t *testing.T) {
+	repo := &awaitingOrders{}
+	uc := &CryptoUC{Orders: repo}
+	seen := map[int64]bool{}
+	for i := 0; i < 99; i++ {
+		o := domain.Order{ID: uuid.New(), Total: 25000}
+		if err := uc.AssignExpectedAmount(context.Background(), &o, 1234.5); err != nil {
+			t.Fatalf("orden %d: %v", i, err)
+		}
+		c := cryptoCents(o.CryptoAmount)
+		if seen[c] {
+			t.Fatalf("orden %d: monto %.2f repetido", i, o.CryptoAmount)
+		}
+		seen[c] = true
+		repo.list = append(repo.list, quoted(o.CryptoAmount, time.Hour))
+	}
+	o := domain.Order{ID: uuid.New(), Total: 25000}
+	if err := uc.AssignExpectedAmount(context.Background(), &o, 1234.5); err == nil {
+		t.Errorf("con los 99 offsets ocupados quería error, dio %.2f", o.CryptoAmount)
+	}
+}
+
+func TestAssignExpectedAmountInvalidRate(t *testing.T) {
+	uc := &CryptoUC{Orders: &awaitingOrders{}}
+	o := domain.Order{ID: uuid.New(), Total: 10000}
+	for _, rate := range []float64{0, -1} {
+		if err := uc.AssignExpectedAmount(context.Background(), &o, rate); err == nil {
+			t.Errorf("tasa %.0f: quería error", rate)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// expiryLookback limita la antigüedad de las órdenes impagas que revisa el vencedor.
+const expiryLookback = 90 * 24 * time.Hour
+
+// ExpiryUC cancela las órdenes que no se pagan dentro del plazo de su medio de pago y avisa al
+// comprador antes y después del vencimiento.
+type ExpiryUC struct {
+	Orders   domain.OrderRepo
+	Receipts domain.ReceiptRepo
+	Email    domain.EmailService
+	// Transitions aplica y registra los cambios de estado de las órdenes.
+	Transitions *OrderUC
+	// Deadlines es el plazo de pago por método, contado desde la creación de la orden. Un método sin
+	// plazo (o con 0) no vence nunca.
+	Deadlines map[string]time.Duration
+	// ReminderBefore es cuánto antes del vencimiento se manda el recordatorio; 0 no recuerda.
+	ReminderBefore time.Duration
+}
+
+// DueAt devuelve el vencimiento del plazo de pago de la orden, o nil si no vence (las órdenes con una
+// seña registrada no se cancelan por vencimiento).
+func (uc *ExpiryUC) DueAt(o *domain.Order) *time.Time {
+	if uc == nil || o == nil || o.Status != domain.OrderStatusAwaitingPay || o.PaymentStatus == "partial" {
+		return nil
+	}
+	d := uc.Deadlines[o.PaymentMethod]
+	if d <= 0 {
+		return nil
+	}
+	due := o.CreatedAt.Add(d)
+	return &due
+}
+
+// ExpireOverdue recuerda el pago a las órdenes cerca del vencimiento y cancela las vencidas. No cancela
+// órdenes con una seña registrada ni con comprobantes esperando revisión.
+func (uc *ExpiryUC) ExpireOverdue(ctx context.Context) (reminded, cancelled int, err error) {
+	now := time.Now()
+	for method, d := range uc.Deadlines {
+		if d <= 0 {
+			continue
+		}
+		orders, err := uc.Orders.ListAwaitingPayment(ctx, method, now.Add(-expiryLookback), now)
+		if err != nil {
+			return reminded, cancelled, err
+		}
+		for i := range orders {
+			o := &orders[i]
+			due := o.CreatedAt.Add(d)
+			if !now.Before(due) {
+				ok, err := uc.cancel(ctx, o, d)
+				if err != nil {
+					log.Error().Err(err).Str("order_id", o.ID.String()).Msg("no se pudo cancelar orden vencida")
+					continue
+				}
+				if ok {
+					cancelled++
+				}
+				continue
+			}
+			if uc.ReminderBefore > 0 && o.ReminderSentAt == nil && !now.Before(due.Add(-uc.ReminderBefore)) {
+				ok, err := uc.remind(ctx, o, due, now)
+				if err != nil {
+					log.Error().Err(err).Str("order_id", o.ID.String()).Msg("no se pudo enviar recordatorio de pago")
+					continue
+				}
+				if ok {
+					reminded++
+				}
+			}
+		}
+	}
+	return reminded, cancelled, nil
+}
+
+// remind marca y envía el recordatorio. La marca es condicional, así que no pisa una orden que se pagó
+// después de leerla en la pasada.
+func (uc *ExpiryUC) remind(ctx context.Context, o *domain.Order, due, now time.Time) (bool, error) {
+	// Se marca antes de enviar: si el correo falla no se reintenta en cada pasada.
+	ok, err := uc.Orders.MarkReminderSent(ctx, o.ID, now)
+	if err != nil || !ok {
+		return false, err
+	}
+	o.ReminderSentAt = &now
+	if uc.Email != nil {
+		return true, uc.Email.SendPaymentReminder(ctx, o, due)
+	}
+	return true, nil
+}
+
+// cancel cancela la orden vencida con una actualización condicional (ver OrderUC.CancelUnpaid).
+func (uc *ExpiryUC) cancel(ctx context.Context, o *domain.Order, d time.Duration) (bool, error) {
+	if o.PaymentStatus == "partial" {
+		return false, nil
+	}
+	if uc.Receipts != nil {
+		rcs, err := uc.Receipts.ListByOrder(ctx, o.ID)
+		if err != nil {
+			return false, err
+		}
+		for _, rc := range rcs {
+			if rc.Status == domain.ReceiptStatusPending {
+				return false, nil
+			}
+		}
+	}
+	reason := fmt.Sprintf("pago no recibido dentro del plazo de %s", formatDeadline(d))
+	ch := domain.StatusChange{Actor: "sistema", Source: domain.StatusSourceExpiry, Note: reason}
+	o.CancelReason = reason
+	ok, err := uc.Transitions.CancelUnpaid(ctx, o, ch)
+	if err != nil || !ok {
+		o.CancelReason = ""
+		return false, err
+	}
+	log.Info().Str("order_id", o.ID.String()).Str("method", o.PaymentMethod).Msg("orden impaga cancelada por vencimiento")
+	if uc.Email != nil {
+		if err := uc.Email.SendOrderCancelled(ctx, o, reason); err != nil {
+			log.Warn().Err(err).Str("order_id", o.ID.String()).Msg("no se pudo avisar la cancelación")
+		}
+	}
+	return true, nil
+}
+
+// formatDeadline escribe el plazo en horas, o en días a partir de 3 ("48 horas", "3 días").
+func formatDeadline(d time.Duration) string {
+	h := int(d.Round(time.Hour) / time.Hour)
+	switch {
+	case h >= 72 && h%24 == 0:
+		return fmt.Sprintf("%d días", h/24)
+	case h <= 1:
+		return d.Round(time.Minute).String()
+	default:
+		return fmt.Sprintf("%d horas", h)
+	}
+}
+
+// RunExpirer ejecuta ExpireOverdue periódicamente hasta que se cancele el contexto.
+func (uc *ExpiryUC) RunExpirer(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			reminded, cancelled, err := uc.ExpireOverdue(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("vencimiento de órdenes impagas falló")
+				continue
+			}
+			if reminded > 0 || cancelled > 0 {
+				log.Info().Int("reminded", reminded).Int("cancelled", cancelled).Msg("vencimiento de órdenes impagas")
+			}
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// invoiceLookback es hasta cuándo atrás se facturan automáticamente las órdenes pagadas: AFIP acepta
+// comprobantes de venta de productos con fecha de hasta 5 días antes de la emisión.
+const invoiceLookback = 5 * 24 * time.Hour
+
+// InvoiceUC emite la factura electrónica de las órdenes pagadas: elige la letra según la condición
+// frente al IVA del comprador, pide el CAE al emisor y guarda el comprobante.
+type InvoiceUC struct {
+	Orders    domain.OrderRepo
+	Customers domain.CustomerRepo
+	Invoices  domain.InvoiceRepo
+	// Issuer es AFIP WSFEv1 o el stub; sin él la facturación está deshabilitada.
+	Issuer    domain.InvoiceIssuer
+	Documents domain.InvoiceDocuments
+	// Datos del emisor.
+	SellerCUIT      string
+	SellerCondition string
+	PointOfSale     int
+
+	// mu serializa las emisiones: el número se toma como último autorizado + 1.
+	mu sync.Mutex
+}
+
+// Enabled indica si hay un emisor de comprobantes configurado.
+func (uc *InvoiceUC) Enabled() bool { return uc != nil && uc.Issuer != nil }
+
+// ForOrder devuelve la factura autorizada de la orden o domain.ErrNotFound (también si sólo hay una
+// pendiente de CAE, que se retoma con Issue).
+func (uc *InvoiceUC) ForOrder(ctx context.Context, orderID uuid.UUID) (*domain.Invoice, error) {
+	if uc == nil || uc.Invoices == nil {
+		return nil, domain.ErrNotFound
+	}
+	inv, err := uc.Invoices.FindByOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if !inv.Authorized() {
+		return nil, domain.ErrNotFound
+	}
+	return inv, nil
+}
+
+// CanInvoice devuelve por qué la orden todavía no se puede facturar, o nil.
+func (uc *InvoiceUC) CanInvoice(o *domain.Order) error {
+	if !uc.Enabled() {
+		return fmt.Errorf("%w: la facturación electrónica no está configurada", domain.ErrInvoiceNotAllowed)
+	}
+	if o.PaymentStatus != "approved" {
+		return fmt.Errorf("%w: la orden no está pagada", domain.ErrInvoiceNotAllowed)
+	}
+	switch o.Status {
+	case domain.OrderStatusFinished, domain.OrderStatusInPrint, domain.OrderStatusShipped:
+	default:
+		return fmt.Errorf("%w: la orden está en estado %s", domain.ErrInvoiceNotAllowed, o.Status)
+	}
+	if o.Total <= 0 {
+		return fmt.Errorf("%w: la orden no tiene importe", domain.ErrInvoiceNotAllowed)
+	}
+	return nil
+}
+
+// Buyer devuelve el cliente de la orden (nil si no tiene uno asociado).
+func (uc *InvoiceUC) Buyer(ctx context.Context, o *domain.Order) (*domain.Customer, error) {
+	if o.CustomerID == nil || uc.Customers == nil {
+		return nil, nil
+	}
+	c, err := uc.Customers.FindByID(ctx, *o.CustomerID)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, nil
+	}
+	return c, err
+}
+
+// SetBuyerTaxData guarda CUIT y condición frente al IVA en el cliente de la orden, creándolo (y
+// asociándolo) si la orden no tiene uno.
+func (uc *InvoiceUC) SetBuyerTaxData(ctx context.Context, o *domain.Order, taxID, condition string) error {
+	taxID = domain.OnlyDigits(taxID)
+	condition = strings.ToUpper(strings.TrimSpace(condition))
+	switch condition {
+	case domain.TaxConditionRI, domain.TaxConditionMT, domain.TaxConditionEX, domain.TaxConditionCF:
+	default:
+		return fmt.Errorf("condición frente al IVA inválida: %q", condition)
+	}
+	if taxID != "" && len(taxID) != 11 {
+		return fmt.Errorf("el CUIT debe tener 11 dígitos")
+	}
+	c, err := uc.Buyer(ctx, o)
+	if err != nil {
+		return err
+	}
+	if c == nil && o.Email != "" {
+		if found, err := uc.Customers.FindByEmail(ctx, o.Email); err == nil {
+			c = found
+		}
+	}
+	if c == nil {
+		c = &domain.Customer{ID: uuid.New(), Email: o.Email, Name: o.Name, Phone: o.Phone, CreatedAt: time.Now()}
+	}
+	if c.TaxID == taxID && c.TaxCondition == condition && o.CustomerID != nil && *o.CustomerID == c.ID {
+		return nil
+	}
+	c.TaxID = taxID
+	c.TaxCondition = condition
+	if err := uc.Customers.Save(ctx, c); err != nil {
+		return err
+	}
+	if o.CustomerID == nil || *o.CustomerID != c.ID {
+		o.CustomerID = &c.ID
+		return uc.Orders.Save(ctx, o)
+	}
+	return nil
+}
+
+// Issue emite la factura de una orden pagada. Si ya tiene una la devuelve sin volver a pedir CAE. El
+// número se reserva guardando la factura pendiente antes de pedir el CAE; si quedó una pendiente (el
+// pedido se cortó o no se pudo guardar la respuesta) se consulta ese número al emisor antes de reintentar.
+// Si la última fue rechazada o descartada se arma una nueva con los datos actuales del comprador.
+func (uc *InvoiceUC) Issue(ctx context.Context, o *domain.Order) (*domain.Invoice, error) {
+	if err := uc.CanInvoice(o); err != nil {
+		return nil, err
+	}
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	if inv, err := uc.Invoices.FindByOrder(ctx, o.ID); err == nil {
+		if inv.Authorized() {
+			return inv, nil
+		}
+		if inv.Pending() {
+			return uc.resume(ctx, o, inv)
+		}
+	} else if !errors.Is(err, domain.ErrNotFound) {
+		return nil, err
+	}
+	inv, err := uc.build(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	return uc.reserve(ctx, o, inv)
+}
+
+// reserve le asigna a la factura el próximo número, la guarda pendiente y pide el CAE.
+func (uc *InvoiceUC) reserve(ctx context.Context, o *domain.Order, inv *domain.Invoice) (*domain.Invoice, error) {
+	last, err := uc.Issuer.LastNumber(ctx, inv.PointOfSale, inv.Type)
+	if err != nil {
+		return nil, fmt.Errorf("último comprobante autorizado: %w", err)
+	}
+	if saved, err := uc.Invoices.LastNumber(ctx, inv.Environment, inv.Type, inv.PointOfSale); err != nil {
+		return nil, err
+	} else if saved > last {
+		last = saved
+	}
+	inv.Number = last + 1
+	inv.Status = domain.InvoiceStatusPending
+	if err := uc.Invoices.Save(ctx, inv); err != nil {
+		return nil, fmt.Errorf("reservando el número %s: %w", inv.FullNumber(), err)
+	}
+	return uc.authorize(ctx, o, inv)
+}
+
+// resume retoma una factura pendiente: si el emisor ya autorizó su número se toma ese CAE; si no, se
+// vuelve a armar con los datos actuales del comprador y se pide con el mismo número y fecha de hoy. Si con
+// los datos nuevos cambia la letra, el número reservado se descarta y se reserva uno de la letra nueva.
+func (uc *InvoiceUC) resume(ctx context.Context, o *domain.Order, inv *domain.Invoice) (*domain.Invoice, error) {
+	auth, err := uc.Issuer.Lookup(ctx, inv.PointOfSale, inv.Type, inv.Number)
+	switch {
+	case err == nil:
+		if math.Abs(auth.Total-inv.Total) > 0.01 {
+			return nil, fmt.Errorf("el comprobante %s autorizado por $%.2f no coincide con la factura pendiente por $%.2f", inv.FullNumber(), auth.Total, inv.Total)
+		}
+		log.Warn().Str("order_id", o.ID.String()).Str("invoice", inv.FullNumber()).Msg("factura pendiente recuperada del emisor")
+		return uc.complete(ctx, o, inv, auth)
+	case !errors.Is(err, domain.ErrNotFound):
+		return nil, fmt.Errorf("consultando %s: %w", inv.FullNumber(), err)
+	}
+	fresh, err := uc.build(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	if fresh.Environment != inv.Environment || fresh.Type != inv.Type || fresh.PointOfSale != inv.PointOfSale {
+		if err := uc.void(ctx, inv, domain.InvoiceStatusDiscarded, "reemplazada por una factura "+string(fresh.Type)); err != nil {
+			return nil, err
+		}
+		return uc.reserve(ctx, o, fresh)
+	}
+	fresh.ID, fresh.Number, fresh.Status, fresh.CreatedAt = inv.ID, inv.Number, domain.InvoiceStatusPending, inv.CreatedAt
+	if err := uc.Invoices.Save(ctx, fresh); err != nil {
+		return nil, err
+	}
+	return uc.authorize(ctx, o, fresh)
+}
+
+// authorize pide el CAE de la factura pendiente y la guarda autorizada. Si el emisor la rechaza queda
+// rechazada con el motivo y su número vuelve a estar libre.
+func (uc *InvoiceUC) authorize(ctx context.Context, o *domain.Order, inv *domain.Invoice) (*domain.Invoice, error) {
+	auth, err := uc.Issuer.Authorize(ctx, inv.Request())
+	if errors.Is(err, domain.ErrInvoiceRejected) {
+		log.Warn().Err(err).Str("order_id", o.ID.String()).Str("invoice", inv.FullNumber()).Msg("factura rechazada por el emisor")
+		if verr := uc.void(ctx, inv, domain.InvoiceStatusRejected, err.Error()); verr != nil {
+			log.Error().Err(verr).Str("invoice", inv.FullNumber()).Msg("no se pudo marcar la factura rechazada")
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return uc.complete(ctx, o, inv, auth)
+}
+
+// void marca la factura rechazada o descartada, con el motivo, liberando su número.
+func (uc *InvoiceUC) void(ctx context.Context, inv *domain.Invoice, status, reason string) error {
+	inv.Status = status
+	inv.Observations = reason
+	return uc.Invoices.Save(ctx, inv)
+}
+
+// Discard descarta la factura pendiente de la orden para liberar su número, después de confirmar con el
+// emisor que ese número no se autorizó. La orden vuelve a poder facturarse con Issue.
+func (uc *InvoiceUC) Discard(ctx context.Context, o *domain.Order, actor string) (*domain.Invoice, error) {
+	if !uc.Enabled() {
+		return nil, fmt.Errorf("%w: la facturación electrónica no está configurada", domain.ErrInvoiceNotAllowed)
+	}
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	inv, err := uc.Invoices.FindByOrder(ctx, o.ID)
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		return nil, err
+	}
+	if inv == nil || !inv.Pending() {
+		return nil, errors.New("la orden no tiene una factura pendiente")
+	}
+	if _, err := uc.Issuer.Lookup(ctx, inv.PointOfSale, inv.Type, inv.Number); err == nil {
+		return nil, fmt.Errorf("el emisor ya autorizó %s: emitila de nuevo para registrar el CAE", inv.FullNumber())
+	} else if !errors.Is(err, domain.ErrNotFound) {
+		return nil, fmt.Errorf("consultando %s: %w", inv.FullNumber(), err)
+	}
+	if err := uc.void(ctx, inv, domain.InvoiceStatusDiscarded, "descartada por "+actor); err != nil {
+		return nil, err
+	}
+	log.Info().Str("order_id", o.ID.String()).Str("invoice", inv.FullNumber()).Str("actor", actor).Msg("factura pendiente descartada")
+	return inv, nil
+}
+
+// Unfinished devuelve la última factura de la orden si no está autorizada (pendiente, rechazada o
+// descartada), o domain.ErrNotFound.
+func (uc *InvoiceUC) Unfinished(ctx context.Context, orderID uuid.UUID) (*domain.Invoice, error) {
+	if uc == nil || uc.Invoices == nil {
+		return nil, domain.ErrNotFound
+	}
+	inv, err := uc.Invoices.FindByOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Authorized() {
+		return nil, domain.ErrNotFound
+	}
+	return inv, nil
+}
+
+func (uc *InvoiceUC) complete(ctx context.Context, o *domain.Order, inv *domain.Invoice, auth *domain.InvoiceAuthorization) (*domain.Invoice, error) {
+	inv.CAE = auth.CAE
+	inv.CAEExpires = auth.CAEExpires
+	inv.Observations = auth.Observations
+	inv.QRData = inv.AFIPQRURL()
+	inv.Status = domain.InvoiceStatusAuthorized
+	if err := uc.Invoices.Save(ctx, inv); err != nil {
+		// Queda pendiente: el próximo intento la recupera consultando el número al emisor.
+		log.Error().Err(err).Str("order_id", o.ID.String()).Str("invoice", inv.FullNumber()).Str("cae", inv.CAE).
+			Msg("factura autorizada pero no se pudo guardar")
+		return nil, err
+	}
+	log.Info().Str("order_id", o.ID.String()).Str("invoice", inv.FullNumber()).Str("cae", inv.CAE).Msg("factura emitida")
+	return inv, nil
+}
+
+// build arma la factura (sin número ni CAE) con los datos actuales del comprador de la orden.
+func (uc *InvoiceUC) build(ctx context.Context, o *domain.Order) (*domain.Invoice, error) {
+	buyer, err := uc.Buyer(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	return uc.prepare(o, buyer)
+}
+
+// prepare arma la factura (sin número ni CAE) a partir de la orden y el comprador.
+func (uc *InvoiceUC) prepare(o *domain.Order, buyer *domain.Customer) (*domain.Invoice, error) {
+	condition, taxID := domain.TaxConditionCF, ""
+	if buyer != nil {
+		taxID = domain.OnlyDigits(buyer.TaxID)
+		if buyer.TaxCondition != "" {
+			condition = buyer.TaxCondition
+		}
+	}
+	typ, err := domain.InvoiceTypeFor(uc.SellerCondition, condition)
+	if err != nil {
+		return nil, err
+	}
+
+	docType, docNumber := domain.DocTypeNone, "0"
+	switch {
+	case len(taxID) == 11:
+		docType, docNumber = domain.DocTypeCUIT, taxID
+	case typ == domain.InvoiceTypeA:
+		return nil, fmt.Errorf("%w: la factura A requiere el CUIT del comprador", domain.ErrInvoiceNotAllowed)
+	case domain.OnlyDigits(o.DNI) != "":
+		docType, docNumber = domain.DocTypeDNI, domain.OnlyDigits(o.DNI)
+	}
+
+	now := time.Now().In(argentinaTZ())
+	total := math.Round(o.Total*100) / 100
+	lines, net, vat := domain.InvoiceAmounts(o)
+	if typ == domain.InvoiceTypeC {
+		// Monotributo: no discrimina IVA, el neto es el total.
+		lines, net, vat = nil, total, 0
+	}
+	address := strings.Trim(strings.Join([]string{o.Address, o.PostalCode, o.Province}, " - "), " -")
+	inv := &domain.Invoice{
+		ID:              uuid.New(),
+		OrderID:         o.ID,
+		Environment:     uc.Issuer.Environment(),
+		Type:            typ,
+		PointOfSale:     uc.PointOfSale,
+		IssuerCUIT:      uc.SellerCUIT,
+		IssuerCondition: uc.SellerCondition,
+		DocType:         docType,
+		DocNumber:       docNumber,
+		BuyerName:       o.Name,
+		BuyerAddress:    address,
+		BuyerCondition:  condition,
+		Net:             net,
+		VAT:             vat,
+		Total:           total,
+		VATLines:        lines,
+		IssuedAt:        now,
+	}
+	return inv, nil
+}
+
+// PDF genera el PDF de la factura de la orden.
+func (uc *InvoiceUC) PDF(ctx context.Context, o *domain.Order) ([]byte, *domain.Invoice, error) {
+	inv, err := uc.ForOrder(ctx, o.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uc.Documents == nil {
+		return nil, nil, errors.New("generador de PDF no configurado")
+	}
+	data, err := uc.Documents.Invoice(inv, o)
+	return data, inv, err
+}
+
+// IssuePending factura las órdenes pagadas de los últimos días que todavía no tienen comprobante y retoma
+// las facturas pendientes.
+func (uc *InvoiceUC) IssuePending(ctx context.Context) (int, error) {
+	now := time.Now()
+	from := now.Add(-invoiceLookback)
+	issued := 0
+	for page := 1; ; page++ {
+		orders, total, err := uc.Orders.List(ctx, domain.OrderFilter{PaymentStatus: "approved", From: &from, Sort: "oldest", Page: page, PageSize: 100})
+		if err != nil {
+			return issued, err
+		}
+		for i := range orders {
+			o := &orders[i]
+			if uc.CanInvoice(o) != nil {
+				continue
+			}
+			// Las rechazadas y descartadas no se reintentan solas: el admin corrige los datos y la emite.
+			if inv, err := uc.Invoices.FindByOrder(ctx, o.ID); err == nil && !inv.Pending() {
+				continue
+			}
+			full, err := uc.Orders.FindByID(ctx, o.ID)
+			if err != nil {
+				return issued, err
+			}
+			if _, err := uc.Issue(ctx, full); err != nil {
+				log.Warn().Err(err).Str("order_id", o.ID.String()).Msg("no se pudo facturar la orden")
+				continue
+			}
+			issued++
+		}
+		if int64(page*100) >= total {
+			return issued, nil
+		}
+	}
+}
+
+// RunInvoicer ejecuta IssuePending periódicamente hasta que se cancele el contexto.
+func (uc *InvoiceUC) RunInvoicer(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			issued, err := uc.IssuePending(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("facturación automática falló")
+				continue
+			}
+			if issued > 0 {
+				log.Info().Int("issued", issued).Msg("facturación automática")
+			}
+		}
+	}
+}
+
+// argentinaTZ es la zona horaria en la que AFIP fecha los comprobantes.
+func argentinaTZ() *time.Location {
+	if loc, err := time.LoadLocation("America/Argentina/Buenos_Aires"); err == nil {
+		return loc
+	}
+	return time.FixedZone("ART", -3*60*60)
+}
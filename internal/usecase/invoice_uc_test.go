@@ -0,0 +1,378 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type invoiceKey struct {
+	t      domain.InvoiceType
+	number int64
+}
+
+// scriptedIssuer autoriza lo que le piden salvo que se le indique cortar la conexión o rechazar.
+type scriptedIssuer struct {
+	last       map[domain.InvoiceType]int64
+	authorized map[invoiceKey]domain.InvoiceAuthorization
+	requests   []domain.InvoiceRequest
+	// fail simula un corte: el pedido no llega al emisor.
+	fail error
+	// reject es el motivo con el que el emisor rechaza el comprobante.
+	reject string
+}
+
+func newScriptedIssuer() *scriptedIssuer {
+	return &scriptedIssuer{last: map[domain.InvoiceType]int64{}, authorized: map[invoiceKey]domain.InvoiceAuthorization{}}
+}
+
+func (s *scriptedIssuer) Environment() string { return "test" }
+
+func (s *scriptedIssuer) LastNumber(ctx context.Context, pointOfSale int, t domain.InvoiceType) (int64, error) {
+	return s.last[t], nil
+}
+
+func (s *scriptedIssuer) Authorize(ctx context.Context, req domain.InvoiceRequest) (*domain.InvoiceAuthorization, error) {
+	s.requests = append(s.requests, req)
+	if s.fail != nil {
+		return nil, s.fail
+	}
+	if s.reject != "" {
+		return nil, fmt.Errorf("%w: %s", domain.ErrInvoiceRejected, s.reject)
+	}
+	auth := domain.InvoiceAuthorization{CAE: fmt.Sprintf("CAE-%s-%d", req.Type, req.Number), CAEExpires: time.Now().AddDate(0, 0, 10), Total: req.Total}
+	s.authorized[invoiceKey{req.Type, req.Number}] = auth
+	if req.Number > s.last[req.Type] {
+		s.last[req.Type] = req.Number
+	}
+	return &auth, nil
+}
+
+func (s *scriptedIssuer) Lookup(ctx context.Context, pointOfSale int, t domain.InvoiceType, number int64) (*domain.InvoiceAuthorization, error) {
+	auth, ok := s.authorized[invoiceKey{t, number}]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &auth, nil
+}
+
+// memInvoices guarda las facturas en orden de creación, como la tabla invoices.
+type memInvoices struct {
+	list []domain.Invoice
+}
+
+func (r *memInvoices) Save(ctx context.Context, inv *domain.Invoice) error {
+	for i := range r.list {
+		if r.list[i].ID == inv.ID {
+			r.list[i] = *inv
+			return nil
+		}
+	}
+	r.list = append(r.list, *inv)
+	return nil
+}
+
+func (r *memInvoices) FindByOrder(ctx context.Context, orderID uuid.UUID) (*domain.Invoice, error) {
+	for i := len(r.list) - 1; i >= 0; i-- {
+		if r.list[i].OrderID == orderID {
+			inv := r.list[i]
+			return &inv, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func (r *memInvoices) LastNumber(ctx context.Context, environment string, t domain.InvoiceType, pointOfSale int) (int64, error) {
+	var last int64
+	for _, inv := range r.list {
+		if inv.Environment == environment && inv.Type == t && inv.PointOfSale == pointOfSale && (inv.Pending() || inv.Authorized()) && inv.Number > last {
+			last = inv.Number
+		}
+	}
+	return last, nil
+}
+
+type memCustomers struct {
+	domain.CustomerRepo
+	byID map[uuid.UUID]*domain.Customer
+}
+
+func (r *memCustomers) FindByID(ctx context.Context, id uuid.UUID) (*domain.Customer, error) {
+	c, ok := r.byID[id]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	cp := *c
+	return &cp, nil
+}
+
+type invoiceFixture struct {
+	uc       *InvoiceUC
+	issuer   *scriptedIssuer
+	invoices *memInvoices
+	buyer    *domain.Customer
+}
+
+func newInvoiceFixture(sellerCondition string) *invoiceFixture {
+	f := &invoiceFixture{
+		issuer:   newScriptedIssuer(),
+		invoices: &memInvoices{},
+		buyer:    &domain.Customer{ID: uuid.New(), Email: "cliente@example.com", TaxCondition: domain.TaxConditionCF},
+	}
+	f.uc = &InvoiceUC{
+		Customers:       &memCustomers{byID: map[uuid.UUID]*domain.Customer{f.buyer.ID: f.buyer}},
+		Invoices:        f.invoices,
+		Issuer:          f.issuer,
+		SellerCUIT:      "20111111112",
+		SellerCondition: sellerCondition,
+		PointOfSale:     3,
+	}
+	return f
+}
+
+func (f *invoiceFixture) order() *domain.Order {
+	return &domain.Order{
+		ID:            uuid.New(),
+		Status:        domain.OrderStatusFinished,
+		PaymentStatus: "approved",
+		Name:          "Cliente",
+		CustomerID:    &f.buyer.ID,
+		Total:         1210,
+		Items:         []domain.OrderItem{{ID: uuid.New(), Title: "Maceta", Qty: 1, UnitPrice: 1210, VATRate: 21}},
+	}
+}
+
+func TestIssueReservesNextNumber(t *testing.T) {
+	ctx := context.Background()
+	f := newInvoiceFixture(domain.TaxConditionMT)
+	f.issuer.last[domain.InvoiceTypeC] = 41
+
+	first, err := f.uc.Issue(ctx, f.order())
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if first.Number != 42 || !first.Authorized() || first.CAE == "" {
+		t.Fatalf("factura = %s %s CAE %q, quería la 42 autorizada", first.FullNumber(), first.Status, first.CAE)
+	}
+
+	// Una factura reservada y sin CAE también ocupa su número.
+	f.issuer.fail = errors.New("timeout")
+	stuck := f.order()
+	if _, err := f.uc.Issue(ctx, stuck); err == nil {
+		t.Fatal("quería el error del corte")
+	}
+	f.issuer.fail = nil
+	next, err := f.uc.Issue(ctx, f.order())
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if next.Number != 44 {
+		t.Errorf("número = %d, quería 44 (la 43 está reservada)", next.Number)
+	}
+}
+
+func TestIssueIsIdempotentOnceAuthorized(t *testing.T) {
+	ctx := context.Background()
+	f := newInvoiceFixture(domain.TaxConditionMT)
+	o := f.order()
+	first, err := f.uc.Issue(ctx, o)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	again, err := f.uc.Issue(ctx, o)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if again.ID != first.ID || len(f.issuer.requests) != 1 {
+		t.Errorf("la segunda emisión volvió a pedir CAE (%d pedidos)", len(f.issuer.requests))
+	}
+}
+
+func TestIssueRetriesPendingWithSameNumber(t *testing.T) {
+	ctx := context.Background()
+	f := newInvoiceFixture(domain.TaxConditionRI)
+	o := f.order()
+
+	f.issuer.fail = errors.New("timeout")
+	if _, err := f.uc.Issue(ctx, o); err == nil {
+		t.Fatal("quería el error del corte")
+	}
+	pending, err := f.invoices.FindByOrder(ctx, o.ID)
+	if err != nil || !pending.Pending() || pending.Number != 1 {
+		t.Fatalf("quería la B 1 pendiente, quedó %+v (%v)", pending, err)
+	}
+
+	// Mientras tanto el comprador cargó su CUIT: el reintento usa el mismo número con los datos nuevos.
+	f.buyer.TaxID = "20-33444555-6"
+	f.issuer.fail = nil
+	inv, err := f.uc.Issue(ctx, o)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if inv.ID != pending.ID || inv.Number != 1 || !inv.Authorized() {
+		t.Errorf("factura = %s %s, quería la misma B 1 autorizada", inv.FullNumber(), inv.Status)
+	}
+	last := f.issuer.requests[len(f.issuer.requests)-1]
+	if last.Number != 1 || last.DocType != domain.DocTypeCUIT || last.DocNumber != "20334445556" {
+		t.Errorf("pedido = número %d doc %d %s, quería el 1 con el CUIT nuevo", last.Number, last.DocType, last.DocNumber)
+	}
+}
+
+func TestIssueRecoversAuthorizedPending(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name      string
+		authTotal float64
+		wantErr   bool
+	}{
+		{name: "el emisor tiene el mismo importe", authTotal: 1210},
+		{name: "el emisor tiene otro importe", authTotal: 999, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newInvoiceFixture(domain.TaxConditionMT)
+			o := f.order()
+			// Se pidió el CAE pero no se llegó a guardar la respuesta.
+			pending := domain.Invoice{ID: uuid.New(), OrderID: o.ID, Environment: "test", Type: domain.InvoiceTypeC, PointOfSale: 3, Number: 7, Total: 1210, Status: domain.InvoiceStatusPending}
+			f.invoices.list = append(f.invoices.list, pending)
+			f.issuer.authorized[invoiceKey{domain.InvoiceTypeC, 7}] = domain.InvoiceAuthorization{CAE: "CAE-RECUPERADO", Total: c.authTotal}
+			f.issuer.last[domain.InvoiceTypeC] = 7
+
+			inv, err := f.uc.Issue(ctx, o)
+			if len(f.issuer.requests) != 0 {
+				t.Errorf("volvió a pedir CAE por un número ya autorizado")
+			}
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("quería error por importes distintos")
+				}
+				if got, _ := f.invoices.FindByOrder(ctx, o.ID); !got.Pending() {
+					t.Errorf("la factura quedó %s, quería pendiente", got.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+			if inv.ID != pending.ID || inv.CAE != "CAE-RECUPERADO" || !inv.Authorized() {
+				t.Errorf("factura = %s %s CAE %q, quería la pendiente con el CAE del emisor", inv.FullNumber(), inv.Status, inv.CAE)
+			}
+		})
+	}
+}
+
+func TestIssueRejectedFreesNumber(t *testing.T) {
+	ctx := context.Background()
+	f := newInvoiceFixture(domain.TaxConditionMT)
+	o := f.order()
+
+	f.issuer.reject = "10015: DocNro inválido"
+	_, err := f.uc.Issue(ctx, o)
+	if !errors.Is(err, domain.ErrInvoiceRejected) {
+		t.Fatalf("error = %v, quería ErrInvoiceRejected", err)
+	}
+	rejected, _ := f.invoices.FindByOrder(ctx, o.ID)
+	if rejected.Status != domain.InvoiceStatusRejected || !strings.Contains(rejected.Observations, "DocNro inválido") {
+		t.Errorf("factura = %s %q, quería rechazada con el motivo", rejected.Status, rejected.Observations)
+	}
+	if n, _ := f.invoices.LastNumber(ctx, "test", domain.InvoiceTypeC, 3); n != 0 {
+		t.Errorf("el número rechazado sigue ocupado (LastNumber = %d)", n)
+	}
+
+	f.issuer.reject = ""
+	inv, err := f.uc.Issue(ctx, o)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if inv.ID == rejected.ID || inv.Number != 1 || !inv.Authorized() {
+		t.Errorf("factura = %s %s, quería una nueva C 1 autorizada", inv.FullNumber(), inv.Status)
+	}
+}
+
+func TestIssuePendingChangesSeries(t *testing.T) {
+	ctx := context.Background()
+	f := newInvoiceFixture(domain.TaxConditionRI)
+	o := f.order()
+
+	f.issuer.fail = errors.New("timeout")
+	if _, err := f.uc.Issue(ctx, o); err == nil {
+		t.Fatal("quería el error del corte")
+	}
+	// El comprador resultó responsable inscripto: corresponde una A, y la B reservada se descarta.
+	f.buyer.TaxID = "30712345678"
+	f.buyer.TaxCondition = domain.TaxConditionRI
+	f.issuer.fail = nil
+	inv, err := f.uc.Issue(ctx, o)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if inv.Type != domain.InvoiceTypeA || inv.Number != 1 || !inv.Authorized() {
+		t.Errorf("factura = %s %s, quería la A 1 autorizada", inv.FullNumber(), inv.Status)
+	}
+	if len(f.invoices.list) != 2 || f.invoices.list[0].Status != domain.InvoiceStatusDiscarded {
+		t.Errorf("la B reservada quedó %q, quería descartada", f.invoices.list[0].Status)
+	}
+	if n, _ := f.invoices.LastNumber(ctx, "test", domain.InvoiceTypeB, 3); n != 0 {
+		t.Errorf("la B descartada sigue ocupando su número (LastNumber = %d)", n)
+	}
+}
+
+func TestDiscardPending(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name       string
+		authorized bool
+		wantErr    bool
+	}{
+		{name: "el emisor no tiene el número", wantErr: false},
+		{name: "el emisor ya lo autorizó", authorized: true, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newInvoiceFixture(domain.TaxConditionMT)
+			o := f.order()
+			f.issuer.fail = errors.New("timeout")
+			if _, err := f.uc.Issue(ctx, o); err == nil {
+				t.Fatal("quería el error del corte")
+			}
+			f.issuer.fail = nil
+			if c.authorized {
+				f.issuer.authorized[invoiceKey{domain.InvoiceTypeC, 1}] = domain.InvoiceAuthorization{CAE: "CAE", Total: 1210}
+			}
+
+			inv, err := f.uc.Discard(ctx, o, "admin")
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("quería error: no se puede descartar un número autorizado")
+				}
+				if got, _ := f.invoices.FindByOrder(ctx, o.ID); !got.Pending() {
+					t.Errorf("la factura quedó %s, quería pendiente", got.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Discard: %v", err)
+			}
+			if inv.Status != domain.InvoiceStatusDiscarded {
+				t.Errorf("estado = %s, quería discarded", inv.Status)
+			}
+			if _, err := f.uc.Discard(ctx, o, "admin"); err == nil {
+				t.Error("descartar dos veces debería fallar")
+			}
+			again, err := f.uc.Issue(ctx, o)
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+			if again.Number != 1 || !again.Authorized() {
+				t.Errorf("factura = %s %s, quería reusar la C 1 liberada", again.FullNumber(), again.Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package usecase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// loginTokenRetention es cuánto se guardan como mínimo los tokens usados o vencidos, para revisar
+// abusos; si la ventana del límite o el TTL son más largos, se guardan por ese plazo.
+const loginTokenRetention = 24 * time.Hour
+
+// LoginLinkUC es el ingreso a "Mi cuenta" con un link por email, sin contraseña ni Google. El link
+// lleva un token al azar firmado con Secret; en la base sólo queda el hash, se usa una vez y vence a
+// los TTL.
+type LoginLinkUC struct {
+	Tokens   domain.LoginTokenRepo
+	Email    domain.EmailService
+	Accounts *AccountUC
+	// BaseURL es la URL pública del sitio; el link es BaseURL/auth/email?token=….
+	BaseURL string
+	Secret  []byte
+	TTL     time.Duration
+	// Límite de links pedidos en Window por email y por IP.
+	MaxPerEmail int
+	MaxPerIP    int
+	Window      time.Duration
+	Clock       domain.Clock
+}
+
+// RequestLink manda un link de ingreso al email. next es la página a la que vuelve después de ingresar.
+// Se responde igual exista o no una cuenta con ese email: el ingreso la crea.
+func (uc *LoginLinkUC) RequestLink(ctx context.Context, email, ip, next string) error {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || addr.Name != "" {
+		return errors.New("el email no es válido")
+	}
+	email = strings.ToLower(addr.Address)
+	now := uc.now()
+	byEmail, byIP, err := uc.Tokens.CountSince(ctx, email, ip, now.Add(-uc.Window))
+	if err != nil {
+		return err
+	}
+	if (uc.MaxPerEmail > 0 && byEmail >= int64(uc.MaxPerEmail)) || (uc.MaxPerIP > 0 && byIP >= int64(uc.MaxPerIP)) {
+		log.Warn().Str("email", email).Str("ip", ip).Int64("by_email", byEmail).Int64("by_ip", byIP).Msg("login: límite de links de ingreso")
+		return domain.ErrLoginRateLimited
+	}
+	if n, err := uc.Tokens.DeleteBefore(ctx, now.Add(-max(loginTokenRetention, uc.Window, uc.TTL))); err != nil {
+		log.Error().Err(err).Msg("login: no se pudieron borrar los tokens viejos")
+	} else if n > 0 {
+		log.Debug().Int64("deleted", n).Msg("login: tokens viejos borrados")
+	}
+	t, raw, err := domain.NewLoginToken(email, ip, next, now, uc.TTL)
+	if err != nil {
+		return err
+	}
+	// Se guarda antes de mandar el email así los envíos fallidos también cuentan para el límite.
+	if err := uc.Tokens.Save(ctx, &t); err != nil {
+		return err
+	}
+	link := strings.TrimRight(uc.BaseURL, "/") + "/auth/email?token=" + url.QueryEscape(uc.sign(raw))
+	if err := uc.Email.SendLoginLink(ctx, email, link, uc.TTL); err != nil {
+		return fmt.Errorf("no pudimos mandar el email: %w", err)
+	}
+	return nil
+}
+
+// Verify usa el link de ingreso: lo invalida y devuelve el cliente (creándolo si es la primera vez) y la
+// página a la que volver. Un link con firma inválida, vencido o ya usado da domain.ErrLoginLinkInvalid.
+func (uc *LoginLinkUC) Verify(ctx context.Context, token string) (*domain.Customer, string, error) {
+	raw, ok := uc.verify(token)
+	if !ok {
+		return nil, "", domain.ErrLoginLinkInvalid
+	}
+	t, err := uc.Tokens.Consume(ctx, domain.HashLoginToken(raw), uc.now())
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, "", domain.ErrLoginLinkInvalid
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := uc.Accounts.Login(ctx, t.Email, "")
+	if err != nil {
+		return nil, "", err
+	}
+	return c, t.Next, nil
+}
+
+// sign agrega al token la firma HMAC-SHA256 con Secret ("<token>.<firma>").
+func (uc *LoginLinkUC) sign(raw string) string {
+	mac := hmac.New(sha256.New, uc.Secret)
+	mac.Write([]byte(raw))
+	return raw + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify controla la firma del token del link y devuelve el token sin firmar.
+func (uc *LoginLinkUC) verify(token string) (string, bool) {
+	raw, sig, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok || raw == "" {
+		return "", false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", false
+	}
+	mac := hmac.New(sha256.New, uc.Secret)
+	mac.Write([]byte(raw))
+	return raw, hmac.Equal(got, mac.Sum(nil))
+}
+
+func (uc *LoginLinkUC) now() time.Time {
+	if uc.Clock != nil {
+		return uc.Clock.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,592 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+const (
+	// outboxLease es cuánto se reserva un aviso tomado por el worker mientras se entrega.
+	outboxLease = 2 * time.Minute
+	// outboxBatch es la cantidad de avisos que se entregan por vuelta.
+	outboxBatch = 20
+	// maxLowStockItems es la cantidad de variantes que se listan en un aviso de stock bajo.
+	maxLowStockItems = 30
+	// webhooksSentKey marca en OutboxPayload.Sent que el evento ya se publicó a los webhooks.
+	webhooksSentKey = "webhooks"
+)
+
+// NotificationUC entrega los avisos del outbox: los que vencen se toman por tandas, se entregan y se
+// marcan entregados o se reprograman con backoff exponencial hasta MaxAttempts, después quedan muertos.
+// Los eventos al staff se arman con la plantilla del evento y se mandan a los destinos de sus reglas por
+// el Notifier de cada canal.
+type NotificationUC struct {
+	Outbox    domain.OutboxRepo
+	Orders    domain.OrderRepo
+	Receipts  domain.ReceiptRepo
+	Products  domain.ProductRepo
+	Templates domain.NotificationRepo
+	// Notifiers son los canales disponibles, por nombre (domain.ChannelTelegram…).
+	Notifiers map[string]domain.Notifier
+	// DefaultRoutes son los destinos de las órdenes y comprobantes mientras no haya ninguna regla
+	// cargada para el evento (la configuración por variables de entorno de antes).
+	DefaultRoutes []domain.NotificationRule
+	// Email manda la confirmación al comprador.
+	Email domain.EmailService
+	// WhatsApp manda los mensajes de WhatsApp al comprador.
+	WhatsApp *WhatsAppUC
+	// Webhooks recibe los eventos de órdenes para los webhooks salientes.
+	Webhooks    *WebhookUC
+	MaxAttempts int
+	// LowStockThreshold es el stock a partir del cual (inclusive) se avisa que queda poco.
+	LowStockThreshold int
+	StoreName         string
+	BaseURL           string
+	Clock             domain.Clock
+
+	once sync.Once
+	kick chan struct{}
+}
+
+// OutboxEntry es un aviso del outbox con su orden, para el admin.
+type OutboxEntry struct {
+	Message domain.OutboxMessage
+	Order   *domain.Order
+}
+
+// Enqueue guarda un aviso que no acompaña un cambio de la orden (p.ej. un comprobante subido) y
+// despierta al worker.
+func (uc *NotificationUC) Enqueue(ctx context.Context, m domain.OutboxMessage) error {
+	if err := uc.Outbox.Save(ctx, &m); err != nil {
+		return err
+	}
+	uc.Kick()
+	return nil
+}
+
+// Kick despierta al worker para que entregue ya los avisos recién guardados, sin esperar al próximo tick.
+func (uc *NotificationUC) Kick() {
+	if uc == nil {
+		return
+	}
+	select {
+	case uc.kickChan() <- struct{}{}:
+	default:
+	}
+}
+
+func (uc *NotificationUC) kickChan() chan struct{} {
+	uc.once.Do(func() { uc.kick = make(chan struct{}, 1) })
+	return uc.kick
+}
+
+// RunDispatcher entrega los avisos pendientes cada every, o antes si alguien llama a Kick.
+func (uc *NotificationUC) RunDispatcher(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	kick := uc.kickChan()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-kick:
+		}
+		delivered, failed, err := uc.DeliverDue(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("outbox: no se pudieron leer los avisos pendientes")
+			continue
+		}
+		if delivered > 0 || failed > 0 {
+			log.Info().Int("delivered", delivered).Int("failed", failed).Msg("outbox de avisos")
+		}
+	}
+}
+
+// DeliverDue entrega los avisos pendientes que ya vencieron. Devuelve cuántos se entregaron y cuántos
+// fallaron en esta vuelta.
+func (uc *NotificationUC) DeliverDue(ctx context.Context) (delivered, failed int, err error) {
+	for {
+		list, err := uc.Outbox.ClaimDue(ctx, uc.now(), outboxLease, outboxBatch)
+		if err != nil {
+			return delivered, failed, err
+		}
+		for i := range list {
+			if uc.deliver(ctx, &list[i]) {
+				delivered++
+			} else {
+				failed++
+			}
+		}
+		if len(list) < outboxBatch {
+			return delivered, failed, nil
+		}
+	}
+}
+
+// deliver entrega un aviso y guarda el resultado. Devuelve true si se entregó.
+func (uc *NotificationUC) deliver(ctx context.Context, m *domain.OutboxMessage) bool {
+	err := uc.send(ctx, m)
+	now := uc.now()
+	if err == nil {
+		m.MarkDelivered(now)
+	} else {
+		m.MarkFailed(err, now, uc.maxAttempts())
+		ev := log.Warn()
+		if m.Status == domain.OutboxStatusDead {
+			ev = log.Error()
+		}
+		ev.Err(err).Str("outbox_id", m.ID.String()).Str("kind", m.Kind).Int("attempts", m.Attempts).Str("status", m.Status).Msg("outbox: falló la entrega del aviso")
+	}
+	if serr := uc.Outbox.Save(ctx, m); serr != nil {
+		log.Error().Err(serr).Str("outbox_id", m.ID.String()).Msg("outbox: no se pudo guardar el resultado de la entrega")
+	}
+	return err == nil
+}
+
+func (uc *NotificationUC) send(ctx context.Context, m *domain.OutboxMessage) error {
+	var o *domain.Order
+	if m.OrderID != nil {
+		var err error
+		if o, err = uc.Orders.FindByID(ctx, *m.OrderID); err != nil {
+			return fmt.Errorf("leyendo la orden: %w", err)
+		}
+	}
+	switch m.Kind {
+	case domain.NotifyOrderCustomer:
+		if o == nil {
+			return errors.New("aviso sin orden")
+		}
+		if uc.Email == nil {
+			return errors.New("servicio de email no configurado")
+		}
+		return uc.Email.SendOrderConfirmation(ctx, o)
+	case domain.NotifyWhatsApp:
+		if o == nil {
+			return errors.New("aviso sin orden")
+		}
+		if uc.WhatsApp == nil {
+			return errors.New("WhatsApp no disponible")
+		}
+		return uc.WhatsApp.Send(ctx, o, m.Params().Event)
+	case domain.NotifyEvent, domain.NotifyOrderAdmin, domain.NotifyReceiptAdmin:
+		return uc.sendEvent(ctx, m, o)
+	}
+	return fmt.Errorf("tipo de aviso desconocido: %s", m.Kind)
+}
+
+// sendEvent arma el evento con su plantilla y lo manda a cada destino que todavía no lo recibió. Los
+// destinos que fallan quedan para el próximo intento.
+func (uc *NotificationUC) sendEvent(ctx context.Context, m *domain.OutboxMessage, o *domain.Order) error {
+	event := m.EventName()
+	p := m.Params()
+	if event != domain.EventLowStock && o == nil {
+		return errors.New("aviso sin orden")
+	}
+	var rc *domain.PaymentReceipt
+	if p.ReceiptID != nil && uc.Receipts != nil {
+		var err error
+		if rc, err = uc.Receipts.FindByID(ctx, *p.ReceiptID); err != nil {
+			return fmt.Errorf("leyendo el comprobante: %w", err)
+		}
+	}
+	sent := map[string]bool{}
+	for _, k := range p.Sent {
+		sent[k] = true
+	}
+	var errs []error
+	// Los eventos de órdenes también se publican a los webhooks salientes, una sola vez por evento.
+	if wev := domain.WebhookEventForNotification(event); wev != "" && uc.Webhooks != nil && !sent[webhooksSentKey] {
+		if err := uc.Webhooks.PublishOrder(ctx, wev, o); err != nil {
+			errs = append(errs, fmt.Errorf("webhooks: %w", err))
+		} else {
+			sent[webhooksSentKey] = true
+			p.Sent = append(p.Sent, webhooksSentKey)
+			m.SetParams(p)
+		}
+	}
+
+	data := notificationData(event, o, rc, uc.StoreName, uc.BaseURL)
+	data.LowStock = p.LowStock
+	if len(p.LowStock) > maxLowStockItems {
+		data.LowStock = p.LowStock[:maxLowStockItems]
+		data.LowStockMore = len(p.LowStock) - maxLowStockItems
+	}
+	subject, text, err := renderNotification(uc.template(ctx, event), data)
+	if err != nil {
+		return fmt.Errorf("plantilla de %s: %w", domain.NotificationEventLabel(event), err)
+	}
+	msg := domain.NotificationMessage{Event: event, Subject: subject, Text: text, Data: notificationPayload(data)}
+
+	routes, err := uc.routes(ctx, event)
+	if err != nil {
+		return fmt.Errorf("leyendo las reglas de envío: %w", err)
+	}
+	for _, r := range routes {
+		if sent[r.Key()] {
+			continue
+		}
+		n := uc.Notifiers[r.Channel]
+		if n == nil {
+			errs = append(errs, fmt.Errorf("%s: canal no disponible", r.Channel))
+			continue
+		}
+		if err := n.Send(ctx, r.Target, msg); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", r.Channel, r.Target, err))
+			continue
+		}
+		sent[r.Key()] = true
+		p.Sent = append(p.Sent, r.Key())
+	}
+	m.SetParams(p)
+	return errors.Join(errs...)
+}
+
+// template devuelve la plantilla guardada del evento o, si no hay, la predeterminada.
+func (uc *NotificationUC) template(ctx context.Context, event string) domain.NotificationTemplate {
+	if uc.Templates != nil {
+		if t, err := uc.Templates.FindTemplate(ctx, event); err == nil {
+			return *t
+		} else if !errors.Is(err, domain.ErrNotFound) {
+			log.Warn().Err(err).Str("event", event).Msg("no se pudo leer la plantilla del aviso, se usa la predeterminada")
+		}
+	}
+	return DefaultNotificationTemplate(event)
+}
+
+// routes devuelve los destinos del evento: sus reglas activas o, si el evento no tiene ninguna regla
+// cargada, los destinos por defecto.
+func (uc *NotificationUC) routes(ctx context.Context, event string) ([]domain.NotificationRule, error) {
+	if uc.Templates != nil {
+		all, err := uc.Templates.ListRules(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var active []domain.NotificationRule
+		configured := false
+		for _, r := range all {
+			if r.Event != event {
+				continue
+			}
+			configured = true
+			if r.Active {
+				active = append(active, r)
+			}
+		}
+		if configured {
+			return active, nil
+		}
+	}
+	var out []domain.NotificationRule
+	for _, r := range uc.DefaultRoutes {
+		if r.Event == event {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// notificationPayload son los datos del evento que se mandan estructurados (webhook).
+func notificationPayload(d NotificationData) map[string]any {
+	out := map[string]any{"event": d.Event}
+	if o := d.Order; o != nil {
+		items := make([]map[string]any, 0, len(d.Items))
+		for _, it := range d.Items {
+			items = append(items, map[string]any{"title": it.Title, "qty": it.Qty, "unit_price": it.UnitPrice, "color": it.Color})
+		}
+		out["order"] = map[string]any{
+			"id":              o.ID.String(),
+			"number":          o.Number(),
+			"status":          string(o.Status),
+			"payment_status":  d.Status,
+			"payment_method":  o.PaymentMethod,
+			"shipping_method": o.ShippingMethod,
+			"name":            o.Name,
+			"email":           o.Email,
+			"phone":           o.Phone,
+			"total":           o.Total,
+			"shipping_cost":   o.ShippingCost,
+			"items":           items,
+		}
+	}
+	if d.Receipt != nil {
+		out["receipt_id"] = d.Receipt.ID.String()
+	}
+	if len(d.LowStock) > 0 {
+		out["low_stock"] = d.LowStock
+	}
+	return out
+}
+
+// List devuelve los avisos filtrados con su orden, los más nuevos primero.
+func (uc *NotificationUC) List(ctx context.Context, f domain.OutboxFilter) ([]OutboxEntry, int64, error) {
+	list, total, err := uc.Outbox.List(ctx, f)
+	if err != nil {
+		return nil, 0, err
+	}
+	orders := map[uuid.UUID]*domain.Order{}
+	out := make([]OutboxEntry, 0, len(list))
+	for _, m := range list {
+		e := OutboxEntry{Message: m}
+		if m.OrderID != nil {
+			o, ok := orders[*m.OrderID]
+			if !ok {
+				o, _ = uc.Orders.FindByID(ctx, *m.OrderID)
+				orders[*m.OrderID] = o
+			}
+			e.Order = o
+		}
+		out = append(out, e)
+	}
+	return out, total, nil
+}
+
+// Resend vuelve a poner en cola un aviso (muerto, pendiente o ya entregado) para entregarlo ya.
+func (uc *NotificationUC) Resend(ctx context.Context, id uuid.UUID) (*domain.OutboxMessage, error) {
+	m, err := uc.Outbox.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	m.Requeue(uc.now())
+	if err := uc.Outbox.Save(ctx, m); err != nil {
+		return nil, err
+	}
+	uc.Kick()
+	return m, nil
+}
+
+// ResendDead vuelve a poner en cola todos los avisos muertos. Devuelve cuántos.
+func (uc *NotificationUC) ResendDead(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		list, _, err := uc.Outbox.List(ctx, domain.OutboxFilter{Status: domain.OutboxStatusDead, PageSize: 100})
+		if err != nil {
+			return n, err
+		}
+		for i := range list {
+			list[i].Requeue(uc.now())
+			if err := uc.Outbox.Save(ctx, &list[i]); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(list) < 100 {
+			break
+		}
+	}
+	uc.Kick()
+	return n, nil
+}
+
+// NotificationTemplateView es la plantilla de un evento para el editor del admin, con una vista previa.
+type NotificationTemplateView struct {
+	Event          string
+	Label          string
+	Subject        string
+	Body           string
+	Custom         bool // hay una plantilla guardada; si no, se usa la predeterminada
+	PreviewSubject string
+	Preview        string
+	PreviewError   string
+}
+
+// TemplateViews devuelve la plantilla vigente de cada evento.
+func (uc *NotificationUC) TemplateViews(ctx context.Context) ([]NotificationTemplateView, error) {
+	saved := map[string]domain.NotificationTemplate{}
+	if uc.Templates != nil {
+		list, err := uc.Templates.ListTemplates(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range list {
+			saved[t.Event] = t
+		}
+	}
+	out := make([]NotificationTemplateView, 0, len(domain.NotificationEvents))
+	for _, ev := range domain.NotificationEvents {
+		t, custom := saved[ev]
+		if !custom {
+			t = DefaultNotificationTemplate(ev)
+		}
+		v := NotificationTemplateView{Event: ev, Label: domain.NotificationEventLabel(ev), Subject: t.Subject, Body: t.Body, Custom: custom}
+		subject, text, err := renderNotification(t, sampleNotificationData(ev, uc.StoreName, uc.BaseURL))
+		if err != nil {
+			v.PreviewError = err.Error()
+		} else {
+			v.PreviewSubject, v.Preview = subject, text
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// SaveTemplate guarda la plantilla de un evento después de probarla con datos de ejemplo.
+func (uc *NotificationUC) SaveTemplate(ctx context.Context, event, subject, body string) error {
+	if uc.Templates == nil {
+		return errors.New("plantillas no disponibles")
+	}
+	if !domain.ValidNotificationEvent(event) {
+		return errors.New("evento desconocido")
+	}
+	if strings.TrimSpace(body) == "" {
+		return errors.New("el texto no puede quedar vacío")
+	}
+	t := domain.NotificationTemplate{Event: event, Subject: strings.TrimSpace(subject), Body: body, UpdatedAt: uc.now()}
+	if _, _, err := renderNotification(t, sampleNotificationData(event, uc.StoreName, uc.BaseURL)); err != nil {
+		return fmt.Errorf("la plantilla tiene errores: %w", err)
+	}
+	return uc.Templates.SaveTemplate(ctx, &t)
+}
+
+// ResetTemplate borra la plantilla guardada del evento para volver a la predeterminada.
+func (uc *NotificationUC) ResetTemplate(ctx context.Context, event string) error {
+	if uc.Templates == nil {
+		return errors.New("plantillas no disponibles")
+	}
+	return uc.Templates.DeleteTemplate(ctx, event)
+}
+
+// Rules devuelve las reglas de envío cargadas.
+func (uc *NotificationUC) Rules(ctx context.Context) ([]domain.NotificationRule, error) {
+	if uc.Templates == nil {
+		return nil, nil
+	}
+	return uc.Templates.ListRules(ctx)
+}
+
+// AddRule agrega una regla que manda los avisos del evento a un destino del canal.
+func (uc *NotificationUC) AddRule(ctx context.Context, event, channel, target string) (*domain.NotificationRule, error) {
+	if uc.Templates == nil {
+		return nil, errors.New("reglas no disponibles")
+	}
+	if !domain.ValidNotificationEvent(event) {
+		return nil, errors.New("evento desconocido")
+	}
+	target, err := domain.NormalizeNotificationTarget(channel, target)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := uc.Templates.ListRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := domain.NotificationRule{Event: event, Channel: channel, Target: target, Active: true, CreatedAt: uc.now()}
+	for _, x := range rules {
+		if x.Event == event && x.Key() == r.Key() {
+			return nil, errors.New("esa regla ya existe")
+		}
+	}
+	if err := uc.Templates.SaveRule(ctx, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ToggleRule activa o pausa una regla.
+func (uc *NotificationUC) ToggleRule(ctx context.Context, id uuid.UUID) (*domain.NotificationRule, error) {
+	if uc.Templates == nil {
+		return nil, errors.New("reglas no disponibles")
+	}
+	r, err := uc.Templates.FindRule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.Active = !r.Active
+	if err := uc.Templates.SaveRule(ctx, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// DeleteRule borra una regla.
+func (uc *NotificationUC) DeleteRule(ctx context.Context, id uuid.UUID) error {
+	if uc.Templates == nil {
+		return errors.New("reglas no disponibles")
+	}
+	return uc.Templates.DeleteRule(ctx, id)
+}
+
+// ChannelReady indica si el canal está configurado para enviar.
+func (uc *NotificationUC) ChannelReady(channel string) bool {
+	return uc.Notifiers[channel] != nil
+}
+
+// RunLowStockCheck revisa el stock cada every.
+func (uc *NotificationUC) RunLowStockCheck(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		if n, err := uc.CheckLowStock(ctx); err != nil {
+			log.Error().Err(err).Msg("no se pudo revisar el stock bajo")
+		} else if n > 0 {
+			log.Info().Int("variants", n).Msg("aviso de stock bajo encolado")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// CheckLowStock encola un aviso con las variantes que quedaron con LowStockThreshold unidades o menos
+// desde la última revisión y las marca avisadas; las que se repusieron vuelven a avisarse la próxima vez
+// que bajen. Devuelve cuántas variantes entraron en el aviso.
+func (uc *NotificationUC) CheckLowStock(ctx context.Context) (int, error) {
+	if uc.Products == nil {
+		return 0, nil
+	}
+	threshold := uc.LowStockThreshold
+	if err := uc.Products.ClearLowStockAlerts(ctx, threshold); err != nil {
+		return 0, err
+	}
+	list, err := uc.Products.ListLowStockVariants(ctx, threshold)
+	if err != nil || len(list) == 0 {
+		return 0, err
+	}
+	names := map[uuid.UUID]string{}
+	items := make([]domain.LowStockItem, 0, len(list))
+	ids := make([]uuid.UUID, 0, len(list))
+	for _, v := range list {
+		name, ok := names[v.ProductID]
+		if !ok {
+			if p, err := uc.Products.FindByID(ctx, v.ProductID); err == nil {
+				name = p.Name
+			}
+			names[v.ProductID] = name
+		}
+		items = append(items, domain.LowStockItem{Product: name, Color: domain.ColorName(v.Color), SKU: v.SKU, Stock: v.Stock})
+		ids = append(ids, v.ID)
+	}
+	m := domain.NewOutboxMessage(domain.NotifyEvent, nil, domain.OutboxPayload{Event: domain.EventLowStock, LowStock: items}, uc.now())
+	if err := uc.Enqueue(ctx, m); err != nil {
+		return 0, err
+	}
+	if err := uc.Products.MarkLowStockAlerted(ctx, ids, uc.now()); err != nil {
+		return 0, err
+	}
+	return len(items), nil
+}
+
+func (uc *NotificationUC) maxAttempts() int {
+	if uc.MaxAttempts > 0 {
+		return uc.MaxAttempts
+	}
+	return 10
+}
+
+func (uc *NotificationUC) now() time.Time {
+	if uc.Clock != nil {
+		return uc.Clock.Now()
+	}
+	return time.Now()
+}
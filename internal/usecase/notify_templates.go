@@ -0,0 +1,171 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// NotificationData son los datos con los que se arman las plantillas de los avisos al staff.
+type NotificationData struct {
+	Event      string
+	EventLabel string
+	Store      string
+	AdminURL   string
+	// Status es el estado del pago en palabras (PAGO APROBADO, PAGO EN PROCESO…).
+	Status   string
+	Order    *domain.Order
+	Items    []NotificationItem
+	Delivery bool // envío o cadete; si no, retira en el local
+	Receipt  *domain.PaymentReceipt
+	LowStock []domain.LowStockItem
+	// LowStockMore es cuántas variantes con poco stock no entraron en el aviso.
+	LowStockMore int
+}
+
+// NotificationItem es un ítem de la orden con el color ya traducido.
+type NotificationItem struct {
+	Title     string
+	Qty       int
+	UnitPrice float64
+	Color     string
+}
+
+var notifyFuncs = template.FuncMap{
+	"money": func(v float64) string { return fmt.Sprintf("%.2f", v) },
+}
+
+const defaultOrderBody = `Orden {{.Order.Number}} - {{.Status}}
+Nombre: {{.Order.Name}}
+Email: {{.Order.Email}}
+Tel: {{.Order.Phone}}
+DNI: {{.Order.DNI}}
+{{if .Delivery}}Envío ({{.Order.ShippingMethod}}) a: {{.Order.Address}} ({{.Order.Province}}) CP:{{.Order.PostalCode}}
+{{with .Order.DeliveryNotes}}Observaciones: {{.}}
+{{end}}{{else}}Retiro en local
+{{end}}Items:
+{{range .Items}}- {{.Title}} x{{.Qty}} — ${{money .UnitPrice}}{{with .Color}}  Color: {{.}}{{end}}
+{{end}}Total: ${{money .Order.Total}} (Envío: ${{money .Order.ShippingCost}})
+`
+
+// defaultTemplates son las plantillas que se usan mientras no se guarde otra desde el admin.
+var defaultTemplates = map[string]domain.NotificationTemplate{
+	domain.EventOrderCreated: {
+		Subject: `Nueva orden {{.Status}} #{{.Order.Number}}`,
+		Body:    defaultOrderBody,
+	},
+	domain.EventOrderPaid: {
+		Subject: `Nueva orden {{.Status}} #{{.Order.Number}}`,
+		Body:    defaultOrderBody,
+	},
+	domain.EventOrderShipped: {
+		Subject: `Orden #{{.Order.Number}} despachada`,
+		Body: `Orden {{.Order.Number}} - DESPACHADA
+Nombre: {{.Order.Name}}
+Tel: {{.Order.Phone}}
+{{if .Delivery}}Envío ({{.Order.ShippingMethod}}) a: {{.Order.Address}} ({{.Order.Province}}) CP:{{.Order.PostalCode}}
+{{else}}Retiro en local
+{{end}}{{with .Order.TrackingNumber}}Seguimiento: {{.}}
+{{end}}Total: ${{money .Order.Total}}
+`,
+	},
+	domain.EventOrderCancelled: {
+		Subject: `Orden #{{.Order.Number}} cancelada`,
+		Body: `Orden {{.Order.Number}} - CANCELADA
+Nombre: {{.Order.Name}}
+Email: {{.Order.Email}}
+{{with .Order.CancelReason}}Motivo: {{.}}
+{{end}}Total: ${{money .Order.Total}} ({{.Order.PaymentMethod}})
+`,
+	},
+	domain.EventReceiptReceived: {
+		Subject: `Comprobante recibido para la orden #{{.Order.Number}}`,
+		Body: `Orden {{.Order.Number}} - COMPROBANTE RECIBIDO ({{.Order.PaymentMethod}})
+Nombre: {{.Order.Name}}
+Email: {{.Order.Email}}
+Total: ${{money .Order.Total}}
+Revisar en {{.AdminURL}}/admin/receipts
+`,
+	},
+	domain.EventLowStock: {
+		Subject: `Stock bajo: {{len .LowStock}} variantes`,
+		Body: `STOCK BAJO
+{{range .LowStock}}- {{.Product}}{{with .Color}} ({{.}}){{end}}{{with .SKU}} [{{.}}]{{end}}: {{.Stock}} u.
+{{end}}{{if .LowStockMore}}… y {{.LowStockMore}} más
+{{end}}Revisar en {{.AdminURL}}/admin/products
+`,
+	},
+}
+
+// DefaultNotificationTemplate devuelve la plantilla predeterminada del evento.
+func DefaultNotificationTemplate(event string) domain.NotificationTemplate {
+	t := defaultTemplates[event]
+	t.Event = event
+	return t
+}
+
+// renderNotification arma el asunto y el texto del aviso con la plantilla.
+func renderNotification(t domain.NotificationTemplate, data NotificationData) (subject, text string, err error) {
+	if subject, err = execNotifyTemplate("subject", t.Subject, data); err != nil {
+		return "", "", fmt.Errorf("asunto: %w", err)
+	}
+	if text, err = execNotifyTemplate("body", t.Body, data); err != nil {
+		return "", "", fmt.Errorf("texto: %w", err)
+	}
+	return strings.TrimSpace(subject), strings.TrimSpace(text), nil
+}
+
+func execNotifyTemplate(name, src string, data NotificationData) (string, error) {
+	tmpl, err := template.New(name).Funcs(notifyFuncs).Option("missingkey=error").Parse(src)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// sampleNotificationData son datos de ejemplo para validar y previsualizar las plantillas en el admin.
+func sampleNotificationData(event, store, adminURL string) NotificationData {
+	now := time.Now()
+	o := &domain.Order{
+		ID: uuid.New(), Seq: 123, Status: domain.OrderStatusAwaitingPay,
+		Name: "Juan Pérez", Email: "juan@example.com", Phone: "+54 9 11 5555-5555", DNI: "30111222",
+		Address: "Av. Siempre Viva 742", Province: "Buenos Aires", PostalCode: "1406", DeliveryNotes: "Timbre 2B",
+		ShippingMethod: "envio", ShippingCost: 4500, PaymentMethod: "transferencia", Total: 254500,
+		CancelReason: "Venció el plazo de pago", TrackingNumber: "CP123456789AR",
+		Items:     []domain.OrderItem{{ID: uuid.New(), Title: "Samsung Galaxy A15 128GB", Qty: 1, UnitPrice: 250000, Color: "#111827"}},
+		CreatedAt: now,
+	}
+	rc := &domain.PaymentReceipt{ID: uuid.New(), OrderID: o.ID, FileName: "comprobante.pdf", Status: domain.ReceiptStatusPending, CreatedAt: now}
+	d := notificationData(event, o, rc, store, adminURL)
+	d.LowStock = []domain.LowStockItem{{Product: "Samsung Galaxy A15 128GB", Color: "Negro", SKU: "A15-128-BK", Stock: 1}, {Product: "Funda silicona iPhone 15", Stock: 0}}
+	d.LowStockMore = 3
+	return d
+}
+
+func notificationData(event string, o *domain.Order, rc *domain.PaymentReceipt, store, adminURL string) NotificationData {
+	d := NotificationData{
+		Event:      event,
+		EventLabel: domain.NotificationEventLabel(event),
+		Store:      store,
+		AdminURL:   adminURL,
+		Order:      o,
+		Receipt:    rc,
+	}
+	if o != nil {
+		d.Status = o.PaymentStatusText(event == domain.EventOrderPaid)
+		d.Delivery = o.ShippingMethod == "envio" || o.ShippingMethod == "cadete"
+		for _, it := range o.Items {
+			d.Items = append(d.Items, NotificationItem{Title: it.Title, Qty: it.Qty, UnitPrice: it.UnitPrice, Color: domain.ColorName(it.Color)})
+		}
+	}
+	return d
+}
@@ -3,8 +3,13 @@ package usecase
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
 	"github.com/phenrril/tienda3d/internal/domain"
 )
 
@@ -12,7 +17,11 @@ type OrderUC struct {
 	Orders   domain.OrderRepo
 	Quotes   domain.QuoteRepo
 	Products domain.ProductRepo
-	Clock    domain.Clock
+	Events   domain.OrderEventRepo
+	Audit    domain.OrderAuditRepo
+	// Notifications despierta al worker de avisos cuando un cambio de estado encola uno.
+	Notifications *NotificationUC
+	Clock         domain.Clock
 }
 
 func (uc *OrderUC) CreateFromQuote(ctx context.Context, quote *domain.Quote, email string) (*domain.Order, error) {
@@ -29,9 +38,264 @@ func (uc *OrderUC) CreateFromQuote(ctx context.Context, quote *domain.Quote, ema
 	if err := uc.Orders.Save(ctx, o); err != nil {
 		return nil, err
 	}
+	uc.RecordCreated(ctx, o, domain.StatusChange{Actor: email, Source: domain.StatusSourceCheckout, Note: "orden creada desde cotización"})
 	return o, nil
 }
 
-func (uc *OrderUC) UpdateStatus(ctx context.Context, id uuid.UUID, st domain.OrderStatus) error {
-	return uc.Orders.UpdateStatus(ctx, id, st)
+// UpdateStatus es el único punto por el que cambia el estado de una orden: valida la transición, la
+// persiste, la registra en el historial y actualiza o.Status. Si la orden ya está en ese estado no hace nada.
+// La escritura es condicional al estado leído: si otro cambio se adelantó devuelve domain.ErrStatusConflict
+// sin registrar nada, y hay que volver a leer la orden.
+func (uc *OrderUC) UpdateStatus(ctx context.Context, o *domain.Order, to domain.OrderStatus, ch domain.StatusChange) error {
+	if o == nil {
+		return errors.New("orden nil")
+	}
+	from := o.Status
+	if from == to {
+		return nil
+	}
+	if err := from.ValidateTransition(to); err != nil {
+		return err
+	}
+	pending := len(o.Outbox)
+	switch to {
+	case domain.OrderStatusShipped:
+		o.QueueEvent(domain.EventOrderShipped, uc.now())
+		o.QueueWhatsApp(domain.WhatsAppShipped, uc.now())
+	case domain.OrderStatusCancelled:
+		o.QueueEvent(domain.EventOrderCancelled, uc.now())
+	}
+	queued := len(o.Outbox) > 0
+	if err := uc.Orders.UpdateStatus(ctx, o, to); err != nil {
+		o.Outbox = o.Outbox[:pending]
+		if errors.Is(err, domain.ErrStatusConflict) {
+			return fmt.Errorf("%w: estaba en %s, volvé a cargarla", err, from)
+		}
+		return err
+	}
+	o.Status = to
+	uc.record(ctx, o.ID, from, to, ch)
+	if queued {
+		uc.Notifications.Kick()
+	}
+	return nil
+}
+
+// CancelUnpaid cancela una orden impaga (con o.CancelReason) mediante una actualización condicional: si
+// mientras tanto llegó un pago o un comprobante no la toca y devuelve false.
+func (uc *OrderUC) CancelUnpaid(ctx context.Context, o *domain.Order, ch domain.StatusChange) (bool, error) {
+	from := o.Status
+	if err := from.ValidateTransition(domain.OrderStatusCancelled); err != nil {
+		return false, err
+	}
+	pending := len(o.Outbox)
+	o.QueueEvent(domain.EventOrderCancelled, uc.now())
+	ok, err := uc.Orders.CancelUnpaid(ctx, o)
+	if err != nil || !ok {
+		o.Outbox = o.Outbox[:pending]
+		return false, err
+	}
+	o.Status = domain.OrderStatusCancelled
+	uc.record(ctx, o.ID, from, o.Status, ch)
+	uc.Notifications.Kick()
+	return true, nil
+}
+
+// RecordCreated registra en el historial el estado inicial de una orden recién creada.
+func (uc *OrderUC) RecordCreated(ctx context.Context, o *domain.Order, ch domain.StatusChange) {
+	uc.record(ctx, o.ID, "", o.Status, ch)
+}
+
+// History devuelve el historial de estados de la orden, del más viejo al más nuevo.
+func (uc *OrderUC) History(ctx context.Context, orderID uuid.UUID) ([]domain.OrderStatusEvent, error) {
+	if uc.Events == nil {
+		return nil, nil
+	}
+	return uc.Events.ListByOrder(ctx, orderID)
+}
+
+func (uc *OrderUC) record(ctx context.Context, orderID uuid.UUID, from, to domain.OrderStatus, ch domain.StatusChange) {
+	if uc.Events == nil {
+		return
+	}
+	ev := &domain.OrderStatusEvent{
+		OrderID:   orderID,
+		From:      from,
+		To:        to,
+		Actor:     ch.Actor,
+		Source:    ch.Source,
+		Note:      ch.Note,
+		CreatedAt: uc.now(),
+	}
+	// El historial no debe frenar el cambio de estado ya persistido.
+	if err := uc.Events.Save(ctx, ev); err != nil {
+		log.Error().Err(err).Str("order_id", orderID.String()).Msg("no se pudo registrar el cambio de estado")
+	}
+}
+
+// UpdateContact corrige los datos de contacto y envío de la orden y deja en la auditoría qué cambió.
+func (uc *OrderUC) UpdateContact(ctx context.Context, o *domain.Order, c domain.OrderContact, actor string) error {
+	if o == nil {
+		return errors.New("orden nil")
+	}
+	if strings.TrimSpace(c.Email) == "" {
+		return errors.New("el email es obligatorio")
+	}
+	var changes []string
+	set := func(label string, dst *string, v string) {
+		v = strings.TrimSpace(v)
+		if *dst == v {
+			return
+		}
+		changes = append(changes, fmt.Sprintf("%s: %q → %q", label, *dst, v))
+		*dst = v
+	}
+	set("nombre", &o.Name, c.Name)
+	set("email", &o.Email, c.Email)
+	set("teléfono", &o.Phone, c.Phone)
+	set("DNI", &o.DNI, c.DNI)
+	set("dirección", &o.Address, c.Address)
+	set("CP", &o.PostalCode, c.PostalCode)
+	set("provincia", &o.Province, c.Province)
+	set("notas de entrega", &o.DeliveryNotes, c.DeliveryNotes)
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := uc.Orders.Save(ctx, o); err != nil {
+		return err
+	}
+	uc.audit(ctx, o.ID, actor, domain.OrderAuditContact, strings.Join(changes, "; "))
+	return nil
+}
+
+// NotifyReadyForPickup avisa por WhatsApp al comprador que su pedido pagado ya se puede retirar.
+func (uc *OrderUC) NotifyReadyForPickup(ctx context.Context, o *domain.Order, actor string) error {
+	if o == nil {
+		return errors.New("orden nil")
+	}
+	if o.ShippingMethod == "envio" || o.ShippingMethod == "cadete" {
+		return errors.New("la orden no es para retirar en el local")
+	}
+	if o.Status != domain.OrderStatusFinished {
+		return errors.New("sólo se avisa el retiro de órdenes pagadas")
+	}
+	if !o.WhatsAppOptIn || o.WhatsAppPhone == "" {
+		return errors.New("el comprador no aceptó recibir mensajes por WhatsApp")
+	}
+	o.QueueWhatsApp(domain.WhatsAppReadyForPickup, uc.now())
+	if err := uc.Orders.Save(ctx, o); err != nil {
+		return err
+	}
+	uc.Notifications.Kick()
+	uc.audit(ctx, o.ID, actor, domain.OrderAuditWhatsApp, "aviso de listo para retirar")
+	return nil
+}
+
+// WhatsAppOptOut da de baja al comprador de los mensajes de WhatsApp de la orden.
+func (uc *OrderUC) WhatsAppOptOut(ctx context.Context, o *domain.Order, actor string) error {
+	if o == nil {
+		return errors.New("orden nil")
+	}
+	if !o.WhatsAppOptIn {
+		return nil
+	}
+	o.WhatsAppOptIn = false
+	if err := uc.Orders.Save(ctx, o); err != nil {
+		return err
+	}
+	uc.audit(ctx, o.ID, actor, domain.OrderAuditWhatsApp, "baja de mensajes por WhatsApp ("+o.WhatsAppPhone+")")
+	return nil
+}
+
+// AddItem agrega un item a una orden impaga y recalcula descuento y total.
+func (uc *OrderUC) AddItem(ctx context.Context, o *domain.Order, it domain.OrderItem, actor string) error {
+	if o == nil {
+		return errors.New("orden nil")
+	}
+	if err := o.CheckItemsEditable(); err != nil {
+		return err
+	}
+	if it.Qty <= 0 || it.UnitPrice < 0 {
+		return errors.New("cantidad o precio inválido")
+	}
+	it.ID = uuid.New()
+	it.OrderID = o.ID
+	if err := uc.Orders.SaveItem(ctx, &it); err != nil {
+		return err
+	}
+	prev := o.Total
+	o.Items = append(o.Items, it)
+	o.RecalculateTotals()
+	if err := uc.Orders.Save(ctx, o); err != nil {
+		return err
+	}
+	uc.audit(ctx, o.ID, actor, domain.OrderAuditItemAdded, fmt.Sprintf("%d x %s ($%.2f) · total $%.2f → $%.2f", it.Qty, itemLabel(it), it.UnitPrice, prev, o.Total))
+	return nil
+}
+
+// RemoveItem quita un item de una orden impaga y recalcula descuento y total. La orden no puede quedar vacía.
+func (uc *OrderUC) RemoveItem(ctx context.Context, o *domain.Order, itemID uuid.UUID, actor string) error {
+	if o == nil {
+		return errors.New("orden nil")
+	}
+	if err := o.CheckItemsEditable(); err != nil {
+		return err
+	}
+	idx := -1
+	for i := range o.Items {
+		if o.Items[i].ID == itemID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return domain.ErrNotFound
+	}
+	if len(o.Items) == 1 {
+		return errors.New("la orden tiene que conservar al menos un item; para anularla cancelala")
+	}
+	it := o.Items[idx]
+	if err := uc.Orders.DeleteItem(ctx, o.ID, itemID); err != nil {
+		return err
+	}
+	prev := o.Total
+	o.Items = append(o.Items[:idx], o.Items[idx+1:]...)
+	o.RecalculateTotals()
+	if err := uc.Orders.Save(ctx, o); err != nil {
+		return err
+	}
+	uc.audit(ctx, o.ID, actor, domain.OrderAuditItemRemoved, fmt.Sprintf("%d x %s ($%.2f) · total $%.2f → $%.2f", it.Qty, itemLabel(it), it.UnitPrice, prev, o.Total))
+	return nil
+}
+
+// AuditTrail devuelve las ediciones manuales de la orden, de la más vieja a la más nueva.
+func (uc *OrderUC) AuditTrail(ctx context.Context, orderID uuid.UUID) ([]domain.OrderAuditEntry, error) {
+	if uc.Audit == nil {
+		return nil, nil
+	}
+	return uc.Audit.ListByOrder(ctx, orderID)
+}
+
+func itemLabel(it domain.OrderItem) string {
+	if it.Color != "" {
+		return it.Title + " (" + it.Color + ")"
+	}
+	return it.Title
+}
+
+func (uc *OrderUC) audit(ctx context.Context, orderID uuid.UUID, actor, action, detail string) {
+	if uc.Audit == nil {
+		return
+	}
+	e := &domain.OrderAuditEntry{OrderID: orderID, Actor: actor, Action: action, Detail: detail, CreatedAt: uc.now()}
+	if err := uc.Audit.Save(ctx, e); err != nil {
+		log.Error().Err(err).Str("order_id", orderID.String()).Msg("no se pudo registrar la auditoría de la orden")
+	}
+}
+
+func (uc *OrderUC) now() time.Time {
+	if uc.Clock != nil {
+		return uc.Clock.Now()
+	}
+	return time.Now()
 }
@@ -2,13 +2,38 @@ package usecase
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
 
 	"github.com/phenrril/tienda3d/internal/domain"
 )
 
+const (
+	// reconcileLookback limita la antigüedad de las órdenes que se concilian.
+	reconcileLookback = 7 * 24 * time.Hour
+	// reconcileMinAge deja tiempo a que llegue el webhook antes de consultar a MP.
+	reconcileMinAge = 10 * time.Minute
+)
+
 type PaymentUC struct {
-	Orders  domain.OrderRepo
-	Gateway domain.PaymentGateway
+	Orders   domain.OrderRepo
+	Payments domain.PaymentRepo
+	Gateway  domain.PaymentGateway
+	// Transitions aplica y registra los cambios de estado de las órdenes.
+	Transitions *OrderUC
+	// Notifications entrega los avisos a admin y comprador que se encolan cuando una orden queda aprobada.
+	Notifications *NotificationUC
+	// Products descuenta el stock de lo vendido cuando la orden queda paga.
+	Products domain.ProductRepo
+	// Webhooks publica stock.changed al descontar lo vendido.
+	Webhooks *WebhookUC
+
+	mu         sync.Mutex
+	lastReport *domain.ReconcileReport
 }
 
 func (uc *PaymentUC) CreatePreference(ctx context.Context, order *domain.Order) (string, error) {
@@ -18,3 +43,281 @@ func (uc *PaymentUC) CreatePreference(ctx context.Context, order *domain.Order)
 	}
 	return url, nil
 }
+
+// ApplyGatewayStatus aplica a la orden las transiciones que corresponden al estado informado por MP
+// (las mismas para el webhook y el conciliador) y registra el pago en el ledger.
+// Devuelve true si la orden quedó paga en esta llamada.
+func (uc *PaymentUC) ApplyGatewayStatus(ctx context.Context, o *domain.Order, gp *domain.GatewayPayment) (bool, error) {
+	if o == nil || gp == nil {
+		return false, errors.New("orden o pago nil")
+	}
+	p := &domain.Payment{
+		OrderID:    o.ID,
+		Method:     "mercadopago",
+		Amount:     gp.Amount,
+		Currency:   gp.Currency,
+		ExternalID: gp.ID,
+		ApprovedAt: gp.ApprovedAt,
+	}
+	if p.Amount <= 0 {
+		p.Amount = o.AmountDue()
+	}
+	if gp.Status == "refunded" || gp.Status == "charged_back" {
+		return false, uc.recordReversal(ctx, o, gp, p.Amount)
+	}
+	o.PaymentStatus = gp.Status
+	ch := domain.StatusChange{Actor: "mercadopago", Source: domain.StatusSourcePayment, Note: fmt.Sprintf("pago MP %s %s", gp.ID, gp.Status)}
+	switch gp.Status {
+	case "approved":
+		p.Status = domain.PaymentStatusApproved
+		o.MPPaymentID = gp.ID
+	case "pending", "in_process", "in_mediation":
+		p.Status = domain.PaymentStatusPending
+		if o.Status == domain.OrderStatusQuoted || o.Status == domain.OrderStatusCancelled {
+			if err := uc.Transitions.UpdateStatus(ctx, o, domain.OrderStatusAwaitingPay, ch); err != nil {
+				return false, err
+			}
+		}
+	default:
+		p.Status = domain.PaymentStatusRejected
+		if gp.Status == "rejected" && o.Status == domain.OrderStatusAwaitingPay {
+			if err := uc.Transitions.UpdateStatus(ctx, o, domain.OrderStatusCancelled, ch); err != nil {
+				return false, err
+			}
+		}
+	}
+	return uc.RecordPayment(ctx, o, p)
+}
+
+// recordReversal registra un reembolso o contracargo informado por MP como un movimiento negativo aparte,
+// con su propio id externo, sin tocar el pago aprobado original del ledger. Los reembolsos hechos desde la
+// tienda ya están en el ledger, así que de un "refunded" sólo se registra lo que falte.
+func (uc *PaymentUC) recordReversal(ctx context.Context, o *domain.Order, gp *domain.GatewayPayment, amount float64) error {
+	extID := gp.ID + ":" + gp.Status
+	if _, err := uc.Payments.FindByExternalID(ctx, "mercadopago", extID); err == nil {
+		return nil
+	} else if !errors.Is(err, domain.ErrNotFound) {
+		return err
+	}
+	status := domain.PaymentStatusChargedBack
+	if gp.Status == "refunded" {
+		status = domain.PaymentStatusRefunded
+		bal, _, err := uc.Balance(ctx, o)
+		if err != nil {
+			return err
+		}
+		amount -= bal.Refunded
+	}
+	if amount <= 0.01 {
+		return nil
+	}
+	currency := gp.Currency
+	if currency == "" {
+		currency = "ARS"
+	}
+	now := time.Now()
+	return uc.Payments.Save(ctx, &domain.Payment{
+		OrderID:    o.ID,
+		Method:     "mercadopago",
+		Amount:     -amount,
+		Currency:   currency,
+		ExternalID: extID,
+		Status:     status,
+		Note:       "MP " + gp.Status + " del pago " + gp.ID,
+		Actor:      "mercadopago",
+		ApprovedAt: &now,
+	})
+}
+
+// RecordPayment agrega (o actualiza, si ya existe el mismo id externo) un movimiento en el ledger de la
+// orden y recalcula el saldo. La orden pasa a pagada sólo cuando el saldo llega a cero; en ese momento
+// se encolan los avisos a admin y comprador junto con la orden y se descuenta del stock lo vendido.
+// Devuelve true si la orden quedó paga en esta llamada.
+func (uc *PaymentUC) RecordPayment(ctx context.Context, o *domain.Order, p *domain.Payment) (bool, error) {
+	if o == nil || p == nil {
+		return false, errors.New("orden o pago nil")
+	}
+	p.OrderID = o.ID
+	if p.Currency == "" {
+		p.Currency = "ARS"
+	}
+	if p.Status == domain.PaymentStatusApproved && p.ApprovedAt == nil {
+		now := time.Now()
+		p.ApprovedAt = &now
+	}
+	if p.ExternalID != "" {
+		if prev, err := uc.Payments.FindByExternalID(ctx, p.Method, p.ExternalID); err == nil && prev.OrderID == o.ID {
+			p.ID = prev.ID
+			p.CreatedAt = prev.CreatedAt
+		}
+	}
+	if err := uc.Payments.Save(ctx, p); err != nil {
+		return false, err
+	}
+
+	bal, _, err := uc.Balance(ctx, o)
+	if err != nil {
+		return false, err
+	}
+	paidNow := false
+	if bal.Settled() {
+		o.PaymentStatus = "approved"
+		if o.Status == domain.OrderStatusAwaitingPay || o.Status == domain.OrderStatusQuoted || o.Status == domain.OrderStatusCancelled {
+			actor := p.Actor
+			if actor == "" {
+				actor = p.Method
+			}
+			ch := domain.StatusChange{Actor: actor, Source: domain.StatusSourcePayment, Note: fmt.Sprintf("saldo cubierto (%s $%.2f)", p.Method, p.Amount)}
+			if err := uc.Transitions.UpdateStatus(ctx, o, domain.OrderStatusFinished, ch); err != nil {
+				return false, err
+			}
+		}
+		if !o.Notified {
+			o.Notified = true
+			paidNow = true
+			now := time.Now()
+			o.QueueEvent(domain.EventOrderPaid, now)
+			o.QueueNotification(domain.NotifyOrderCustomer, domain.OutboxPayload{Success: true}, now)
+			o.QueueWhatsApp(domain.WhatsAppPaymentConfirmed, now)
+		}
+	} else if bal.Paid > 0 {
+		// Seña o pago parcial: la orden sigue esperando el resto.
+		o.PaymentStatus = "partial"
+	}
+	if err := uc.Orders.Save(ctx, o); err != nil {
+		return false, err
+	}
+	if paidNow {
+		uc.takeStock(ctx, o)
+		uc.Notifications.Kick()
+	}
+	return paidNow, nil
+}
+
+// takeStock descuenta del stock las unidades vendidas de una orden que acaba de quedar paga. Las preventas
+// toman el stock al asignarse (PreOrderUC.Allocate). El pago ya está cobrado, así que no se frena por falta
+// de stock: la variante puede quedar en negativo y eso marca la sobreventa.
+func (uc *PaymentUC) takeStock(ctx context.Context, o *domain.Order) {
+	if uc.Products == nil || o.PreOrder {
+		return
+	}
+	for i := range o.Items {
+		takeItemStock(ctx, uc.Orders, uc.Products, uc.Webhooks, &o.Items[i])
+	}
+}
+
+// Balance devuelve el estado de cuenta de la orden junto con los movimientos del ledger.
+func (uc *PaymentUC) Balance(ctx context.Context, o *domain.Order) (domain.OrderBalance, []domain.Payment, error) {
+	pays, err := uc.Payments.ListByOrder(ctx, o.ID)
+	if err != nil {
+		return domain.OrderBalance{}, nil, err
+	}
+	return domain.ComputeBalance(o.Total, pays), pays, nil
+}
+
+// Reconcile recorre las órdenes de MP que siguen pendientes, consulta sus pagos en el gateway
+// y aplica el estado real cuando difiere del local (p.ej. si se perdió el webhook).
+func (uc *PaymentUC) Reconcile(ctx context.Context) (*domain.ReconcileReport, error) {
+	now := time.Now()
+	rep := &domain.ReconcileReport{StartedAt: now}
+	orders, err := uc.Orders.ListAwaitingPayment(ctx, "mercadopago", now.Add(-reconcileLookback), now.Add(-reconcileMinAge))
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		o := &orders[i]
+		rep.Checked++
+		pays, err := uc.Gateway.SearchPaymentsByOrder(ctx, o.ID)
+		if err != nil {
+			rep.Errors = append(rep.Errors, fmt.Sprintf("%s: %v", o.ID, err))
+			continue
+		}
+		if o.PaymentStatus == "partial" {
+			// Seña ya registrada: sólo interesan los pagos de MP que todavía no se acreditaron.
+			pays = uc.unrecorded(ctx, pays)
+		}
+		gp := pickGatewayPayment(pays)
+		if gp == nil || gp.Status == o.PaymentStatus {
+			continue
+		}
+		entry := domain.ReconcileEntry{
+			OrderID:       o.ID,
+			Email:         o.Email,
+			Total:         o.Total,
+			OrderStatus:   o.Status,
+			LocalMPStatus: o.PaymentStatus,
+			GatewayStatus: gp.Status,
+			PaymentID:     gp.ID,
+		}
+		if due := o.AmountDue(); gp.Amount > 0 && due > 0 && (gp.Amount-due > 0.01 || due-gp.Amount > 0.01) {
+			entry.Note = fmt.Sprintf("monto MP %.2f distinto al cobro %.2f", gp.Amount, due)
+		}
+		if _, err := uc.ApplyGatewayStatus(ctx, o, gp); err != nil {
+			entry.Note = "error aplicando estado: " + err.Error()
+		} else {
+			entry.Applied = true
+		}
+		rep.Entries = append(rep.Entries, entry)
+	}
+	rep.FinishedAt = time.Now()
+	uc.mu.Lock()
+	uc.lastReport = rep
+	uc.mu.Unlock()
+	return rep, nil
+}
+
+// LastReconcileReport devuelve el resultado de la última conciliación (nil si no corrió aún).
+func (uc *PaymentUC) LastReconcileReport() *domain.ReconcileReport {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	return uc.lastReport
+}
+
+// RunReconciler ejecuta Reconcile periódicamente hasta que se cancele el contexto.
+func (uc *PaymentUC) RunReconciler(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			rep, err := uc.Reconcile(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("conciliación MP falló")
+				continue
+			}
+			if len(rep.Entries) > 0 || len(rep.Errors) > 0 {
+				log.Info().Int("checked", rep.Checked).Int("mismatches", len(rep.Entries)).Int("errors", len(rep.Errors)).Msg("conciliación MP")
+			}
+		}
+	}
+}
+
+// unrecorded descarta los pagos de MP que ya figuran aprobados en el ledger.
+func (uc *PaymentUC) unrecorded(ctx context.Context, pays []domain.GatewayPayment) []domain.GatewayPayment {
+	out := pays[:0]
+	for _, p := range pays {
+		if prev, err := uc.Payments.FindByExternalID(ctx, "mercadopago", p.ID); err == nil && prev.Status == domain.PaymentStatusApproved {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// pickGatewayPayment elige el pago que define el estado de la orden:
+// uno aprobado si existe, si no el más reciente.
+func pickGatewayPayment(pays []domain.GatewayPayment) *domain.GatewayPayment {
+	var latest *domain.GatewayPayment
+	for i := range pays {
+		p := &pays[i]
+		if p.Status == "approved" {
+			return p
+		}
+		if latest == nil || p.CreatedAt.After(latest.CreatedAt) {
+			latest = p
+		}
+	}
+	return latest
+}
@@ -0,0 +1,315 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// PreOrderUC maneja las preventas: variantes sin stock que se venden cobrando una seña, y la asignación
+// del stock que llega a las preventas en orden de compra.
+type PreOrderUC struct {
+	Products domain.ProductRepo
+	Orders   domain.OrderRepo
+	Payments *PaymentUC
+	Audit    domain.OrderAuditRepo
+	Email    domain.EmailService
+	// DefaultDepositPct es la seña de las variantes sin porcentaje propio.
+	DefaultDepositPct float64
+	Clock             domain.Clock
+	// Webhooks publica stock.changed al asignar stock a las preventas.
+	Webhooks *WebhookUC
+}
+
+// PreOrderVariant es una variante marcada para preventa, con su producto y las unidades que esperan
+// stock en preventas con la seña paga.
+type PreOrderVariant struct {
+	Product    *domain.Product
+	Variant    domain.Variant
+	DepositPct float64
+	Waiting    int
+}
+
+// PreOrderEntry es una preventa abierta con lo cobrado hasta ahora.
+type PreOrderEntry struct {
+	Order       domain.Order
+	Paid        float64
+	Balance     float64
+	DepositPaid bool
+}
+
+// DepositPct es el porcentaje de seña de la variante.
+func (uc *PreOrderUC) DepositPct(v domain.Variant) float64 {
+	if v.DepositPct > 0 {
+		return v.DepositPct
+	}
+	return uc.DefaultDepositPct
+}
+
+// Configure marca (o desmarca) para preventa la variante con ese SKU o EAN. pct 0 usa la seña por defecto.
+func (uc *PreOrderUC) Configure(ctx context.Context, code string, enabled bool, eta *time.Time, pct float64) (*domain.Product, *domain.Variant, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, nil, errors.New("indicá el SKU o EAN de la variante")
+	}
+	if pct < 0 || pct > 100 {
+		return nil, nil, errors.New("la seña tiene que ser un porcentaje entre 0 y 100")
+	}
+	p, v, err := uc.Products.FindVariantBySKU(ctx, code)
+	if errors.Is(err, domain.ErrNotFound) {
+		p, v, err = uc.Products.FindVariantByEAN(ctx, code)
+	}
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil, fmt.Errorf("no hay una variante con SKU o EAN %s", code)
+		}
+		return nil, nil, err
+	}
+	v.PreOrder = enabled
+	v.PreOrderETA = nil
+	v.DepositPct = 0
+	if enabled {
+		v.PreOrderETA = eta
+		v.DepositPct = pct
+	}
+	if err := uc.Products.SaveVariant(ctx, v); err != nil {
+		return nil, nil, err
+	}
+	return p, v, nil
+}
+
+// Variants devuelve las variantes marcadas para preventa con las unidades que esperan stock.
+func (uc *PreOrderUC) Variants(ctx context.Context) ([]PreOrderVariant, error) {
+	list, err := uc.Products.ListPreOrderVariants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	open, err := uc.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	waiting := map[uuid.UUID]int{}
+	for _, e := range open {
+		if !e.DepositPaid || e.Order.AllocatedAt != nil {
+			continue
+		}
+		for _, it := range e.Order.Items {
+			if it.VariantID != nil {
+				waiting[*it.VariantID] += it.Qty
+			}
+		}
+	}
+	products := map[uuid.UUID]*domain.Product{}
+	out := make([]PreOrderVariant, 0, len(list))
+	for _, v := range list {
+		p, ok := products[v.ProductID]
+		if !ok {
+			if p, err = uc.Products.FindByID(ctx, v.ProductID); err != nil && !errors.Is(err, domain.ErrNotFound) {
+				return nil, err
+			}
+			products[v.ProductID] = p
+		}
+		out = append(out, PreOrderVariant{Product: p, Variant: v, DepositPct: uc.DepositPct(v), Waiting: waiting[v.ID]})
+	}
+	return out, nil
+}
+
+// Apply revisa los items de una orden nueva: si son variantes en preventa la marca como preventa y fija
+// la seña como monto a cobrar. Devuelve domain.ErrPreOrderMixed si mezcla preventa con stock.
+// Se llama con los items y el total ya calculados, antes de guardar la orden.
+func (uc *PreOrderUC) Apply(ctx context.Context, o *domain.Order) error {
+	cache := map[uuid.UUID][]domain.Variant{}
+	pre, regular := 0, 0
+	weighted, base := 0.0, 0.0
+	var eta *time.Time
+	for _, it := range o.Items {
+		v, err := uc.variantOf(ctx, it, cache)
+		if err != nil {
+			return err
+		}
+		if v == nil || !v.PreOrderable() {
+			regular++
+			continue
+		}
+		pre++
+		weighted += it.Subtotal() * uc.DepositPct(*v)
+		base += it.Subtotal()
+		if v.PreOrderETA != nil && (eta == nil || v.PreOrderETA.After(*eta)) {
+			eta = v.PreOrderETA
+		}
+	}
+	if pre == 0 {
+		return nil
+	}
+	if regular > 0 {
+		return domain.ErrPreOrderMixed
+	}
+	pct := 0.0
+	if base > 0 {
+		pct = weighted / base
+	}
+	o.PreOrder = true
+	o.PreOrderETA = eta
+	o.DepositAmount = domain.DepositFor(o.Total, pct)
+	o.ChargeAmount = o.DepositAmount
+	return nil
+}
+
+// Open devuelve las preventas que falta terminar de pagar, las más viejas primero.
+func (uc *PreOrderUC) Open(ctx context.Context) ([]PreOrderEntry, error) {
+	orders, err := uc.Orders.ListPreOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PreOrderEntry, 0, len(orders))
+	for i := range orders {
+		bal, _, err := uc.Payments.Balance(ctx, &orders[i])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, PreOrderEntry{
+			Order:       orders[i],
+			Paid:        bal.Paid,
+			Balance:     bal.Balance,
+			DepositPaid: bal.Paid > 0 && bal.Paid+0.01 >= orders[i].DepositAmount,
+		})
+	}
+	return out, nil
+}
+
+// Allocate asigna el stock disponible a las preventas con la seña paga, por orden de compra, y les pide
+// el saldo. Si a la preventa más vieja de una variante no le alcanza el stock, las siguientes de esa
+// variante siguen esperando aunque pidan menos unidades. Devuelve cuántas preventas se asignaron.
+func (uc *PreOrderUC) Allocate(ctx context.Context, actor string) (int, error) {
+	open, err := uc.Open(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cache := map[uuid.UUID][]domain.Variant{}
+	stock := map[uuid.UUID]int{}
+	blocked := map[uuid.UUID]bool{}
+	allocated := 0
+	for i := range open {
+		e := &open[i]
+		o := &e.Order
+		if o.AllocatedAt != nil || !e.DepositPaid {
+			continue
+		}
+		need := map[uuid.UUID]int{}
+		fits := true
+		for _, it := range o.Items {
+			v, err := uc.variantOf(ctx, it, cache)
+			if err != nil {
+				return allocated, err
+			}
+			if v == nil {
+				continue
+			}
+			if _, ok := stock[v.ID]; !ok {
+				stock[v.ID] = v.Stock
+			}
+			need[v.ID] += it.Qty
+		}
+		for vid, qty := range need {
+			if blocked[vid] || stock[vid] < qty {
+				fits = false
+			}
+		}
+		if !fits {
+			for vid := range need {
+				blocked[vid] = true
+			}
+			continue
+		}
+		for vid, qty := range need {
+			if err := uc.Products.UpdateVariantStock(ctx, vid, -qty); err != nil {
+				return allocated, err
+			}
+			uc.Webhooks.StockChanged(ctx, vid, -qty)
+			stock[vid] -= qty
+		}
+		for j := range o.Items {
+			it := &o.Items[j]
+			if it.VariantID == nil || need[*it.VariantID] == 0 {
+				continue
+			}
+			it.StockTaken = it.Qty
+			if err := uc.Orders.SaveItem(ctx, it); err != nil {
+				log.Error().Err(err).Str("order_id", o.ID.String()).Msg("preventa: no se pudo registrar el stock tomado")
+			}
+		}
+		if err := uc.requestBalance(ctx, o, e.Balance, actor); err != nil {
+			log.Error().Err(err).Str("order_id", o.ID.String()).Msg("preventa: no se pudo pedir el saldo")
+			continue
+		}
+		allocated++
+	}
+	return allocated, nil
+}
+
+// requestBalance marca la preventa como asignada y le pide al comprador el saldo. Se descartan la
+// preferencia de MP y la cotización cripto de la seña para que el próximo pago se arme por el saldo.
+func (uc *PreOrderUC) requestBalance(ctx context.Context, o *domain.Order, balance float64, actor string) error {
+	now := uc.now()
+	o.AllocatedAt = &now
+	o.ChargeAmount = balance
+	o.MPPreferenceID = ""
+	o.CryptoAmount = 0
+	o.CryptoExpires = nil
+	if err := uc.Orders.Save(ctx, o); err != nil {
+		return err
+	}
+	uc.audit(ctx, o.ID, actor, fmt.Sprintf("stock asignado · saldo pedido $%.2f", balance))
+	log.Info().Str("order_id", o.ID.String()).Float64("balance", balance).Msg("preventa: stock asignado, saldo pedido")
+	if uc.Email != nil {
+		if err := uc.Email.SendPreOrderBalance(ctx, o); err != nil {
+			log.Error().Err(err).Str("order_id", o.ID.String()).Msg("preventa: no se pudo enviar el pedido de saldo")
+		}
+	}
+	return nil
+}
+
+// variantOf busca la variante de un item de orden (nil si el item no tiene variante).
+func (uc *PreOrderUC) variantOf(ctx context.Context, it domain.OrderItem, cache map[uuid.UUID][]domain.Variant) (*domain.Variant, error) {
+	if it.ProductID == nil || it.VariantID == nil {
+		return nil, nil
+	}
+	vs, ok := cache[*it.ProductID]
+	if !ok {
+		var err error
+		if vs, err = uc.Products.ListVariants(ctx, *it.ProductID); err != nil {
+			return nil, err
+		}
+		cache[*it.ProductID] = vs
+	}
+	for i := range vs {
+		if vs[i].ID == *it.VariantID {
+			return &vs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (uc *PreOrderUC) audit(ctx context.Context, orderID uuid.UUID, actor, detail string) {
+	if uc.Audit == nil {
+		return
+	}
+	e := &domain.OrderAuditEntry{OrderID: orderID, Actor: actor, Action: domain.OrderAuditPreOrder, Detail: detail, CreatedAt: time.Now()}
+	if err := uc.Audit.Save(ctx, e); err != nil {
+		log.Error().Err(err).Str("order_id", orderID.String()).Msg("no se pudo registrar la auditoría de la preventa")
+	}
+}
+
+func (uc *PreOrderUC) now() time.Time {
+	if uc.Clock != nil {
+		return uc.Clock.Now()
+	}
+	return time.Now()
+}
@@ -11,6 +11,8 @@ import (
 
 type ProductUC struct {
 	Products domain.ProductRepo
+	// Webhooks publica product.updated y stock.changed.
+	Webhooks *WebhookUC
 }
 
 func (uc *ProductUC) List(ctx context.Context, f domain.ProductFilter) ([]domain.Product, int64, error) {
@@ -32,7 +34,11 @@ func (uc *ProductUC) Create(ctx context.Context, p *domain.Product) error {
 		p.ID = uuid.New()
 	}
 	p.Slug = strings.ToLower(strings.ReplaceAll(p.Name, " ", "-"))
-	return uc.Products.Save(ctx, p)
+	if err := uc.Products.Save(ctx, p); err != nil {
+		return err
+	}
+	uc.Webhooks.ProductUpdated(ctx, p)
+	return nil
 }
 
 func (uc *ProductUC) AddImages(ctx context.Context, productID uuid.UUID, imgs []domain.Image) error {
@@ -83,14 +89,28 @@ func (uc *ProductUC) CreateVariant(ctx context.Context, v *domain.Variant) error
 	if v.ID == uuid.Nil {
 		v.ID = uuid.New()
 	}
-	return uc.Products.SaveVariant(ctx, v)
+	if err := uc.Products.SaveVariant(ctx, v); err != nil {
+		return err
+	}
+	uc.Webhooks.StockChanged(ctx, v.ID, v.Stock)
+	return nil
 }
 
 func (uc *ProductUC) UpdateVariant(ctx context.Context, v *domain.Variant) error {
 	if v == nil || v.ID == uuid.Nil {
 		return errors.New("variant id")
 	}
-	return uc.Products.SaveVariant(ctx, v)
+	prev := v.Stock
+	if uc.Webhooks != nil {
+		if _, old, err := uc.Products.FindVariantByID(ctx, v.ID); err == nil {
+			prev = old.Stock
+		}
+	}
+	if err := uc.Products.SaveVariant(ctx, v); err != nil {
+		return err
+	}
+	uc.Webhooks.StockChanged(ctx, v.ID, v.Stock-prev)
+	return nil
 }
 
 func (uc *ProductUC) DeleteVariant(ctx context.Context, id uuid.UUID) error {
@@ -0,0 +1,219 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+const (
+	// MaxReceiptSize es el tamaño máximo de un comprobante subido por el comprador.
+	MaxReceiptSize = 10 << 20
+	// receiptReviewLookback limita la antigüedad de las órdenes que aparecen en la cola de revisión.
+	receiptReviewLookback = 30 * 24 * time.Hour
+	// maxPendingReceipts es cuántos comprobantes sin revisar puede tener una orden; la subida es pública.
+	maxPendingReceipts = 3
+)
+
+// receiptTypes son los formatos de comprobante aceptados y su extensión.
+var receiptTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+type ReceiptUC struct {
+	Orders   domain.OrderRepo
+	Receipts domain.ReceiptRepo
+	Storage  domain.FileStorage
+	Payments *PaymentUC
+	Email    domain.EmailService
+	// Notifications entrega el aviso al staff de que hay un comprobante nuevo para revisar.
+	Notifications *NotificationUC
+}
+
+// Submit guarda el comprobante que sube el comprador de una orden por transferencia o cripto que
+// todavía no está paga y lo deja pendiente de revisión.
+func (uc *ReceiptUC) Submit(ctx context.Context, orderID uuid.UUID, contentType string, data []byte) (*domain.PaymentReceipt, error) {
+	o, err := uc.Orders.FindByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if o.PaymentMethod != "transferencia" && o.PaymentMethod != "cripto" {
+		return nil, errors.New("la orden no admite comprobantes")
+	}
+	if o.Status != domain.OrderStatusAwaitingPay {
+		return nil, errors.New("la orden no está pendiente de pago")
+	}
+	if len(data) == 0 {
+		return nil, errors.New("archivo vacío")
+	}
+	if len(data) > MaxReceiptSize {
+		return nil, fmt.Errorf("el archivo supera %d MB", MaxReceiptSize>>20)
+	}
+	ext, ok := receiptTypes[contentType]
+	if !ok {
+		return nil, errors.New("formato no soportado (JPG, PNG, WEBP o PDF)")
+	}
+	prev, err := uc.Receipts.ListByOrder(ctx, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	pending := 0
+	for _, rc := range prev {
+		if rc.Status == domain.ReceiptStatusPending {
+			pending++
+		}
+	}
+	if pending >= maxPendingReceipts {
+		return nil, fmt.Errorf("ya hay %d comprobantes esperando revisión; esperá a que los revisemos", pending)
+	}
+	name := o.ID.String()[:8] + ext
+	path, err := uc.Storage.SaveReceipt(ctx, name, data)
+	if err != nil {
+		return nil, err
+	}
+	rc := &domain.PaymentReceipt{
+		OrderID:     o.ID,
+		FileName:    name,
+		ContentType: contentType,
+		Path:        path,
+		Size:        int64(len(data)),
+		Status:      domain.ReceiptStatusPending,
+	}
+	if err := uc.Receipts.Save(ctx, rc); err != nil {
+		return nil, err
+	}
+	if uc.Notifications != nil {
+		m := domain.NewOutboxMessage(domain.NotifyEvent, &o.ID, domain.OutboxPayload{Event: domain.EventReceiptReceived, ReceiptID: &rc.ID}, time.Now())
+		if err := uc.Notifications.Enqueue(ctx, m); err != nil {
+			log.Error().Err(err).Str("order_id", o.ID.String()).Str("receipt_id", rc.ID.String()).Msg("no se pudo encolar el aviso del comprobante")
+		}
+	}
+	return rc, nil
+}
+
+// Pending devuelve las órdenes por transferencia y cripto que esperan pago, con sus comprobantes y saldo.
+func (uc *ReceiptUC) Pending(ctx context.Context) ([]domain.ReceiptReview, error) {
+	now := time.Now()
+	var out []domain.ReceiptReview
+	for _, method := range []string{"transferencia", "cripto"} {
+		orders, err := uc.Orders.ListAwaitingPayment(ctx, method, now.Add(-receiptReviewLookback), now)
+		if err != nil {
+			return nil, err
+		}
+		for i := range orders {
+			o := &orders[i]
+			rv := domain.ReceiptReview{Order: *o}
+			if rv.Receipts, err = uc.Receipts.ListByOrder(ctx, o.ID); err != nil {
+				return nil, err
+			}
+			if uc.Payments != nil {
+				if rv.Balance, _, err = uc.Payments.Balance(ctx, o); err != nil {
+					return nil, err
+				}
+			}
+			out = append(out, rv)
+		}
+	}
+	return out, nil
+}
+
+// Approve acepta el comprobante y registra el cobro en el ledger (amount <= 0 = saldo pendiente, o la seña si es una preventa),
+// con la misma lógica y notificaciones que la confirmación manual. Devuelve true si la orden quedó paga.
+func (uc *ReceiptUC) Approve(ctx context.Context, receiptID uuid.UUID, amount float64, actor string) (bool, error) {
+	rc, o, err := uc.load(ctx, receiptID)
+	if err != nil {
+		return false, err
+	}
+	if amount <= 0 {
+		bal, _, err := uc.Payments.Balance(ctx, o)
+		if err != nil {
+			return false, err
+		}
+		if bal.Settled() {
+			return false, errors.New("la orden ya está paga")
+		}
+		amount = bal.Balance
+		if due := o.AmountDue(); due < amount {
+			amount = due
+		}
+	}
+	// El id del comprobante como id externo evita registrar dos veces el mismo cobro.
+	paid, err := uc.Payments.RecordPayment(ctx, o, &domain.Payment{
+		Method:     o.PaymentMethod,
+		Amount:     amount,
+		ExternalID: "receipt:" + rc.ID.String(),
+		Status:     domain.PaymentStatusApproved,
+		Note:       "comprobante " + rc.FileName,
+		Actor:      actor,
+	})
+	if err != nil {
+		return false, err
+	}
+	uc.review(ctx, rc, domain.ReceiptStatusApproved, "", actor)
+	return paid, nil
+}
+
+// Reject marca el comprobante como rechazado y avisa al comprador con el motivo.
+func (uc *ReceiptUC) Reject(ctx context.Context, receiptID uuid.UUID, reason, actor string) error {
+	rc, o, err := uc.load(ctx, receiptID)
+	if err != nil {
+		return err
+	}
+	uc.review(ctx, rc, domain.ReceiptStatusRejected, reason, actor)
+	if uc.Email != nil {
+		go func() {
+			if err := uc.Email.SendReceiptRejected(context.Background(), o, reason); err != nil {
+				log.Error().Err(err).Str("order_id", o.ID.String()).Msg("error enviando aviso de comprobante rechazado")
+			}
+		}()
+	}
+	return nil
+}
+
+// Open devuelve el comprobante y su contenido para mostrarlo en el admin.
+func (uc *ReceiptUC) Open(ctx context.Context, receiptID uuid.UUID) (*domain.PaymentReceipt, []byte, error) {
+	rc, err := uc.Receipts.FindByID(ctx, receiptID)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := uc.Storage.ReadReceipt(ctx, rc.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rc, data, nil
+}
+
+func (uc *ReceiptUC) load(ctx context.Context, receiptID uuid.UUID) (*domain.PaymentReceipt, *domain.Order, error) {
+	rc, err := uc.Receipts.FindByID(ctx, receiptID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rc.Status != domain.ReceiptStatusPending {
+		return nil, nil, fmt.Errorf("el comprobante ya fue revisado (%s)", rc.Status)
+	}
+	o, err := uc.Orders.FindByID(ctx, rc.OrderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rc, o, nil
+}
+
+func (uc *ReceiptUC) review(ctx context.Context, rc *domain.PaymentReceipt, status, note, actor string) {
+	now := time.Now()
+	rc.Status = status
+	rc.ReviewNote = note
+	rc.ReviewedBy = actor
+	rc.ReviewedAt = &now
+	if err := uc.Receipts.Save(ctx, rc); err != nil {
+		log.Error().Err(err).Str("receipt_id", rc.ID.String()).Msg("no se pudo guardar la revisión del comprobante")
+	}
+}
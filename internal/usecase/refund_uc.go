@@ -0,0 +1,300 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type RefundUC struct {
+	Orders   domain.OrderRepo
+	Refunds  domain.RefundRepo
+	Payments domain.PaymentRepo
+	Products domain.ProductRepo
+	Gateway  domain.PaymentGateway
+	Email    domain.EmailService
+	// Transitions aplica y registra los cambios de estado de las órdenes.
+	Transitions *OrderUC
+	// Webhooks publica stock.changed al reponer stock.
+	Webhooks *WebhookUC
+}
+
+// Refund devuelve (total o parcialmente) los items indicados de una orden pagada: reembolsa vía gateway
+// si fue pagada por MP, repone el stock que se había descontado por lo devuelto, registra el Refund,
+// actualiza el estado de la orden y avisa al comprador. El reembolso se guarda como pendiente antes de
+// pedirlo al gateway; si el pedido falla queda para Retry, que usa la misma clave de idempotencia.
+func (uc *RefundUC) Refund(ctx context.Context, orderID uuid.UUID, req []domain.RefundLineRequest, reason, actor string) (*domain.Refund, error) {
+	o, err := uc.Orders.FindByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	switch o.Status {
+	case domain.OrderStatusFinished, domain.OrderStatusInPrint, domain.OrderStatusShipped, domain.OrderStatusPartRefunded:
+	default:
+		return nil, fmt.Errorf("la orden no admite reembolsos en estado %s", o.Status)
+	}
+	if o.PaymentMethod == "mercadopago" {
+		if o.MPPaymentID == "" {
+			return nil, errors.New("la orden de MercadoPago no tiene el id del pago a reembolsar")
+		}
+		if uc.Gateway == nil {
+			return nil, errors.New("no hay gateway de pagos configurado para reembolsar")
+		}
+	}
+
+	prev, err := uc.Refunds.ListByOrder(ctx, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	refundedQty := map[uuid.UUID]int{}
+	refundedAmt := map[uuid.UUID]float64{}
+	refundedTotal := 0.0
+	for _, rf := range prev {
+		if rf.Status == domain.RefundStatusPending {
+			return nil, fmt.Errorf("hay un reembolso pendiente (%s): reintentalo o descartalo antes de pedir otro", rf.ID.String()[:8])
+		}
+		if !rf.Active() {
+			continue
+		}
+		refundedTotal += rf.Amount
+		for _, l := range rf.Lines {
+			refundedQty[l.OrderItemID] += l.Qty
+			refundedAmt[l.OrderItemID] += l.Amount
+		}
+	}
+
+	items := map[uuid.UUID]domain.OrderItem{}
+	for _, it := range o.Items {
+		items[it.ID] = it
+	}
+	rf := &domain.Refund{ID: uuid.New(), OrderID: o.ID, Reason: reason, Actor: actor, Status: domain.RefundStatusPending}
+	for _, lr := range req {
+		if lr.Qty == 0 && lr.Amount <= 0 {
+			continue
+		}
+		it, ok := items[lr.OrderItemID]
+		if !ok {
+			return nil, errors.New("item inexistente en la orden")
+		}
+		if lr.Qty < 0 || lr.Amount < 0 {
+			return nil, errors.New("cantidades y montos deben ser positivos")
+		}
+		if lr.Qty > it.Qty-refundedQty[it.ID] {
+			return nil, fmt.Errorf("%s: se pidieron %d unidades y quedan %d sin reembolsar", it.Title, lr.Qty, it.Qty-refundedQty[it.ID])
+		}
+		amount := lr.Amount
+		if amount <= 0 {
+			amount = it.UnitPrice * float64(lr.Qty)
+		}
+		if amount > it.UnitPrice*float64(it.Qty)-refundedAmt[it.ID]+0.01 {
+			return nil, fmt.Errorf("%s: el monto supera lo cobrado por el item", it.Title)
+		}
+		rf.Lines = append(rf.Lines, domain.RefundLine{OrderItemID: it.ID, VariantID: it.VariantID, Title: it.Title, Qty: lr.Qty, Amount: amount, SkipRestock: lr.SkipRestock})
+		rf.Amount += amount
+	}
+	if len(rf.Lines) == 0 || rf.Amount <= 0 {
+		return nil, errors.New("no hay nada para reembolsar")
+	}
+	if refundedTotal+rf.Amount > o.Total+0.01 {
+		return nil, fmt.Errorf("el reembolso supera el total de la orden (ya reembolsado $%.2f)", refundedTotal)
+	}
+
+	if err := uc.Refunds.Save(ctx, rf); err != nil {
+		return nil, err
+	}
+	return uc.settle(ctx, o, rf, actor)
+}
+
+// Retry vuelve a pedir al gateway un reembolso que quedó pendiente, con la misma clave de idempotencia.
+func (uc *RefundUC) Retry(ctx context.Context, refundID uuid.UUID, actor string) (*domain.Refund, error) {
+	rf, err := uc.Refunds.FindByID(ctx, refundID)
+	if err != nil {
+		return nil, err
+	}
+	if rf.Status != domain.RefundStatusPending {
+		return nil, fmt.Errorf("el reembolso está en estado %s", rf.Status)
+	}
+	o, err := uc.Orders.FindByID(ctx, rf.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	return uc.settle(ctx, o, rf, actor)
+}
+
+// Discard descarta un reembolso pendiente que el gateway no llegó a procesar (hay que verificarlo en el
+// panel del gateway antes), liberando lo que tenía reservado.
+func (uc *RefundUC) Discard(ctx context.Context, refundID uuid.UUID, actor string) error {
+	rf, err := uc.Refunds.FindByID(ctx, refundID)
+	if err != nil {
+		return err
+	}
+	if rf.Status != domain.RefundStatusPending {
+		return fmt.Errorf("el reembolso está en estado %s", rf.Status)
+	}
+	rf.Status = domain.RefundStatusDiscarded
+	if err := uc.Refunds.Update(ctx, rf); err != nil {
+		return err
+	}
+	log.Info().Str("order_id", rf.OrderID.String()).Str("refund_id", rf.ID.String()).Str("actor", actor).Msg("reembolso pendiente descartado")
+	return nil
+}
+
+// settle ejecuta un reembolso pendiente: lo pide al gateway (o lo marca manual), repone stock, lo registra
+// en el ledger y actualiza el estado de la orden. Si falla a mitad de camino el reembolso sigue pendiente
+// y se puede reintentar: el gateway deduplica por la clave y el stock sólo se repone una vez por unidad.
+func (uc *RefundUC) settle(ctx context.Context, o *domain.Order, rf *domain.Refund, actor string) (*domain.Refund, error) {
+	if o.PaymentMethod == "mercadopago" {
+		if o.MPPaymentID == "" || uc.Gateway == nil {
+			return nil, errors.New("la orden de MercadoPago no se puede reembolsar por el gateway")
+		}
+		extID, err := uc.Gateway.Refund(ctx, o.MPPaymentID, rf.Amount, rf.IdempotencyKey())
+		if err != nil {
+			return nil, fmt.Errorf("el gateway rechazó el reembolso (queda pendiente para reintentar): %w", err)
+		}
+		rf.PaymentID = o.MPPaymentID
+		rf.ExternalID = extID
+		rf.Status = domain.RefundStatusApproved
+	} else {
+		// Transferencia / cripto / efectivo: el dinero se devuelve por fuera del sistema.
+		rf.Status = domain.RefundStatusManual
+	}
+
+	uc.restock(ctx, o, rf)
+
+	if err := uc.Refunds.Update(ctx, rf); err != nil {
+		return rf, fmt.Errorf("reembolso hecho pero no se pudo registrar: %w", err)
+	}
+	if uc.Payments != nil {
+		now := time.Now()
+		if err := uc.Payments.Save(ctx, &domain.Payment{
+			OrderID:    o.ID,
+			Method:     o.PaymentMethod,
+			Amount:     -rf.Amount,
+			Currency:   "ARS",
+			ExternalID: rf.ExternalID,
+			Status:     domain.PaymentStatusRefunded,
+			Note:       "reembolso " + rf.ID.String()[:8],
+			Actor:      actor,
+			ApprovedAt: &now,
+		}); err != nil {
+			log.Error().Err(err).Str("order_id", o.ID.String()).Msg("reembolso: no se pudo registrar en el ledger")
+		}
+	}
+
+	all, err := uc.Refunds.ListByOrder(ctx, o.ID)
+	if err != nil {
+		return rf, err
+	}
+	refundedTotal := 0.0
+	for _, prev := range all {
+		if prev.Settled() {
+			refundedTotal += prev.Amount
+		}
+	}
+	st := domain.OrderStatusPartRefunded
+	if refundedTotal >= o.Total-0.01 {
+		st = domain.OrderStatusRefunded
+	}
+	ch := domain.StatusChange{Actor: actor, Source: domain.StatusSourceRefund, Note: fmt.Sprintf("reembolso %s por $%.2f", rf.ID.String()[:8], rf.Amount)}
+	if err := uc.Transitions.UpdateStatus(ctx, o, st, ch); err != nil {
+		return rf, err
+	}
+
+	if uc.Email != nil {
+		go func() {
+			if err := uc.Email.SendRefundNotice(context.Background(), o, rf); err != nil {
+				log.Error().Err(err).Str("order_id", o.ID.String()).Msg("error enviando aviso de reembolso")
+			}
+		}()
+	}
+	return rf, nil
+}
+
+// restock repone el stock de las líneas devueltas, sólo por las unidades que se habían descontado del
+// stock (OrderItem.StockTaken: al quedar paga la orden o al asignar la preventa); las órdenes cobradas
+// antes de que se descontara el stock al vender no reponen nada, porque esas unidades nunca salieron.
+func (uc *RefundUC) restock(ctx context.Context, o *domain.Order, rf *domain.Refund) {
+	if uc.Products == nil {
+		return
+	}
+	for i := range rf.Lines {
+		l := &rf.Lines[i]
+		if l.VariantID == nil || l.Qty <= 0 || l.SkipRestock || l.Restocked {
+			continue
+		}
+		it := orderItem(o, l.OrderItemID)
+		if it == nil {
+			continue
+		}
+		if n := releaseItemStock(ctx, uc.Orders, uc.Products, uc.Webhooks, it, l.Qty); n > 0 {
+			l.Restocked = true
+		}
+	}
+}
+
+func (uc *RefundUC) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.Refund, error) {
+	return uc.Refunds.ListByOrder(ctx, orderID)
+}
+
+// orderItem busca un item de la orden por id.
+func orderItem(o *domain.Order, id uuid.UUID) *domain.OrderItem {
+	for i := range o.Items {
+		if o.Items[i].ID == id {
+			return &o.Items[i]
+		}
+	}
+	return nil
+}
+
+// takeItemStock descuenta del stock las unidades del item que todavía no se habían tomado. Primero guarda
+// el item como tomado, así un reintento no descuenta dos veces. Devuelve cuántas unidades se descontaron.
+func takeItemStock(ctx context.Context, orders domain.OrderRepo, products domain.ProductRepo, webhooks *WebhookUC, it *domain.OrderItem) int {
+	if it.VariantID == nil {
+		return 0
+	}
+	prev := it.StockTaken
+	n := it.TakeStock()
+	if n == 0 {
+		return 0
+	}
+	if err := orders.SaveItem(ctx, it); err != nil {
+		it.StockTaken = prev
+		log.Error().Err(err).Str("order_item_id", it.ID.String()).Msg("no se pudo registrar el stock tomado del item")
+		return 0
+	}
+	if err := products.UpdateVariantStock(ctx, *it.VariantID, -n); err != nil {
+		log.Error().Err(err).Str("variant_id", it.VariantID.String()).Msg("no se pudo descontar stock")
+		return 0
+	}
+	webhooks.StockChanged(ctx, *it.VariantID, -n)
+	return n
+}
+
+// releaseItemStock repone hasta qty unidades del item que se habían descontado del stock. Primero guarda
+// lo que queda tomado, así un reintento no repone dos veces. Devuelve cuántas unidades volvieron al stock.
+func releaseItemStock(ctx context.Context, orders domain.OrderRepo, products domain.ProductRepo, webhooks *WebhookUC, it *domain.OrderItem, qty int) int {
+	if it.VariantID == nil {
+		return 0
+	}
+	n := it.ReleaseStock(qty)
+	if n == 0 {
+		return 0
+	}
+	if err := orders.SaveItem(ctx, it); err != nil {
+		it.StockTaken += n
+		log.Error().Err(err).Str("order_item_id", it.ID.String()).Msg("no se pudo registrar el stock liberado del item")
+		return 0
+	}
+	if err := products.UpdateVariantStock(ctx, *it.VariantID, n); err != nil {
+		log.Error().Err(err).Str("variant_id", it.VariantID.String()).Msg("no se pudo reponer stock")
+		return 0
+	}
+	webhooks.StockChanged(ctx, *it.VariantID, n)
+	return n
+}
@@ -0,0 +1,219 @@
+package usecase
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// refundOrders guarda una sola orden en memoria; sólo implementa lo que usa RefundUC.
+type refundOrders struct {
+	domain.OrderRepo
+	o *domain.Order
+}
+
+func (r *refundOrders) FindByID(ctx context.Context, id uuid.UUID) (*domain.Order, error) {
+	o := *r.o
+	o.Items = append([]domain.OrderItem(nil), r.o.Items...)
+	return &o, nil
+}
+
+func (r *refundOrders) SaveItem(ctx context.Context, it *domain.OrderItem) error {
+	for i := range r.o.Items {
+		if r.o.Items[i].ID == it.ID {
+			r.o.Items[i] = *it
+		}
+	}
+	return nil
+}
+
+func (r *refundOrders) UpdateStatus(ctx context.Context, o *domain.Order, st domain.OrderStatus) error {
+	if r.o.Status != o.Status {
+		return domain.ErrStatusConflict
+	}
+	r.o.Status = st
+	return nil
+}
+
+type memRefunds struct {
+	domain.RefundRepo
+	list []domain.Refund
+}
+
+func (r *memRefunds) Save(ctx context.Context, rf *domain.Refund) error {
+	r.list = append(r.list, *rf)
+	return nil
+}
+
+func (r *memRefunds) Update(ctx context.Context, rf *domain.Refund) error {
+	for i := range r.list {
+		if r.list[i].ID == rf.ID {
+			r.list[i] = *rf
+		}
+	}
+	return nil
+}
+
+func (r *memRefunds) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.Refund, error) {
+	return append([]domain.Refund(nil), r.list...), nil
+}
+
+type stockProducts struct {
+	domain.ProductRepo
+	stock map[uuid.UUID]int
+}
+
+func (p *stockProducts) UpdateVariantStock(ctx context.Context, variantID uuid.UUID, delta int) error {
+	p.stock[variantID] += delta
+	return nil
+}
+
+func TestRefundPartialAmounts(t *testing.T) {
+	variant := uuid.New()
+	itemA, itemB := uuid.New(), uuid.New()
+	// Orden por transferencia: 2 × $300 (con variante y stock descontado) + 1 × $400, con $100 de descuento.
+	newOrder := func() *domain.Order {
+		return &domain.Order{
+			ID:            uuid.New(),
+			Status:        domain.OrderStatusFinished,
+			PaymentMethod: "transferencia",
+			Total:         900,
+			Items: []domain.OrderItem{
+				{ID: itemA, VariantID: &variant, Title: "Maceta", Qty: 2, UnitPrice: 300, StockTaken: 2},
+				{ID: itemB, Title: "Lámpara", Qty: 1, UnitPrice: 400},
+			},
+		}
+	}
+	settled := func(amount float64, lines ...domain.RefundLine) domain.Refund {
+		return domain.Refund{ID: uuid.New(), Status: domain.RefundStatusManual, Amount: amount, Lines: lines}
+	}
+
+	cases := []struct {
+		name      string
+		prev      []domain.Refund
+		req       []domain.RefundLineRequest
+		wantErr   string
+		amount    float64
+		status    domain.OrderStatus
+		restocked int
+	}{
+		{
+			name:      "una unidad con monto automático",
+			req:       []domain.RefundLineRequest{{OrderItemID: itemA, Qty: 1}},
+			amount:    300,
+			status:    domain.OrderStatusPartRefunded,
+			restocked: 1,
+		},
+		{
+			name:   "sólo dinero, sin unidades ni reposición",
+			req:    []domain.RefundLineRequest{{OrderItemID: itemB, Amount: 150}},
+			amount: 150,
+			status: domain.OrderStatusPartRefunded,
+		},
+		{
+			name:   "unidad devuelta sin reponer stock",
+			req:    []domain.RefundLineRequest{{OrderItemID: itemA, Qty: 1, Amount: 250, SkipRestock: true}},
+			amount: 250,
+			status: domain.OrderStatusPartRefunded,
+		},
+		{
+			name:   "completar el total deja la orden reembolsada",
+			prev:   []domain.Refund{settled(500, domain.RefundLine{OrderItemID: itemA, Qty: 2, Amount: 500})},
+			req:    []domain.RefundLineRequest{{OrderItemID: itemB, Amount: 400}},
+			amount: 400,
+			status: domain.OrderStatusRefunded,
+		},
+		{
+			name:    "monto mayor a lo cobrado por el item",
+			req:     []domain.RefundLineRequest{{OrderItemID: itemB, Amount: 400.5}},
+			wantErr: "supera lo cobrado",
+		},
+		{
+			name:    "monto que sumado a reembolsos previos supera el item",
+			prev:    []domain.Refund{settled(300, domain.RefundLine{OrderItemID: itemB, Amount: 300})},
+			req:     []domain.RefundLineRequest{{OrderItemID: itemB, Amount: 150}},
+			wantErr: "supera lo cobrado",
+		},
+		{
+			name:    "más unidades de las que quedan sin reembolsar",
+			prev:    []domain.Refund{settled(300, domain.RefundLine{OrderItemID: itemA, Qty: 1, Amount: 300})},
+			req:     []domain.RefundLineRequest{{OrderItemID: itemA, Qty: 2}},
+			wantErr: "quedan 1 sin reembolsar",
+		},
+		{
+			name:    "descuento: los items no pueden superar el total de la orden",
+			prev:    []domain.Refund{settled(600, domain.RefundLine{OrderItemID: itemA, Qty: 2, Amount: 600})},
+			req:     []domain.RefundLineRequest{{OrderItemID: itemB, Qty: 1}},
+			wantErr: "supera el total de la orden",
+		},
+		{
+			name:   "los reembolsos descartados no cuentan",
+			prev:   []domain.Refund{{ID: uuid.New(), Status: domain.RefundStatusDiscarded, Amount: 600, Lines: []domain.RefundLine{{OrderItemID: itemA, Qty: 2, Amount: 600}}}},
+			req:    []domain.RefundLineRequest{{OrderItemID: itemA, Qty: 2}},
+			amount: 600,
+			status: domain.OrderStatusPartRefunded,
+			// El descartado no llegó a reponer: las dos unidades vuelven ahora.
+			restocked: 2,
+		},
+		{
+			name:    "con un reembolso pendiente no se pide otro",
+			prev:    []domain.Refund{{ID: uuid.New(), Status: domain.RefundStatusPending, Amount: 100}},
+			req:     []domain.RefundLineRequest{{OrderItemID: itemB, Amount: 50}},
+			wantErr: "reembolso pendiente",
+		},
+		{
+			name:    "montos negativos",
+			req:     []domain.RefundLineRequest{{OrderItemID: itemB, Qty: -1}},
+			wantErr: "deben ser positivos",
+		},
+		{
+			name:    "nada para reembolsar",
+			req:     []domain.RefundLineRequest{{OrderItemID: itemA}},
+			wantErr: "no hay nada",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			o := newOrder()
+			orders := &refundOrders{o: o}
+			refunds := &memRefunds{list: c.prev}
+			products := &stockProducts{stock: map[uuid.UUID]int{}}
+			uc := &RefundUC{Orders: orders, Refunds: refunds, Products: products, Transitions: &OrderUC{Orders: orders}}
+
+			rf, err := uc.Refund(ctx, o.ID, c.req, "prueba", "admin")
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("error = %v, quería uno con %q", err, c.wantErr)
+				}
+				if len(refunds.list) != len(c.prev) {
+					t.Errorf("se guardó un reembolso rechazado")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error inesperado: %v", err)
+			}
+			if math.Abs(rf.Amount-c.amount) > 1e-9 {
+				t.Errorf("monto = %.2f, quería %.2f", rf.Amount, c.amount)
+			}
+			if rf.Status != domain.RefundStatusManual {
+				t.Errorf("estado del reembolso = %s, quería manual", rf.Status)
+			}
+			if o.Status != c.status {
+				t.Errorf("estado de la orden = %s, quería %s", o.Status, c.status)
+			}
+			if got := products.stock[variant]; got != c.restocked {
+				t.Errorf("stock repuesto = %d, quería %d", got, c.restocked)
+			}
+			if got := o.Items[0].StockTaken; got != 2-c.restocked {
+				t.Errorf("StockTaken = %d, quería %d", got, 2-c.restocked)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// RepairPaymentMethods son los medios con los que se puede cobrar una reparación.
+var RepairPaymentMethods = []string{"efectivo", "transferencia", "mercadopago", "cripto"}
+
+type RepairUC struct {
+	Tickets  domain.RepairRepo
+	Products domain.ProductRepo
+	Orders   domain.OrderRepo
+	Email    domain.EmailService
+	// Transitions registra el alta de las órdenes de cobro.
+	Transitions *OrderUC
+	// Payments y Crypto preparan el cobro por MercadoPago y cripto igual que en el checkout.
+	Payments *PaymentUC
+	Crypto   *CryptoUC
+	// Webhooks publica stock.changed al usar o devolver repuestos.
+	Webhooks *WebhookUC
+}
+
+// Create da de alta el equipo recibido en el taller.
+func (uc *RepairUC) Create(ctx context.Context, t *domain.RepairTicket, actor string) error {
+	t.Name = strings.TrimSpace(t.Name)
+	t.Email = strings.ToLower(strings.TrimSpace(t.Email))
+	t.Phone = strings.TrimSpace(t.Phone)
+	t.Brand = strings.TrimSpace(t.Brand)
+	t.Model = strings.TrimSpace(t.Model)
+	t.IMEI = domain.OnlyDigits(t.IMEI)
+	t.Fault = strings.TrimSpace(t.Fault)
+	switch {
+	case t.Name == "":
+		return errors.New("el nombre del cliente es obligatorio")
+	case t.Email == "" && t.Phone == "":
+		return errors.New("indicá un email o un teléfono de contacto")
+	case t.Brand == "" || t.Model == "":
+		return errors.New("indicá marca y modelo del equipo")
+	case t.Fault == "":
+		return errors.New("describí la falla reportada")
+	case t.IMEI != "" && !domain.ValidIMEI(t.IMEI):
+		return errors.New("el IMEI no es válido (15 dígitos)")
+	}
+	t.ID = uuid.New()
+	t.Status = domain.RepairStatusReceived
+	if err := uc.Tickets.Save(ctx, t); err != nil {
+		return err
+	}
+	uc.event(ctx, t.ID, "", t.Status, actor, "equipo recibido")
+	uc.notify(t)
+	return nil
+}
+
+// SetDiagnostic guarda el diagnóstico y el presupuesto. Sólo se puede cambiar antes de que el cliente
+// lo apruebe.
+func (uc *RepairUC) SetDiagnostic(ctx context.Context, id uuid.UUID, diagnostic string, budget float64, actor string) (*domain.RepairTicket, error) {
+	t, err := uc.Tickets.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch t.Status {
+	case domain.RepairStatusReceived, domain.RepairStatusDiagnosing, domain.RepairStatusAwaitingApproval:
+	default:
+		return nil, errors.New("el presupuesto ya no se puede modificar")
+	}
+	if budget < 0 {
+		return nil, errors.New("presupuesto inválido")
+	}
+	t.Diagnostic = strings.TrimSpace(diagnostic)
+	t.EstimatedBudget = budget
+	if err := uc.Tickets.Save(ctx, t); err != nil {
+		return nil, err
+	}
+	uc.event(ctx, t.ID, "", "", actor, fmt.Sprintf("diagnóstico: %s · presupuesto $%.2f", t.Diagnostic, budget))
+	return t, nil
+}
+
+// Advance cambia el estado de la orden de servicio validando las condiciones de cada paso: para pedir
+// aprobación hace falta diagnóstico y presupuesto, y para entregar tiene que estar cobrada.
+func (uc *RepairUC) Advance(ctx context.Context, id uuid.UUID, to, note, actor string) (*domain.RepairTicket, error) {
+	t, err := uc.Tickets.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.ValidateTransition(to); err != nil {
+		return nil, err
+	}
+	if t.Status == domain.RepairStatusAwaitingApproval {
+		// La salida de este estado es la respuesta del cliente: Approve o Reject.
+		return nil, errors.New("el presupuesto todavía no fue respondido")
+	}
+	now := time.Now()
+	switch to {
+	case domain.RepairStatusAwaitingApproval:
+		if t.Diagnostic == "" || t.EstimatedBudget <= 0 {
+			return nil, errors.New("cargá el diagnóstico y el presupuesto antes de pedir la aprobación")
+		}
+	case domain.RepairStatusDelivered:
+		paid, err := uc.Paid(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		if !paid {
+			return nil, errors.New("la reparación todavía no está cobrada")
+		}
+		t.DeliveredAt = &now
+	}
+	return t, uc.transition(ctx, t, to, actor, note)
+}
+
+// Approve registra que el cliente aceptó el presupuesto y pasa el equipo a reparación.
+func (uc *RepairUC) Approve(ctx context.Context, id uuid.UUID, actor string) (*domain.RepairTicket, error) {
+	t, err := uc.Tickets.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.Status != domain.RepairStatusAwaitingApproval {
+		return nil, errors.New("no hay un presupuesto esperando aprobación")
+	}
+	now := time.Now()
+	t.ApprovedBudget = t.EstimatedBudget
+	t.ApprovedAt = &now
+	t.BudgetRejected = false
+	return t, uc.transition(ctx, t, domain.RepairStatusRepairing, actor, fmt.Sprintf("presupuesto aprobado: $%.2f", t.ApprovedBudget))
+}
+
+// Reject registra que el cliente rechazó el presupuesto: el equipo queda listo para retirar sin reparar.
+func (uc *RepairUC) Reject(ctx context.Context, id uuid.UUID, actor string) (*domain.RepairTicket, error) {
+	t, err := uc.Tickets.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.Status != domain.RepairStatusAwaitingApproval {
+		return nil, errors.New("no hay un presupuesto esperando aprobación")
+	}
+	t.BudgetRejected = true
+	return t, uc.transition(ctx, t, domain.RepairStatusReady, actor, "presupuesto rechazado, se devuelve sin reparar")
+}
+
+// AddPart descuenta del stock un repuesto (buscado por SKU o EAN de la variante) y lo registra en la orden.
+func (uc *RepairUC) AddPart(ctx context.Context, id uuid.UUID, code string, qty int, actor string) (*domain.RepairTicket, error) {
+	t, err := uc.Tickets.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !t.PartsEditable() {
+		return nil, errors.New("los repuestos se cargan durante el diagnóstico o la reparación")
+	}
+	code = strings.TrimSpace(code)
+	if code == "" || qty <= 0 {
+		return nil, errors.New("indicá SKU o EAN y la cantidad")
+	}
+	p, v, err := uc.Products.FindVariantBySKU(ctx, code)
+	if err != nil {
+		p, v, err = uc.Products.FindVariantByEAN(ctx, code)
+	}
+	if err != nil || v == nil {
+		return nil, errors.New("repuesto no encontrado")
+	}
+	if v.Stock < qty {
+		return nil, fmt.Errorf("stock insuficiente de %s: quedan %d", p.Name, v.Stock)
+	}
+	if err := uc.Products.UpdateVariantStock(ctx, v.ID, -qty); err != nil {
+		return nil, err
+	}
+	uc.Webhooks.StockChanged(ctx, v.ID, -qty)
+	cost := v.Cost
+	if cost <= 0 {
+		cost = v.Price
+	}
+	part := domain.RepairPart{TicketID: t.ID, ProductID: &p.ID, VariantID: v.ID, Title: p.Name, SKU: v.SKU, Qty: qty, UnitCost: cost, Actor: actor}
+	if err := uc.Tickets.SavePart(ctx, &part); err != nil {
+		// Devolver lo descontado: el repuesto no quedó registrado.
+		if rerr := uc.Products.UpdateVariantStock(ctx, v.ID, qty); rerr != nil {
+			log.Error().Err(rerr).Str("variant_id", v.ID.String()).Msg("servicio técnico: no se pudo reponer stock")
+		} else {
+			uc.Webhooks.StockChanged(ctx, v.ID, qty)
+		}
+		return nil, err
+	}
+	t.Parts = append(t.Parts, part)
+	uc.event(ctx, t.ID, "", "", actor, fmt.Sprintf("repuesto: %d x %s (%s)", qty, p.Name, v.SKU))
+	return t, nil
+}
+
+// RemovePart quita un repuesto cargado por error y lo devuelve al stock.
+func (uc *RepairUC) RemovePart(ctx context.Context, id, partID uuid.UUID, actor string) (*domain.RepairTicket, error) {
+	t, err := uc.Tickets.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !t.PartsEditable() {
+		return nil, errors.New("los repuestos ya no se pueden modificar")
+	}
+	idx := -1
+	for i := range t.Parts {
+		if t.Parts[i].ID == partID {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, domain.ErrNotFound
+	}
+	part := t.Parts[idx]
+	if err := uc.Tickets.DeletePart(ctx, t.ID, part.ID); err != nil {
+		return nil, err
+	}
+	if err := uc.Products.UpdateVariantStock(ctx, part.VariantID, part.Qty); err != nil {
+		log.Error().Err(err).Str("variant_id", part.VariantID.String()).Msg("servicio técnico: no se pudo reponer stock")
+	} else {
+		uc.Webhooks.StockChanged(ctx, part.VariantID, part.Qty)
+	}
+	t.Parts = append(t.Parts[:idx], t.Parts[idx+1:]...)
+	uc.event(ctx, t.ID, "", "", actor, fmt.Sprintf("repuesto quitado: %d x %s", part.Qty, part.Title))
+	return t, nil
+}
+
+// Chargeable indica si ya se puede cobrar la reparación: con el presupuesto aprobado y mientras el
+// equipo no se entregó.
+func (uc *RepairUC) Chargeable(t *domain.RepairTicket) bool {
+	if t.ApprovedBudget <= 0 {
+		return false
+	}
+	return t.Status == domain.RepairStatusRepairing || t.Status == domain.RepairStatusReady
+}
+
+// Charge genera (o reutiliza) la orden con la que se cobra la reparación por el medio de pago elegido.
+// Devuelve la orden y la URL a la que hay que mandar al cliente para pagar.
+func (uc *RepairUC) Charge(ctx context.Context, id uuid.UUID, method, actor string) (*domain.Order, string, error) {
+	t, err := uc.Tickets.FindByID(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if !uc.Chargeable(t) {
+		return nil, "", errors.New("la reparación todavía no se puede cobrar")
+	}
+	if !validRepairPaymentMethod(method) {
+		return nil, "", errors.New("medio de pago inválido")
+	}
+	if t.OrderID != nil {
+		o, err := uc.Orders.FindByID(ctx, *t.OrderID)
+		if err == nil && o.Status != domain.OrderStatusCancelled {
+			if o.PaymentMethod == method || o.PaymentStatus == "approved" {
+				return o, "/pay/" + o.ID.String(), nil
+			}
+			// Cambió el medio de pago: se anula la orden anterior y se genera otra.
+			ch := domain.StatusChange{Actor: actor, Source: domain.StatusSourceRepair, Note: "cambio de medio de pago de " + t.Number()}
+			if err := uc.Transitions.UpdateStatus(ctx, o, domain.OrderStatusCancelled, ch); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	o := &domain.Order{
+		ID:             uuid.New(),
+		Status:         domain.OrderStatusAwaitingPay,
+		Email:          t.Email,
+		Name:           t.Name,
+		Phone:          t.Phone,
+		DNI:            t.DNI,
+		CustomerID:     t.CustomerID,
+		ShippingMethod: "retiro",
+		PaymentMethod:  method,
+		DeliveryNotes:  "Servicio técnico " + t.Number(),
+		Items: []domain.OrderItem{{
+			ID:        uuid.New(),
+			Title:     "Reparación " + t.Device() + " (" + t.Number() + ")",
+			Qty:       1,
+			UnitPrice: t.ApprovedBudget,
+			VATRate:   21,
+		}},
+		// El presupuesto aprobado es el precio final: no aplica el descuento por medio de pago.
+		Total: t.ApprovedBudget,
+	}
+	switch method {
+	case "efectivo":
+		o.PaymentStatus = "efectivo_pending"
+	case "transferencia":
+		o.PaymentStatus = "transferencia_pending"
+	case "cripto":
+		o.PaymentStatus = "crypto_pending"
+	}
+	if err := uc.Orders.Save(ctx, o); err != nil {
+		return nil, "", err
+	}
+	uc.Transitions.RecordCreated(ctx, o, domain.StatusChange{Actor: actor, Source: domain.StatusSourceRepair, Note: "cobro de " + t.Number()})
+	t.OrderID = &o.ID
+	if err := uc.Tickets.Save(ctx, t); err != nil {
+		return nil, "", err
+	}
+	uc.event(ctx, t.ID, "", "", actor, fmt.Sprintf("cobro generado: orden %s por %s", o.Number(), method))
+
+	redirect := "/pay/" + o.ID.String() + "?status=pending"
+	switch method {
+	case "cripto":
+		if uc.Crypto != nil {
+			if err := uc.Crypto.Quote(ctx, o); err != nil {
+				log.Warn().Err(err).Str("order_id", o.ID.String()).Msg("servicio técnico: orden cripto sin cotización")
+			} else {
+				_ = uc.Orders.Save(ctx, o)
+			}
+		}
+	case "mercadopago":
+		if uc.Payments == nil {
+			return o, "/pay/" + o.ID.String(), nil
+		}
+		url, err := uc.Payments.CreatePreference(ctx, o)
+		if err != nil || url == "" {
+			log.Error().Err(err).Str("order_id", o.ID.String()).Msg("servicio técnico: no se pudo crear la preferencia de MP")
+			return o, "/pay/" + o.ID.String() + "?error=mp", nil
+		}
+		_ = uc.Orders.Save(ctx, o)
+		redirect = url
+	}
+	return o, redirect, nil
+}
+
+// Paid indica si la reparación está cobrada (o no tiene nada que cobrar).
+func (uc *RepairUC) Paid(ctx context.Context, t *domain.RepairTicket) (bool, error) {
+	if t.ApprovedBudget <= 0 {
+		return true, nil
+	}
+	if t.OrderID == nil {
+		return false, nil
+	}
+	o, err := uc.Orders.FindByID(ctx, *t.OrderID)
+	if err != nil {
+		return false, err
+	}
+	return o.PaymentStatus == "approved", nil
+}
+
+func (uc *RepairUC) FindByID(ctx context.Context, id uuid.UUID) (*domain.RepairTicket, error) {
+	return uc.Tickets.FindByID(ctx, id)
+}
+
+func (uc *RepairUC) List(ctx context.Context, f domain.RepairFilter) ([]domain.RepairTicket, int64, error) {
+	return uc.Tickets.List(ctx, f)
+}
+
+func (uc *RepairUC) History(ctx context.Context, id uuid.UUID) ([]domain.RepairEvent, error) {
+	return uc.Tickets.ListEvents(ctx, id)
+}
+
+// transition persiste el nuevo estado, lo registra en el historial y avisa al cliente.
+func (uc *RepairUC) transition(ctx context.Context, t *domain.RepairTicket, to, actor, note string) error {
+	from := t.Status
+	t.Status = to
+	if note = strings.TrimSpace(note); note != "" {
+		if t.StaffNote != "" {
+			t.StaffNote += "\n"
+		}
+		t.StaffNote += note
+	}
+	if err := uc.Tickets.Save(ctx, t); err != nil {
+		t.Status = from
+		return err
+	}
+	uc.event(ctx, t.ID, from, to, actor, note)
+	uc.notify(t)
+	return nil
+}
+
+func (uc *RepairUC) event(ctx context.Context, ticketID uuid.UUID, from, to, actor, note string) {
+	e := &domain.RepairEvent{TicketID: ticketID, From: from, To: to, Actor: actor, Note: note}
+	if err := uc.Tickets.SaveEvent(ctx, e); err != nil {
+		log.Error().Err(err).Str("ticket_id", ticketID.String()).Msg("servicio técnico: no se pudo registrar el historial")
+	}
+}
+
+func (uc *RepairUC) notify(t *domain.RepairTicket) {
+	if uc.Email == nil || t.Email == "" {
+		return
+	}
+	go func() {
+		if err := uc.Email.SendRepairUpdate(context.Background(), t); err != nil {
+			log.Error().Err(err).Str("ticket_id", t.ID.String()).Msg("error enviando aviso de servicio técnico")
+		}
+	}()
+}
+
+func validRepairPaymentMethod(m string) bool {
+	for _, v := range RepairPaymentMethods {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,419 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+type RMAUC struct {
+	Orders   domain.OrderRepo
+	RMAs     domain.RMARepo
+	Products domain.ProductRepo
+	Audit    domain.OrderAuditRepo
+	Email    domain.EmailService
+	// Refunds ejecuta el reembolso cuando la devolución se resuelve con dinero.
+	Refunds *RefundUC
+	// Transitions registra el alta de las órdenes de cambio.
+	Transitions *OrderUC
+	// Window es el plazo desde la compra para pedir una devolución; 0 no pone límite.
+	Window time.Duration
+	// Webhooks publica stock.changed al reingresar lo devuelto.
+	Webhooks *WebhookUC
+}
+
+// CanRequest indica si el cliente puede pedir una devolución de la orden.
+func (uc *RMAUC) CanRequest(o *domain.Order) error {
+	if o.PaymentStatus != "approved" {
+		return errors.New("la orden no está pagada")
+	}
+	switch o.Status {
+	case domain.OrderStatusFinished, domain.OrderStatusInPrint, domain.OrderStatusShipped, domain.OrderStatusPartRefunded:
+	default:
+		return fmt.Errorf("la orden no admite devoluciones en estado %s", o.Status)
+	}
+	if uc.Window > 0 && time.Since(o.CreatedAt) > uc.Window {
+		return fmt.Errorf("pasaron más de %d días desde la compra", int(uc.Window.Hours()/24))
+	}
+	return nil
+}
+
+// Returnable devuelve, por item de la orden, cuántas unidades quedan por devolver (descontando las que
+// ya están en devoluciones no rechazadas).
+func (uc *RMAUC) Returnable(ctx context.Context, o *domain.Order) (map[uuid.UUID]int, error) {
+	list, err := uc.RMAs.ListByOrder(ctx, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	left := map[uuid.UUID]int{}
+	for _, it := range o.Items {
+		left[it.ID] = it.Qty
+	}
+	for _, r := range list {
+		if r.Status == domain.RMAStatusRejected {
+			continue
+		}
+		for _, l := range r.Lines {
+			left[l.OrderItemID] -= l.Qty
+		}
+	}
+	return left, nil
+}
+
+// Request registra el pedido de devolución del cliente.
+func (uc *RMAUC) Request(ctx context.Context, o *domain.Order, req []domain.RMALineRequest, reason, condition, resolution, note string) (*domain.RMA, error) {
+	if err := uc.CanRequest(o); err != nil {
+		return nil, err
+	}
+	reason, condition = strings.TrimSpace(reason), strings.TrimSpace(condition)
+	if reason == "" {
+		return nil, errors.New("indicá el motivo de la devolución")
+	}
+	if !validResolution(resolution) {
+		return nil, errors.New("resolución inválida")
+	}
+	left, err := uc.Returnable(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	items := map[uuid.UUID]domain.OrderItem{}
+	for _, it := range o.Items {
+		items[it.ID] = it
+	}
+	r := &domain.RMA{
+		ID:                  uuid.New(),
+		OrderID:             o.ID,
+		Status:              domain.RMAStatusRequested,
+		Reason:              reason,
+		Condition:           condition,
+		RequestedResolution: resolution,
+		CustomerNote:        strings.TrimSpace(note),
+	}
+	for _, lr := range req {
+		if lr.Qty == 0 {
+			continue
+		}
+		it, ok := items[lr.OrderItemID]
+		if !ok {
+			return nil, errors.New("item inexistente en la orden")
+		}
+		if lr.Qty < 0 || lr.Qty > left[it.ID] {
+			return nil, fmt.Errorf("%s: se pueden devolver hasta %d unidades", it.Title, left[it.ID])
+		}
+		r.Lines = append(r.Lines, domain.RMALine{
+			ID:          uuid.New(),
+			RMAID:       r.ID,
+			OrderItemID: it.ID,
+			ProductID:   it.ProductID,
+			VariantID:   it.VariantID,
+			Title:       it.Title,
+			Color:       it.Color,
+			SKU:         it.SKU,
+			Qty:         lr.Qty,
+			UnitPrice:   it.UnitPrice,
+		})
+	}
+	if len(r.Lines) == 0 {
+		return nil, errors.New("elegí al menos un producto para devolver")
+	}
+	if err := uc.RMAs.Save(ctx, r); err != nil {
+		return nil, err
+	}
+	uc.audit(ctx, o.ID, o.Email, fmt.Sprintf("%s solicitada (%s, %s)", r.Number(), reason, domain.RMAResolutionLabel(resolution)))
+	uc.notify(o, r)
+	return r, nil
+}
+
+// Approve acepta la devolución; el cliente tiene que enviar o acercar el producto.
+func (uc *RMAUC) Approve(ctx context.Context, id uuid.UUID, note, actor string) (*domain.RMA, error) {
+	return uc.advance(ctx, id, domain.RMAStatusApproved, note, actor, nil)
+}
+
+// Reject rechaza la devolución (sólo antes de recibir el producto).
+func (uc *RMAUC) Reject(ctx context.Context, id uuid.UUID, note, actor string) (*domain.RMA, error) {
+	if strings.TrimSpace(note) == "" {
+		return nil, errors.New("indicá el motivo del rechazo")
+	}
+	return uc.advance(ctx, id, domain.RMAStatusRejected, note, actor, nil)
+}
+
+// Receive registra que el producto llegó al local.
+func (uc *RMAUC) Receive(ctx context.Context, id uuid.UUID, note, actor string) (*domain.RMA, error) {
+	return uc.advance(ctx, id, domain.RMAStatusReceived, note, actor, nil)
+}
+
+// Inspect registra el estado en que llegó el producto y si se puede volver a vender.
+func (uc *RMAUC) Inspect(ctx context.Context, id uuid.UUID, condition string, resellable bool, note, actor string) (*domain.RMA, error) {
+	condition = strings.TrimSpace(condition)
+	if condition == "" {
+		return nil, errors.New("indicá el estado del producto")
+	}
+	return uc.advance(ctx, id, domain.RMAStatusInspected, note, actor, func(_ *domain.Order, r *domain.RMA) (string, error) {
+		r.InspectedCondition = condition
+		r.Resellable = resellable
+		if resellable {
+			return condition + ", vuelve al stock", nil
+		}
+		return condition + ", no se puede revender", nil
+	})
+}
+
+// Resolve cierra la devolución: repone stock si lo devuelto es revendible y, según la resolución,
+// reembolsa las unidades devueltas o genera una orden de cambio sin cargo con los mismos productos.
+func (uc *RMAUC) Resolve(ctx context.Context, id uuid.UUID, resolution, note, actor string) (*domain.RMA, error) {
+	if !validResolution(resolution) {
+		return nil, errors.New("resolución inválida")
+	}
+	return uc.advance(ctx, id, domain.RMAStatusResolved, note, actor, func(o *domain.Order, r *domain.RMA) (string, error) {
+		r.Resolution = resolution
+		detail := domain.RMAResolutionLabel(resolution)
+		switch resolution {
+		case domain.RMAResolutionRefund:
+			req := make([]domain.RefundLineRequest, 0, len(r.Lines))
+			for _, l := range r.Lines {
+				// El stock lo repone la devolución según la inspección, no el reembolso.
+				req = append(req, domain.RefundLineRequest{OrderItemID: l.OrderItemID, Qty: l.Qty, SkipRestock: true})
+			}
+			rf, err := uc.Refunds.Refund(ctx, o.ID, req, "devolución "+r.Number(), actor)
+			if err != nil {
+				if rf == nil {
+					return "", err
+				}
+				log.Error().Err(err).Str("order_id", o.ID.String()).Msg("devolución: reembolso registrado sin cambiar el estado de la orden")
+			}
+			r.RefundID = &rf.ID
+			detail += fmt.Sprintf(" $%.2f", rf.Amount)
+		case domain.RMAResolutionExchange:
+			ex, err := uc.exchangeOrder(ctx, o, r, actor)
+			if err != nil {
+				return "", err
+			}
+			r.ExchangeOrderID = &ex.ID
+			detail += ", orden " + ex.Number()
+		}
+		if resolution != domain.RMAResolutionRepair && r.Resellable {
+			if n := uc.restock(ctx, o, r); n > 0 {
+				detail += fmt.Sprintf(", %d u. al stock", n)
+			} else {
+				detail += ", sin reposición (no se había descontado stock)"
+			}
+		}
+		return detail, nil
+	})
+}
+
+// advance valida y aplica el cambio de estado de la devolución. apply hace lo propio de cada paso y
+// devuelve el detalle que queda en la auditoría de la orden.
+func (uc *RMAUC) advance(ctx context.Context, id uuid.UUID, to, note, actor string, apply func(*domain.Order, *domain.RMA) (string, error)) (*domain.RMA, error) {
+	r, err := uc.RMAs.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.ValidateTransition(to); err != nil {
+		return nil, err
+	}
+	o, err := uc.Orders.FindByID(ctx, r.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	detail := ""
+	if apply != nil {
+		if detail, err = apply(o, r); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	switch to {
+	case domain.RMAStatusApproved:
+		r.ApprovedAt = &now
+	case domain.RMAStatusReceived:
+		r.ReceivedAt = &now
+	case domain.RMAStatusInspected:
+		r.InspectedAt = &now
+	case domain.RMAStatusResolved, domain.RMAStatusRejected:
+		r.ResolvedAt = &now
+	}
+	r.Status = to
+	if note = strings.TrimSpace(note); note != "" {
+		if r.StaffNote != "" {
+			r.StaffNote += "\n"
+		}
+		r.StaffNote += note
+	}
+	if err := uc.RMAs.Save(ctx, r); err != nil {
+		return nil, err
+	}
+	msg := r.Number() + " " + strings.ToLower(domain.RMAStatusLabel(to))
+	if detail != "" {
+		msg += ": " + detail
+	}
+	if note != "" {
+		msg += " · " + note
+	}
+	uc.audit(ctx, o.ID, actor, msg)
+	uc.notify(o, r)
+	return r, nil
+}
+
+// exchangeOrder crea una orden ya pagada con los mismos productos devueltos, bonificada al 100%, para
+// que siga el circuito normal de preparación y envío. Las unidades que se envían se descuentan del stock
+// (y quedan como tomadas en la orden de cambio).
+func (uc *RMAUC) exchangeOrder(ctx context.Context, o *domain.Order, r *domain.RMA, actor string) (*domain.Order, error) {
+	ex := &domain.Order{
+		ID:             uuid.New(),
+		Status:         domain.OrderStatusFinished,
+		Email:          o.Email,
+		Name:           o.Name,
+		Phone:          o.Phone,
+		DNI:            o.DNI,
+		Address:        o.Address,
+		PostalCode:     o.PostalCode,
+		Province:       o.Province,
+		DeliveryNotes:  "Cambio por " + r.Number() + " de la orden " + o.Number(),
+		PaymentStatus:  "approved",
+		CustomerID:     o.CustomerID,
+		ShippingMethod: o.ShippingMethod,
+		PaymentMethod:  "cambio",
+	}
+	for _, l := range r.Lines {
+		ex.Items = append(ex.Items, domain.OrderItem{
+			ID:        uuid.New(),
+			OrderID:   ex.ID,
+			ProductID: l.ProductID,
+			VariantID: l.VariantID,
+			Title:     l.Title,
+			Color:     l.Color,
+			SKU:       l.SKU,
+			Qty:       l.Qty,
+			UnitPrice: l.UnitPrice,
+		})
+	}
+	ex.DiscountAmount = ex.ItemsSubtotal()
+	ex.Total = 0
+	taken, err := uc.takeStock(ctx, ex)
+	if err != nil {
+		return nil, err
+	}
+	if err := uc.Orders.Save(ctx, ex); err != nil {
+		uc.returnStock(ctx, taken)
+		return nil, err
+	}
+	if uc.Transitions != nil {
+		uc.Transitions.RecordCreated(ctx, ex, domain.StatusChange{Actor: actor, Source: domain.StatusSourceRMA, Note: "cambio por " + r.Number()})
+	}
+	return ex, nil
+}
+
+// takeStock descuenta del stock las unidades de la orden de cambio y las marca como tomadas. Si alguna
+// variante no alcanza, devuelve lo ya descontado y falla.
+func (uc *RMAUC) takeStock(ctx context.Context, ex *domain.Order) (map[uuid.UUID]int, error) {
+	taken := map[uuid.UUID]int{}
+	if uc.Products == nil {
+		return taken, nil
+	}
+	for i := range ex.Items {
+		it := &ex.Items[i]
+		if it.VariantID == nil || it.Qty <= 0 {
+			continue
+		}
+		_, v, err := uc.Products.FindVariantByID(ctx, *it.VariantID)
+		if err == nil && v.Stock < it.Qty+taken[v.ID] {
+			err = fmt.Errorf("stock insuficiente de %s para el cambio: quedan %d", it.Title, v.Stock-taken[v.ID])
+		}
+		if err == nil {
+			err = uc.Products.UpdateVariantStock(ctx, *it.VariantID, -it.Qty)
+		}
+		if err != nil {
+			uc.returnStock(ctx, taken)
+			return nil, err
+		}
+		uc.Webhooks.StockChanged(ctx, *it.VariantID, -it.Qty)
+		taken[*it.VariantID] += it.Qty
+		it.StockTaken = it.Qty
+	}
+	return taken, nil
+}
+
+// returnStock repone lo descontado por takeStock cuando la orden de cambio no se pudo crear.
+func (uc *RMAUC) returnStock(ctx context.Context, taken map[uuid.UUID]int) {
+	for vid, qty := range taken {
+		if err := uc.Products.UpdateVariantStock(ctx, vid, qty); err != nil {
+			log.Error().Err(err).Str("variant_id", vid.String()).Msg("devolución: no se pudo reponer stock del cambio")
+			continue
+		}
+		uc.Webhooks.StockChanged(ctx, vid, qty)
+	}
+}
+
+// restock repone el stock de lo devuelto, sólo por las unidades que se habían descontado al vender
+// (OrderItem.StockTaken). Devuelve cuántas unidades volvieron al stock.
+func (uc *RMAUC) restock(ctx context.Context, o *domain.Order, r *domain.RMA) int {
+	if uc.Products == nil {
+		return 0
+	}
+	total := 0
+	for i := range r.Lines {
+		l := &r.Lines[i]
+		if l.VariantID == nil || l.Qty <= 0 || l.Restocked {
+			continue
+		}
+		it := orderItem(o, l.OrderItemID)
+		if it == nil {
+			continue
+		}
+		if n := releaseItemStock(ctx, uc.Orders, uc.Products, uc.Webhooks, it, l.Qty); n > 0 {
+			l.Restocked = true
+			total += n
+		}
+	}
+	return total
+}
+
+func (uc *RMAUC) FindByID(ctx context.Context, id uuid.UUID) (*domain.RMA, error) {
+	return uc.RMAs.FindByID(ctx, id)
+}
+
+func (uc *RMAUC) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.RMA, error) {
+	return uc.RMAs.ListByOrder(ctx, orderID)
+}
+
+func (uc *RMAUC) List(ctx context.Context, status string, page, pageSize int) ([]domain.RMA, int64, error) {
+	return uc.RMAs.List(ctx, status, page, pageSize)
+}
+
+func (uc *RMAUC) audit(ctx context.Context, orderID uuid.UUID, actor, detail string) {
+	if uc.Audit == nil {
+		return
+	}
+	e := &domain.OrderAuditEntry{OrderID: orderID, Actor: actor, Action: domain.OrderAuditRMA, Detail: detail, CreatedAt: time.Now()}
+	if err := uc.Audit.Save(ctx, e); err != nil {
+		log.Error().Err(err).Str("order_id", orderID.String()).Msg("no se pudo registrar la auditoría de la devolución")
+	}
+}
+
+func (uc *RMAUC) notify(o *domain.Order, r *domain.RMA) {
+	if uc.Email == nil || o.Email == "" {
+		return
+	}
+	go func() {
+		if err := uc.Email.SendRMAUpdate(context.Background(), o, r); err != nil {
+			log.Error().Err(err).Str("order_id", o.ID.String()).Msg("error enviando aviso de devolución")
+		}
+	}()
+}
+
+func validResolution(r string) bool {
+	for _, v := range domain.RMAResolutions {
+		if v == r {
+			return true
+		}
+	}
+	return false
+}
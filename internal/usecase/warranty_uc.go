@@ -0,0 +1,358 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// orderNumberRe reconoce el número corto de las órdenes sin correlativo (los primeros 8 caracteres del UUID).
+var orderNumberRe = regexp.MustCompile(`^[0-9a-f]{8}$`)
+
+type WarrantyUC struct {
+	Warranties domain.WarrantyRepo
+	Orders     domain.OrderRepo
+	Products   domain.ProductRepo
+	Payments   domain.PaymentRepo
+	Events     domain.OrderEventRepo
+	Audit      domain.OrderAuditRepo
+	// DefaultMonths es la garantía de las categorías sin política propia (0: sin garantía).
+	DefaultMonths int
+	Clock         domain.Clock
+}
+
+// PaidAt es la fecha desde la que corre la garantía: el primer pago aprobado de la orden, o si no hay
+// ledger, el paso a finished. Devuelve nil si la orden no está pagada.
+func (uc *WarrantyUC) PaidAt(ctx context.Context, o *domain.Order) (*time.Time, error) {
+	if o.PaymentStatus != "approved" {
+		return nil, nil
+	}
+	var paid *time.Time
+	if uc.Payments != nil {
+		pays, err := uc.Payments.ListByOrder(ctx, o.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pays {
+			if p.Status == domain.PaymentStatusApproved && p.ApprovedAt != nil && (paid == nil || p.ApprovedAt.Before(*paid)) {
+				t := *p.ApprovedAt
+				paid = &t
+			}
+		}
+	}
+	if paid == nil && uc.Events != nil {
+		evs, err := uc.Events.ListByOrder(ctx, o.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range evs {
+			if ev.To == domain.OrderStatusFinished {
+				t := ev.CreatedAt
+				paid = &t
+				break
+			}
+		}
+	}
+	if paid == nil {
+		t := o.UpdatedAt
+		paid = &t
+	}
+	return paid, nil
+}
+
+// Policy devuelve la duración y las condiciones de garantía de un item según la categoría del producto.
+func (uc *WarrantyUC) Policy(ctx context.Context, it domain.OrderItem) (category string, months int, terms string) {
+	months = uc.DefaultMonths
+	if it.ProductID == nil {
+		return "", months, ""
+	}
+	p, err := uc.Products.FindByID(ctx, *it.ProductID)
+	if err != nil {
+		return "", months, ""
+	}
+	pol, err := uc.Warranties.FindPolicy(ctx, p.Category)
+	if err != nil {
+		return p.Category, months, ""
+	}
+	return p.Category, pol.Months, pol.Terms
+}
+
+// Coverage calcula la cobertura de cada item de la orden, con las unidades registradas por IMEI.
+func (uc *WarrantyUC) Coverage(ctx context.Context, o *domain.Order) ([]domain.WarrantyCoverage, error) {
+	start, err := uc.PaidAt(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	units, err := uc.Warranties.ListByOrder(ctx, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.WarrantyCoverage, 0, len(o.Items))
+	for _, it := range o.Items {
+		_, months, terms := uc.Policy(ctx, it)
+		c := domain.WarrantyCoverage{OrderID: o.ID, Item: it, Months: months, Terms: terms, StartsAt: start}
+		for _, u := range units {
+			if u.OrderItemID == it.ID {
+				c.Units = append(c.Units, u)
+			}
+		}
+		// Lo registrado manda: guarda las condiciones con las que se vendió.
+		if len(c.Units) > 0 {
+			c.Months, c.Terms = c.Units[0].Months, c.Units[0].Terms
+		}
+		c.Status, c.EndsAt = domain.WarrantyStatusAt(start, c.Months, uc.now())
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// Lookup busca la cobertura por IMEI (15 dígitos) o por número de orden (8 caracteres o el UUID). No
+// acepta el número correlativo (NM-000123): la consulta es pública y se podría recorrer la numeración.
+func (uc *WarrantyUC) Lookup(ctx context.Context, q string) (*domain.Order, []domain.WarrantyCoverage, error) {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if q == "" {
+		return nil, nil, errors.New("ingresá un IMEI o un número de orden")
+	}
+	if imei := domain.OnlyDigits(q); len(imei) == 15 && len(imei) == len(strings.ReplaceAll(q, " ", "")) {
+		w, err := uc.Warranties.FindByIMEI(ctx, imei)
+		if err != nil {
+			return nil, nil, err
+		}
+		o, err := uc.Orders.FindByID(ctx, w.OrderID)
+		if err != nil {
+			return nil, nil, err
+		}
+		c := domain.WarrantyCoverage{OrderID: o.ID, Units: []domain.Warranty{*w}, Months: w.Months, Terms: w.Terms}
+		for _, it := range o.Items {
+			if it.ID == w.OrderItemID {
+				c.Item = it
+			}
+		}
+		start := w.StartsAt
+		c.StartsAt = &start
+		c.Status, c.EndsAt = domain.WarrantyStatusAt(&start, w.Months, uc.now())
+		return o, []domain.WarrantyCoverage{c}, nil
+	}
+	o, err := uc.findOrder(ctx, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	cov, err := uc.Coverage(ctx, o)
+	return o, cov, err
+}
+
+func (uc *WarrantyUC) findOrder(ctx context.Context, q string) (*domain.Order, error) {
+	if id, err := uuid.Parse(q); err == nil {
+		return uc.Orders.FindByID(ctx, id)
+	}
+	if !orderNumberRe.MatchString(q) {
+		return nil, domain.ErrNotFound
+	}
+	list, _, err := uc.Orders.List(ctx, domain.OrderFilter{IDPrefix: q, PageSize: 2})
+	if err != nil {
+		return nil, err
+	}
+	if len(list) != 1 {
+		return nil, domain.ErrNotFound
+	}
+	return uc.Orders.FindByID(ctx, list[0].ID)
+}
+
+// Register asocia el IMEI de una unidad vendida a su item, guardando la garantía vigente.
+func (uc *WarrantyUC) Register(ctx context.Context, o *domain.Order, itemID uuid.UUID, imei, actor string) (*domain.Warranty, error) {
+	imei = domain.OnlyDigits(imei)
+	if !domain.ValidIMEI(imei) {
+		return nil, errors.New("el IMEI no es válido (15 dígitos)")
+	}
+	start, err := uc.PaidAt(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	if start == nil {
+		return nil, errors.New("la orden no está pagada")
+	}
+	it, ok := findItem(o, itemID)
+	if !ok {
+		return nil, errors.New("item inválido")
+	}
+	if prev, err := uc.Warranties.FindByIMEI(ctx, imei); err == nil {
+		number := prev.OrderID.String()[:8]
+		if po, err := uc.Orders.FindByID(ctx, prev.OrderID); err == nil {
+			number = po.Number()
+		}
+		return nil, fmt.Errorf("el IMEI ya está registrado en la orden %s", number)
+	} else if !errors.Is(err, domain.ErrNotFound) {
+		return nil, err
+	}
+	units, err := uc.Warranties.ListByOrder(ctx, o.ID)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, u := range units {
+		if u.OrderItemID == it.ID {
+			n++
+		}
+	}
+	if n >= it.Qty {
+		return nil, errors.New("todas las unidades del item ya tienen IMEI")
+	}
+	category, months, terms := uc.Policy(ctx, it)
+	w := &domain.Warranty{
+		OrderID:     o.ID,
+		OrderItemID: it.ID,
+		IMEI:        imei,
+		Title:       it.Title,
+		Category:    category,
+		Months:      months,
+		Terms:       terms,
+		StartsAt:    *start,
+		EndsAt:      start.AddDate(0, months, 0),
+		Actor:       actor,
+	}
+	if err := uc.Warranties.Save(ctx, w); err != nil {
+		return nil, err
+	}
+	uc.audit(ctx, o.ID, actor, fmt.Sprintf("IMEI %s registrado para %s (%d meses de garantía)", imei, it.Title, months))
+	return w, nil
+}
+
+// OpenClaim abre un reclamo de garantía sobre un item de la orden. Se puede abrir aunque la garantía esté
+// vencida: queda registrado como fuera de garantía para que el staff decida.
+func (uc *WarrantyUC) OpenClaim(ctx context.Context, o *domain.Order, itemID uuid.UUID, imei, problem, actor string) (*domain.WarrantyClaim, error) {
+	problem = strings.TrimSpace(problem)
+	if problem == "" {
+		return nil, errors.New("describí la falla reclamada")
+	}
+	it, ok := findItem(o, itemID)
+	if !ok {
+		return nil, errors.New("item inválido")
+	}
+	cov, err := uc.Coverage(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	c := &domain.WarrantyClaim{OrderID: o.ID, OrderItemID: it.ID, Title: it.Title, Problem: problem, Status: domain.WarrantyClaimOpen, Actor: actor}
+	imei = domain.OnlyDigits(imei)
+	for _, cv := range cov {
+		if cv.Item.ID != it.ID {
+			continue
+		}
+		c.InWarranty = cv.Active()
+		for _, u := range cv.Units {
+			if u.IMEI == imei {
+				id := u.ID
+				c.WarrantyID, c.IMEI = &id, u.IMEI
+				c.InWarranty = uc.now().Before(u.EndsAt)
+			}
+		}
+	}
+	if imei != "" && c.WarrantyID == nil {
+		return nil, errors.New("el IMEI no corresponde a ninguna unidad registrada del item")
+	}
+	if err := uc.Warranties.SaveClaim(ctx, c); err != nil {
+		return nil, err
+	}
+	state := "en garantía"
+	if !c.InWarranty {
+		state = "fuera de garantía"
+	}
+	uc.audit(ctx, o.ID, actor, fmt.Sprintf("reclamo de garantía %s abierto para %s (%s)", c.Number(), it.Title, state))
+	return c, nil
+}
+
+// ResolveClaim cierra el reclamo con su resultado y lo que costó resolverlo.
+func (uc *WarrantyUC) ResolveClaim(ctx context.Context, id uuid.UUID, outcome string, cost float64, note, actor string) (*domain.WarrantyClaim, error) {
+	c, err := uc.Warranties.FindClaim(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Open() {
+		return nil, errors.New("el reclamo ya está cerrado")
+	}
+	valid := false
+	for _, o := range domain.WarrantyOutcomes {
+		valid = valid || o == outcome
+	}
+	if !valid {
+		return nil, errors.New("resultado inválido")
+	}
+	if cost < 0 {
+		return nil, errors.New("costo inválido")
+	}
+	now := uc.now()
+	c.Status, c.Outcome, c.Cost = domain.WarrantyClaimResolved, outcome, cost
+	c.Resolution = strings.TrimSpace(note)
+	c.ResolvedBy, c.ResolvedAt = actor, &now
+	if err := uc.Warranties.SaveClaim(ctx, c); err != nil {
+		return nil, err
+	}
+	uc.audit(ctx, c.OrderID, actor, fmt.Sprintf("reclamo de garantía %s cerrado: %s (costo $%.2f)", c.Number(), c.OutcomeLabel(), cost))
+	return c, nil
+}
+
+func (uc *WarrantyUC) FindClaim(ctx context.Context, id uuid.UUID) (*domain.WarrantyClaim, error) {
+	return uc.Warranties.FindClaim(ctx, id)
+}
+
+func (uc *WarrantyUC) ListClaims(ctx context.Context, status string, page, pageSize int) ([]domain.WarrantyClaim, int64, error) {
+	return uc.Warranties.ListClaims(ctx, status, page, pageSize)
+}
+
+func (uc *WarrantyUC) ClaimsByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.WarrantyClaim, error) {
+	return uc.Warranties.ListClaimsByOrder(ctx, orderID)
+}
+
+func (uc *WarrantyUC) Policies(ctx context.Context) ([]domain.WarrantyPolicy, error) {
+	return uc.Warranties.ListPolicies(ctx)
+}
+
+// SavePolicy crea o actualiza la garantía de una categoría. No cambia lo ya registrado.
+func (uc *WarrantyUC) SavePolicy(ctx context.Context, category string, months int, terms string) error {
+	category = strings.TrimSpace(category)
+	if category == "" {
+		return errors.New("indicá la categoría")
+	}
+	if months < 0 || months > 120 {
+		return errors.New("duración inválida")
+	}
+	return uc.Warranties.SavePolicy(ctx, &domain.WarrantyPolicy{Category: category, Months: months, Terms: strings.TrimSpace(terms)})
+}
+
+func (uc *WarrantyUC) DeletePolicy(ctx context.Context, category string) error {
+	return uc.Warranties.DeletePolicy(ctx, category)
+}
+
+func (uc *WarrantyUC) audit(ctx context.Context, orderID uuid.UUID, actor, detail string) {
+	if uc.Audit == nil {
+		return
+	}
+	e := &domain.OrderAuditEntry{OrderID: orderID, Actor: actor, Action: domain.OrderAuditWarranty, Detail: detail, CreatedAt: time.Now()}
+	if err := uc.Audit.Save(ctx, e); err != nil {
+		log.Error().Err(err).Str("order_id", orderID.String()).Msg("no se pudo registrar la auditoría de la garantía")
+	}
+}
+
+func (uc *WarrantyUC) now() time.Time {
+	if uc.Clock != nil {
+		return uc.Clock.Now()
+	}
+	return time.Now()
+}
+
+func findItem(o *domain.Order, itemID uuid.UUID) (domain.OrderItem, bool) {
+	for _, it := range o.Items {
+		if it.ID == itemID {
+			return it, true
+		}
+	}
+	return domain.OrderItem{}, false
+}
@@ -0,0 +1,456 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// webhookBatch es la cantidad de entregas que se mandan por vuelta.
+const webhookBatch = 20
+
+// WebhookUC publica los eventos de órdenes y productos a los webhooks suscriptos y entrega cada envío
+// firmado con HMAC, con reintentos y backoff. Cada publicación guarda una entrega por webhook, que es a
+// la vez la cola del worker y el registro que se ve en el admin.
+type WebhookUC struct {
+	Webhooks domain.WebhookRepo
+	Sender   domain.WebhookSender
+	Products domain.ProductRepo
+	// MaxAttempts es la cantidad de intentos antes de dejar una entrega muerta.
+	MaxAttempts int
+	Clock       domain.Clock
+
+	once sync.Once
+	kick chan struct{}
+}
+
+// webhookBody es el cuerpo JSON de cada entrega.
+type webhookBody struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Publish guarda una entrega del evento para cada webhook activo suscripto y despierta al worker.
+// Sin webhooks configurados no hace nada.
+func (uc *WebhookUC) Publish(ctx context.Context, event string, data any) error {
+	if uc == nil || uc.Webhooks == nil {
+		return nil
+	}
+	endpoints, err := uc.Webhooks.ListEndpoints(ctx)
+	if err != nil {
+		return fmt.Errorf("leyendo los webhooks: %w", err)
+	}
+	var targets []domain.WebhookEndpoint
+	for _, e := range endpoints {
+		if e.Active && e.Subscribed(event) {
+			targets = append(targets, e)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	return uc.enqueue(ctx, targets, event, data)
+}
+
+func (uc *WebhookUC) enqueue(ctx context.Context, targets []domain.WebhookEndpoint, event string, data any) error {
+	now := uc.now()
+	eventID := uuid.New()
+	raw, err := json.Marshal(webhookBody{ID: eventID.String(), Event: event, CreatedAt: now.UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+	for _, e := range targets {
+		d := domain.NewWebhookDelivery(e.ID, eventID, event, string(raw), now)
+		if err := uc.Webhooks.SaveDelivery(ctx, &d); err != nil {
+			return fmt.Errorf("guardando la entrega a %s: %w", e.URL, err)
+		}
+	}
+	uc.Kick()
+	return nil
+}
+
+// PublishOrder publica un evento de orden (domain.WebhookOrderCreated…).
+func (uc *WebhookUC) PublishOrder(ctx context.Context, event string, o *domain.Order) error {
+	if o == nil {
+		return errors.New("orden nil")
+	}
+	return uc.Publish(ctx, event, webhookOrderData(o))
+}
+
+// ProductUpdated publica product.updated. Un error no frena el guardado del producto: se registra.
+func (uc *WebhookUC) ProductUpdated(ctx context.Context, p *domain.Product) {
+	if uc == nil || p == nil {
+		return
+	}
+	if err := uc.Publish(ctx, domain.WebhookProductUpdated, webhookProductData(p)); err != nil {
+		log.Error().Err(err).Str("product_id", p.ID.String()).Msg("webhooks: no se pudo publicar product.updated")
+	}
+}
+
+// StockChanged publica stock.changed con el stock actual de la variante; delta es cuánto cambió. Un
+// error no frena el movimiento de stock: se registra.
+func (uc *WebhookUC) StockChanged(ctx context.Context, variantID uuid.UUID, delta int) {
+	if uc == nil || delta == 0 || uc.Products == nil {
+		return
+	}
+	p, v, err := uc.Products.FindVariantByID(ctx, variantID)
+	if err == nil {
+		err = uc.Publish(ctx, domain.WebhookStockChanged, map[string]any{
+			"variant_id":     v.ID.String(),
+			"product_id":     p.ID.String(),
+			"product_slug":   p.Slug,
+			"product_name":   p.Name,
+			"sku":            v.SKU,
+			"ean":            v.EAN,
+			"color":          domain.ColorName(v.Color),
+			"stock":          v.Stock,
+			"previous_stock": v.Stock - delta,
+			"delta":          delta,
+		})
+	}
+	if err != nil {
+		log.Error().Err(err).Str("variant_id", variantID.String()).Msg("webhooks: no se pudo publicar stock.changed")
+	}
+}
+
+func webhookOrderData(o *domain.Order) map[string]any {
+	items := make([]map[string]any, 0, len(o.Items))
+	for _, it := range o.Items {
+		item := map[string]any{"title": it.Title, "qty": it.Qty, "unit_price": it.UnitPrice, "sku": it.SKU, "ean": it.EAN, "color": domain.ColorName(it.Color)}
+		if it.ProductID != nil {
+			item["product_id"] = it.ProductID.String()
+		}
+		if it.VariantID != nil {
+			item["variant_id"] = it.VariantID.String()
+		}
+		items = append(items, item)
+	}
+	return map[string]any{
+		"id":              o.ID.String(),
+		"number":          o.Number(),
+		"status":          string(o.Status),
+		"payment_status":  o.PaymentStatus,
+		"payment_method":  o.PaymentMethod,
+		"shipping_method": o.ShippingMethod,
+		"tracking_number": o.TrackingNumber,
+		"name":            o.Name,
+		"email":           o.Email,
+		"phone":           o.Phone,
+		"dni":             o.DNI,
+		"address":         o.Address,
+		"postal_code":     o.PostalCode,
+		"province":        o.Province,
+		"subtotal_net":    o.SubtotalNet,
+		"vat_amount":      o.VATAmount,
+		"discount":        o.DiscountAmount,
+		"shipping_cost":   o.ShippingCost,
+		"total":           o.Total,
+		"items":           items,
+		"created_at":      o.CreatedAt.UTC(),
+	}
+}
+
+func webhookProductData(p *domain.Product) map[string]any {
+	variants := make([]map[string]any, 0, len(p.Variants))
+	for _, v := range p.Variants {
+		variants = append(variants, map[string]any{"id": v.ID.String(), "sku": v.SKU, "ean": v.EAN, "color": domain.ColorName(v.Color), "price": v.Price, "stock": v.Stock})
+	}
+	return map[string]any{
+		"id":         p.ID.String(),
+		"slug":       p.Slug,
+		"name":       p.Name,
+		"category":   p.Category,
+		"base_price": p.BasePrice,
+		"active":     p.Active,
+		"variants":   variants,
+	}
+}
+
+// Kick despierta al worker para que mande ya las entregas recién guardadas.
+func (uc *WebhookUC) Kick() {
+	if uc == nil {
+		return
+	}
+	select {
+	case uc.kickChan() <- struct{}{}:
+	default:
+	}
+}
+
+func (uc *WebhookUC) kickChan() chan struct{} {
+	uc.once.Do(func() { uc.kick = make(chan struct{}, 1) })
+	return uc.kick
+}
+
+// RunDispatcher manda las entregas pendientes cada every, o antes si alguien llama a Kick.
+func (uc *WebhookUC) RunDispatcher(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	kick := uc.kickChan()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-kick:
+		}
+		delivered, failed, err := uc.DeliverDue(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("webhooks: no se pudieron leer las entregas pendientes")
+			continue
+		}
+		if delivered > 0 || failed > 0 {
+			log.Info().Int("delivered", delivered).Int("failed", failed).Msg("webhooks salientes")
+		}
+	}
+}
+
+// DeliverDue manda las entregas pendientes que ya vencieron. Devuelve cuántas se entregaron y cuántas
+// fallaron en esta vuelta.
+func (uc *WebhookUC) DeliverDue(ctx context.Context) (delivered, failed int, err error) {
+	endpoints := map[uuid.UUID]*domain.WebhookEndpoint{}
+	for {
+		list, err := uc.Webhooks.ClaimDueDeliveries(ctx, uc.now(), outboxLease, webhookBatch)
+		if err != nil {
+			return delivered, failed, err
+		}
+		for i := range list {
+			d := &list[i]
+			e, ok := endpoints[d.EndpointID]
+			if !ok {
+				e, err = uc.Webhooks.FindEndpoint(ctx, d.EndpointID)
+				if err != nil && !errors.Is(err, domain.ErrNotFound) {
+					return delivered, failed, err
+				}
+				endpoints[d.EndpointID] = e
+			}
+			if uc.deliver(ctx, e, d) {
+				delivered++
+			} else {
+				failed++
+			}
+		}
+		if len(list) < webhookBatch {
+			return delivered, failed, nil
+		}
+	}
+}
+
+// deliver manda una entrega firmada y guarda el resultado. Devuelve true si se entregó.
+func (uc *WebhookUC) deliver(ctx context.Context, e *domain.WebhookEndpoint, d *domain.WebhookDelivery) bool {
+	now := uc.now()
+	var err error
+	switch {
+	case e == nil:
+		err = errors.New("el webhook se borró")
+		d.Kill(err)
+	case !e.Active && d.Event != domain.WebhookPing:
+		err = errors.New("el webhook está desactivado")
+		d.Kill(err)
+	case uc.Sender == nil:
+		err = errors.New("envío de webhooks no disponible")
+		d.MarkFailed(err, 0, now, uc.maxAttempts())
+	default:
+		var code int
+		code, err = uc.Sender.Post(ctx, e.URL, webhookHeaders(e, d, now), []byte(d.Payload))
+		if err == nil {
+			d.MarkDelivered(code, now)
+		} else {
+			d.MarkFailed(err, code, now, uc.maxAttempts())
+		}
+	}
+	if err != nil {
+		ev := log.Warn()
+		if d.Status == domain.WebhookStatusDead {
+			ev = log.Error()
+		}
+		ev.Err(err).Str("delivery_id", d.ID.String()).Str("event", d.Event).Int("attempts", d.Attempts).Str("status", d.Status).Msg("webhooks: falló la entrega")
+	}
+	if serr := uc.Webhooks.SaveDelivery(ctx, d); serr != nil {
+		log.Error().Err(serr).Str("delivery_id", d.ID.String()).Msg("webhooks: no se pudo guardar el resultado de la entrega")
+	}
+	return err == nil
+}
+
+// webhookHeaders son los encabezados de una entrega. La firma se calcula en cada intento con su
+// timestamp; X-Webhook-Id es el mismo en los reintentos y reenvíos, para que el receptor descarte
+// duplicados.
+func webhookHeaders(e *domain.WebhookEndpoint, d *domain.WebhookDelivery, now time.Time) map[string]string {
+	ts := now.Unix()
+	return map[string]string{
+		"X-Webhook-Id":        d.EventID.String(),
+		"X-Webhook-Delivery":  d.ID.String(),
+		"X-Webhook-Event":     d.Event,
+		"X-Webhook-Timestamp": strconv.FormatInt(ts, 10),
+		"X-Webhook-Signature": domain.WebhookSignature(e.Secret, ts, []byte(d.Payload)),
+	}
+}
+
+// --- Admin ---
+
+// WebhookDeliveryEntry es una entrega con su webhook, para el registro del admin.
+type WebhookDeliveryEntry struct {
+	Delivery domain.WebhookDelivery
+	Endpoint *domain.WebhookEndpoint
+}
+
+// Endpoints devuelve los webhooks configurados.
+func (uc *WebhookUC) Endpoints(ctx context.Context) ([]domain.WebhookEndpoint, error) {
+	return uc.Webhooks.ListEndpoints(ctx)
+}
+
+// AddEndpoint da de alta un webhook con un secreto nuevo.
+func (uc *WebhookUC) AddEndpoint(ctx context.Context, name, rawURL string, events []string) (*domain.WebhookEndpoint, error) {
+	target, err := domain.NormalizeNotificationTarget(domain.ChannelWebhook, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	evs, err := webhookEvents(events)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := domain.NewWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	e := &domain.WebhookEndpoint{ID: uuid.New(), Name: strings.TrimSpace(name), URL: target, Secret: secret, Events: evs, Active: true, CreatedAt: uc.now()}
+	if err := uc.Webhooks.SaveEndpoint(ctx, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// UpdateEndpoint cambia el nombre, la URL y los eventos de un webhook.
+func (uc *WebhookUC) UpdateEndpoint(ctx context.Context, id uuid.UUID, name, rawURL string, events []string) (*domain.WebhookEndpoint, error) {
+	e, err := uc.Webhooks.FindEndpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.URL, err = domain.NormalizeNotificationTarget(domain.ChannelWebhook, rawURL); err != nil {
+		return nil, err
+	}
+	if e.Events, err = webhookEvents(events); err != nil {
+		return nil, err
+	}
+	e.Name = strings.TrimSpace(name)
+	return e, uc.Webhooks.SaveEndpoint(ctx, e)
+}
+
+// ToggleEndpoint activa o desactiva un webhook. Desactivado no recibe eventos nuevos y sus entregas
+// pendientes quedan muertas.
+func (uc *WebhookUC) ToggleEndpoint(ctx context.Context, id uuid.UUID) (*domain.WebhookEndpoint, error) {
+	e, err := uc.Webhooks.FindEndpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	e.Active = !e.Active
+	return e, uc.Webhooks.SaveEndpoint(ctx, e)
+}
+
+// RotateSecret reemplaza el secreto de firma de un webhook; las entregas siguientes se firman con el nuevo.
+func (uc *WebhookUC) RotateSecret(ctx context.Context, id uuid.UUID) (*domain.WebhookEndpoint, error) {
+	e, err := uc.Webhooks.FindEndpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Secret, err = domain.NewWebhookSecret(); err != nil {
+		return nil, err
+	}
+	return e, uc.Webhooks.SaveEndpoint(ctx, e)
+}
+
+// DeleteEndpoint borra un webhook; su registro de entregas se conserva.
+func (uc *WebhookUC) DeleteEndpoint(ctx context.Context, id uuid.UUID) error {
+	return uc.Webhooks.DeleteEndpoint(ctx, id)
+}
+
+// Ping manda un evento de prueba sólo a ese webhook, esté o no suscripto.
+func (uc *WebhookUC) Ping(ctx context.Context, id uuid.UUID) error {
+	e, err := uc.Webhooks.FindEndpoint(ctx, id)
+	if err != nil {
+		return err
+	}
+	return uc.enqueue(ctx, []domain.WebhookEndpoint{*e}, domain.WebhookPing, map[string]any{"webhook_id": e.ID.String(), "message": "prueba de webhook"})
+}
+
+// Deliveries devuelve el registro de entregas filtrado con su webhook, las más nuevas primero.
+func (uc *WebhookUC) Deliveries(ctx context.Context, f domain.WebhookDeliveryFilter) ([]WebhookDeliveryEntry, int64, error) {
+	list, total, err := uc.Webhooks.ListDeliveries(ctx, f)
+	if err != nil {
+		return nil, 0, err
+	}
+	endpoints := map[uuid.UUID]*domain.WebhookEndpoint{}
+	out := make([]WebhookDeliveryEntry, 0, len(list))
+	for _, d := range list {
+		e, ok := endpoints[d.EndpointID]
+		if !ok {
+			e, _ = uc.Webhooks.FindEndpoint(ctx, d.EndpointID)
+			endpoints[d.EndpointID] = e
+		}
+		out = append(out, WebhookDeliveryEntry{Delivery: d, Endpoint: e})
+	}
+	return out, total, nil
+}
+
+// Redeliver vuelve a mandar una entrega (entregada, muerta o pendiente) como una entrega nueva con el
+// mismo cuerpo.
+func (uc *WebhookUC) Redeliver(ctx context.Context, id uuid.UUID) (*domain.WebhookDelivery, error) {
+	d, err := uc.Webhooks.FindDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := uc.Webhooks.FindEndpoint(ctx, d.EndpointID); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, errors.New("el webhook de la entrega se borró")
+		}
+		return nil, err
+	}
+	r := d.Redelivery(uc.now())
+	if err := uc.Webhooks.SaveDelivery(ctx, &r); err != nil {
+		return nil, err
+	}
+	uc.Kick()
+	return &r, nil
+}
+
+func webhookEvents(events []string) ([]string, error) {
+	var out []string
+	for _, ev := range domain.WebhookEvents {
+		for _, e := range events {
+			if e == ev {
+				out = append(out, ev)
+				break
+			}
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("elegí al menos un evento")
+	}
+	return out, nil
+}
+
+func (uc *WebhookUC) maxAttempts() int {
+	if uc.MaxAttempts > 0 {
+		return uc.MaxAttempts
+	}
+	return 8
+}
+
+func (uc *WebhookUC) now() time.Time {
+	if uc.Clock != nil {
+		return uc.Clock.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,113 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/phenrril/tienda3d/internal/domain"
+)
+
+// WhatsAppUC manda a los compradores que lo aceptaron los mensajes de WhatsApp del estado de su pedido
+// y registra cada envío. Los mensajes se encolan en el outbox (ver Order.QueueWhatsApp) y el worker de
+// avisos los entrega con Send.
+type WhatsAppUC struct {
+	// Sender es la API de WhatsApp; sin ella los mensajes se registran como omitidos.
+	Sender domain.WhatsAppSender
+	Log    domain.WhatsAppLogRepo
+	// Templates es el nombre de la plantilla aprobada de cada mensaje (domain.WhatsAppOrderReceived…).
+	Templates map[string]string
+	Lang      string
+	Clock     domain.Clock
+}
+
+// Send manda el mensaje del evento al comprador. Un error deja el mensaje en el outbox para reintentar;
+// lo que no se puede mandar nunca (sin opt-in, sin plantilla, sin WhatsApp configurado) se registra
+// como omitido y no se reintenta.
+func (uc *WhatsAppUC) Send(ctx context.Context, o *domain.Order, event string) error {
+	m := domain.WhatsAppMessage{ID: uuid.New(), OrderID: o.ID, Event: event, To: o.WhatsAppPhone, CreatedAt: uc.now()}
+	switch {
+	case !o.WhatsAppOptIn || o.WhatsAppPhone == "":
+		m.Status, m.Error = domain.WhatsAppStatusSkipped, "el comprador no aceptó recibir mensajes por WhatsApp"
+	case uc.Templates[event] == "":
+		m.Status, m.Error = domain.WhatsAppStatusSkipped, "no hay plantilla configurada para el mensaje"
+	case uc.Sender == nil:
+		m.Status, m.Error = domain.WhatsAppStatusSkipped, "WhatsApp no configurado (WHATSAPP_TOKEN, WHATSAPP_PHONE_NUMBER_ID)"
+	}
+	if m.Status != "" {
+		uc.record(ctx, &m)
+		return nil
+	}
+	m.Template = uc.Templates[event]
+	m.Params = whatsAppParams(o, event)
+	id, err := uc.Sender.SendTemplate(ctx, o.WhatsAppPhone, m.Template, uc.Language(), m.Params)
+	if err != nil {
+		m.Status, m.Error = domain.WhatsAppStatusFailed, err.Error()
+	} else {
+		m.Status, m.ProviderID = domain.WhatsAppStatusSent, id
+	}
+	uc.record(ctx, &m)
+	return err
+}
+
+func (uc *WhatsAppUC) record(ctx context.Context, m *domain.WhatsAppMessage) {
+	if uc.Log == nil {
+		return
+	}
+	if err := uc.Log.Save(ctx, m); err != nil {
+		log.Error().Err(err).Str("order_id", m.OrderID.String()).Str("event", m.Event).Msg("whatsapp: no se pudo registrar el envío")
+	}
+}
+
+// whatsAppParams son los parámetros de la plantilla: {{1}} nombre, {{2}} número de pedido y {{3}} el
+// total (pedido recibido, pago confirmado) o el código de seguimiento (despachado).
+func whatsAppParams(o *domain.Order, event string) []string {
+	name := strings.TrimSpace(o.Name)
+	if first, _, ok := strings.Cut(name, " "); ok {
+		name = first
+	}
+	params := []string{name, o.Number()}
+	switch event {
+	case domain.WhatsAppOrderReceived, domain.WhatsAppPaymentConfirmed:
+		params = append(params, fmt.Sprintf("$%.2f", o.Total))
+	case domain.WhatsAppShipped:
+		params = append(params, o.TrackingNumber)
+	}
+	return params
+}
+
+// ListByOrder devuelve los envíos a la orden, del más viejo al más nuevo.
+func (uc *WhatsAppUC) ListByOrder(ctx context.Context, orderID uuid.UUID) ([]domain.WhatsAppMessage, error) {
+	if uc.Log == nil {
+		return nil, nil
+	}
+	return uc.Log.ListByOrder(ctx, orderID)
+}
+
+// List devuelve el registro de envíos filtrado, los más nuevos primero.
+func (uc *WhatsAppUC) List(ctx context.Context, f domain.WhatsAppFilter) ([]domain.WhatsAppMessage, int64, error) {
+	if uc.Log == nil {
+		return nil, 0, errors.New("registro de WhatsApp no disponible")
+	}
+	return uc.Log.List(ctx, f)
+}
+
+// Language es el idioma de las plantillas (es_AR si no se configuró otro).
+func (uc *WhatsAppUC) Language() string {
+	if uc.Lang != "" {
+		return uc.Lang
+	}
+	return "es_AR"
+}
+
+func (uc *WhatsAppUC) now() time.Time {
+	if uc.Clock != nil {
+		return uc.Clock.Now()
+	}
+	return time.Now()
+}
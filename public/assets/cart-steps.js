@@ -151,7 +151,8 @@ function validateStep2() {
       email: emailValue,
       phoneType: document.getElementById('phoneType').value,
       areaCode: areaCode.value.trim(),
-      phoneNumber: phoneNumber.value.trim()
+      phoneNumber: phoneNumber.value.trim(),
+      whatsappOptIn: document.getElementById('whatsappOptIn').checked
     };
     saveStepData(2, checkoutData.step2);
   }
@@ -263,6 +264,11 @@ function validateStep4() {
     alert('Por favor seleccioná un método de pago');
     return false;
   }
+  const shippingMethod = document.querySelector('input[name="shipping_method"]:checked');
+  if (paymentMethod.value === 'efectivo' && shippingMethod && shippingMethod.value === 'envio') {
+    alert('El pago en efectivo sólo está disponible para retiro en el local o cadete');
+    return false;
+  }
   
   checkoutData.step4 = {
     payment_method: paymentMethod.value
@@ -293,10 +299,26 @@ function selectShipping(method) {
     }
   }
   
+  updateCashAvailability(method);
   updateShippingSummary();
   updateTotalSummary();
 }
 
+// El efectivo sólo se ofrece con retiro o cadete.
+function updateCashAvailability(shipping) {
+  const option = document.getElementById('paymentOptionEfectivo');
+  if (!option) return;
+  const allowed = shipping !== 'envio';
+  option.style.display = allowed ? '' : 'none';
+  const radio = option.querySelector('input[name="payment_method"]');
+  if (radio) {
+    radio.disabled = !allowed;
+    if (!allowed && radio.checked) {
+      selectPayment('transferencia');
+    }
+  }
+}
+
 function selectPayment(method) {
   document.querySelectorAll('input[name="payment_method"]').forEach(radio => {
     radio.checked = radio.value === method;
@@ -631,6 +653,7 @@ function populateFormFields() {
     if (data.phoneType) document.getElementById('phoneType').value = data.phoneType;
     if (data.areaCode) document.getElementById('areaCode').value = data.areaCode;
     if (data.phoneNumber) document.getElementById('phoneNumber').value = data.phoneNumber;
+    document.getElementById('whatsappOptIn').checked = !!data.whatsappOptIn;
   }
   
   if (checkoutData.step3) {